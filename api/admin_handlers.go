@@ -0,0 +1,303 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/services/linkcheck"
+	"github.com/dselans/blastbeat-api/services/release"
+)
+
+const (
+	AdminTokenHeader = "X-Admin-Token"
+
+	DefaultNeedsArtLimit  = 100
+	DefaultAdminListLimit = 100
+
+	// maxReleaseUpdateBytes caps the body of PUT /api/releases/:id
+	maxReleaseUpdateBytes = 64 << 10
+)
+
+// adminOnly guards a handler with the configured admin token. Admin
+// endpoints are disabled entirely when no token is configured.
+func (a *API) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if a.config.AdminToken == "" {
+			a.writeError(rw, http.StatusForbidden, "Admin endpoints are disabled")
+			return
+		}
+
+		token := r.Header.Get(AdminTokenHeader)
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.config.AdminToken)) != 1 {
+			a.log.Warn("rejected admin request",
+				zap.String("path", r.URL.Path),
+				zap.String("remoteAddr", r.RemoteAddr))
+			a.writeError(rw, http.StatusUnauthorized, "Invalid admin token")
+			return
+		}
+
+		next(rw, r)
+	}
+}
+
+func (a *API) adminConfigHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "adminConfigHandler"))
+	logger.Info("handling /api/admin/config request", zap.String("remoteAddr", r.RemoteAddr))
+
+	WriteJSON(rw, a.config.GetRedactedMap(), http.StatusOK)
+}
+
+func (a *API) adminNeedsArtHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "adminNeedsArtHandler"))
+	logger.Info("handling /api/admin/releases/needs-art request", zap.String("remoteAddr", r.RemoteAddr))
+
+	limit := DefaultNeedsArtLimit
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 {
+			a.writeError(rw, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = v
+	}
+
+	releases, err := a.deps.ReleaseService.GetReleasesNeedingArt(r.Context(), limit)
+	if err != nil {
+		logger.Error("Failed to fetch releases needing art", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch releases")
+		return
+	}
+
+	WriteJSON(rw, releases, http.StatusOK)
+}
+
+// adminInvalidCountriesHandler lists releases whose stored country isn't an
+// ISO 3166-1 alpha-2 code
+func (a *API) adminInvalidCountriesHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "adminInvalidCountriesHandler"))
+	logger.Info("handling /api/admin/releases/invalid-countries request", zap.String("remoteAddr", r.RemoteAddr))
+
+	limit := DefaultAdminListLimit
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 {
+			a.writeError(rw, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = v
+	}
+
+	releases, err := a.deps.ReleaseService.GetReleasesWithInvalidCountry(r.Context(), limit)
+	if err != nil {
+		logger.Error("Failed to fetch releases with invalid country", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch releases")
+		return
+	}
+
+	WriteJSON(rw, releases, http.StatusOK)
+}
+
+// adminReleasesHandler lists releases for curation; sort=quality (least
+// complete first, the default) or sort=-quality
+func (a *API) adminReleasesHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "adminReleasesHandler"))
+	logger.Info("handling /api/admin/releases request", zap.String("remoteAddr", r.RemoteAddr))
+
+	var descending bool
+
+	switch r.URL.Query().Get("sort") {
+	case "", "quality":
+	case "-quality":
+		descending = true
+	default:
+		a.writeError(rw, http.StatusBadRequest, "Invalid sort parameter (expected quality or -quality)")
+		return
+	}
+
+	limit := DefaultAdminListLimit
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 {
+			a.writeError(rw, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+		limit = v
+	}
+
+	releases, err := a.deps.ReleaseService.GetReleasesByQuality(r.Context(), limit, descending)
+	if err != nil {
+		logger.Error("Failed to fetch releases by quality", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch releases")
+		return
+	}
+
+	WriteJSON(rw, releases, http.StatusOK)
+}
+
+type enrichmentAuditEntry struct {
+	Provider        string    `json:"provider"`
+	Lookup          string    `json:"lookup"`
+	URL             string    `json:"url"`
+	StatusCode      int32     `json:"status_code"`
+	Error           string    `json:"error,omitempty"`
+	ResponseExcerpt string    `json:"response_excerpt,omitempty"`
+	DurationMs      int32     `json:"duration_ms"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// adminEnrichmentAuditHandler lists the provider calls the importer made
+// while enriching a release (recorded with import-releases -audit)
+func (a *API) adminEnrichmentAuditHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "adminEnrichmentAuditHandler"))
+	logger.Info("handling /api/admin/enrichment-audit request", zap.String("remoteAddr", r.RemoteAddr))
+
+	releaseID, err := uuid.Parse(r.URL.Query().Get("releaseId"))
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, "Invalid releaseId parameter")
+		return
+	}
+
+	rows, err := a.deps.DBBackend.ListEnrichmentAuditByRelease(r.Context(),
+		uuid.NullUUID{UUID: releaseID, Valid: true})
+	if err != nil {
+		logger.Error("Failed to fetch enrichment audit", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch enrichment audit")
+		return
+	}
+
+	entries := make([]enrichmentAuditEntry, 0, len(rows))
+
+	for _, row := range rows {
+		entries = append(entries, enrichmentAuditEntry{
+			Provider:        row.Provider,
+			Lookup:          row.Lookup,
+			URL:             row.Url,
+			StatusCode:      row.StatusCode,
+			Error:           row.Error.String,
+			ResponseExcerpt: row.ResponseExcerpt.String,
+			DurationMs:      row.DurationMs,
+			CreatedAt:       row.CreatedAt,
+		})
+	}
+
+	WriteJSON(rw, entries, http.StatusOK)
+}
+
+type linkHealthResponse struct {
+	ReleaseID string             `json:"release_id"`
+	Dead      int                `json:"dead"`
+	Links     []linkcheck.Result `json:"links"`
+}
+
+// linkHealthHandler checks every link stored on a release and reports which
+// are dead (import-releases -link-check does the same in bulk)
+func (a *API) linkHealthHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "linkHealthHandler"))
+	logger.Info("handling /api/releases/:id/links/health request", zap.String("remoteAddr", r.RemoteAddr))
+
+	releaseID, err := uuid.Parse(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, "Invalid release id")
+		return
+	}
+
+	release, err := a.deps.DBBackend.GetRelease(r.Context(), releaseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.writeError(rw, http.StatusNotFound, "Release not found")
+			return
+		}
+
+		logger.Error("Failed to fetch release", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch release")
+		return
+	}
+
+	results := a.deps.LinkCheck.Check(r.Context(), linkcheck.Links(release))
+
+	WriteJSON(rw, linkHealthResponse{
+		ReleaseID: release.ID.String(),
+		Dead:      len(linkcheck.Dead(results)),
+		Links:     results,
+	}, http.StatusOK)
+}
+
+// updateReleaseHandler applies a partial update to a release; fields left
+// out of the body are not changed
+func (a *API) updateReleaseHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "updateReleaseHandler"))
+	logger.Info("handling PUT /api/releases/:id request", zap.String("remoteAddr", r.RemoteAddr))
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	var update release.ReleaseUpdate
+
+	dec := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxReleaseUpdateBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&update); err != nil {
+		a.writeError(rw, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+
+	if err := update.Validate(); err != nil {
+		a.writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rel, err := a.deps.ReleaseService.UpdateRelease(r.Context(), id, &update)
+	if err != nil {
+		a.writeReleaseError(rw, logger, err, "Failed to update release")
+		return
+	}
+
+	logger.Info("updated release", zap.String("releaseId", rel.ID))
+
+	WriteJSON(rw, rel, http.StatusOK)
+}
+
+func (a *API) deleteReleaseHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "deleteReleaseHandler"))
+	logger.Info("handling DELETE /api/releases/:id request", zap.String("remoteAddr", r.RemoteAddr))
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	if err := a.deps.ReleaseService.DeleteRelease(r.Context(), id); err != nil {
+		a.writeReleaseError(rw, logger, err, "Failed to delete release")
+		return
+	}
+
+	logger.Info("deleted release", zap.String("releaseId", id))
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// writeReleaseError maps release service errors to HTTP responses; msg is
+// used for unexpected errors
+func (a *API) writeReleaseError(rw http.ResponseWriter, logger clog.ICustomLog, err error, msg string) {
+	switch {
+	case errors.Is(err, release.ErrInvalidID):
+		a.writeError(rw, http.StatusBadRequest, "Invalid release id")
+	case errors.Is(err, release.ErrNotFound):
+		a.writeError(rw, http.StatusNotFound, "Release not found")
+	case errors.Is(err, release.ErrConflict):
+		a.writeError(rw, http.StatusConflict, "Another release has the same artist, title and release date")
+	default:
+		logger.Error(msg, zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, msg)
+	}
+}
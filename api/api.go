@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"log"
 	"net/http"
@@ -41,14 +42,10 @@ func New(cfg *config.Config, d *deps.Dependencies, version string) (*API, error)
 		return nil, errors.New("deps cannot be nil")
 	}
 
-	server := &http.Server{
-		Addr: cfg.APIListenAddress,
-	}
-
 	a := &API{
 		config:  cfg,
 		deps:    d,
-		server:  server,
+		server:  newServer(cfg),
 		version: version,
 		log:     d.Log.With(zap.String("pkg", "api")),
 	}
@@ -60,6 +57,29 @@ func New(cfg *config.Config, d *deps.Dependencies, version string) (*API, error)
 
 }
 
+// newServer builds the API http.Server with connection tuning from cfg;
+// HTTP/2 is always enabled over TLS and optionally over cleartext (h2c)
+func newServer(cfg *config.Config) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(cfg.APIHTTP2Cleartext)
+
+	return &http.Server{
+		Addr:              cfg.APIListenAddress,
+		ReadHeaderTimeout: time.Duration(cfg.APIReadHeaderTimeoutSec) * time.Second,
+		IdleTimeout:       time.Duration(cfg.APIIdleTimeoutSec) * time.Second,
+		MaxHeaderBytes:    cfg.APIMaxHeaderBytes,
+		Protocols:         protocols,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.APIHTTP2MaxConcurrentStreams,
+		},
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+}
+
 func (a *API) runShutdownListener() {
 	<-a.deps.ShutdownCtx.Done()
 
@@ -77,19 +97,54 @@ func (a *API) Run() error {
 
 	router := nrhttprouter.New(a.deps.NewRelicApp)
 
-	a.server.Handler = a.corsMiddleware(router)
+	a.server.Handler = a.securityHeadersMiddleware(a.corsMiddleware(router))
 
 	router.HandlerFunc("GET", "/health-check", a.healthCheckHandler)
 	router.HandlerFunc("GET", "/version", a.versionHandler)
+	router.HandlerFunc("GET", "/robots.txt", a.robotsHandler)
+	router.HandlerFunc("GET", "/sitemap.xml", a.sitemapHandler)
+	router.HandlerFunc("GET", "/sitemaps/:file", a.sitemapPageHandler)
 
+	// Unversioned routes negotiate via Accept (default v1)
 	router.HandlerFunc("GET", "/api/releases", a.releasesHandler)
+	router.HandlerFunc("GET", "/api/v1/releases", a.withAPIVersion(APIVersion1, a.releasesHandler))
+	router.HandlerFunc("GET", "/api/v2/releases", a.withAPIVersion(APIVersion2, a.releasesHandler))
+	// Also serves /api/releases/trending; httprouter won't register a static
+	// segment next to the :id wildcard
+	router.HandlerFunc("GET", "/api/releases/:id", a.releaseHandler)
+	router.HandlerFunc("POST", "/api/releases/:id/view", a.releaseViewHandler)
 	router.HandlerFunc("GET", "/api/genres", a.genresHandler)
+	router.HandlerFunc("GET", "/api/labels", a.labelsHandler)
+	router.HandlerFunc("GET", "/api/countries", a.countriesHandler)
+	router.HandlerFunc("GET", "/api/stats", a.statsHandler)
+	router.HandlerFunc("GET", "/api/stats/enrichment", a.enrichmentStatsHandler)
+
+	// Favorites (keyed by the client's FavoritesTokenHeader)
+	router.HandlerFunc("GET", "/api/favorites", a.listFavoritesHandler)
+	router.HandlerFunc("PUT", "/api/favorites/:releaseId", a.addFavoriteHandler)
+	router.HandlerFunc("DELETE", "/api/favorites/:releaseId", a.removeFavoriteHandler)
+
+	// Admin
+	router.HandlerFunc("GET", "/api/admin/config", a.adminOnly(a.adminConfigHandler))
+	router.HandlerFunc("GET", "/api/admin/releases", a.adminOnly(a.adminReleasesHandler))
+	router.HandlerFunc("GET", "/api/admin/releases/needs-art", a.adminOnly(a.adminNeedsArtHandler))
+	router.HandlerFunc("GET", "/api/admin/releases/invalid-countries", a.adminOnly(a.adminInvalidCountriesHandler))
+	router.HandlerFunc("GET", "/api/admin/enrichment-audit", a.adminOnly(a.adminEnrichmentAuditHandler))
+	router.HandlerFunc("GET", "/api/releases/:id/links/health", a.adminOnly(a.linkHealthHandler))
+	router.HandlerFunc("PUT", "/api/releases/:id", a.adminOnly(a.updateReleaseHandler))
+	router.HandlerFunc("DELETE", "/api/releases/:id", a.adminOnly(a.deleteReleaseHandler))
 
 	// Maybe enable profiling
 	if a.config.EnablePprof {
 		router.Handler(http.MethodGet, "/debug/pprof/*item", http.DefaultServeMux)
 	}
 
+	if a.config.APITLSCertFile != "" {
+		logger.Info("API server running (TLS)", zap.String("listenAddress", a.config.APIListenAddress))
+
+		return a.server.ListenAndServeTLS(a.config.APITLSCertFile, a.config.APITLSKeyFile)
+	}
+
 	logger.Info("API server running", zap.String("listenAddress", a.config.APIListenAddress))
 
 	return a.server.ListenAndServe()
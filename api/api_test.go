@@ -1,7 +1,29 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/InVisionApp/go-health"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/cache"
+	"github.com/dselans/blastbeat-api/backends/gensql"
+	"github.com/dselans/blastbeat-api/config"
+	"github.com/dselans/blastbeat-api/deps"
+	"github.com/dselans/blastbeat-api/services/favorite"
+	"github.com/dselans/blastbeat-api/services/linkrefresh"
+	"github.com/dselans/blastbeat-api/services/release"
+
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("API", func() {
@@ -17,6 +39,655 @@ var _ = Describe("API", func() {
 		})
 	})
 
+	Describe("requestedAPIVersion", func() {
+		It("should default to v1 without an Accept version", func() {
+			r := httptest.NewRequest("GET", "/api/releases", nil)
+			r.Header.Set("Accept", "application/json")
+
+			version, negotiated, err := requestedAPIVersion(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version).To(Equal(APIVersion1))
+			Expect(negotiated).To(BeFalse())
+		})
+
+		It("should use the vendor media type version", func() {
+			r := httptest.NewRequest("GET", "/api/releases", nil)
+			r.Header.Set("Accept", "text/html, application/vnd.blastbeat.v2+json; q=0.9")
+
+			version, negotiated, err := requestedAPIVersion(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version).To(Equal(APIVersion2))
+			Expect(negotiated).To(BeTrue())
+		})
+
+		It("should reject unsupported versions", func() {
+			r := httptest.NewRequest("GET", "/api/releases", nil)
+			r.Header.Set("Accept", "application/vnd.blastbeat.v9+json")
+
+			_, _, err := requestedAPIVersion(r)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should prefer the path version over Accept", func() {
+			var version int
+
+			handler := (&API{}).withAPIVersion(APIVersion1, func(_ http.ResponseWriter, r *http.Request) {
+				version, _, _ = requestedAPIVersion(r)
+			})
+
+			r := httptest.NewRequest("GET", "/api/v1/releases", nil)
+			r.Header.Set("Accept", "application/vnd.blastbeat.v2+json")
+			handler(httptest.NewRecorder(), r)
+
+			Expect(version).To(Equal(APIVersion1))
+		})
+	})
+
+	Describe("newCollectionResponse", func() {
+		It("should set meta and next/prev links", func() {
+			r := httptest.NewRequest("GET", "/api/releases?q=foo&limit=10&offset=10", nil)
+
+			resp := newCollectionResponse(r, "", []string{}, 25, 10, 10)
+			Expect(resp.Meta).To(Equal(collectionMeta{Total: 25, Limit: 10, Offset: 10}))
+			Expect(*resp.Links.Next).To(Equal("/api/releases?limit=10&offset=20&q=foo"))
+			Expect(*resp.Links.Prev).To(Equal("/api/releases?limit=10&offset=0&q=foo"))
+		})
+
+		It("should omit links on the last page and without a limit", func() {
+			r := httptest.NewRequest("GET", "/api/releases", nil)
+
+			resp := newCollectionResponse(r, "", []string{}, 25, 10, 20)
+			Expect(resp.Links.Next).To(BeNil())
+
+			resp = newCollectionResponse(r, "", []string{}, 25, 0, 0)
+			Expect(resp.Links.Next).To(BeNil())
+			Expect(resp.Links.Prev).To(BeNil())
+		})
+
+		It("should build absolute links from the base URL", func() {
+			r := httptest.NewRequest("GET", "/api/releases?limit=10", nil)
+
+			resp := newCollectionResponse(r, "https://www.blastbeat.io/", []string{}, 25, 10, 0)
+			Expect(*resp.Links.Next).To(Equal("https://www.blastbeat.io/api/releases?limit=10&offset=10"))
+		})
+	})
+
+	Describe("parseIDs", func() {
+		It("should keep request order and drop duplicates", func() {
+			a, b := uuid.New(), uuid.New()
+
+			ids, err := parseIDs([]string{b.String() + "," + a.String(), b.String()})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids).To(Equal([]uuid.UUID{b, a}))
+		})
+
+		It("should reject invalid and too many ids", func() {
+			_, err := parseIDs([]string{"not-a-uuid"})
+			Expect(err).To(HaveOccurred())
+
+			tooMany := make([]string, 0, MaxBatchIDs+1)
+			for i := 0; i <= MaxBatchIDs; i++ {
+				tooMany = append(tooMany, uuid.NewString())
+			}
+
+			_, err = parseIDs(tooMany)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("parseCountries", func() {
+		It("should accept repeated and comma-separated codes in any case", func() {
+			countries, err := parseCountries([]string{"se,No", " fi "})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(countries).To(Equal([]string{"SE", "NO", "FI"}))
+		})
+
+		It("should reject anything that isn't a two-letter code", func() {
+			for _, v := range []string{"SWE", "S", "1A"} {
+				_, err := parseCountries([]string{v})
+				Expect(err).To(HaveOccurred(), v)
+			}
+		})
+	})
+
+	Describe("parseKeywordFields", func() {
+		It("should accept repeated and comma-separated fields in any case", func() {
+			fields, err := parseKeywordFields([]string{"Title,label", " artist "})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fields).To(Equal([]string{"title", "label", "artist"}))
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := parseKeywordFields([]string{"title,genre"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("parseGenreCount", func() {
+		It("should accept non-negative counts only", func() {
+			v, err := parseGenreCount("3")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal(3))
+
+			_, err = parseGenreCount("-1")
+			Expect(err).To(HaveOccurred())
+
+			_, err = parseGenreCount("three")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("parseFollowerCount", func() {
+		It("should accept non-negative 64-bit counts only", func() {
+			v, err := parseFollowerCount("25000")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal(int64(25000)))
+
+			v, err = parseFollowerCount("3000000000")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal(int64(3000000000)))
+
+			for _, s := range []string{"-1", "10K", "9223372036854775808"} {
+				_, err = parseFollowerCount(s)
+				Expect(err).To(HaveOccurred(), s)
+			}
+		})
+	})
+
+	Describe("parseSort", func() {
+		It("should parse keys with and without a direction", func() {
+			by, dir, err := parseSort("followerCount:asc")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(by).To(Equal("followerCount"))
+			Expect(dir).To(Equal("asc"))
+
+			by, dir, err = parseSort("releaseDate")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(by).To(Equal("releaseDate"))
+			Expect(dir).To(Equal("desc"))
+
+			_, dir, err = parseSort("title")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dir).To(Equal("asc"))
+		})
+
+		It("should reject unknown keys and directions", func() {
+			_, _, err := parseSort("popularity:desc")
+			Expect(err).To(HaveOccurred())
+
+			_, _, err = parseSort("title:sideways")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("parsePagination", func() {
+		It("should default to the given limit and offset 0", func() {
+			r := httptest.NewRequest("GET", "/api/releases", nil)
+			limit, offset, err := parsePagination(r, DefaultPageLimit, MaxPageLimit)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(limit).To(Equal(50))
+			Expect(offset).To(Equal(0))
+		})
+
+		It("should reject out of range and non-numeric values", func() {
+			for _, q := range []string{"limit=0", "limit=-5", "limit=201", "limit=ten", "offset=-1", "offset=x"} {
+				r := httptest.NewRequest("GET", "/api/releases?"+q, nil)
+				_, _, err := parsePagination(r, DefaultPageLimit, MaxPageLimit)
+				Expect(err).To(HaveOccurred(), q)
+			}
+		})
+	})
+
+	Describe("robotsHandler", func() {
+		It("should only advertise the sitemap when AppBaseURL is set", func() {
+			rec := httptest.NewRecorder()
+			(&API{config: &config.Config{}}).robotsHandler(rec, httptest.NewRequest("GET", "/robots.txt", nil))
+			Expect(rec.Body.String()).ToNot(ContainSubstring("Sitemap:"))
+
+			rec = httptest.NewRecorder()
+			(&API{config: &config.Config{AppBaseURL: "https://www.blastbeat.io/"}}).
+				robotsHandler(rec, httptest.NewRequest("GET", "/robots.txt", nil))
+			Expect(rec.Body.String()).To(ContainSubstring("Sitemap: https://www.blastbeat.io/sitemap.xml"))
+		})
+	})
+
+	Describe("securityHeadersMiddleware", func() {
+		var a *API
+
+		BeforeEach(func() {
+			a = &API{config: &config.Config{
+				FrameOptions:          "DENY",
+				ReferrerPolicy:        "no-referrer",
+				ContentSecurityPolicy: "default-src 'self'",
+			}}
+		})
+
+		It("should set headers and skip the CSP for JSON", func() {
+			rec := httptest.NewRecorder()
+			a.securityHeadersMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				WriteJSON(rw, map[string]string{}, http.StatusOK)
+			})).ServeHTTP(rec, httptest.NewRequest("GET", "/api/genres", nil))
+
+			Expect(rec.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+			Expect(rec.Header().Get("X-Frame-Options")).To(Equal("DENY"))
+			Expect(rec.Header().Get("Referrer-Policy")).To(Equal("no-referrer"))
+			Expect(rec.Header().Get("Content-Security-Policy")).To(BeEmpty())
+		})
+
+		It("should set the CSP for HTML", func() {
+			rec := httptest.NewRecorder()
+			a.securityHeadersMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+				rw.Write([]byte("<html><body>pprof</body></html>"))
+			})).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/pprof/", nil))
+
+			Expect(rec.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+		})
+	})
+
+	Describe("healthCheckHandler", func() {
+		It("should return every component's state", func() {
+			a := &API{deps: &deps.Dependencies{Health: &fakeHealth{states: map[string]health.State{
+				"redis": {Name: "redis", Status: "ok"},
+				"db":    {Name: "db", Status: "failed", Err: "timeout", Fatal: true},
+			}, failed: true}}}
+
+			rec := httptest.NewRecorder()
+			a.healthCheckHandler(rec, httptest.NewRequest("GET", "/health-check", nil))
+
+			Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+			Expect(rec.Body.String()).To(ContainSubstring(`"status":"failed"`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"error":"timeout"`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"redis":{"name":"redis","status":"ok"`))
+		})
+	})
+
+	Describe("toReleaseV1", func() {
+		It("should keep Apple Music out of the frozen v1 preview links", func() {
+			link := func(s string) *string { return &s }
+
+			b, err := json.Marshal(toReleaseV1(&release.ReleaseResponse{
+				PreviewLinks: release.PreviewLinks{
+					Spotify:    link("https://open.spotify.com/album/x"),
+					AppleMusic: link("https://music.apple.com/us/album/1"),
+				},
+			}))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(string(b)).To(ContainSubstring(`"previewLinks":{"spotify":"https://open.spotify.com/album/x"}`))
+			Expect(string(b)).ToNot(ContainSubstring("appleMusic"))
+		})
+	})
+
+	Describe("releasesHandler", func() {
+		newAPI := func(releases release.IRelease) *API {
+			return &API{
+				config: &config.Config{},
+				deps:   &deps.Dependencies{ReleaseService: releases},
+				log:    clog.New(zap.NewNop()),
+			}
+		}
+
+		It("should only reject included and excluded genres that can't match anything", func() {
+			genres := "includedGenres=doom&includedGenres=sludge&excludedGenres=sludge"
+
+			cases := map[string]int{
+				genres:                              http.StatusBadRequest,
+				genres + "&includedGenresMatch=any": http.StatusOK,
+				genres + "&excludedGenres=DOOM&includedGenresMatch=any": http.StatusBadRequest,
+			}
+
+			for query, code := range cases {
+				rec := httptest.NewRecorder()
+				newAPI(&fakeReleases{result: &release.ReleasesResult{}}).releasesHandler(rec,
+					httptest.NewRequest("GET", "/api/releases?"+query, nil))
+
+				Expect(rec.Code).To(Equal(code), query)
+			}
+		})
+
+		It("should return an empty array when nothing matches", func() {
+			for _, accept := range []string{"application/json", "application/vnd.blastbeat.v2+json"} {
+				a := newAPI(&fakeReleases{result: &release.ReleasesResult{}})
+
+				r := httptest.NewRequest("GET", "/api/releases", nil)
+				r.Header.Set("Accept", accept)
+
+				rec := httptest.NewRecorder()
+				a.releasesHandler(rec, r)
+
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(strings.TrimSpace(rec.Body.String())).To(Equal("[]"), accept)
+			}
+		})
+
+		It("should report the pre-pagination total in the envelope", func() {
+			a := newAPI(&fakeReleases{result: &release.ReleasesResult{
+				Releases: []*release.ReleaseResponse{{ID: "a"}, {ID: "b"}},
+				Total:    42,
+			}})
+
+			rec := httptest.NewRecorder()
+			a.releasesHandler(rec, httptest.NewRequest("GET", "/api/releases?envelope=true&limit=2&offset=4", nil))
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"meta":{"total":42,"limit":2,"offset":4}`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"next":"/api/releases?envelope=true\u0026limit=2\u0026offset=6"`))
+		})
+
+		It("should keep the bare array by default", func() {
+			a := newAPI(&fakeReleases{result: &release.ReleasesResult{
+				Releases: []*release.ReleaseResponse{{ID: "a"}},
+				Total:    42,
+			}})
+
+			rec := httptest.NewRecorder()
+			a.releasesHandler(rec, httptest.NewRequest("GET", "/api/releases?limit=1", nil))
+
+			Expect(rec.Body.String()).To(HavePrefix("["))
+			Expect(rec.Body.String()).ToNot(ContainSubstring(`"total"`))
+		})
+
+		It("should reject unknown hasPreview values", func() {
+			a := newAPI(&fakeReleases{result: &release.ReleasesResult{}})
+
+			rec := httptest.NewRecorder()
+			a.releasesHandler(rec, httptest.NewRequest("GET", "/api/releases?hasPreview=soundcloud", nil))
+
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should return an error when the fetch fails", func() {
+			a := newAPI(&fakeReleases{err: errors.New("connection refused")})
+
+			rec := httptest.NewRecorder()
+			a.releasesHandler(rec, httptest.NewRequest("GET", "/api/releases", nil))
+
+			Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+			Expect(rec.Body.String()).To(ContainSubstring(`"error":"Failed to fetch releases"`))
+		})
+	})
+
+	Describe("updateReleaseHandler", func() {
+		withID := func(r *http.Request, id string) *http.Request {
+			return r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey,
+				httprouter.Params{{Key: "id", Value: id}}))
+		}
+
+		newAPI := func(releases release.IRelease) *API {
+			return &API{
+				config: &config.Config{},
+				deps:   &deps.Dependencies{ReleaseService: releases},
+				log:    clog.New(zap.NewNop()),
+			}
+		}
+
+		It("should return the updated release", func() {
+			fake := &fakeReleases{updated: &release.ReleaseResponse{ID: "abc", Title: "Fixed"}}
+
+			r := withID(httptest.NewRequest("PUT", "/api/releases/abc", strings.NewReader(`{"title":"Fixed"}`)), "abc")
+
+			rec := httptest.NewRecorder()
+			newAPI(fake).updateReleaseHandler(rec, r)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"title":"Fixed"`))
+			Expect(*fake.update.Title).To(Equal("Fixed"))
+			Expect(fake.update.Country).To(BeNil())
+		})
+
+		It("should reject unknown fields and invalid values", func() {
+			for _, body := range []string{`{"name":"x"}`, `{"title":""}`, `not json`} {
+				r := withID(httptest.NewRequest("PUT", "/api/releases/abc", strings.NewReader(body)), "abc")
+
+				rec := httptest.NewRecorder()
+				newAPI(&fakeReleases{}).updateReleaseHandler(rec, r)
+
+				Expect(rec.Code).To(Equal(http.StatusBadRequest), body)
+			}
+		})
+
+		It("should 404 on an unknown release", func() {
+			r := withID(httptest.NewRequest("PUT", "/api/releases/abc", strings.NewReader(`{}`)), "abc")
+
+			rec := httptest.NewRecorder()
+			newAPI(&fakeReleases{err: release.ErrNotFound}).updateReleaseHandler(rec, r)
+
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should 409 when the update duplicates another release", func() {
+			r := withID(httptest.NewRequest("PUT", "/api/releases/abc", strings.NewReader(`{"title":"Taken"}`)), "abc")
+
+			rec := httptest.NewRecorder()
+			newAPI(&fakeReleases{err: release.ErrConflict}).updateReleaseHandler(rec, r)
+
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+		})
+
+		It("should delete a release", func() {
+			r := withID(httptest.NewRequest("DELETE", "/api/releases/abc", nil), "abc")
+
+			rec := httptest.NewRecorder()
+			newAPI(&fakeReleases{}).deleteReleaseHandler(rec, r)
+
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+			rec = httptest.NewRecorder()
+			newAPI(&fakeReleases{err: release.ErrNotFound}).deleteReleaseHandler(rec, r)
+
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("releaseHandler", func() {
+		withID := func(r *http.Request, id string) *http.Request {
+			return r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey,
+				httprouter.Params{{Key: "id", Value: id}}))
+		}
+
+		newAPI := func(releases release.IRelease, refresh linkrefresh.ILinkRefresh) *API {
+			return &API{
+				config: &config.Config{},
+				deps:   &deps.Dependencies{ReleaseService: releases, LinkRefresh: refresh},
+				log:    clog.New(zap.NewNop()),
+			}
+		}
+
+		It("should trigger a link refresh for the release", func() {
+			id := uuid.New()
+			refresh := &fakeLinkRefresh{}
+
+			r := withID(httptest.NewRequest("GET", "/api/releases/"+id.String(), nil), id.String())
+
+			rec := httptest.NewRecorder()
+			newAPI(&fakeReleases{release: &release.ReleaseResponse{ID: id.String()}}, refresh).releaseHandler(rec, r)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(refresh.triggered).To(Equal([]uuid.UUID{id}))
+		})
+
+		It("should not trigger a refresh for an unknown release", func() {
+			id := uuid.New().String()
+			refresh := &fakeLinkRefresh{}
+
+			r := withID(httptest.NewRequest("GET", "/api/releases/"+id, nil), id)
+
+			rec := httptest.NewRecorder()
+			newAPI(&fakeReleases{err: release.ErrNotFound}, refresh).releaseHandler(rec, r)
+
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(refresh.triggered).To(BeEmpty())
+		})
+	})
+
+	Describe("favorite handlers", func() {
+		const token = "0123456789abcdef0123"
+
+		var (
+			fake *fakeFavorites
+			a    *API
+		)
+
+		withReleaseID := func(r *http.Request, id string) *http.Request {
+			r.Header.Set(FavoritesTokenHeader, token)
+
+			return r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey,
+				httprouter.Params{{Key: "releaseId", Value: id}}))
+		}
+
+		BeforeEach(func() {
+			fake = &fakeFavorites{}
+			a = &API{
+				config: &config.Config{},
+				deps:   &deps.Dependencies{FavoriteService: fake},
+				log:    clog.New(zap.NewNop()),
+			}
+		})
+
+		It("should list the token's favorites", func() {
+			id := uuid.New()
+			fake.ids = []uuid.UUID{id}
+
+			r := httptest.NewRequest("GET", "/api/favorites", nil)
+			r.Header.Set(FavoritesTokenHeader, token)
+
+			rec := httptest.NewRecorder()
+			a.listFavoritesHandler(rec, r)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(`["` + id.String() + `"]`))
+			Expect(fake.token).To(Equal(token))
+		})
+
+		It("should list no favorites as an empty array", func() {
+			rec := httptest.NewRecorder()
+			a.listFavoritesHandler(rec, withReleaseID(httptest.NewRequest("GET", "/api/favorites", nil), ""))
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(`[]`))
+		})
+
+		It("should add and remove a favorite", func() {
+			id := uuid.New()
+
+			rec := httptest.NewRecorder()
+			a.addFavoriteHandler(rec, withReleaseID(httptest.NewRequest("PUT", "/api/favorites/"+id.String(), nil), id.String()))
+
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			Expect(fake.added).To(Equal([]uuid.UUID{id}))
+			Expect(fake.token).To(Equal(token))
+
+			rec = httptest.NewRecorder()
+			a.removeFavoriteHandler(rec, withReleaseID(httptest.NewRequest("DELETE", "/api/favorites/"+id.String(), nil), id.String()))
+
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			Expect(fake.removed).To(Equal([]uuid.UUID{id}))
+		})
+
+		It("should reject an invalid release id", func() {
+			rec := httptest.NewRecorder()
+			a.addFavoriteHandler(rec, withReleaseID(httptest.NewRequest("PUT", "/api/favorites/nope", nil), "nope"))
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+			rec = httptest.NewRecorder()
+			a.removeFavoriteHandler(rec, withReleaseID(httptest.NewRequest("DELETE", "/api/favorites/nope", nil), "nope"))
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+			Expect(fake.added).To(BeEmpty())
+			Expect(fake.removed).To(BeEmpty())
+		})
+
+		It("should map service errors to status codes", func() {
+			cases := map[error]int{
+				favorite.ErrInvalidToken:       http.StatusBadRequest,
+				favorite.ErrReleaseNotFound:    http.StatusNotFound,
+				favorite.ErrTooManyFavorites:   http.StatusConflict,
+				errors.New("connection reset"): http.StatusInternalServerError,
+			}
+
+			id := uuid.New().String()
+
+			for err, code := range cases {
+				fake.err = err
+
+				rec := httptest.NewRecorder()
+				a.addFavoriteHandler(rec, withReleaseID(httptest.NewRequest("PUT", "/api/favorites/"+id, nil), id))
+				Expect(rec.Code).To(Equal(code), err.Error())
+
+				rec = httptest.NewRecorder()
+				a.listFavoritesHandler(rec, withReleaseID(httptest.NewRequest("GET", "/api/favorites", nil), ""))
+				Expect(rec.Code).To(Equal(code), err.Error())
+			}
+		})
+	})
+
+	Describe("statsHandler", func() {
+		It("should serve a cached response without querying the database", func() {
+			c := cache.New()
+			c.Set(statsCacheKey, &StatsResponse{
+				TotalReleases: 7,
+				TopGenres:     []GenreCountResponse{{Name: "doom metal", Count: 3}},
+			}, StatsCacheTTL)
+
+			a := &API{deps: &deps.Dependencies{Cache: c}, log: clog.New(zap.NewNop())}
+
+			rec := httptest.NewRecorder()
+			a.statsHandler(rec, httptest.NewRequest("GET", "/api/stats", nil))
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"totalReleases":7`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"topGenres":[{"name":"doom metal","count":3}]`))
+		})
+	})
+
+	Describe("enrichmentStatsHandler", func() {
+		It("should turn coverage counts into percentages", func() {
+			stats := enrichmentStats(gensql.GetEnrichmentCoverageRow{
+				TotalReleases: 3,
+				WithCountry:   3,
+				WithArt:       2,
+				WithSpotify:   1,
+			}, 2)
+
+			Expect(stats.MinGenres).To(Equal(2))
+			Expect(stats.Country).To(Equal(CoverageResponse{Count: 3, Percent: 100}))
+			Expect(stats.Art).To(Equal(CoverageResponse{Count: 2, Percent: 66.7}))
+			Expect(stats.SpotifyPreview).To(Equal(CoverageResponse{Count: 1, Percent: 33.3}))
+			Expect(stats.Genres).To(Equal(CoverageResponse{}))
+		})
+
+		It("should report an empty catalog as 0% covered", func() {
+			Expect(enrichmentStats(gensql.GetEnrichmentCoverageRow{}, 2).Art.Percent).To(BeZero())
+		})
+
+		It("should serve a cached response per minGenres", func() {
+			c := cache.New()
+			c.Set(coverageCacheKeyPrefix+"3", &EnrichmentStatsResponse{TotalReleases: 9, MinGenres: 3}, StatsCacheTTL)
+
+			a := &API{deps: &deps.Dependencies{Cache: c}, log: clog.New(zap.NewNop())}
+
+			rec := httptest.NewRecorder()
+			a.enrichmentStatsHandler(rec, httptest.NewRequest("GET", "/api/stats/enrichment?minGenres=3", nil))
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"totalReleases":9`))
+		})
+
+		It("should reject an invalid minGenres", func() {
+			a := &API{config: &config.Config{}, deps: &deps.Dependencies{Cache: cache.New()}, log: clog.New(zap.NewNop())}
+
+			rec := httptest.NewRecorder()
+			a.enrichmentStatsHandler(rec, httptest.NewRequest("GET", "/api/stats/enrichment?minGenres=-1", nil))
+
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+			rec = httptest.NewRecorder()
+			a.enrichmentStatsHandler(rec, httptest.NewRequest("GET", "/api/stats/enrichment?minGenres=21", nil))
+
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
 	//Describe("HealthCheckHandler", func() {
 	//	Context("when the request is successful", func() {
 	//		It("should return 200", func() {
@@ -36,3 +707,92 @@ var _ = Describe("API", func() {
 	//	})
 	//})
 })
+
+// fakeHealth is a health.IHealth with fixed state
+type fakeHealth struct {
+	health.IHealth
+
+	states map[string]health.State
+	failed bool
+}
+
+func (f *fakeHealth) State() (map[string]health.State, bool, error) {
+	return f.states, f.failed, nil
+}
+
+func (f *fakeHealth) Failed() bool {
+	return f.failed
+}
+
+// fakeReleases is a release.IRelease whose calls return result/release/updated
+// and err; UpdateRelease records the update it was given
+type fakeReleases struct {
+	release.IRelease
+
+	result  *release.ReleasesResult
+	release *release.ReleaseResponse
+	updated *release.ReleaseResponse
+	update  *release.ReleaseUpdate
+	err     error
+}
+
+func (f *fakeReleases) GetReleases(_ context.Context, _ *release.ReleaseFilters) (*release.ReleasesResult, error) {
+	return f.result, f.err
+}
+
+func (f *fakeReleases) GetReleaseByID(_ context.Context, _ string) (*release.ReleaseResponse, error) {
+	return f.release, f.err
+}
+
+func (f *fakeReleases) UpdateRelease(_ context.Context, _ string, update *release.ReleaseUpdate) (*release.ReleaseResponse, error) {
+	f.update = update
+
+	return f.updated, f.err
+}
+
+func (f *fakeReleases) DeleteRelease(_ context.Context, _ string) error {
+	return f.err
+}
+
+// fakeLinkRefresh is a linkrefresh.ILinkRefresh that records triggers
+type fakeLinkRefresh struct {
+	triggered []uuid.UUID
+}
+
+func (f *fakeLinkRefresh) Trigger(releaseID uuid.UUID) {
+	f.triggered = append(f.triggered, releaseID)
+}
+
+// fakeFavorites is a favorite.IFavorite that records the token and release
+// ids it was given and returns ids and err
+type fakeFavorites struct {
+	token   string
+	added   []uuid.UUID
+	removed []uuid.UUID
+	ids     []uuid.UUID
+	err     error
+}
+
+func (f *fakeFavorites) Add(_ context.Context, token string, releaseID uuid.UUID) error {
+	f.token = token
+	if f.err == nil {
+		f.added = append(f.added, releaseID)
+	}
+
+	return f.err
+}
+
+func (f *fakeFavorites) Remove(_ context.Context, token string, releaseID uuid.UUID) error {
+	f.token = token
+	if f.err == nil {
+		f.removed = append(f.removed, releaseID)
+	}
+
+	return f.err
+}
+
+func (f *fakeFavorites) List(_ context.Context, token string) ([]uuid.UUID, error) {
+	f.token = token
+
+	return f.ids, f.err
+}
@@ -4,20 +4,35 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/InVisionApp/go-health"
 	"go.uber.org/zap"
 )
 
+// healthCheckResponse is the /health-check body; Components is keyed by
+// check name
+type healthCheckResponse struct {
+	Status     string                  `json:"status"`
+	Components map[string]health.State `json:"components"`
+}
+
+// healthCheckHandler reports every health check's state; the status code is
+// 500 when a fatal check is failing
 func (a *API) healthCheckHandler(wr http.ResponseWriter, r *http.Request) {
+	states, failed, err := a.deps.Health.State()
+	if err != nil {
+		a.writeError(wr, http.StatusInternalServerError, "Unable to read health state")
+		return
+	}
+
 	status := http.StatusOK
-	body := "ok"
+	body := healthCheckResponse{Status: "ok", Components: states}
 
-	if a.deps.Health.Failed() {
+	if failed {
 		status = http.StatusInternalServerError
-		body = "failed"
+		body.Status = "failed"
 	}
 
-	wr.WriteHeader(status)
-	wr.Write([]byte(body))
+	WriteJSON(wr, body, status)
 }
 
 func (a *API) versionHandler(rw http.ResponseWriter, r *http.Request) {
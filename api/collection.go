@@ -0,0 +1,140 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EnvelopeHeader opts a request into the collection envelope; the
+	// "envelope" query param does the same for clients that can't set headers
+	EnvelopeHeader = "X-Response-Envelope"
+
+	// DefaultPageLimit is used when no "limit" query param is given and
+	// Config.DefaultPageLimit is unset
+	DefaultPageLimit = 50
+
+	// MaxPageLimit caps the "limit" query param when Config.MaxPageLimit is
+	// unset
+	MaxPageLimit = 200
+)
+
+// collectionResponse is the optional {data, meta, links} envelope for
+// collection endpoints
+type collectionResponse struct {
+	Data  interface{}     `json:"data"`
+	Meta  collectionMeta  `json:"meta"`
+	Links collectionLinks `json:"links"`
+}
+
+type collectionMeta struct {
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+type collectionLinks struct {
+	Next *string `json:"next"`
+	Prev *string `json:"prev"`
+}
+
+// wantsEnvelope reports whether the client asked for the collection envelope
+func wantsEnvelope(r *http.Request) bool {
+	if v := r.URL.Query().Get("envelope"); v != "" {
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+
+	b, _ := strconv.ParseBool(strings.TrimSpace(r.Header.Get(EnvelopeHeader)))
+
+	return b
+}
+
+// pageLimits returns the configured default and max page sizes every list
+// endpoint paginates with
+func (a *API) pageLimits() (int, int) {
+	defaultLimit, maxLimit := DefaultPageLimit, MaxPageLimit
+
+	if a.config.DefaultPageLimit > 0 {
+		defaultLimit = a.config.DefaultPageLimit
+	}
+
+	if a.config.MaxPageLimit > 0 {
+		maxLimit = a.config.MaxPageLimit
+	}
+
+	return defaultLimit, maxLimit
+}
+
+// parsePagination reads the "limit" and "offset" query params; a missing
+// limit means defaultLimit and limits above maxLimit are rejected
+func parsePagination(r *http.Request, defaultLimit, maxLimit int) (int, int, error) {
+	limit, offset := defaultLimit, 0
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxLimit {
+			return 0, 0, errInvalidParam("limit")
+		}
+		limit = v
+	}
+
+	if s := r.URL.Query().Get("offset"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return 0, 0, errInvalidParam("offset")
+		}
+		offset = v
+	}
+
+	return limit, offset, nil
+}
+
+type errInvalidParam string
+
+func (e errInvalidParam) Error() string {
+	return "Invalid " + string(e) + " parameter"
+}
+
+// newCollectionResponse wraps data with paging meta and next/prev links
+// built from the request URL; links are absolute when baseURL is set
+func newCollectionResponse(r *http.Request, baseURL string, data interface{}, total, limit, offset int) *collectionResponse {
+	resp := &collectionResponse{
+		Data: data,
+		Meta: collectionMeta{
+			Total:  total,
+			Limit:  limit,
+			Offset: offset,
+		},
+	}
+
+	if limit <= 0 {
+		return resp
+	}
+
+	if offset+limit < total {
+		resp.Links.Next = pageLink(r.URL, baseURL, limit, offset+limit)
+	}
+
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		resp.Links.Prev = pageLink(r.URL, baseURL, limit, prev)
+	}
+
+	return resp
+}
+
+func pageLink(u *url.URL, baseURL string, limit, offset int) *string {
+	q := u.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+
+	link := joinBaseURL(baseURL, u.Path+"?"+q.Encode())
+
+	return &link
+}
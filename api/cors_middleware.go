@@ -16,7 +16,8 @@ func (a *API) corsMiddleware(next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Authorization, "+AdminTokenHeader+", "+EnvelopeHeader+", "+FavoritesTokenHeader)
+			w.Header().Set("Access-Control-Expose-Headers", ResultsTruncatedHeader+", "+APIVersionHeader)
 			w.Header().Set("Access-Control-Max-Age", "43200") // 12 hours
 		}
 
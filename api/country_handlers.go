@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/services/release"
+)
+
+type CountryResponse struct {
+	Code  string `json:"code"`
+	Count int64  `json:"count"`
+}
+
+func (a *API) countriesHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "countriesHandler"))
+	logger.Info("handling /api/countries request", zap.String("remoteAddr", r.RemoteAddr))
+
+	// limit/offset are optional; without a limit every country is returned
+	_, maxLimit := a.pageLimits()
+
+	limit, offset, err := parsePagination(r, 0, maxLimit)
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Country codes with their release counts, most releases first
+	dbCountries, err := a.deps.DBBackend.ListCountries(r.Context())
+	if err != nil {
+		logger.Error("Failed to fetch countries", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch countries")
+		return
+	}
+
+	countries := make([]CountryResponse, 0, len(dbCountries))
+
+	for _, dbCountry := range dbCountries {
+		countries = append(countries, CountryResponse{
+			Code:  dbCountry.Code,
+			Count: dbCountry.ReleaseCount,
+		})
+	}
+
+	total := len(countries)
+	countries = release.Paginate(countries, limit, offset)
+
+	var payload interface{} = countries
+	if wantsEnvelope(r) {
+		payload = newCollectionResponse(r, a.config.AppBaseURL, countries, total, limit, offset)
+	}
+
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	rw.Header().Add("Vary", EnvelopeHeader)
+	rw.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(rw).Encode(payload); err != nil {
+		logger.Error("Failed to encode countries response", zap.Error(err))
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/services/favorite"
+)
+
+const (
+	// FavoritesTokenHeader carries the client's opaque favorites token
+	FavoritesTokenHeader = "X-Favorites-Token"
+)
+
+func (a *API) listFavoritesHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "listFavoritesHandler"))
+	logger.Info("handling /api/favorites request", zap.String("remoteAddr", r.RemoteAddr))
+
+	ids, err := a.deps.FavoriteService.List(r.Context(), r.Header.Get(FavoritesTokenHeader))
+	if err != nil {
+		a.writeFavoriteError(rw, logger, err)
+		return
+	}
+
+	releaseIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		releaseIDs = append(releaseIDs, id.String())
+	}
+
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	rw.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(rw).Encode(releaseIDs); err != nil {
+		logger.Error("Failed to encode favorites response", zap.Error(err))
+	}
+}
+
+func (a *API) addFavoriteHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "addFavoriteHandler"))
+	logger.Info("handling PUT /api/favorites request", zap.String("remoteAddr", r.RemoteAddr))
+
+	releaseID, err := uuid.Parse(httprouter.ParamsFromContext(r.Context()).ByName("releaseId"))
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, "Invalid release id")
+		return
+	}
+
+	if err := a.deps.FavoriteService.Add(r.Context(), r.Header.Get(FavoritesTokenHeader), releaseID); err != nil {
+		a.writeFavoriteError(rw, logger, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) removeFavoriteHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "removeFavoriteHandler"))
+	logger.Info("handling DELETE /api/favorites request", zap.String("remoteAddr", r.RemoteAddr))
+
+	releaseID, err := uuid.Parse(httprouter.ParamsFromContext(r.Context()).ByName("releaseId"))
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, "Invalid release id")
+		return
+	}
+
+	if err := a.deps.FavoriteService.Remove(r.Context(), r.Header.Get(FavoritesTokenHeader), releaseID); err != nil {
+		a.writeFavoriteError(rw, logger, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// writeFavoriteError maps favorite service errors to HTTP responses
+func (a *API) writeFavoriteError(rw http.ResponseWriter, logger clog.ICustomLog, err error) {
+	switch {
+	case errors.Is(err, favorite.ErrInvalidToken):
+		a.writeError(rw, http.StatusBadRequest, "Missing or invalid "+FavoritesTokenHeader+" header")
+	case errors.Is(err, favorite.ErrReleaseNotFound):
+		a.writeError(rw, http.StatusNotFound, "Release not found")
+	case errors.Is(err, favorite.ErrTooManyFavorites):
+		a.writeError(rw, http.StatusConflict, "Too many favorites")
+	default:
+		logger.Error("Favorites request failed", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to update favorites")
+	}
+}
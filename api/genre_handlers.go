@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/services/release"
 )
 
 type GenreResponse struct {
@@ -17,6 +19,15 @@ func (a *API) genresHandler(rw http.ResponseWriter, r *http.Request) {
 	logger := a.log.With(zap.String("method", "genresHandler"))
 	logger.Info("handling /api/genres request", zap.String("remoteAddr", r.RemoteAddr))
 
+	// limit/offset are optional; without a limit every genre is returned
+	_, maxLimit := a.pageLimits()
+
+	limit, offset, err := parsePagination(r, 0, maxLimit)
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Fetch genres directly from database
 	dbGenres, err := a.deps.DBBackend.ListGenres(r.Context())
 	if err != nil {
@@ -36,11 +47,20 @@ func (a *API) genresHandler(rw http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	total := len(genres)
+	genres = release.Paginate(genres, limit, offset)
+
+	var payload interface{} = genres
+	if wantsEnvelope(r) {
+		payload = newCollectionResponse(r, a.config.AppBaseURL, genres, total, limit, offset)
+	}
+
 	// Write response
 	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	rw.Header().Add("Vary", EnvelopeHeader)
 	rw.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(rw).Encode(genres); err != nil {
+	if err := json.NewEncoder(rw).Encode(payload); err != nil {
 		logger.Error("Failed to encode genres response", zap.Error(err))
 	}
 }
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/services/release"
+)
+
+type LabelResponse struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+func (a *API) labelsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "labelsHandler"))
+	logger.Info("handling /api/labels request", zap.String("remoteAddr", r.RemoteAddr))
+
+	// limit/offset are optional; without a limit every label is returned
+	_, maxLimit := a.pageLimits()
+
+	limit, offset, err := parsePagination(r, 0, maxLimit)
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Distinct labels with their release counts, most releases first
+	dbLabels, err := a.deps.DBBackend.ListLabels(r.Context())
+	if err != nil {
+		logger.Error("Failed to fetch labels", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch labels")
+		return
+	}
+
+	labels := make([]LabelResponse, 0, len(dbLabels))
+
+	for _, dbLabel := range dbLabels {
+		labels = append(labels, LabelResponse{
+			Name:  dbLabel.Name,
+			Count: dbLabel.ReleaseCount,
+		})
+	}
+
+	total := len(labels)
+	labels = release.Paginate(labels, limit, offset)
+
+	var payload interface{} = labels
+	if wantsEnvelope(r) {
+		payload = newCollectionResponse(r, a.config.AppBaseURL, labels, total, limit, offset)
+	}
+
+	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	rw.Header().Add("Vary", EnvelopeHeader)
+	rw.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(rw).Encode(payload); err != nil {
+		logger.Error("Failed to encode labels response", zap.Error(err))
+	}
+}
@@ -0,0 +1,19 @@
+package api
+
+import "strings"
+
+// absoluteURL joins path onto the configured AppBaseURL. All absolute links
+// the API generates (sitemap, collection links, ...) must go through here so
+// they point at the public site rather than whatever host served the request.
+func (a *API) absoluteURL(path string) string {
+	return joinBaseURL(a.config.AppBaseURL, path)
+}
+
+// joinBaseURL returns path unchanged when baseURL is empty
+func joinBaseURL(baseURL, path string) string {
+	if baseURL == "" {
+		return path
+	}
+
+	return strings.TrimRight(baseURL, "/") + path
+}
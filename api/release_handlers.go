@@ -2,24 +2,60 @@ package api
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
 	"github.com/dselans/blastbeat-api/services/release"
 )
 
+const (
+	// ResultsTruncatedHeader is set when a list query hit the server-side
+	// max results cap
+	ResultsTruncatedHeader = "X-Results-Truncated"
+
+	// MaxBatchIDs caps the number of ids accepted by ?ids=
+	MaxBatchIDs = 100
+)
+
 func (a *API) releasesHandler(rw http.ResponseWriter, r *http.Request) {
 	logger := a.log.With(zap.String("method", "releasesHandler"))
 	logger.Info("handling /api/releases request", zap.String("remoteAddr", r.RemoteAddr))
 
+	version, negotiated, err := requestedAPIVersion(r)
+	if err != nil {
+		a.writeError(rw, http.StatusNotAcceptable, err.Error())
+		return
+	}
+
 	// Parse query parameters
 	filters := &release.ReleaseFilters{}
 
+	// ids (batch fetch, returned in request order)
+	if idsParam := r.URL.Query()["ids"]; len(idsParam) > 0 {
+		ids, err := parseIDs(idsParam)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+		filters.IDs = ids
+	}
+
+	// q (accent-insensitive artist/title search)
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		filters.Query = q
+	}
+
 	// dateExact (takes precedence over dateFrom/dateTo)
 	if dateExactStr := r.URL.Query().Get("dateExact"); dateExactStr != "" {
-		dateExact, err := time.Parse("2006-01-02", dateExactStr)
+		dateExact, err := time.Parse(release.DateFormat, dateExactStr)
 		if err != nil {
 			a.writeError(rw, http.StatusBadRequest, "Invalid dateExact parameter")
 			return
@@ -28,7 +64,7 @@ func (a *API) releasesHandler(rw http.ResponseWriter, r *http.Request) {
 	} else {
 		// dateFrom
 		if dateFromStr := r.URL.Query().Get("dateFrom"); dateFromStr != "" {
-			dateFrom, err := time.Parse("2006-01-02", dateFromStr)
+			dateFrom, err := time.Parse(release.DateFormat, dateFromStr)
 			if err != nil {
 				a.writeError(rw, http.StatusBadRequest, "Invalid dateFrom parameter")
 				return
@@ -37,7 +73,7 @@ func (a *API) releasesHandler(rw http.ResponseWriter, r *http.Request) {
 		}
 
 		if dateToStr := r.URL.Query().Get("dateTo"); dateToStr != "" {
-			dateTo, err := time.Parse("2006-01-02", dateToStr)
+			dateTo, err := time.Parse(release.DateFormat, dateToStr)
 			if err != nil {
 				a.writeError(rw, http.StatusBadRequest, "Invalid dateTo parameter")
 				return
@@ -52,40 +88,432 @@ func (a *API) releasesHandler(rw http.ResponseWriter, r *http.Request) {
 		filters.IncludedGenres = includedGenres
 	}
 
+	// includedGenresMatch: "all" (default) keeps releases tagged with every
+	// included genre, "any" keeps releases tagged with at least one of them
+	switch m := strings.ToLower(r.URL.Query().Get("includedGenresMatch")); m {
+	case "", release.GenreMatchAll, release.GenreMatchAny:
+		filters.IncludedGenresMatch = m
+	default:
+		a.writeError(rw, http.StatusBadRequest, "Invalid includedGenresMatch parameter (expected all or any)")
+		return
+	}
+
+	// hasPreview (spotify, youtube, bandcamp, apple_music or any)
+	if v := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("hasPreview"))); v != "" {
+		if !release.ValidPreview(v) {
+			a.writeError(rw, http.StatusBadRequest,
+				"Invalid hasPreview parameter (expected spotify, youtube, bandcamp, apple_music or any)")
+			return
+		}
+		filters.HasPreview = v
+	}
+
 	// excludedGenres
 	excludedGenres := r.URL.Query()["excludedGenres"]
 	if len(excludedGenres) > 0 {
 		filters.ExcludedGenres = excludedGenres
 	}
 
+	// A genre that is both included and excluded can never match anything
+	// when every included genre is required; with "any" the query is only
+	// empty once every included genre is excluded
+	conflicts := conflictingGenres(filters.IncludedGenres, filters.ExcludedGenres)
+	if len(conflicts) > 0 && (filters.IncludedGenresMatch != release.GenreMatchAny ||
+		len(conflicts) == len(filters.IncludedGenres)) {
+		a.writeError(rw, http.StatusBadRequest,
+			"Genres cannot be both included and excluded: "+
+				strings.Join(conflicts, ", "))
+		return
+	}
+
+	// includedCountries/excludedCountries (ISO codes, e.g. SE,NO)
+	if v := r.URL.Query()["includedCountries"]; len(v) > 0 {
+		countries, err := parseCountries(v)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, "Invalid includedCountries parameter: "+err.Error())
+			return
+		}
+		filters.IncludedCountries = countries
+	}
+
+	if v := r.URL.Query()["excludedCountries"]; len(v) > 0 {
+		countries, err := parseCountries(v)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, "Invalid excludedCountries parameter: "+err.Error())
+			return
+		}
+		filters.ExcludedCountries = countries
+	}
+
+	// label (substring match; repeated params are OR'd)
+	for _, label := range r.URL.Query()["label"] {
+		if label = strings.TrimSpace(label); label != "" {
+			filters.Labels = append(filters.Labels, label)
+		}
+	}
+
+	// excludedLabels (substring match; any match drops the release)
+	for _, label := range r.URL.Query()["excludedLabels"] {
+		if label = strings.TrimSpace(label); label != "" {
+			filters.ExcludedLabels = append(filters.ExcludedLabels, label)
+		}
+	}
+
 	// excludedKeywords
 	excludedKeywords := r.URL.Query()["excludedKeywords"]
 	if len(excludedKeywords) > 0 {
 		filters.ExcludedKeywords = excludedKeywords
 	}
 
+	// keywordFields (title, artist and/or label; default title and artist)
+	if v := r.URL.Query()["keywordFields"]; len(v) > 0 {
+		fields, err := parseKeywordFields(v)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, "Invalid keywordFields parameter: "+err.Error())
+			return
+		}
+		filters.KeywordFields = fields
+	}
+
+	// minGenres
+	if minGenresStr := r.URL.Query().Get("minGenres"); minGenresStr != "" {
+		minGenres, err := parseGenreCount(minGenresStr)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, "Invalid minGenres parameter")
+			return
+		}
+		filters.MinGenres = &minGenres
+	}
+
+	// maxGenres
+	if maxGenresStr := r.URL.Query().Get("maxGenres"); maxGenresStr != "" {
+		maxGenres, err := parseGenreCount(maxGenresStr)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, "Invalid maxGenres parameter")
+			return
+		}
+		filters.MaxGenres = &maxGenres
+	}
+
+	if filters.MinGenres != nil && filters.MaxGenres != nil &&
+		*filters.MinGenres > *filters.MaxGenres {
+		a.writeError(rw, http.StatusBadRequest, "minGenres cannot be greater than maxGenres")
+		return
+	}
+
+	// includeQuality
+	if includeQuality := r.URL.Query().Get("includeQuality"); includeQuality != "" {
+		v, err := strconv.ParseBool(includeQuality)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, "Invalid includeQuality parameter")
+			return
+		}
+		filters.IncludeQuality = v
+	}
+
 	// followerRange
 	if followerRange := r.URL.Query().Get("followerRange"); followerRange != "" {
 		filters.FollowerRange = followerRange
 	}
 
+	// followerMin/followerMax (take precedence over followerRange)
+	if s := r.URL.Query().Get("followerMin"); s != "" {
+		v, err := parseFollowerCount(s)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, "Invalid followerMin parameter")
+			return
+		}
+		filters.FollowerMin = &v
+	}
+
+	if s := r.URL.Query().Get("followerMax"); s != "" {
+		v, err := parseFollowerCount(s)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, "Invalid followerMax parameter")
+			return
+		}
+		filters.FollowerMax = &v
+	}
+
+	if filters.FollowerMin != nil && filters.FollowerMax != nil &&
+		*filters.FollowerMin > *filters.FollowerMax {
+		a.writeError(rw, http.StatusBadRequest, "followerMin cannot be greater than followerMax")
+		return
+	}
+
+	// sort, e.g. "followerCount:desc" or "title" (ascending)
+	if sortParam := r.URL.Query().Get("sort"); sortParam != "" {
+		sortBy, sortDir, err := parseSort(sortParam)
+		if err != nil {
+			a.writeError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+		filters.SortBy = sortBy
+		filters.SortDir = sortDir
+	}
+
+	// limit/offset; a batch fetch returns all requested ids by default
+	defaultLimit, maxLimit := a.pageLimits()
+	if len(filters.IDs) > 0 {
+		defaultLimit = MaxBatchIDs
+	}
+
+	limit, offset, err := parsePagination(r, defaultLimit, maxLimit)
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	filters.Limit = limit
+	filters.Offset = offset
+
 	// Fetch releases from service
-	releases, err := a.deps.ReleaseService.GetReleases(r.Context(), filters)
+	result, err := a.deps.ReleaseService.GetReleases(r.Context(), filters)
 	if err != nil {
 		logger.Error("Failed to fetch releases", zap.Error(err))
 		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch releases")
 		return
 	}
 
-	// Write response
-	rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if result.Truncated {
+		rw.Header().Set(ResultsTruncatedHeader, "true")
+	}
+
+	// Write response; clients that negotiated via Accept get the vendor
+	// media type back, everyone else keeps getting plain JSON
+	contentType := "application/json; charset=UTF-8"
+	if negotiated {
+		contentType = vendorMediaType(version) + "; charset=UTF-8"
+	}
+
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set(APIVersionHeader, strconv.Itoa(version))
+	rw.Header().Add("Vary", "Accept")
+	rw.Header().Add("Vary", EnvelopeHeader)
 	rw.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(rw).Encode(releases); err != nil {
+	var payload interface{} = versionedReleases(version, result.Releases)
+	if wantsEnvelope(r) {
+		payload = newCollectionResponse(r, a.config.AppBaseURL, payload, result.Total, limit, offset)
+	}
+
+	if err := json.NewEncoder(rw).Encode(payload); err != nil {
 		logger.Error("Failed to encode releases response", zap.Error(err))
 	}
 }
 
+// releaseHandler serves a single release by id (and dispatches
+// /api/releases/trending, see Run)
+func (a *API) releaseHandler(rw http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	if id == "trending" {
+		a.trendingReleasesHandler(rw, r)
+		return
+	}
+
+	logger := a.log.With(zap.String("method", "releaseHandler"))
+	logger.Info("handling /api/releases/:id request", zap.String("remoteAddr", r.RemoteAddr))
+
+	version, negotiated, err := requestedAPIVersion(r)
+	if err != nil {
+		a.writeError(rw, http.StatusNotAcceptable, err.Error())
+		return
+	}
+
+	rel, err := a.deps.ReleaseService.GetReleaseByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, release.ErrInvalidID):
+			a.writeError(rw, http.StatusBadRequest, "Invalid release id")
+		case errors.Is(err, release.ErrNotFound):
+			a.writeError(rw, http.StatusNotFound, "Release not found")
+		default:
+			logger.Error("Failed to fetch release", zap.Error(err))
+			a.writeError(rw, http.StatusInternalServerError, "Failed to fetch release")
+		}
+
+		return
+	}
+
+	// Repairs a dead YouTube link in the background, at most once per
+	// LinkRefreshIntervalHours per release
+	if a.deps.LinkRefresh != nil {
+		if releaseID, err := uuid.Parse(rel.ID); err == nil {
+			a.deps.LinkRefresh.Trigger(releaseID)
+		}
+	}
+
+	var payload interface{} = rel
+	if version == APIVersion1 {
+		payload = toReleaseV1(rel)
+	}
+
+	contentType := "application/json; charset=UTF-8"
+	if negotiated {
+		contentType = vendorMediaType(version) + "; charset=UTF-8"
+	}
+
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set(APIVersionHeader, strconv.Itoa(version))
+	rw.Header().Add("Vary", "Accept")
+	rw.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(rw).Encode(payload); err != nil {
+		logger.Error("Failed to encode release response", zap.Error(err))
+	}
+}
+
+// parseSort parses "key[:dir]"; dir defaults to ascending for title and
+// descending for everything else
+func parseSort(v string) (string, string, error) {
+	key, dir, hasDir := strings.Cut(strings.TrimSpace(v), ":")
+
+	if !release.ValidSortBy(key) {
+		return "", "", errors.Errorf("Invalid sort parameter: unknown key %q (expected %s, %s or %s)",
+			key, release.SortByReleaseDate, release.SortByFollowerCount, release.SortByTitle)
+	}
+
+	if !hasDir {
+		dir = release.SortDesc
+		if key == release.SortByTitle {
+			dir = release.SortAsc
+		}
+	}
+
+	dir = strings.ToLower(dir)
+	if dir != release.SortAsc && dir != release.SortDesc {
+		return "", "", errors.Errorf("Invalid sort parameter: unknown direction %q (expected asc or desc)", dir)
+	}
+
+	return key, dir, nil
+}
+
+// parseIDs parses comma-separated (and/or repeated) release ids, dropping
+// duplicates but keeping the first-seen order
+func parseIDs(values []string) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+
+	seen := make(map[uuid.UUID]bool)
+
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+
+			id, err := uuid.Parse(s)
+			if err != nil {
+				return nil, errors.Errorf("Invalid ids parameter: %s", s)
+			}
+
+			if seen[id] {
+				continue
+			}
+
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) > MaxBatchIDs {
+		return nil, errors.Errorf("Too many ids (max %d)", MaxBatchIDs)
+	}
+
+	return ids, nil
+}
+
+// parseCountries parses repeated (and/or comma-separated) two-letter country
+// codes into upper case
+func parseCountries(values []string) ([]string, error) {
+	var countries []string
+
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.ToUpper(strings.TrimSpace(s))
+			if s == "" {
+				continue
+			}
+
+			if len(s) != 2 || s[0] < 'A' || s[0] > 'Z' || s[1] < 'A' || s[1] > 'Z' {
+				return nil, errors.Errorf("%q is not a two-letter country code", s)
+			}
+
+			countries = append(countries, s)
+		}
+	}
+
+	return countries, nil
+}
+
+// parseKeywordFields parses repeated and/or comma-separated keyword fields
+func parseKeywordFields(values []string) ([]string, error) {
+	var fields []string
+
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.ToLower(strings.TrimSpace(s))
+			if s == "" {
+				continue
+			}
+
+			if !release.ValidKeywordField(s) {
+				return nil, errors.Errorf("unknown field %q (expected title, artist or label)", s)
+			}
+
+			fields = append(fields, s)
+		}
+	}
+
+	return fields, nil
+}
+
+// parseGenreCount parses a non-negative genre count
+func parseGenreCount(s string) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if v < 0 || v > math.MaxInt32 {
+		return 0, errors.Errorf("genre count out of range: %d", v)
+	}
+
+	return v, nil
+}
+
+// parseFollowerCount parses a non-negative follower count
+func parseFollowerCount(s string) (int64, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if v < 0 {
+		return 0, errors.Errorf("follower count out of range: %d", v)
+	}
+
+	return v, nil
+}
+
+// conflictingGenres returns the genres (case-insensitive) present in both
+// included and excluded
+func conflictingGenres(included, excluded []string) []string {
+	excludedMap := make(map[string]bool)
+	for _, genre := range excluded {
+		excludedMap[strings.ToLower(strings.TrimSpace(genre))] = true
+	}
+
+	var conflicts []string
+
+	for _, genre := range included {
+		if excludedMap[strings.ToLower(strings.TrimSpace(genre))] {
+			conflicts = append(conflicts, genre)
+		}
+	}
+
+	return conflicts
+}
+
 func (a *API) writeError(rw http.ResponseWriter, statusCode int, message string) {
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(statusCode)
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+const (
+	// MaxSitemapURLs is the sitemap protocol's per-file URL limit; larger
+	// catalogs are split and served behind a sitemap index
+	MaxSitemapURLs = 50000
+
+	sitemapXMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+func (a *API) robotsHandler(rw http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	b.WriteString("User-agent: *\n")
+	b.WriteString("Disallow: /api/admin/\n")
+	b.WriteString("Disallow: /debug/\n")
+
+	if a.config.AppBaseURL != "" {
+		b.WriteString("\nSitemap: " + a.absoluteURL("/sitemap.xml") + "\n")
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte(b.String()))
+}
+
+// sitemapHandler serves the release sitemap, or a sitemap index when the
+// catalog exceeds MaxSitemapURLs
+func (a *API) sitemapHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "sitemapHandler"))
+	logger.Info("handling /sitemap.xml request", zap.String("remoteAddr", r.RemoteAddr))
+
+	if a.config.AppBaseURL == "" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	total, err := a.deps.DBBackend.CountReleases(r.Context())
+	if err != nil {
+		logger.Error("Failed to count releases", zap.Error(err))
+		http.Error(rw, "Failed to generate sitemap", http.StatusInternalServerError)
+		return
+	}
+
+	if total <= MaxSitemapURLs {
+		a.writeSitemapPage(rw, r, logger, 1)
+		return
+	}
+
+	pages := int((total + MaxSitemapURLs - 1) / MaxSitemapURLs)
+	index := &sitemapIndex{XMLNS: sitemapXMLNS}
+
+	for i := 1; i <= pages; i++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc: a.absoluteURL("/sitemaps/" + strconv.Itoa(i) + ".xml"),
+		})
+	}
+
+	writeXML(rw, logger, index)
+}
+
+// sitemapPageHandler serves /sitemaps/N.xml (1-based)
+func (a *API) sitemapPageHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "sitemapPageHandler"))
+	logger.Info("handling /sitemaps request", zap.String("remoteAddr", r.RemoteAddr))
+
+	if a.config.AppBaseURL == "" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	file := httprouter.ParamsFromContext(r.Context()).ByName("file")
+
+	page, err := strconv.Atoi(strings.TrimSuffix(file, ".xml"))
+	if err != nil || page < 1 || !strings.HasSuffix(file, ".xml") {
+		http.NotFound(rw, r)
+		return
+	}
+
+	a.writeSitemapPage(rw, r, logger, page)
+}
+
+func (a *API) writeSitemapPage(rw http.ResponseWriter, r *http.Request, logger clog.ICustomLog, page int) {
+	entries, err := a.deps.DBBackend.ListReleaseSitemapEntries(r.Context(),
+		gensql.ListReleaseSitemapEntriesParams{
+			Limit:  MaxSitemapURLs,
+			Offset: int32((page - 1) * MaxSitemapURLs),
+		})
+	if err != nil {
+		logger.Error("Failed to list sitemap entries", zap.Error(err))
+		http.Error(rw, "Failed to generate sitemap", http.StatusInternalServerError)
+		return
+	}
+
+	if len(entries) == 0 && page > 1 {
+		http.NotFound(rw, r)
+		return
+	}
+
+	set := &sitemapURLSet{
+		XMLNS: sitemapXMLNS,
+		URLs:  make([]sitemapURL, 0, len(entries)),
+	}
+
+	for _, e := range entries {
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:     a.absoluteURL("/releases/" + e.ID.String()),
+			LastMod: e.UpdatedAt.UTC().Format("2006-01-02"),
+		})
+	}
+
+	writeXML(rw, logger, set)
+}
+
+func writeXML(rw http.ResponseWriter, logger clog.ICustomLog, v interface{}) {
+	rw.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte(xml.Header))
+
+	if err := xml.NewEncoder(rw).Encode(v); err != nil {
+		logger.Error("Failed to encode sitemap", zap.Error(err))
+	}
+}
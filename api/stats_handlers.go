@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+const (
+	// StatsCacheTTL is how long a computed /api/stats response is reused
+	StatsCacheTTL = 60 * time.Second
+
+	// statsRecentDays is the window of recentReleases
+	statsRecentDays = 30
+
+	// statsTopGenres is the number of genres in topGenres
+	statsTopGenres = 20
+
+	// statsCoverageMinGenres is the default genre count a release needs to
+	// count towards enrichment genre coverage
+	statsCoverageMinGenres = 2
+
+	// statsCoverageMaxGenres caps minGenres, which keeps the number of
+	// cached coverage responses small
+	statsCoverageMaxGenres = 20
+
+	statsCacheKey = "api:stats"
+
+	// coverageCacheKeyPrefix is suffixed with minGenres
+	coverageCacheKeyPrefix = "api:stats:enrichment:"
+)
+
+type StatsResponse struct {
+	TotalReleases   int64                `json:"totalReleases"`
+	RecentReleases  int64                `json:"recentReleases"`
+	DistinctArtists int64                `json:"distinctArtists"`
+	DistinctLabels  int64                `json:"distinctLabels"`
+	TopGenres       []GenreCountResponse `json:"topGenres"`
+}
+
+type GenreCountResponse struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// EnrichmentStatsResponse is the share of releases carrying each piece of
+// enriched data
+type EnrichmentStatsResponse struct {
+	TotalReleases     int64            `json:"totalReleases"`
+	MinGenres         int              `json:"minGenres"`
+	Country           CoverageResponse `json:"country"`
+	Art               CoverageResponse `json:"art"`
+	SpotifyPreview    CoverageResponse `json:"spotifyPreview"`
+	YoutubePreview    CoverageResponse `json:"youtubePreview"`
+	BandcampPreview   CoverageResponse `json:"bandcampPreview"`
+	AppleMusicPreview CoverageResponse `json:"appleMusicPreview"`
+	Genres            CoverageResponse `json:"genres"`
+}
+
+// CoverageResponse is a count of releases and its percentage of the catalog
+type CoverageResponse struct {
+	Count   int64   `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+func (a *API) statsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "statsHandler"))
+	logger.Info("handling /api/stats request", zap.String("remoteAddr", r.RemoteAddr))
+
+	if cached, ok := a.deps.Cache.Get(statsCacheKey); ok {
+		WriteJSON(rw, cached, http.StatusOK)
+		return
+	}
+
+	stats, err := a.catalogStats(r.Context(), time.Now())
+	if err != nil {
+		logger.Error("Failed to compute stats", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch stats")
+		return
+	}
+
+	a.deps.Cache.Set(statsCacheKey, stats, StatsCacheTTL)
+
+	WriteJSON(rw, stats, http.StatusOK)
+}
+
+// catalogStats runs the aggregate queries behind /api/stats; recent releases
+// are those released in the statsRecentDays up to now
+func (a *API) catalogStats(ctx context.Context, now time.Time) (*StatsResponse, error) {
+	counts, err := a.deps.DBBackend.GetCatalogStats(ctx, gensql.GetCatalogStatsParams{
+		RecentFrom: now.AddDate(0, 0, -statsRecentDays),
+		RecentTo:   now,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count releases")
+	}
+
+	genres, err := a.deps.DBBackend.ListGenreCounts(ctx, statsTopGenres)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count genres")
+	}
+
+	stats := &StatsResponse{
+		TotalReleases:   counts.TotalReleases,
+		RecentReleases:  counts.RecentReleases,
+		DistinctArtists: counts.DistinctArtists,
+		DistinctLabels:  counts.DistinctLabels,
+		TopGenres:       make([]GenreCountResponse, 0, len(genres)),
+	}
+
+	for _, g := range genres {
+		stats.TopGenres = append(stats.TopGenres, GenreCountResponse{
+			Name:  g.Name,
+			Count: g.ReleaseCount,
+		})
+	}
+
+	return stats, nil
+}
+
+func (a *API) enrichmentStatsHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "enrichmentStatsHandler"))
+	logger.Info("handling /api/stats/enrichment request", zap.String("remoteAddr", r.RemoteAddr))
+
+	minGenres := statsCoverageMinGenres
+
+	if v := r.URL.Query().Get("minGenres"); v != "" {
+		n, err := parseGenreCount(v)
+		if err != nil || n > statsCoverageMaxGenres {
+			a.writeError(rw, http.StatusBadRequest, "Invalid minGenres parameter")
+			return
+		}
+
+		minGenres = n
+	}
+
+	cacheKey := coverageCacheKeyPrefix + strconv.Itoa(minGenres)
+
+	if cached, ok := a.deps.Cache.Get(cacheKey); ok {
+		WriteJSON(rw, cached, http.StatusOK)
+		return
+	}
+
+	coverage, err := a.deps.DBBackend.GetEnrichmentCoverage(r.Context(), int32(minGenres))
+	if err != nil {
+		logger.Error("Failed to compute enrichment coverage", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch enrichment stats")
+		return
+	}
+
+	stats := enrichmentStats(coverage, minGenres)
+
+	a.deps.Cache.Set(cacheKey, stats, StatsCacheTTL)
+
+	WriteJSON(rw, stats, http.StatusOK)
+}
+
+func enrichmentStats(c gensql.GetEnrichmentCoverageRow, minGenres int) *EnrichmentStatsResponse {
+	share := func(count int64) CoverageResponse {
+		return CoverageResponse{Count: count, Percent: percentOf(count, c.TotalReleases)}
+	}
+
+	return &EnrichmentStatsResponse{
+		TotalReleases:     c.TotalReleases,
+		MinGenres:         minGenres,
+		Country:           share(c.WithCountry),
+		Art:               share(c.WithArt),
+		SpotifyPreview:    share(c.WithSpotify),
+		YoutubePreview:    share(c.WithYoutube),
+		BandcampPreview:   share(c.WithBandcamp),
+		AppleMusicPreview: share(c.WithAppleMusic),
+		Genres:            share(c.WithMinGenres),
+	}
+}
+
+// percentOf returns count as a percentage of total, to one decimal place; an
+// empty catalog is 0% covered
+func percentOf(count, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return math.Round(float64(count)*1000/float64(total)) / 10
+}
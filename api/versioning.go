@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dselans/blastbeat-api/services/release"
+)
+
+const (
+	APIVersion1 = 1
+	APIVersion2 = 2
+
+	// DefaultAPIVersion is used when a client does not ask for a version;
+	// it must stay at v1 so existing clients keep working
+	DefaultAPIVersion = APIVersion1
+	LatestAPIVersion  = APIVersion2
+
+	// APIVersionHeader is set on versioned responses
+	APIVersionHeader = "X-API-Version"
+)
+
+type apiVersionKey struct{}
+
+// vendorMediaTypeRe matches application/vnd.blastbeat.vN+json
+var vendorMediaTypeRe = regexp.MustCompile(`^application/vnd\.blastbeat\.v(\d+)\+json$`)
+
+// withAPIVersion pins the version for path-versioned routes (/api/vN/...);
+// a path version always wins over the Accept header
+func (a *API) withAPIVersion(version int, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), apiVersionKey{}, version)
+		next(rw, r.WithContext(ctx))
+	}
+}
+
+// requestedAPIVersion returns the API version for the request and whether
+// it was explicitly requested via the Accept header. An error is returned
+// when the client asked for a version we don't serve.
+func requestedAPIVersion(r *http.Request) (int, bool, error) {
+	if v, ok := r.Context().Value(apiVersionKey{}).(int); ok {
+		return v, false, nil
+	}
+
+	version, ok := parseAcceptVersion(r.Header.Get("Accept"))
+	if !ok {
+		return DefaultAPIVersion, false, nil
+	}
+
+	if version < APIVersion1 || version > LatestAPIVersion {
+		return 0, true, errUnsupportedAPIVersion(version)
+	}
+
+	return version, true, nil
+}
+
+// parseAcceptVersion extracts N from the first
+// application/vnd.blastbeat.vN+json entry in an Accept header
+func parseAcceptVersion(accept string) (int, bool) {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		m := vendorMediaTypeRe.FindStringSubmatch(mediaType)
+		if m == nil {
+			continue
+		}
+
+		v, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+
+		return v, true
+	}
+
+	return 0, false
+}
+
+func vendorMediaType(version int) string {
+	return "application/vnd.blastbeat.v" + strconv.Itoa(version) + "+json"
+}
+
+type errUnsupportedAPIVersion int
+
+func (e errUnsupportedAPIVersion) Error() string {
+	return "Unsupported API version: v" + strconv.Itoa(int(e))
+}
+
+// releaseV1 is the frozen v1 release shape. Do not change it; new or
+// changed fields belong in release.ReleaseResponse (served as v2+).
+type releaseV1 struct {
+	ID            string                 `json:"id"`
+	Title         string                 `json:"title"`
+	Artist        string                 `json:"artist"`
+	AlbumArt      string                 `json:"albumArt"`
+	ReleaseDate   release.Date           `json:"releaseDate"`
+	Label         string                 `json:"label"`
+	LabelUrl      *string                `json:"labelUrl,omitempty"`
+	FollowerCount int64                  `json:"followerCount"`
+	Genres        []string               `json:"genres"`
+	Country       *string                `json:"country,omitempty"`
+	ExternalLinks []release.ExternalLink `json:"externalLinks,omitempty"`
+	PreviewLinks  previewLinksV1         `json:"previewLinks"`
+	CreatedAt     release.Timestamp      `json:"createdAt"`
+	UpdatedAt     release.Timestamp      `json:"updatedAt"`
+}
+
+// previewLinksV1 is the frozen v1 preview links shape; links added since
+// (e.g. Apple Music) are v2+ only
+type previewLinksV1 struct {
+	Spotify  *string `json:"spotify,omitempty"`
+	Youtube  *string `json:"youtube,omitempty"`
+	Bandcamp *string `json:"bandcamp,omitempty"`
+}
+
+func toReleaseV1(r *release.ReleaseResponse) *releaseV1 {
+	previewLinks := previewLinksV1{
+		Spotify:  r.PreviewLinks.Spotify,
+		Youtube:  r.PreviewLinks.Youtube,
+		Bandcamp: r.PreviewLinks.Bandcamp,
+	}
+
+	return &releaseV1{
+		ID:            r.ID,
+		Title:         r.Title,
+		Artist:        r.Artist,
+		AlbumArt:      r.AlbumArt,
+		ReleaseDate:   r.ReleaseDate,
+		Label:         r.Label,
+		LabelUrl:      r.LabelUrl,
+		FollowerCount: r.FollowerCount,
+		Genres:        r.Genres,
+		Country:       r.Country,
+		ExternalLinks: r.ExternalLinks,
+		PreviewLinks:  previewLinks,
+		CreatedAt:     r.CreatedAt,
+		UpdatedAt:     r.UpdatedAt,
+	}
+}
+
+// versionedReleases converts service releases into the shape for version;
+// the result always encodes as a JSON array, never null
+func versionedReleases(version int, releases []*release.ReleaseResponse) interface{} {
+	if version == APIVersion1 {
+		out := make([]*releaseV1, 0, len(releases))
+		for _, r := range releases {
+			out = append(out, toReleaseV1(r))
+		}
+
+		return out
+	}
+
+	if releases == nil {
+		return []*release.ReleaseResponse{}
+	}
+
+	return releases
+}
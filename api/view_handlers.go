@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/services/release"
+	"github.com/dselans/blastbeat-api/services/view"
+)
+
+const (
+	DefaultTrendingLimit = 20
+	MaxTrendingLimit     = MaxBatchIDs
+)
+
+func (a *API) releaseViewHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "releaseViewHandler"))
+	logger.Debug("handling POST /api/releases/:id/view request", zap.String("remoteAddr", r.RemoteAddr))
+
+	if a.deps.ViewService == nil {
+		a.writeError(rw, http.StatusServiceUnavailable, "View tracking is disabled")
+		return
+	}
+
+	releaseID, err := uuid.Parse(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil {
+		a.writeError(rw, http.StatusBadRequest, "Invalid release id")
+		return
+	}
+
+	if err := a.deps.ViewService.Record(r.Context(), releaseID); err != nil {
+		if errors.Is(err, view.ErrReleaseNotFound) {
+			a.writeError(rw, http.StatusNotFound, "Release not found")
+			return
+		}
+
+		logger.Error("Failed to record view", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to record view")
+		return
+	}
+
+	// Repairs a dead YouTube link in the background, at most once per
+	// LinkRefreshIntervalHours per release
+	if a.deps.LinkRefresh != nil {
+		a.deps.LinkRefresh.Trigger(releaseID)
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) trendingReleasesHandler(rw http.ResponseWriter, r *http.Request) {
+	logger := a.log.With(zap.String("method", "trendingReleasesHandler"))
+	logger.Info("handling /api/releases/trending request", zap.String("remoteAddr", r.RemoteAddr))
+
+	if a.deps.ViewService == nil {
+		a.writeError(rw, http.StatusServiceUnavailable, "Trending is disabled")
+		return
+	}
+
+	version, negotiated, err := requestedAPIVersion(r)
+	if err != nil {
+		a.writeError(rw, http.StatusNotAcceptable, err.Error())
+		return
+	}
+
+	limit := DefaultTrendingLimit
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > MaxTrendingLimit {
+			a.writeError(rw, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+	}
+
+	ids, err := a.deps.ViewService.Trending(r.Context(), limit)
+	if err != nil {
+		logger.Error("Failed to fetch trending", zap.Error(err))
+		a.writeError(rw, http.StatusInternalServerError, "Failed to fetch trending releases")
+		return
+	}
+
+	releases := []*release.ReleaseResponse{}
+
+	if len(ids) > 0 {
+		result, err := a.deps.ReleaseService.GetReleases(r.Context(), &release.ReleaseFilters{IDs: ids})
+		if err != nil {
+			logger.Error("Failed to fetch trending releases", zap.Error(err))
+			a.writeError(rw, http.StatusInternalServerError, "Failed to fetch trending releases")
+			return
+		}
+
+		releases = result.Releases
+	}
+
+	contentType := "application/json; charset=UTF-8"
+	if negotiated {
+		contentType = vendorMediaType(version) + "; charset=UTF-8"
+	}
+
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set(APIVersionHeader, strconv.Itoa(version))
+	rw.Header().Add("Vary", "Accept")
+	rw.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(rw).Encode(versionedReleases(version, releases)); err != nil {
+		logger.Error("Failed to encode trending response", zap.Error(err))
+	}
+}
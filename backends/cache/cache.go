@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// ICache is a process-local key/value cache with per-entry expiry
+type ICache interface {
+	// Get returns the value stored under key, or false when it is missing
+	// or expired
+	Get(key string) (interface{}, bool)
+
+	// Set stores value under key for ttl
+	Set(key string, value interface{}, ttl time.Duration)
+}
+
+type Cache struct {
+	mu    sync.Mutex
+	items map[string]item
+}
+
+type item struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+func New() *Cache {
+	return &Cache{
+		items: make(map[string]item),
+	}
+}
+
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	it, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(it.expiresAt) {
+		delete(c.items, key)
+		return nil, false
+	}
+
+	return it.value, true
+}
+
+func (c *Cache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items[key] = item{
+		value:     value,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
@@ -1,12 +1,15 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
 
 	"github.com/dselans/blastbeat-api/backends/gensql"
 )
@@ -18,6 +21,23 @@ type Options struct {
 	Port     int
 	DBName   string
 	SSLMode  string
+
+	// Log is optional; when nil, query instrumentation is disabled
+	Log clog.ICustomLog
+
+	// ExplainSlowQueries logs EXPLAIN ANALYZE output for list queries that
+	// take longer than ExplainThreshold. Intended for dev only.
+	ExplainSlowQueries bool
+	ExplainThreshold   time.Duration
+
+	// SlowQueryThreshold logs a warning for any query that takes longer
+	// than this; zero disables slow query logging
+	SlowQueryThreshold time.Duration
+
+	// MaxOpenConns and MaxIdleConns size the connection pool; zero keeps
+	// the database/sql defaults (unlimited open, 2 idle)
+	MaxOpenConns int
+	MaxIdleConns int
 }
 
 type DB struct {
@@ -27,7 +47,12 @@ type DB struct {
 	db   *sql.DB
 }
 
-const DefaultPostgreSQLPort = 5432
+const (
+	DefaultPostgreSQLPort = 5432
+
+	// DefaultPingTimeout bounds the connectivity check in New
+	DefaultPingTimeout = 5 * time.Second
+)
 
 func New(opts *Options) (*DB, error) {
 	if err := validateOptions(opts); err != nil {
@@ -51,7 +76,25 @@ func New(opts *Options) (*DB, error) {
 	}
 
 	db := stdlib.OpenDB(*cfg.ConnConfig)
-	queries := gensql.New(db)
+
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+
+	// Fail here on a wrong host or password rather than on the first query
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPingTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "unable to ping database at %s:%d", opts.Host, opts.Port)
+	}
+
+	queries := gensql.New(newInstrumentedDB(db, opts))
 
 	return &DB{
 		Queries: queries,
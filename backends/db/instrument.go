@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultExplainThreshold = 200 * time.Millisecond
+	explainTimeout          = 10 * time.Second
+)
+
+var queryNameRe = regexp.MustCompile(`^--\s*name:\s*(\S+)\s+:(\w+)`)
+
+// instrumentedDB wraps *sql.DB and satisfies gensql.DBTX so that generated
+// queries can be observed without touching generated code.
+type instrumentedDB struct {
+	db   *sql.DB
+	opts *Options
+	log  clog.ICustomLog
+}
+
+func newInstrumentedDB(db *sql.DB, opts *Options) *instrumentedDB {
+	i := &instrumentedDB{
+		db:   db,
+		opts: opts,
+	}
+
+	if opts.Log != nil {
+		i.log = opts.Log.With(zap.String("pkg", "db"))
+	}
+
+	return i
+}
+
+func (i *instrumentedDB) ExecContext(ctx context.Context, query string,
+	args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := i.db.ExecContext(ctx, query, args...)
+	i.observe(query, args, time.Since(start))
+
+	return res, err
+}
+
+func (i *instrumentedDB) PrepareContext(ctx context.Context,
+	query string) (*sql.Stmt, error) {
+	return i.db.PrepareContext(ctx, query)
+}
+
+func (i *instrumentedDB) QueryContext(ctx context.Context, query string,
+	args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	i.observe(query, args, time.Since(start))
+
+	return rows, err
+}
+
+func (i *instrumentedDB) QueryRowContext(ctx context.Context, query string,
+	args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := i.db.QueryRowContext(ctx, query, args...)
+	i.observe(query, args, time.Since(start))
+
+	return row
+}
+
+func (i *instrumentedDB) observe(query string, args []interface{},
+	took time.Duration) {
+	if i.log == nil {
+		return
+	}
+
+	name, kind := parseQueryName(query)
+
+	if i.opts.SlowQueryThreshold > 0 && took >= i.opts.SlowQueryThreshold {
+		i.log.Warn("slow query",
+			zap.String("query", name),
+			zap.Duration("took", took),
+			zap.Duration("threshold", i.opts.SlowQueryThreshold))
+	}
+
+	// EXPLAIN ANALYZE re-executes the statement, so only do it for list
+	// (read-only) queries and only when explicitly enabled (dev)
+	if i.opts.ExplainSlowQueries && kind == "many" &&
+		took >= i.explainThreshold() {
+		go i.explain(name, query, args, took)
+	}
+}
+
+func (i *instrumentedDB) explainThreshold() time.Duration {
+	if i.opts.ExplainThreshold > 0 {
+		return i.opts.ExplainThreshold
+	}
+
+	return DefaultExplainThreshold
+}
+
+func (i *instrumentedDB) explain(name, query string, args []interface{},
+	took time.Duration) {
+	logger := i.log.With(zap.String("method", "explain"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+
+	// Generated queries begin with a "-- name:" comment line which is
+	// terminated by the newline, so prefixing EXPLAIN is safe
+	rows, err := i.db.QueryContext(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+query,
+		args...)
+	if err != nil {
+		logger.Warn("unable to explain slow query",
+			zap.String("query", name), zap.Error(err))
+		return
+	}
+	defer rows.Close()
+
+	var plan []string
+
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			logger.Warn("unable to scan query plan",
+				zap.String("query", name), zap.Error(err))
+			return
+		}
+
+		plan = append(plan, line)
+	}
+
+	logger.Debug("slow query plan",
+		zap.String("query", name),
+		zap.Duration("took", took),
+		zap.String("plan", "\n"+strings.Join(plan, "\n")))
+}
+
+// parseQueryName extracts the sqlc query name and kind (one, many, exec)
+// from the leading "-- name: X :kind" comment.
+func parseQueryName(query string) (string, string) {
+	if m := queryNameRe.FindStringSubmatch(query); len(m) == 3 {
+		return m[1], m[2]
+	}
+
+	return "unknown", ""
+}
@@ -2,10 +2,15 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"io/fs"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/superpowerdotcom/go-common-lib/clog"
 	"go.uber.org/zap"
@@ -13,6 +18,10 @@ import (
 	"github.com/dselans/blastbeat-api/migrations"
 )
 
+// downFileName is the optional SQL file in a migration directory that
+// undoes the migration; see Rollback
+const downFileName = "down.sql"
+
 func (d *DB) Migrate(ctx context.Context,
 	log clog.ICustomLog) error {
 	logger := log.With(zap.String("method", "Migrate"))
@@ -32,8 +41,15 @@ func (d *DB) Migrate(ctx context.Context,
 		return errors.Wrap(err, "failed to get applied migrations")
 	}
 
+	// Check every applied migration before applying anything, so an edited
+	// migration stops the service instead of being silently skipped
+	if err := d.verifyChecksums(ctx, logger, migrationFiles,
+		applied); err != nil {
+		return err
+	}
+
 	for _, migration := range migrationFiles {
-		if applied[migration.DirName] {
+		if _, ok := applied[migration.DirName]; ok {
 			logger.Debug("Migration already applied",
 				zap.String("migration", migration.DirName),
 				zap.String("file", migration.Name))
@@ -57,6 +73,15 @@ func (d *DB) Migrate(ctx context.Context,
 				migration.FullPath)
 		}
 
+		if hook := migrationHooks[migration.DirName]; hook != nil {
+			if err := hook(ctx, tx, logger); err != nil {
+				tx.Rollback()
+				return errors.Wrapf(err,
+					"failed to run hook for migration: %s",
+					migration.DirName)
+			}
+		}
+
 		if _, err := tx.ExecContext(ctx, string(content)); err != nil {
 			tx.Rollback()
 			return errors.Wrapf(err,
@@ -65,9 +90,9 @@ func (d *DB) Migrate(ctx context.Context,
 		}
 
 		if _, err := tx.ExecContext(ctx,
-			"INSERT INTO schema_migrations (name, applied_at) "+
-				"VALUES ($1, NOW())",
-			migration.DirName); err != nil {
+			"INSERT INTO schema_migrations (name, applied_at, checksum) "+
+				"VALUES ($1, NOW(), $2)",
+			migration.DirName, checksum(content)); err != nil {
 			tx.Rollback()
 			return errors.Wrapf(err,
 				"failed to record migration: %s", migration.DirName)
@@ -87,17 +112,146 @@ func (d *DB) Migrate(ctx context.Context,
 	return nil
 }
 
+// Rollback undoes the steps most recently applied migrations, newest first,
+// by running their down.sql and removing them from schema_migrations. Each
+// one is rolled back in its own transaction. Nothing is rolled back unless
+// every one of them has a down.sql.
+func (d *DB) Rollback(ctx context.Context, log clog.ICustomLog,
+	steps int) error {
+	logger := log.With(zap.String("method", "Rollback"))
+
+	if steps <= 0 {
+		return errors.New("steps must be positive")
+	}
+
+	names, err := d.getLatestMigrations(ctx, steps)
+	if err != nil {
+		return errors.Wrap(err, "failed to get applied migrations")
+	}
+
+	if len(names) == 0 {
+		logger.Info("No migrations to roll back")
+		return nil
+	}
+
+	downs := make([][]byte, len(names))
+
+	for i, name := range names {
+		content, err := migrations.FS.ReadFile(name + "/" + downFileName)
+		if err != nil {
+			return errors.Wrapf(err,
+				"migration %s has no %s", name, downFileName)
+		}
+
+		downs[i] = content
+	}
+
+	for i, name := range names {
+		logger.Info("Rolling back migration",
+			zap.String("migration", name))
+
+		tx, err := d.db.BeginTx(ctx, nil)
+		if err != nil {
+			return errors.Wrap(err, "failed to begin transaction")
+		}
+
+		if _, err := tx.ExecContext(ctx, string(downs[i])); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err,
+				"failed to execute rollback: %s", name)
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM schema_migrations WHERE name = $1",
+			name); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err,
+				"failed to remove migration record: %s", name)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err,
+				"failed to commit rollback: %s", name)
+		}
+
+		logger.Info("Migration rolled back successfully",
+			zap.String("migration", name))
+	}
+
+	logger.Info("Rollback completed", zap.Int("migrations", len(names)))
+	return nil
+}
+
+// createMigrationsTable creates schema_migrations, adding the checksum
+// column to tables created before it existed
 func (d *DB) createMigrationsTable(ctx context.Context) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS schema_migrations (
 		name VARCHAR(255) PRIMARY KEY,
-		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		checksum VARCHAR(64)
 	);
+	ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS checksum VARCHAR(64);
 	`
 	_, err := d.db.ExecContext(ctx, query)
 	return err
 }
 
+// verifyChecksums errors when an applied migration's file no longer
+// matches the checksum recorded when it was applied. Migrations applied
+// before checksums were recorded get the checksum of their current file.
+func (d *DB) verifyChecksums(ctx context.Context, log clog.ICustomLog,
+	migrationFiles []migrationFile, applied map[string]string) error {
+	for _, migration := range migrationFiles {
+		recorded, ok := applied[migration.DirName]
+		if !ok {
+			continue
+		}
+
+		content, err := migrations.FS.ReadFile(migration.FullPath)
+		if err != nil {
+			return errors.Wrapf(err,
+				"failed to read migration file: %s",
+				migration.FullPath)
+		}
+
+		sum := checksum(content)
+
+		if recorded == "" {
+			if _, err := d.db.ExecContext(ctx,
+				"UPDATE schema_migrations SET checksum = $2 "+
+					"WHERE name = $1 AND checksum IS NULL",
+				migration.DirName, sum); err != nil {
+				return errors.Wrapf(err,
+					"failed to record checksum of migration: %s",
+					migration.DirName)
+			}
+
+			log.Info("Recorded checksum of applied migration",
+				zap.String("migration", migration.DirName),
+				zap.String("checksum", sum))
+
+			continue
+		}
+
+		if recorded != sum {
+			return errors.Errorf(
+				"migration %s was edited after it was applied "+
+					"(checksum %s, file is now %s); add a new "+
+					"migration instead", migration.DirName,
+				recorded, sum)
+		}
+	}
+
+	return nil
+}
+
+// checksum is the hex SHA-256 of a migration file
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
 func (d *DB) getMigrationFiles() ([]migrationFile, error) {
 	entries, err := fs.ReadDir(migrations.FS, ".")
 	if err != nil {
@@ -114,7 +268,8 @@ func (d *DB) getMigrationFiles() ([]migrationFile, error) {
 			}
 			for _, fileEntry := range dirEntries {
 				if !fileEntry.IsDir() &&
-					strings.HasSuffix(fileEntry.Name(), ".sql") {
+					strings.HasSuffix(fileEntry.Name(), ".sql") &&
+					fileEntry.Name() != downFileName {
 					migrationFiles = append(migrationFiles,
 						migrationFile{
 							Name:    fileEntry.Name(),
@@ -135,25 +290,117 @@ func (d *DB) getMigrationFiles() ([]migrationFile, error) {
 	return migrationFiles, nil
 }
 
+// getAppliedMigrations returns the checksum of every applied migration by
+// name; "" for those applied before checksums were recorded
 func (d *DB) getAppliedMigrations(ctx context.Context) (
-	map[string]bool, error) {
-	applied := make(map[string]bool)
+	map[string]string, error) {
+	applied := make(map[string]string)
 	rows, err := d.db.QueryContext(ctx,
-		"SELECT name FROM schema_migrations")
+		"SELECT name, COALESCE(checksum, '') FROM schema_migrations")
 	if err != nil {
 		return applied, nil
 	}
 	defer rows.Close()
 
+	for rows.Next() {
+		var name, sum string
+		if err := rows.Scan(&name, &sum); err != nil {
+			return nil, err
+		}
+		applied[name] = sum
+	}
+
+	return applied, rows.Err()
+}
+
+// getLatestMigrations returns up to n applied migrations, newest first
+func (d *DB) getLatestMigrations(ctx context.Context, n int) (
+	[]string, error) {
+	rows, err := d.db.QueryContext(ctx,
+		"SELECT name FROM schema_migrations ORDER BY name DESC LIMIT $1", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
 	for rows.Next() {
 		var name string
 		if err := rows.Scan(&name); err != nil {
 			return nil, err
 		}
-		applied[name] = true
+		names = append(names, name)
 	}
 
-	return applied, rows.Err()
+	return names, rows.Err()
+}
+
+// migrationHooks run in a migration's transaction right before its SQL,
+// keyed by migration directory
+var migrationHooks = map[string]func(ctx context.Context, tx *sql.Tx, log clog.ICustomLog) error{
+	"008_release_unique_key": logDuplicateReleases,
+}
+
+// duplicateReleasesQuery lists the releases 008_release_unique_key drops;
+// the ranking must match the migration's
+const duplicateReleasesQuery = `
+SELECT id, keep_id, artist, title, release_date, follower_count
+FROM (
+  SELECT
+    id, artist, title, release_date, follower_count,
+    first_value(id) OVER w AS keep_id,
+    row_number() OVER w AS n
+  FROM releases
+  WINDOW w AS (
+    PARTITION BY lower(artist), lower(title), release_date
+    ORDER BY follower_count DESC, created_at, id
+  )
+) ranked
+WHERE n > 1
+ORDER BY keep_id, id
+`
+
+// logDuplicateReleases logs every release 008_release_unique_key is about
+// to drop, and how many there are
+func logDuplicateReleases(ctx context.Context, tx *sql.Tx, log clog.ICustomLog) error {
+	rows, err := tx.QueryContext(ctx, duplicateReleasesQuery)
+	if err != nil {
+		return errors.Wrap(err, "failed to list duplicate releases")
+	}
+	defer rows.Close()
+
+	dropped := 0
+
+	for rows.Next() {
+		var (
+			id, keepID    uuid.UUID
+			artist, title string
+			releaseDate   time.Time
+			followerCount int64
+		)
+
+		if err := rows.Scan(&id, &keepID, &artist, &title, &releaseDate, &followerCount); err != nil {
+			return errors.Wrap(err, "failed to scan duplicate release")
+		}
+
+		dropped++
+
+		log.Warn("Dropping duplicate release",
+			zap.String("id", id.String()),
+			zap.String("keptId", keepID.String()),
+			zap.String("artist", artist),
+			zap.String("title", title),
+			zap.String("releaseDate", releaseDate.Format("2006-01-02")),
+			zap.Int64("followerCount", followerCount))
+	}
+
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "failed to list duplicate releases")
+	}
+
+	log.Info("Collapsing duplicate releases", zap.Int("dropped", dropped))
+
+	return nil
 }
 
 type migrationFile struct {
@@ -12,6 +12,27 @@ import (
 	"github.com/google/uuid"
 )
 
+type EnrichmentAudit struct {
+	ID              int64
+	ReleaseID       uuid.NullUUID
+	Artist          string
+	Album           string
+	Provider        string
+	Lookup          string
+	Url             string
+	StatusCode      int32
+	Error           sql.NullString
+	ResponseExcerpt sql.NullString
+	DurationMs      int32
+	CreatedAt       time.Time
+}
+
+type Favorite struct {
+	Token     string
+	ReleaseID uuid.UUID
+	CreatedAt time.Time
+}
+
 type Genre struct {
 	ID   uuid.UUID
 	Name string
@@ -26,13 +47,20 @@ type Release struct {
 	ReleaseDate   time.Time
 	Label         string
 	LabelUrl      sql.NullString
-	FollowerCount int32
+	FollowerCount int64
 	Genres        json.RawMessage
 	Country       sql.NullString
 	ExternalLinks json.RawMessage
 	SpotifyUrl    sql.NullString
 	YoutubeUrl    sql.NullString
 	BandcampUrl   sql.NullString
+	AppleMusicUrl sql.NullString
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 }
+
+type ReleaseView struct {
+	ReleaseID uuid.UUID
+	ViewCount int64
+	UpdatedAt time.Time
+}
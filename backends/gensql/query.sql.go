@@ -15,6 +15,105 @@ import (
 	"github.com/lib/pq"
 )
 
+const addFavorite = `-- name: AddFavorite :execrows
+INSERT INTO favorites (token, release_id)
+SELECT $1::text, $2::uuid
+WHERE (SELECT COUNT(*) FROM favorites WHERE token = $1::text) < $3::int
+ON CONFLICT DO NOTHING
+`
+
+type AddFavoriteParams struct {
+	Token        string
+	ReleaseID    uuid.UUID
+	MaxFavorites int32
+}
+
+func (q *Queries) AddFavorite(ctx context.Context, arg AddFavoriteParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, addFavorite, arg.Token, arg.ReleaseID, arg.MaxFavorites)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const addReleaseViews = `-- name: AddReleaseViews :exec
+INSERT INTO release_views (release_id, view_count)
+SELECT id, $1::bigint
+FROM releases
+WHERE id = $2
+ON CONFLICT (release_id) DO UPDATE
+SET view_count = release_views.view_count + EXCLUDED.view_count,
+    updated_at = now()
+`
+
+type AddReleaseViewsParams struct {
+	Views     int64
+	ReleaseID uuid.UUID
+}
+
+func (q *Queries) AddReleaseViews(ctx context.Context, arg AddReleaseViewsParams) error {
+	_, err := q.db.ExecContext(ctx, addReleaseViews, arg.Views, arg.ReleaseID)
+	return err
+}
+
+const countReleases = `-- name: CountReleases :one
+SELECT COUNT(*)
+FROM releases
+`
+
+func (q *Queries) CountReleases(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countReleases)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createEnrichmentAudit = `-- name: CreateEnrichmentAudit :exec
+INSERT INTO enrichment_audit (
+  release_id,
+  artist,
+  album,
+  provider,
+  lookup,
+  url,
+  status_code,
+  error,
+  response_excerpt,
+  duration_ms
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+`
+
+type CreateEnrichmentAuditParams struct {
+	ReleaseID       uuid.NullUUID
+	Artist          string
+	Album           string
+	Provider        string
+	Lookup          string
+	Url             string
+	StatusCode      int32
+	Error           sql.NullString
+	ResponseExcerpt sql.NullString
+	DurationMs      int32
+}
+
+func (q *Queries) CreateEnrichmentAudit(ctx context.Context, arg CreateEnrichmentAuditParams) error {
+	_, err := q.db.ExecContext(ctx, createEnrichmentAudit,
+		arg.ReleaseID,
+		arg.Artist,
+		arg.Album,
+		arg.Provider,
+		arg.Lookup,
+		arg.Url,
+		arg.StatusCode,
+		arg.Error,
+		arg.ResponseExcerpt,
+		arg.DurationMs,
+	)
+	return err
+}
+
 const createGenre = `-- name: CreateGenre :one
 INSERT INTO genres (
   id,
@@ -56,7 +155,8 @@ INSERT INTO releases (
   external_links,
   spotify_url,
   youtube_url,
-  bandcamp_url
+  bandcamp_url,
+  apple_music_url
 ) VALUES (
   $1,  -- id
   $2,  -- title
@@ -71,9 +171,10 @@ INSERT INTO releases (
   $11, -- external_links (jsonb)
   $12, -- spotify_url
   $13, -- youtube_url
-  $14  -- bandcamp_url
+  $14, -- bandcamp_url
+  $15  -- apple_music_url
 )
-RETURNING id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
+RETURNING id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
 `
 
 type CreateReleaseParams struct {
@@ -84,13 +185,14 @@ type CreateReleaseParams struct {
 	ReleaseDate   time.Time
 	Label         string
 	LabelUrl      sql.NullString
-	FollowerCount int32
+	FollowerCount int64
 	Genres        json.RawMessage
 	Country       sql.NullString
 	ExternalLinks json.RawMessage
 	SpotifyUrl    sql.NullString
 	YoutubeUrl    sql.NullString
 	BandcampUrl   sql.NullString
+	AppleMusicUrl sql.NullString
 }
 
 func (q *Queries) CreateRelease(ctx context.Context, arg CreateReleaseParams) (Release, error) {
@@ -109,6 +211,7 @@ func (q *Queries) CreateRelease(ctx context.Context, arg CreateReleaseParams) (R
 		arg.SpotifyUrl,
 		arg.YoutubeUrl,
 		arg.BandcampUrl,
+		arg.AppleMusicUrl,
 	)
 	var i Release
 	err := row.Scan(
@@ -126,6 +229,7 @@ func (q *Queries) CreateRelease(ctx context.Context, arg CreateReleaseParams) (R
 		&i.SpotifyUrl,
 		&i.YoutubeUrl,
 		&i.BandcampUrl,
+		&i.AppleMusicUrl,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -142,14 +246,110 @@ func (q *Queries) DeleteGenre(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
-const deleteRelease = `-- name: DeleteRelease :exec
+const deleteRelease = `-- name: DeleteRelease :execrows
 DELETE FROM releases
 WHERE id = $1
 `
 
-func (q *Queries) DeleteRelease(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteRelease, id)
-	return err
+func (q *Queries) DeleteRelease(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteRelease, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const favoriteExists = `-- name: FavoriteExists :one
+SELECT EXISTS (
+  SELECT 1
+  FROM favorites
+  WHERE token = $1 AND release_id = $2
+)
+`
+
+type FavoriteExistsParams struct {
+	Token     string
+	ReleaseID uuid.UUID
+}
+
+func (q *Queries) FavoriteExists(ctx context.Context, arg FavoriteExistsParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, favoriteExists, arg.Token, arg.ReleaseID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const getCatalogStats = `-- name: GetCatalogStats :one
+SELECT
+  COUNT(*) AS total_releases,
+  COUNT(*) FILTER (WHERE release_date BETWEEN $1::date AND $2::date) AS recent_releases,
+  COUNT(DISTINCT LOWER(artist)) AS distinct_artists,
+  COUNT(DISTINCT LOWER(label)) FILTER (WHERE label <> '') AS distinct_labels
+FROM releases
+`
+
+type GetCatalogStatsParams struct {
+	RecentFrom time.Time
+	RecentTo   time.Time
+}
+
+type GetCatalogStatsRow struct {
+	TotalReleases   int64
+	RecentReleases  int64
+	DistinctArtists int64
+	DistinctLabels  int64
+}
+
+func (q *Queries) GetCatalogStats(ctx context.Context, arg GetCatalogStatsParams) (GetCatalogStatsRow, error) {
+	row := q.db.QueryRowContext(ctx, getCatalogStats, arg.RecentFrom, arg.RecentTo)
+	var i GetCatalogStatsRow
+	err := row.Scan(
+		&i.TotalReleases,
+		&i.RecentReleases,
+		&i.DistinctArtists,
+		&i.DistinctLabels,
+	)
+	return i, err
+}
+
+const getEnrichmentCoverage = `-- name: GetEnrichmentCoverage :one
+SELECT
+  COUNT(*) AS total_releases,
+  COUNT(*) FILTER (WHERE COALESCE(country, '') <> '') AS with_country,
+  COUNT(*) FILTER (WHERE album_art_url <> '' AND album_art_url NOT LIKE 'https://via.placeholder.com/%') AS with_art,
+  COUNT(*) FILTER (WHERE COALESCE(spotify_url, '') <> '') AS with_spotify,
+  COUNT(*) FILTER (WHERE COALESCE(youtube_url, '') <> '') AS with_youtube,
+  COUNT(*) FILTER (WHERE COALESCE(bandcamp_url, '') <> '') AS with_bandcamp,
+  COUNT(*) FILTER (WHERE COALESCE(apple_music_url, '') <> '') AS with_apple_music,
+  COUNT(*) FILTER (WHERE jsonb_array_length(genres) >= $1::int) AS with_min_genres
+FROM releases
+`
+
+type GetEnrichmentCoverageRow struct {
+	TotalReleases  int64
+	WithCountry    int64
+	WithArt        int64
+	WithSpotify    int64
+	WithYoutube    int64
+	WithBandcamp   int64
+	WithAppleMusic int64
+	WithMinGenres  int64
+}
+
+func (q *Queries) GetEnrichmentCoverage(ctx context.Context, minGenres int32) (GetEnrichmentCoverageRow, error) {
+	row := q.db.QueryRowContext(ctx, getEnrichmentCoverage, minGenres)
+	var i GetEnrichmentCoverageRow
+	err := row.Scan(
+		&i.TotalReleases,
+		&i.WithCountry,
+		&i.WithArt,
+		&i.WithSpotify,
+		&i.WithYoutube,
+		&i.WithBandcamp,
+		&i.WithAppleMusic,
+		&i.WithMinGenres,
+	)
+	return i, err
 }
 
 const getGenre = `-- name: GetGenre :one
@@ -181,7 +381,7 @@ func (q *Queries) GetGenreBySlug(ctx context.Context, slug string) (Genre, error
 }
 
 const getRelease = `-- name: GetRelease :one
-SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
 FROM releases
 WHERE id = $1
 LIMIT 1
@@ -205,12 +405,158 @@ func (q *Queries) GetRelease(ctx context.Context, id uuid.UUID) (Release, error)
 		&i.SpotifyUrl,
 		&i.YoutubeUrl,
 		&i.BandcampUrl,
+		&i.AppleMusicUrl,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
+const listCountries = `-- name: ListCountries :many
+SELECT country::text AS code, COUNT(*) AS release_count
+FROM releases
+WHERE country IS NOT NULL
+GROUP BY country
+ORDER BY release_count DESC, country
+`
+
+type ListCountriesRow struct {
+	Code         string
+	ReleaseCount int64
+}
+
+func (q *Queries) ListCountries(ctx context.Context) ([]ListCountriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listCountries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListCountriesRow
+	for rows.Next() {
+		var i ListCountriesRow
+		if err := rows.Scan(&i.Code, &i.ReleaseCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEnrichmentAuditByRelease = `-- name: ListEnrichmentAuditByRelease :many
+SELECT id, release_id, artist, album, provider, lookup, url, status_code, error, response_excerpt, duration_ms, created_at
+FROM enrichment_audit
+WHERE release_id = $1
+ORDER BY created_at, id
+`
+
+func (q *Queries) ListEnrichmentAuditByRelease(ctx context.Context, releaseID uuid.NullUUID) ([]EnrichmentAudit, error) {
+	rows, err := q.db.QueryContext(ctx, listEnrichmentAuditByRelease, releaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []EnrichmentAudit
+	for rows.Next() {
+		var i EnrichmentAudit
+		if err := rows.Scan(
+			&i.ID,
+			&i.ReleaseID,
+			&i.Artist,
+			&i.Album,
+			&i.Provider,
+			&i.Lookup,
+			&i.Url,
+			&i.StatusCode,
+			&i.Error,
+			&i.ResponseExcerpt,
+			&i.DurationMs,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFavoriteReleaseIDs = `-- name: ListFavoriteReleaseIDs :many
+SELECT release_id
+FROM favorites
+WHERE token = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListFavoriteReleaseIDs(ctx context.Context, token string) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listFavoriteReleaseIDs, token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var release_id uuid.UUID
+		if err := rows.Scan(&release_id); err != nil {
+			return nil, err
+		}
+		items = append(items, release_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGenreCounts = `-- name: ListGenreCounts :many
+SELECT LOWER(g.genre)::text AS name, COUNT(DISTINCT r.id) AS release_count
+FROM releases AS r, jsonb_array_elements_text(r.genres) AS g(genre)
+GROUP BY LOWER(g.genre)
+ORDER BY release_count DESC, name
+LIMIT $1
+`
+
+type ListGenreCountsRow struct {
+	Name         string
+	ReleaseCount int64
+}
+
+func (q *Queries) ListGenreCounts(ctx context.Context, limit int32) ([]ListGenreCountsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listGenreCounts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGenreCountsRow
+	for rows.Next() {
+		var i ListGenreCountsRow
+		if err := rows.Scan(&i.Name, &i.ReleaseCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listGenres = `-- name: ListGenres :many
 SELECT id, name, slug
 FROM genres
@@ -240,14 +586,125 @@ func (q *Queries) ListGenres(ctx context.Context) ([]Genre, error) {
 	return items, nil
 }
 
+const listLabels = `-- name: ListLabels :many
+SELECT label AS name, COUNT(*) AS release_count
+FROM releases
+WHERE label <> ''
+GROUP BY label
+ORDER BY release_count DESC, label
+`
+
+type ListLabelsRow struct {
+	Name         string
+	ReleaseCount int64
+}
+
+func (q *Queries) ListLabels(ctx context.Context) ([]ListLabelsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listLabels)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListLabelsRow
+	for rows.Next() {
+		var i ListLabelsRow
+		if err := rows.Scan(&i.Name, &i.ReleaseCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReleaseKeysByDate = `-- name: ListReleaseKeysByDate :many
+SELECT artist, title
+FROM releases
+WHERE release_date = $1
+`
+
+type ListReleaseKeysByDateRow struct {
+	Artist string
+	Title  string
+}
+
+func (q *Queries) ListReleaseKeysByDate(ctx context.Context, releaseDate time.Time) ([]ListReleaseKeysByDateRow, error) {
+	rows, err := q.db.QueryContext(ctx, listReleaseKeysByDate, releaseDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListReleaseKeysByDateRow
+	for rows.Next() {
+		var i ListReleaseKeysByDateRow
+		if err := rows.Scan(&i.Artist, &i.Title); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReleaseSitemapEntries = `-- name: ListReleaseSitemapEntries :many
+SELECT id, updated_at
+FROM releases
+ORDER BY release_date DESC, id
+LIMIT $1 OFFSET $2
+`
+
+type ListReleaseSitemapEntriesParams struct {
+	Limit  int32
+	Offset int32
+}
+
+type ListReleaseSitemapEntriesRow struct {
+	ID        uuid.UUID
+	UpdatedAt time.Time
+}
+
+func (q *Queries) ListReleaseSitemapEntries(ctx context.Context, arg ListReleaseSitemapEntriesParams) ([]ListReleaseSitemapEntriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listReleaseSitemapEntries, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListReleaseSitemapEntriesRow
+	for rows.Next() {
+		var i ListReleaseSitemapEntriesRow
+		if err := rows.Scan(&i.ID, &i.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listReleases = `-- name: ListReleases :many
-SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
 FROM releases
 ORDER BY release_date DESC, created_at DESC
+LIMIT $1
 `
 
-func (q *Queries) ListReleases(ctx context.Context) ([]Release, error) {
-	rows, err := q.db.QueryContext(ctx, listReleases)
+func (q *Queries) ListReleases(ctx context.Context, limit int32) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleases, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -270,6 +727,7 @@ func (q *Queries) ListReleases(ctx context.Context) ([]Release, error) {
 			&i.SpotifyUrl,
 			&i.YoutubeUrl,
 			&i.BandcampUrl,
+			&i.AppleMusicUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -287,14 +745,20 @@ func (q *Queries) ListReleases(ctx context.Context) ([]Release, error) {
 }
 
 const listReleasesByArtist = `-- name: ListReleasesByArtist :many
-SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
 FROM releases
-WHERE artist LIKE '%' || $1 || '%'
+WHERE f_unaccent(LOWER(artist)) LIKE '%' || f_unaccent(LOWER($1::text)) || '%'
 ORDER BY release_date DESC, created_at DESC
+LIMIT $2
 `
 
-func (q *Queries) ListReleasesByArtist(ctx context.Context, dollar_1 sql.NullString) ([]Release, error) {
-	rows, err := q.db.QueryContext(ctx, listReleasesByArtist, dollar_1)
+type ListReleasesByArtistParams struct {
+	Column1 string
+	Limit   int32
+}
+
+func (q *Queries) ListReleasesByArtist(ctx context.Context, arg ListReleasesByArtistParams) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesByArtist, arg.Column1, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -317,6 +781,7 @@ func (q *Queries) ListReleasesByArtist(ctx context.Context, dollar_1 sql.NullStr
 			&i.SpotifyUrl,
 			&i.YoutubeUrl,
 			&i.BandcampUrl,
+			&i.AppleMusicUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -334,19 +799,21 @@ func (q *Queries) ListReleasesByArtist(ctx context.Context, dollar_1 sql.NullStr
 }
 
 const listReleasesByDateRange = `-- name: ListReleasesByDateRange :many
-SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
 FROM releases
 WHERE release_date BETWEEN $1 AND $2
 ORDER BY release_date DESC, created_at DESC
+LIMIT $3
 `
 
 type ListReleasesByDateRangeParams struct {
 	ReleaseDate   time.Time
 	ReleaseDate_2 time.Time
+	Limit         int32
 }
 
 func (q *Queries) ListReleasesByDateRange(ctx context.Context, arg ListReleasesByDateRangeParams) ([]Release, error) {
-	rows, err := q.db.QueryContext(ctx, listReleasesByDateRange, arg.ReleaseDate, arg.ReleaseDate_2)
+	rows, err := q.db.QueryContext(ctx, listReleasesByDateRange, arg.ReleaseDate, arg.ReleaseDate_2, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -369,6 +836,7 @@ func (q *Queries) ListReleasesByDateRange(ctx context.Context, arg ListReleasesB
 			&i.SpotifyUrl,
 			&i.YoutubeUrl,
 			&i.BandcampUrl,
+			&i.AppleMusicUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -386,14 +854,20 @@ func (q *Queries) ListReleasesByDateRange(ctx context.Context, arg ListReleasesB
 }
 
 const listReleasesByExactDate = `-- name: ListReleasesByExactDate :many
-SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
 FROM releases
 WHERE release_date = $1
 ORDER BY created_at DESC
+LIMIT $2
 `
 
-func (q *Queries) ListReleasesByExactDate(ctx context.Context, releaseDate time.Time) ([]Release, error) {
-	rows, err := q.db.QueryContext(ctx, listReleasesByExactDate, releaseDate)
+type ListReleasesByExactDateParams struct {
+	ReleaseDate time.Time
+	Limit       int32
+}
+
+func (q *Queries) ListReleasesByExactDate(ctx context.Context, arg ListReleasesByExactDateParams) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesByExactDate, arg.ReleaseDate, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -416,6 +890,7 @@ func (q *Queries) ListReleasesByExactDate(ctx context.Context, releaseDate time.
 			&i.SpotifyUrl,
 			&i.YoutubeUrl,
 			&i.BandcampUrl,
+			&i.AppleMusicUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -433,19 +908,21 @@ func (q *Queries) ListReleasesByExactDate(ctx context.Context, releaseDate time.
 }
 
 const listReleasesByFollowerRange = `-- name: ListReleasesByFollowerRange :many
-SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
 FROM releases
 WHERE follower_count BETWEEN $1 AND $2
 ORDER BY follower_count DESC, release_date DESC
+LIMIT $3
 `
 
 type ListReleasesByFollowerRangeParams struct {
-	FollowerCount   int32
-	FollowerCount_2 int32
+	FollowerCount   int64
+	FollowerCount_2 int64
+	Limit           int32
 }
 
 func (q *Queries) ListReleasesByFollowerRange(ctx context.Context, arg ListReleasesByFollowerRangeParams) ([]Release, error) {
-	rows, err := q.db.QueryContext(ctx, listReleasesByFollowerRange, arg.FollowerCount, arg.FollowerCount_2)
+	rows, err := q.db.QueryContext(ctx, listReleasesByFollowerRange, arg.FollowerCount, arg.FollowerCount_2, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -468,6 +945,7 @@ func (q *Queries) ListReleasesByFollowerRange(ctx context.Context, arg ListRelea
 			&i.SpotifyUrl,
 			&i.YoutubeUrl,
 			&i.BandcampUrl,
+			&i.AppleMusicUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -485,7 +963,7 @@ func (q *Queries) ListReleasesByFollowerRange(ctx context.Context, arg ListRelea
 }
 
 const listReleasesByGenre = `-- name: ListReleasesByGenre :many
-SELECT r.id, r.title, r.artist, r.album_art_url, r.release_date, r.label, r.label_url, r.follower_count, r.genres, r.country, r.external_links, r.spotify_url, r.youtube_url, r.bandcamp_url, r.created_at, r.updated_at
+SELECT r.id, r.title, r.artist, r.album_art_url, r.release_date, r.label, r.label_url, r.follower_count, r.genres, r.country, r.external_links, r.spotify_url, r.youtube_url, r.bandcamp_url, r.apple_music_url, r.created_at, r.updated_at
 FROM releases AS r
 WHERE EXISTS (
   SELECT 1
@@ -493,10 +971,16 @@ WHERE EXISTS (
   WHERE LOWER(genre) = LOWER($1)
 )
 ORDER BY r.release_date DESC, r.created_at DESC
+LIMIT $2
 `
 
-func (q *Queries) ListReleasesByGenre(ctx context.Context, lower string) ([]Release, error) {
-	rows, err := q.db.QueryContext(ctx, listReleasesByGenre, lower)
+type ListReleasesByGenreParams struct {
+	Lower string
+	Limit int32
+}
+
+func (q *Queries) ListReleasesByGenre(ctx context.Context, arg ListReleasesByGenreParams) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesByGenre, arg.Lower, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -519,6 +1003,7 @@ func (q *Queries) ListReleasesByGenre(ctx context.Context, lower string) ([]Rele
 			&i.SpotifyUrl,
 			&i.YoutubeUrl,
 			&i.BandcampUrl,
+			&i.AppleMusicUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -535,19 +1020,22 @@ func (q *Queries) ListReleasesByGenre(ctx context.Context, lower string) ([]Rele
 	return items, nil
 }
 
-const listReleasesByGenresAny = `-- name: ListReleasesByGenresAny :many
-SELECT r.id, r.title, r.artist, r.album_art_url, r.release_date, r.label, r.label_url, r.follower_count, r.genres, r.country, r.external_links, r.spotify_url, r.youtube_url, r.bandcamp_url, r.created_at, r.updated_at
-FROM releases r
-WHERE EXISTS (
-  SELECT 1
-  FROM jsonb_array_elements_text(r.genres) g(genre)
-  WHERE g.genre = ANY($1::text[])
-)
-ORDER BY r.release_date DESC, r.created_at DESC
+const listReleasesByGenreCount = `-- name: ListReleasesByGenreCount :many
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
+FROM releases
+WHERE jsonb_array_length(genres) BETWEEN $1::int AND $2::int
+ORDER BY release_date DESC, created_at DESC
+LIMIT $3
 `
 
-func (q *Queries) ListReleasesByGenresAny(ctx context.Context, dollar_1 []string) ([]Release, error) {
-	rows, err := q.db.QueryContext(ctx, listReleasesByGenresAny, pq.Array(dollar_1))
+type ListReleasesByGenreCountParams struct {
+	Column1 int32
+	Column2 int32
+	Limit   int32
+}
+
+func (q *Queries) ListReleasesByGenreCount(ctx context.Context, arg ListReleasesByGenreCountParams) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesByGenreCount, arg.Column1, arg.Column2, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -570,6 +1058,7 @@ func (q *Queries) ListReleasesByGenresAny(ctx context.Context, dollar_1 []string
 			&i.SpotifyUrl,
 			&i.YoutubeUrl,
 			&i.BandcampUrl,
+			&i.AppleMusicUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -586,21 +1075,274 @@ func (q *Queries) ListReleasesByGenresAny(ctx context.Context, dollar_1 []string
 	return items, nil
 }
 
-const searchReleases = `-- name: SearchReleases :many
-SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
-FROM releases
-WHERE artist LIKE '%' || $1 || '%'
-   OR title LIKE '%' || $1 || '%'
-ORDER BY release_date DESC, created_at DESC
-`
-
-func (q *Queries) SearchReleases(ctx context.Context, dollar_1 sql.NullString) ([]Release, error) {
-	rows, err := q.db.QueryContext(ctx, searchReleases, dollar_1)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Release
+const listReleasesByGenresAny = `-- name: ListReleasesByGenresAny :many
+SELECT r.id, r.title, r.artist, r.album_art_url, r.release_date, r.label, r.label_url, r.follower_count, r.genres, r.country, r.external_links, r.spotify_url, r.youtube_url, r.bandcamp_url, r.apple_music_url, r.created_at, r.updated_at
+FROM releases r
+WHERE EXISTS (
+  SELECT 1
+  FROM jsonb_array_elements_text(r.genres) g(genre)
+  WHERE g.genre = ANY($1::text[])
+)
+ORDER BY r.release_date DESC, r.created_at DESC
+LIMIT $2
+`
+
+type ListReleasesByGenresAnyParams struct {
+	Column1 []string
+	Limit   int32
+}
+
+func (q *Queries) ListReleasesByGenresAny(ctx context.Context, arg ListReleasesByGenresAnyParams) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesByGenresAny, pq.Array(arg.Column1), arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Release
+	for rows.Next() {
+		var i Release
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Artist,
+			&i.AlbumArtUrl,
+			&i.ReleaseDate,
+			&i.Label,
+			&i.LabelUrl,
+			&i.FollowerCount,
+			&i.Genres,
+			&i.Country,
+			&i.ExternalLinks,
+			&i.SpotifyUrl,
+			&i.YoutubeUrl,
+			&i.BandcampUrl,
+			&i.AppleMusicUrl,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReleasesByIDs = `-- name: ListReleasesByIDs :many
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
+FROM releases
+WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) ListReleasesByIDs(ctx context.Context, ids []uuid.UUID) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesByIDs, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Release
+	for rows.Next() {
+		var i Release
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Artist,
+			&i.AlbumArtUrl,
+			&i.ReleaseDate,
+			&i.Label,
+			&i.LabelUrl,
+			&i.FollowerCount,
+			&i.Genres,
+			&i.Country,
+			&i.ExternalLinks,
+			&i.SpotifyUrl,
+			&i.YoutubeUrl,
+			&i.BandcampUrl,
+			&i.AppleMusicUrl,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReleasesFiltered = `-- name: ListReleasesFiltered :many
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
+FROM releases
+WHERE ($1::date IS NULL OR release_date >= $1::date)
+  AND ($2::date IS NULL OR release_date <= $2::date)
+  AND jsonb_array_length(genres) BETWEEN $3::int AND $4::int
+  AND follower_count BETWEEN $5::bigint AND $6::bigint
+  AND (
+    cardinality($7::text[]) = 0
+    OR ($8::bool AND LOWER(genres::text)::jsonb ?| $7::text[])
+    OR (NOT $8::bool AND LOWER(genres::text)::jsonb @> to_jsonb($7::text[]))
+  )
+  AND NOT (LOWER(genres::text)::jsonb ?| $9::text[])
+  AND (
+    $10::text = ''
+    OR ($10::text IN ('spotify', 'any') AND COALESCE(spotify_url, '') <> '')
+    OR ($10::text IN ('youtube', 'any') AND COALESCE(youtube_url, '') <> '')
+    OR ($10::text IN ('bandcamp', 'any') AND COALESCE(bandcamp_url, '') <> '')
+    OR ($10::text IN ('apple_music', 'any') AND COALESCE(apple_music_url, '') <> '')
+  )
+ORDER BY release_date DESC, created_at DESC
+LIMIT $11
+`
+
+type ListReleasesFilteredParams struct {
+	DateFrom       sql.NullTime
+	DateTo         sql.NullTime
+	MinGenres      int32
+	MaxGenres      int32
+	FollowerMin    int64
+	FollowerMax    int64
+	IncludedGenres []string
+	GenresMatchAny bool
+	ExcludedGenres []string
+	HasPreview     string
+	RowLimit       int32
+}
+
+// Genres are compared lowercased (callers pass lowercased genres) so
+// matching stays case-insensitive like the in-memory filters
+func (q *Queries) ListReleasesFiltered(ctx context.Context, arg ListReleasesFilteredParams) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesFiltered,
+		arg.DateFrom,
+		arg.DateTo,
+		arg.MinGenres,
+		arg.MaxGenres,
+		arg.FollowerMin,
+		arg.FollowerMax,
+		pq.Array(arg.IncludedGenres),
+		arg.GenresMatchAny,
+		pq.Array(arg.ExcludedGenres),
+		arg.HasPreview,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Release
+	for rows.Next() {
+		var i Release
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Artist,
+			&i.AlbumArtUrl,
+			&i.ReleaseDate,
+			&i.Label,
+			&i.LabelUrl,
+			&i.FollowerCount,
+			&i.Genres,
+			&i.Country,
+			&i.ExternalLinks,
+			&i.SpotifyUrl,
+			&i.YoutubeUrl,
+			&i.BandcampUrl,
+			&i.AppleMusicUrl,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReleasesNeedingArt = `-- name: ListReleasesNeedingArt :many
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
+FROM releases
+WHERE album_art_url = '' OR album_art_url LIKE 'https://via.placeholder.com/%'
+ORDER BY follower_count DESC, release_date DESC
+LIMIT $1
+`
+
+func (q *Queries) ListReleasesNeedingArt(ctx context.Context, limit int32) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesNeedingArt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Release
+	for rows.Next() {
+		var i Release
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Artist,
+			&i.AlbumArtUrl,
+			&i.ReleaseDate,
+			&i.Label,
+			&i.LabelUrl,
+			&i.FollowerCount,
+			&i.Genres,
+			&i.Country,
+			&i.ExternalLinks,
+			&i.SpotifyUrl,
+			&i.YoutubeUrl,
+			&i.BandcampUrl,
+			&i.AppleMusicUrl,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReleasesWithInvalidCountry = `-- name: ListReleasesWithInvalidCountry :many
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
+FROM releases
+WHERE country IS NOT NULL
+  AND NOT (country = ANY($1::text[]))
+ORDER BY country, created_at DESC
+LIMIT $2::int
+`
+
+type ListReleasesWithInvalidCountryParams struct {
+	Codes    []string
+	RowLimit int32
+}
+
+func (q *Queries) ListReleasesWithInvalidCountry(ctx context.Context, arg ListReleasesWithInvalidCountryParams) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesWithInvalidCountry, pq.Array(arg.Codes), arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Release
 	for rows.Next() {
 		var i Release
 		if err := rows.Scan(
@@ -618,6 +1360,132 @@ func (q *Queries) SearchReleases(ctx context.Context, dollar_1 sql.NullString) (
 			&i.SpotifyUrl,
 			&i.YoutubeUrl,
 			&i.BandcampUrl,
+			&i.AppleMusicUrl,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReleasesWithMissingFields = `-- name: ListReleasesWithMissingFields :many
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
+FROM releases
+WHERE country IS NULL
+   OR genres = '[]'::jsonb
+   OR album_art_url = '' OR album_art_url LIKE 'https://via.placeholder.com/%'
+   OR spotify_url IS NULL
+   OR youtube_url IS NULL
+   OR apple_music_url IS NULL
+   OR label_url IS NULL
+ORDER BY follower_count DESC, release_date DESC
+LIMIT $1
+`
+
+func (q *Queries) ListReleasesWithMissingFields(ctx context.Context, limit int32) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, listReleasesWithMissingFields, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Release
+	for rows.Next() {
+		var i Release
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Artist,
+			&i.AlbumArtUrl,
+			&i.ReleaseDate,
+			&i.Label,
+			&i.LabelUrl,
+			&i.FollowerCount,
+			&i.Genres,
+			&i.Country,
+			&i.ExternalLinks,
+			&i.SpotifyUrl,
+			&i.YoutubeUrl,
+			&i.BandcampUrl,
+			&i.AppleMusicUrl,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const removeFavorite = `-- name: RemoveFavorite :exec
+DELETE FROM favorites
+WHERE token = $1 AND release_id = $2
+`
+
+type RemoveFavoriteParams struct {
+	Token     string
+	ReleaseID uuid.UUID
+}
+
+func (q *Queries) RemoveFavorite(ctx context.Context, arg RemoveFavoriteParams) error {
+	_, err := q.db.ExecContext(ctx, removeFavorite, arg.Token, arg.ReleaseID)
+	return err
+}
+
+const searchReleases = `-- name: SearchReleases :many
+SELECT id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
+FROM releases
+WHERE f_unaccent(LOWER(artist)) LIKE '%' || f_unaccent(LOWER($1::text)) || '%'
+   OR f_unaccent(LOWER(title)) LIKE '%' || f_unaccent(LOWER($1::text)) || '%'
+ORDER BY release_date DESC, created_at DESC
+LIMIT $2
+`
+
+type SearchReleasesParams struct {
+	Column1 string
+	Limit   int32
+}
+
+func (q *Queries) SearchReleases(ctx context.Context, arg SearchReleasesParams) ([]Release, error) {
+	rows, err := q.db.QueryContext(ctx, searchReleases, arg.Column1, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Release
+	for rows.Next() {
+		var i Release
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Artist,
+			&i.AlbumArtUrl,
+			&i.ReleaseDate,
+			&i.Label,
+			&i.LabelUrl,
+			&i.FollowerCount,
+			&i.Genres,
+			&i.Country,
+			&i.ExternalLinks,
+			&i.SpotifyUrl,
+			&i.YoutubeUrl,
+			&i.BandcampUrl,
+			&i.AppleMusicUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -672,9 +1540,10 @@ SET
   spotify_url = $12,
   youtube_url = $13,
   bandcamp_url = $14,
+  apple_music_url = $15,
   updated_at = now()
 WHERE id = $1
-RETURNING id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, created_at, updated_at
+RETURNING id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at
 `
 
 type UpdateReleaseParams struct {
@@ -685,13 +1554,14 @@ type UpdateReleaseParams struct {
 	ReleaseDate   time.Time
 	Label         string
 	LabelUrl      sql.NullString
-	FollowerCount int32
+	FollowerCount int64
 	Genres        json.RawMessage
 	Country       sql.NullString
 	ExternalLinks json.RawMessage
 	SpotifyUrl    sql.NullString
 	YoutubeUrl    sql.NullString
 	BandcampUrl   sql.NullString
+	AppleMusicUrl sql.NullString
 }
 
 func (q *Queries) UpdateRelease(ctx context.Context, arg UpdateReleaseParams) (Release, error) {
@@ -710,6 +1580,7 @@ func (q *Queries) UpdateRelease(ctx context.Context, arg UpdateReleaseParams) (R
 		arg.SpotifyUrl,
 		arg.YoutubeUrl,
 		arg.BandcampUrl,
+		arg.AppleMusicUrl,
 	)
 	var i Release
 	err := row.Scan(
@@ -727,8 +1598,224 @@ func (q *Queries) UpdateRelease(ctx context.Context, arg UpdateReleaseParams) (R
 		&i.SpotifyUrl,
 		&i.YoutubeUrl,
 		&i.BandcampUrl,
+		&i.AppleMusicUrl,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateReleaseArt = `-- name: UpdateReleaseArt :execrows
+UPDATE releases
+SET
+  album_art_url = $2,
+  updated_at = now()
+WHERE id = $1
+  AND (album_art_url = '' OR album_art_url LIKE 'https://via.placeholder.com/%')
+`
+
+type UpdateReleaseArtParams struct {
+	ID          uuid.UUID
+	AlbumArtUrl string
+}
+
+func (q *Queries) UpdateReleaseArt(ctx context.Context, arg UpdateReleaseArtParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateReleaseArt, arg.ID, arg.AlbumArtUrl)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateReleaseCountry = `-- name: UpdateReleaseCountry :execrows
+UPDATE releases
+SET
+  country = $1,
+  updated_at = now()
+WHERE country = $2::text
+`
+
+type UpdateReleaseCountryParams struct {
+	NewCountry sql.NullString
+	OldCountry string
+}
+
+func (q *Queries) UpdateReleaseCountry(ctx context.Context, arg UpdateReleaseCountryParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateReleaseCountry, arg.NewCountry, arg.OldCountry)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateReleaseYoutubeURL = `-- name: UpdateReleaseYoutubeURL :execrows
+UPDATE releases
+SET
+  youtube_url = $1::text,
+  external_links = CASE
+    WHEN jsonb_typeof(external_links) = 'object'
+      THEN jsonb_set(external_links, '{youtube}', to_jsonb($1::text))
+    ELSE external_links
+  END,
+  updated_at = now()
+WHERE id = $2
+  AND youtube_url = $3::text
+`
+
+type UpdateReleaseYoutubeURLParams struct {
+	NewUrl string
+	ID     uuid.UUID
+	OldUrl string
+}
+
+func (q *Queries) UpdateReleaseYoutubeURL(ctx context.Context, arg UpdateReleaseYoutubeURLParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateReleaseYoutubeURL, arg.NewUrl, arg.ID, arg.OldUrl)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const upsertRelease = `-- name: UpsertRelease :one
+INSERT INTO releases (
+  id,
+  title,
+  artist,
+  album_art_url,
+  release_date,
+  label,
+  label_url,
+  follower_count,
+  genres,
+  country,
+  external_links,
+  spotify_url,
+  youtube_url,
+  bandcamp_url,
+  apple_music_url
+) VALUES (
+  $1,  -- id
+  $2,  -- title
+  $3,  -- artist
+  $4,  -- album_art_url
+  $5,  -- release_date
+  $6,  -- label
+  $7,  -- label_url
+  $8,  -- follower_count
+  $9,  -- genres (jsonb)
+  $10, -- country
+  $11, -- external_links (jsonb)
+  $12, -- spotify_url
+  $13, -- youtube_url
+  $14, -- bandcamp_url
+  $15  -- apple_music_url
+)
+ON CONFLICT (lower(artist), lower(title), release_date) DO UPDATE
+SET
+  -- Stored values win; only empty fields are filled in
+  album_art_url = CASE
+    WHEN releases.album_art_url = '' OR releases.album_art_url LIKE 'https://via.placeholder.com/%'
+      THEN EXCLUDED.album_art_url
+    ELSE releases.album_art_url
+  END,
+  label = COALESCE(NULLIF(releases.label, ''), EXCLUDED.label),
+  label_url = COALESCE(NULLIF(releases.label_url, ''), EXCLUDED.label_url),
+  follower_count = COALESCE(NULLIF(releases.follower_count, 0), EXCLUDED.follower_count),
+  genres = CASE
+    WHEN releases.genres = '[]'::jsonb THEN EXCLUDED.genres
+    ELSE releases.genres
+  END,
+  country = COALESCE(NULLIF(releases.country, ''), EXCLUDED.country),
+  external_links = CASE
+    WHEN jsonb_typeof(releases.external_links) = 'object' AND jsonb_typeof(EXCLUDED.external_links) = 'object'
+      THEN EXCLUDED.external_links || releases.external_links
+    ELSE releases.external_links
+  END,
+  spotify_url = COALESCE(NULLIF(releases.spotify_url, ''), EXCLUDED.spotify_url),
+  youtube_url = COALESCE(NULLIF(releases.youtube_url, ''), EXCLUDED.youtube_url),
+  bandcamp_url = COALESCE(NULLIF(releases.bandcamp_url, ''), EXCLUDED.bandcamp_url),
+  apple_music_url = COALESCE(NULLIF(releases.apple_music_url, ''), EXCLUDED.apple_music_url),
+  updated_at = now()
+RETURNING id, title, artist, album_art_url, release_date, label, label_url, follower_count, genres, country, external_links, spotify_url, youtube_url, bandcamp_url, apple_music_url, created_at, updated_at, (xmax = 0) AS inserted
+`
+
+type UpsertReleaseParams struct {
+	ID            uuid.UUID
+	Title         string
+	Artist        string
+	AlbumArtUrl   string
+	ReleaseDate   time.Time
+	Label         string
+	LabelUrl      sql.NullString
+	FollowerCount int64
+	Genres        json.RawMessage
+	Country       sql.NullString
+	ExternalLinks json.RawMessage
+	SpotifyUrl    sql.NullString
+	YoutubeUrl    sql.NullString
+	BandcampUrl   sql.NullString
+	AppleMusicUrl sql.NullString
+}
+
+type UpsertReleaseRow struct {
+	ID            uuid.UUID
+	Title         string
+	Artist        string
+	AlbumArtUrl   string
+	ReleaseDate   time.Time
+	Label         string
+	LabelUrl      sql.NullString
+	FollowerCount int64
+	Genres        json.RawMessage
+	Country       sql.NullString
+	ExternalLinks json.RawMessage
+	SpotifyUrl    sql.NullString
+	YoutubeUrl    sql.NullString
+	BandcampUrl   sql.NullString
+	AppleMusicUrl sql.NullString
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Inserted      bool
+}
+
+func (q *Queries) UpsertRelease(ctx context.Context, arg UpsertReleaseParams) (UpsertReleaseRow, error) {
+	row := q.db.QueryRowContext(ctx, upsertRelease,
+		arg.ID,
+		arg.Title,
+		arg.Artist,
+		arg.AlbumArtUrl,
+		arg.ReleaseDate,
+		arg.Label,
+		arg.LabelUrl,
+		arg.FollowerCount,
+		arg.Genres,
+		arg.Country,
+		arg.ExternalLinks,
+		arg.SpotifyUrl,
+		arg.YoutubeUrl,
+		arg.BandcampUrl,
+		arg.AppleMusicUrl,
+	)
+	var i UpsertReleaseRow
+	err := row.Scan(
+		&i.ID,
+		&i.Title,
+		&i.Artist,
+		&i.AlbumArtUrl,
+		&i.ReleaseDate,
+		&i.Label,
+		&i.LabelUrl,
+		&i.FollowerCount,
+		&i.Genres,
+		&i.Country,
+		&i.ExternalLinks,
+		&i.SpotifyUrl,
+		&i.YoutubeUrl,
+		&i.BandcampUrl,
+		&i.AppleMusicUrl,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Inserted,
 	)
 	return i, err
 }
@@ -0,0 +1,175 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultKeyPrefix   = "blastbeat:"
+	DefaultDialTimeout = 5 * time.Second
+)
+
+type Options struct {
+	// Redis is the parsed connection config (see config.ParseRedisURL)
+	Redis *redis.Options
+
+	// KeyPrefix namespaces every key; defaults to DefaultKeyPrefix
+	KeyPrefix string
+}
+
+type State struct {
+	opts   *Options
+	client *redis.Client
+}
+
+// Z is a sorted set member and its score
+type Z struct {
+	Member string
+	Score  float64
+}
+
+func New(opts *Options) (*State, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, errors.Wrap(err, "invalid options")
+	}
+
+	client := redis.NewClient(opts.Redis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDialTimeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to ping redis")
+	}
+
+	return &State{
+		opts:   opts,
+		client: client,
+	}, nil
+}
+
+func validateOptions(opts *Options) error {
+	if opts == nil {
+		return errors.New("options cannot be nil")
+	}
+
+	if opts.Redis == nil {
+		return errors.New("redis options cannot be nil")
+	}
+
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = DefaultKeyPrefix
+	}
+
+	return nil
+}
+
+func (s *State) key(k string) string {
+	return s.opts.KeyPrefix + k
+}
+
+// Incr atomically increments key by 1 and returns the new value
+func (s *State) Incr(ctx context.Context, key string) (int64, error) {
+	return s.client.Incr(ctx, s.key(key)).Result()
+}
+
+// IncrBy atomically increments key by n and returns the new value
+func (s *State) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	return s.client.IncrBy(ctx, s.key(key), n).Result()
+}
+
+// GetDel atomically reads and deletes an integer key; a missing key is 0
+func (s *State) GetDel(ctx context.Context, key string) (int64, error) {
+	v, err := s.client.GetDel(ctx, s.key(key)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+
+	return v, err
+}
+
+// SAdd adds members to the set at key
+func (s *State) SAdd(ctx context.Context, key string, members ...string) error {
+	args := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		args = append(args, m)
+	}
+
+	return s.client.SAdd(ctx, s.key(key), args...).Err()
+}
+
+// SPopN removes and returns up to n random members of the set at key
+func (s *State) SPopN(ctx context.Context, key string, n int64) ([]string, error) {
+	return s.client.SPopN(ctx, s.key(key), n).Result()
+}
+
+// ZIncrBy increments member's score in the sorted set at key and (re)sets
+// the key's TTL; a ttl of 0 leaves the key without expiry
+func (s *State) ZIncrBy(ctx context.Context, key, member string, incr float64, ttl time.Duration) error {
+	pipe := s.client.TxPipeline()
+	pipe.ZIncrBy(ctx, s.key(key), incr, member)
+
+	if ttl > 0 {
+		pipe.Expire(ctx, s.key(key), ttl)
+	}
+
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// ZUnionTop returns the n highest scored members across the sorted sets at
+// keys, each set's scores multiplied by the matching weight
+func (s *State) ZUnionTop(ctx context.Context, keys []string, weights []float64, n int) ([]Z, error) {
+	prefixed := make([]string, 0, len(keys))
+	for _, k := range keys {
+		prefixed = append(prefixed, s.key(k))
+	}
+
+	zs, err := s.client.ZUnionWithScores(ctx, redis.ZStore{
+		Keys:    prefixed,
+		Weights: weights,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// ZUNION has no LIMIT; results come back ascending by score
+	out := make([]Z, 0, n)
+
+	for i := len(zs) - 1; i >= 0 && len(out) < n; i-- {
+		member, _ := zs[i].Member.(string)
+		out = append(out, Z{Member: member, Score: zs[i].Score})
+	}
+
+	return out, nil
+}
+
+// SetNX sets key with a ttl unless it already exists and reports whether it
+// was set; use it as a lock that expires on its own
+func (s *State) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.key(key), 1, ttl).Result()
+}
+
+// Del removes keys
+func (s *State) Del(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, 0, len(keys))
+	for _, k := range keys {
+		prefixed = append(prefixed, s.key(k))
+	}
+
+	return s.client.Del(ctx, prefixed...).Err()
+}
+
+// Ping checks the redis connection
+func (s *State) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *State) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const itunesSearchBase = "https://itunes.apple.com/search"
+
+// itunesAlbum is an album result of an iTunes Search API query
+type itunesAlbum struct {
+	ArtistName        string `json:"artistName"`
+	CollectionName    string `json:"collectionName"`
+	CollectionViewURL string `json:"collectionViewUrl"`
+}
+
+// findAppleMusicURL returns the Apple Music page of the artist's album from
+// the iTunes Search API (no key needed), or "" when there is no close match.
+// ITUNES_COUNTRY picks the storefront searched (default us); albums that
+// aren't sold there aren't found.
+func findAppleMusicURL(ctx context.Context, artist, album string) string {
+	q := url.Values{
+		"term":    {artist + " " + album},
+		"media":   {"music"},
+		"entity":  {"album"},
+		"country": {strings.ToLower(getenv("ITUNES_COUNTRY", "us"))},
+		"limit":   {"25"},
+	}
+
+	u := itunesSearchBase + "?" + q.Encode()
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+	logrus.Debugf("REQ GET %s", u)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logrus.Warnf("iTunes search: %v", err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logrus.Warnf("iTunes search %d", resp.StatusCode)
+		return ""
+	}
+
+	b, _ := io.ReadAll(resp.Body)
+
+	albums, err := parseITunesAlbums(b)
+	if err != nil {
+		logrus.Warnf("iTunes search: %v", err)
+		recordProviderError(ctx, "itunes", err)
+
+		return ""
+	}
+
+	return pickAppleMusicURL(albums, artist, album)
+}
+
+func parseITunesAlbums(b []byte) ([]itunesAlbum, error) {
+	var out struct {
+		Results []itunesAlbum `json:"results"`
+	}
+
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, errors.Wrap(err, "failed to decode response")
+	}
+
+	return out.Results, nil
+}
+
+// pickAppleMusicURL returns the link of the album by artist whose title is
+// most similar to album, without the iTunes affiliate query ("?uo=4")
+func pickAppleMusicURL(albums []itunesAlbum, artist, album string) string {
+	var (
+		best      string
+		bestScore float64
+	)
+
+	for _, a := range albums {
+		if norm(a.ArtistName) != norm(artist) || a.CollectionViewURL == "" {
+			continue
+		}
+
+		score := albumSimilarity(a.CollectionName, album)
+		if score >= minAlbumSimilarity && score > bestScore {
+			best, bestScore = a.CollectionViewURL, score
+		}
+	}
+
+	if i := strings.IndexByte(best, '?'); i >= 0 {
+		best = best[:i]
+	}
+
+	return best
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+// auditExcerptBytes is how much of each provider response is kept
+const auditExcerptBytes = 2048
+
+type (
+	auditKey  struct{}
+	lookupKey struct{}
+)
+
+// auditEntry is a single provider call made while enriching a row
+type auditEntry struct {
+	provider   string
+	lookup     string
+	url        string
+	statusCode int
+	err        string
+	excerpt    string
+	duration   time.Duration
+}
+
+// auditLog collects the provider calls of one row; it is attached to the
+// row's context with withAudit
+type auditLog struct {
+	mu      sync.Mutex
+	entries []auditEntry
+}
+
+func (l *auditLog) add(e auditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, e)
+}
+
+func withAudit(ctx context.Context) (context.Context, *auditLog) {
+	l := &auditLog{}
+	return context.WithValue(ctx, auditKey{}, l), l
+}
+
+// withLookup labels provider calls made with ctx (e.g. "country") so the
+// audit shows what each call was for
+func withLookup(ctx context.Context, lookup string) context.Context {
+	return context.WithValue(ctx, lookupKey{}, lookup)
+}
+
+// auditTransport records every request whose context carries an auditLog;
+// requests without one pass straight through. Failed calls are also added
+// to the row's providerErrorLog.
+type auditTransport struct {
+	base http.RoundTripper
+}
+
+func (t *auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l, _ := req.Context().Value(auditKey{}).(*auditLog)
+	if l == nil {
+		resp, err := t.base.RoundTrip(req)
+		recordProviderCall(req.Context(), req, resp, err)
+
+		return resp, err
+	}
+
+	lookup, _ := req.Context().Value(lookupKey{}).(string)
+	if lookup == "" {
+		lookup = "unknown"
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	recordProviderCall(req.Context(), req, resp, err)
+
+	e := auditEntry{
+		provider: providerForHost(req.URL.Hostname()),
+		lookup:   lookup,
+		url:      redactURL(req.URL),
+		duration: time.Since(start),
+	}
+
+	if err != nil {
+		e.err = err.Error()
+		l.add(e)
+
+		return resp, err
+	}
+
+	e.statusCode = resp.StatusCode
+
+	// Keep the start of the body and hand the caller an equivalent reader
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, auditExcerptBytes))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+
+	e.excerpt = strings.ToValidUTF8(string(head), string(utf8.RuneError))
+	l.add(e)
+
+	return resp, nil
+}
+
+// providerForHost maps a request host to its providerLimits name, falling
+// back to the host itself
+func providerForHost(host string) string {
+	for _, l := range providerLimits {
+		if host == l.Host {
+			return l.Name
+		}
+	}
+
+	if strings.HasSuffix(host, ".spotify.com") {
+		return "spotify"
+	}
+
+	return host
+}
+
+// redactURL strips credentials that providers take as query params
+func redactURL(u *url.URL) string {
+	q := u.Query()
+
+	for _, param := range []string{"key", "token", "api_key"} {
+		if q.Has(param) {
+			q.Set(param, "REDACTED")
+		}
+	}
+
+	redacted := *u
+	redacted.RawQuery = q.Encode()
+
+	return redacted.String()
+}
+
+// writeAudit persists l's entries; releaseID may be uuid.Nil when the row
+// was not inserted
+func writeAudit(ctx context.Context, store importStore, l *auditLog,
+	releaseID uuid.UUID, artist, album string) {
+	if l == nil || store == nil {
+		return
+	}
+
+	l.mu.Lock()
+	entries := l.entries
+	l.mu.Unlock()
+
+	for _, e := range entries {
+		err := store.CreateEnrichmentAudit(ctx, gensql.CreateEnrichmentAuditParams{
+			ReleaseID:       uuid.NullUUID{UUID: releaseID, Valid: releaseID != uuid.Nil},
+			Artist:          artist,
+			Album:           album,
+			Provider:        e.provider,
+			Lookup:          e.lookup,
+			Url:             e.url,
+			StatusCode:      int32(e.statusCode),
+			Error:           sql.NullString{String: e.err, Valid: e.err != ""},
+			ResponseExcerpt: sql.NullString{String: e.excerpt, Valid: e.excerpt != ""},
+			DurationMs:      int32(e.duration.Milliseconds()),
+		})
+		if err != nil {
+			logrus.Warnf("unable to write enrichment audit for %s - %s: %v", artist, album, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+// runArtBackfill re-resolves art for releases that still have placeholder
+// art, trying Spotify album art, then the Spotify artist image, then
+// Bandcamp. Rows are only updated when a real image is found, and only
+// with -enable-write.
+func runArtBackfill(limit int) error {
+	if os.Getenv("SPOTIFY_CLIENT_ID") == "" || os.Getenv("SPOTIFY_CLIENT_SECRET") == "" {
+		return errors.New("SPOTIFY_CLIENT_ID and SPOTIFY_CLIENT_SECRET are required for -backfill-art")
+	}
+
+	if !enableWrite {
+		logrus.Info("DRY RUN MODE - no database writes will occur")
+	}
+
+	dbBackend, err := openDB(0)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to database")
+	}
+	defer dbBackend.GetDB().Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	releases, err := dbBackend.ListReleasesNeedingArt(ctx, int32(limit))
+	if err != nil {
+		return errors.Wrap(err, "failed to list releases needing art")
+	}
+
+	logrus.Infof("Art backfill start (releases=%d, enable-write=%v)", len(releases), enableWrite)
+
+	var found, updated, missing int
+
+	for _, r := range releases {
+		if ctx.Err() != nil {
+			logrus.Warnf("Art backfill interrupted: %v", ctx.Err())
+			break
+		}
+
+		art, source := resolveArt(ctx, r.Artist, r.Title)
+		if art == "" {
+			missing++
+			logrus.Infof("no art found: %s - %s", r.Artist, r.Title)
+			continue
+		}
+
+		found++
+		logrus.Infof("art found via %s: %s - %s: %s", source, r.Artist, r.Title, art)
+
+		if !enableWrite {
+			continue
+		}
+
+		n, err := dbBackend.UpdateReleaseArt(ctx, gensql.UpdateReleaseArtParams{
+			ID:          r.ID,
+			AlbumArtUrl: art,
+		})
+		if err != nil {
+			logrus.Errorf("failed to update art for %s: %v", r.ID, err)
+			continue
+		}
+
+		updated += int(n)
+	}
+
+	logrus.Infof("Art backfill done. Checked: %d, Found: %d, Updated: %d, Missing: %d",
+		len(releases), found, updated, missing)
+
+	return nil
+}
+
+// resolveArt returns the first real image found and where it came from
+func resolveArt(ctx context.Context, artist, album string) (string, string) {
+	if art := spotifyAlbumArt(ctx, artist, album); art != "" {
+		return art, "spotify_album"
+	}
+
+	if art := spotifyArtistImage(ctx, artist); art != "" {
+		return art, "spotify_artist"
+	}
+
+	if art := bandcampAlbumArt(ctx, artist, album); art != "" {
+		return art, "bandcamp"
+	}
+
+	return "", ""
+}
+
+func spotifyAlbumArt(ctx context.Context, artist, album string) string {
+	var sb struct {
+		Albums struct {
+			Items []struct {
+				Name    string `json:"name"`
+				Artists []struct {
+					Name string `json:"name"`
+				} `json:"artists"`
+				Images []struct {
+					URL string `json:"url"`
+				} `json:"images"`
+			} `json:"items"`
+		} `json:"albums"`
+	}
+
+	q := fmt.Sprintf(`album:"%s" artist:"%s"`, album, artist)
+	if !spotifySearch(ctx, "album", q, &sb) {
+		return ""
+	}
+
+	for _, item := range sb.Albums.Items {
+		if norm(item.Name) != norm(album) || len(item.Images) == 0 {
+			continue
+		}
+
+		for _, a := range item.Artists {
+			if norm(a.Name) == norm(artist) {
+				return item.Images[0].URL
+			}
+		}
+	}
+
+	return ""
+}
+
+func spotifyArtistImage(ctx context.Context, artist string) string {
+	var sa struct {
+		Artists struct {
+			Items []struct {
+				Name   string `json:"name"`
+				Images []struct {
+					URL string `json:"url"`
+				} `json:"images"`
+			} `json:"items"`
+		} `json:"artists"`
+	}
+
+	if !spotifySearch(ctx, "artist", `artist:"`+artist+`"`, &sa) {
+		return ""
+	}
+
+	for _, item := range sa.Artists.Items {
+		if norm(item.Name) == norm(artist) && len(item.Images) > 0 {
+			return item.Images[0].URL
+		}
+	}
+
+	return ""
+}
+
+// spotifySearch runs a throttled Spotify search and decodes it into out
+func spotifySearch(ctx context.Context, kind, q string, out interface{}) bool {
+	tok := getSpotifyToken(ctx)
+	if tok == "" {
+		return false
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET",
+		spotifySearchBase+"?type="+kind+"&limit=5&q="+url.QueryEscape(q), nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	logrus.Debugf("REQ GET %s", req.URL.String())
+
+	resp, err := doWithRetry(req)
+	if err != nil {
+		logrus.Warnf("Spotify %s search: %v", kind, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logrus.Warnf("Spotify %s search %d", kind, resp.StatusCode)
+		return false
+	}
+
+	b, _ := io.ReadAll(resp.Body)
+
+	return json.Unmarshal(b, out) == nil
+}
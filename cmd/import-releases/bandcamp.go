@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const bandcampSearchBase = "https://bandcamp.com/search"
+
+var (
+	bcResultRe  = regexp.MustCompile(`(?s)<li class="searchresult[^"]*"(.*?)</li>`)
+	bcArtRe     = regexp.MustCompile(`(?s)<div class="art">\s*<img src="([^"]+)"`)
+	bcHeadingRe = regexp.MustCompile(`(?s)<div class="heading">\s*<a[^>]*?href="([^"]*)"[^>]*>(.*?)</a>`)
+	bcSubheadRe = regexp.MustCompile(`(?s)<div class="subhead">\s*(?:from .*?)?by (.*?)\s*</div>`)
+)
+
+// bandcampResult is an album in a Bandcamp search results page
+type bandcampResult struct {
+	Title  string
+	Artist string
+	URL    string
+	Art    string
+}
+
+// findBandcampURL returns the Bandcamp page of the artist's album, or ""
+// when Bandcamp has none
+func findBandcampURL(ctx context.Context, artist, album string) string {
+	page := searchBandcamp(ctx, artist, album)
+	if page == "" {
+		return ""
+	}
+
+	return parseBandcampURL(page, artist, album)
+}
+
+// bandcampAlbumArt returns the art of the artist's album on Bandcamp for
+// -backfill-art
+func bandcampAlbumArt(ctx context.Context, artist, album string) string {
+	page := searchBandcamp(ctx, artist, album)
+	if page == "" {
+		return ""
+	}
+
+	return parseBandcampArt(page, artist, album)
+}
+
+// searchBandcamp returns the album search results page for artist and
+// album, or "" when the search fails
+func searchBandcamp(ctx context.Context, artist, album string) string {
+	u := bandcampSearchBase + "?item_type=a&q=" + url.QueryEscape(artist+" "+album)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+	req.Header.Set("User-Agent", "blastbeat-api/1.0 (+"+getenv("CONTACT_EMAIL", defaultContactEmail)+")")
+	logrus.Debugf("REQ GET %s", u)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logrus.Warnf("Bandcamp search: %v", err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logrus.Warnf("Bandcamp search %d", resp.StatusCode)
+		return ""
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 2<<20))
+
+	return string(b)
+}
+
+// parseBandcampResults returns the albums of a search results page, in
+// page order
+func parseBandcampResults(page string) []bandcampResult {
+	var results []bandcampResult
+
+	for _, m := range bcResultRe.FindAllStringSubmatch(page, -1) {
+		block := m[1]
+
+		heading := bcHeadingRe.FindStringSubmatch(block)
+		subhead := bcSubheadRe.FindStringSubmatch(block)
+
+		if heading == nil || subhead == nil {
+			continue
+		}
+
+		r := bandcampResult{
+			Title:  htmlUnescape(strings.TrimSpace(stripTags(heading[2]))),
+			Artist: htmlUnescape(strings.TrimSpace(stripTags(subhead[1]))),
+			URL:    bandcampAlbumURL(htmlUnescape(heading[1])),
+		}
+
+		if art := bcArtRe.FindStringSubmatch(block); art != nil {
+			r.Art = art[1]
+		}
+
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// bandcampAlbumURL strips the search tracking params from a result link
+func bandcampAlbumURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	u.RawQuery, u.Fragment = "", ""
+
+	return u.String()
+}
+
+// parseBandcampArt returns the art of the first Bandcamp search result
+// whose title and artist match
+func parseBandcampArt(page, artist, album string) string {
+	for _, r := range parseBandcampResults(page) {
+		if r.Art != "" && norm(r.Title) == norm(album) && norm(r.Artist) == norm(artist) {
+			return r.Art
+		}
+	}
+
+	return ""
+}
+
+// parseBandcampURL returns the album page of the first Bandcamp search
+// result whose title and artist match. Searches often return the same title
+// by several artists (splits, tributes, namesakes), so only an exact
+// normalized artist match counts.
+func parseBandcampURL(page, artist, album string) string {
+	for _, r := range parseBandcampResults(page) {
+		if r.URL != "" && norm(r.Title) == norm(album) && norm(r.Artist) == norm(artist) {
+			return r.URL
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCacheFile    = ".import-releases-cache.json"
+	defaultCacheTTLDays = 30
+)
+
+// lookups caches Metal Archives and MusicBrainz artist lookups between runs;
+// nil (replay, or before loadLookupCache) always fetches
+var lookups *lookupCache
+
+// lookupCache is an on-disk JSON cache of per-artist lookups, keyed by
+// lookup and normalized artist name. Only hits are cached, so artists a
+// provider didn't know (or failed for) are looked up again next run.
+type lookupCache struct {
+	path string
+	ttl  time.Duration
+
+	// refresh ignores cached entries but still stores fresh results
+	refresh bool
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+type cacheEntry struct {
+	Country   string    `json:"country,omitempty"`
+	Genres    []string  `json:"genres,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// openLookupCache reads the cache at path, dropping expired entries; a
+// missing file is an empty cache
+func openLookupCache(path string, ttl time.Duration, refresh bool) (*lookupCache, error) {
+	c := &lookupCache{
+		path:    path,
+		ttl:     ttl,
+		refresh: refresh,
+		entries: map[string]cacheEntry{},
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read cache %s", path)
+	}
+
+	if err := json.Unmarshal(b, &c.entries); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode cache %s", path)
+	}
+
+	for k, e := range c.entries {
+		if c.expired(e) {
+			delete(c.entries, k)
+			c.dirty = true
+		}
+	}
+
+	return c, nil
+}
+
+// loadLookupCache sets lookups from the cache at path, with CACHE_TTL_DAYS
+// as the TTL. An unreadable cache is warned about and replaced.
+func loadLookupCache(path string, refresh bool) {
+	days := defaultCacheTTLDays
+	if n, ok := positiveEnv("CACHE_TTL_DAYS"); ok {
+		days = n
+	}
+
+	ttl := time.Duration(days) * 24 * time.Hour
+
+	c, err := openLookupCache(path, ttl, refresh)
+	if err != nil {
+		logrus.Warnf("ignoring lookup cache: %v", err)
+		c = &lookupCache{path: path, ttl: ttl, refresh: refresh, entries: map[string]cacheEntry{}}
+	}
+
+	logrus.Infof("Lookup cache: %s (entries=%d, ttl=%dd, refresh=%v)", path, len(c.entries), days, refresh)
+
+	lookups = c
+}
+
+// saveLookupCache writes lookups back to disk, logging failures; the
+// import's results don't depend on it
+func saveLookupCache() {
+	if err := lookups.save(); err != nil {
+		logrus.Errorf("unable to save lookup cache: %v", err)
+	}
+}
+
+func (c *lookupCache) expired(e cacheEntry) bool {
+	return time.Since(e.FetchedAt) > c.ttl
+}
+
+func (c *lookupCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || c.refresh || c.expired(e) {
+		return cacheEntry{}, false
+	}
+
+	return e, true
+}
+
+func (c *lookupCache) put(key string, e cacheEntry) {
+	e.FetchedAt = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = e
+	c.dirty = true
+}
+
+// country returns the cached country of lookup for artist, calling fetch
+// on a miss
+func (c *lookupCache) country(lookup, artist string, fetch func() string) string {
+	if c == nil {
+		return fetch()
+	}
+
+	key := lookup + ":" + norm(artist)
+
+	if e, ok := c.get(key); ok {
+		logrus.Debugf("Cache hit for %s of %s: %s", lookup, artist, e.Country)
+		return e.Country
+	}
+
+	country := fetch()
+	if country != "" {
+		c.put(key, cacheEntry{Country: country})
+	}
+
+	return country
+}
+
+// genres returns the cached genres of lookup for artist, calling fetch on a
+// miss
+func (c *lookupCache) genres(lookup, artist string, fetch func() []string) []string {
+	if c == nil {
+		return fetch()
+	}
+
+	key := lookup + ":" + norm(artist)
+
+	if e, ok := c.get(key); ok {
+		logrus.Debugf("Cache hit for %s of %s: %v", lookup, artist, e.Genres)
+		return e.Genres
+	}
+
+	genres := fetch()
+	if len(genres) > 0 {
+		c.put(key, cacheEntry{Genres: genres})
+	}
+
+	return genres
+}
+
+// save writes the cache if it changed, through a temp file so an
+// interrupted write never leaves a truncated cache behind
+func (c *lookupCache) save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	b, err := json.Marshal(c.entries)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal cache")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write %s", tmp.Name())
+	}
+
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return errors.Wrapf(err, "failed to replace %s", c.path)
+	}
+
+	c.dirty = false
+
+	return nil
+}
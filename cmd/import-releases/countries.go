@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+// countryFix rewrites every release stored with country From to To; an
+// empty To clears the country
+type countryFix struct {
+	From     string
+	To       string
+	Releases int64
+}
+
+// runCountryNormalization re-normalizes every stored country through
+// countryNameToISO, so names and lowercase codes stored before the mapping
+// improved become ISO codes. Values the mapping doesn't know are reported
+// and left alone. Rows are only updated with -enable-write.
+func runCountryNormalization() error {
+	if !enableWrite {
+		logrus.Info("DRY RUN MODE - no database writes will occur")
+	}
+
+	dbBackend, err := openDB(0)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to database")
+	}
+	defer dbBackend.GetDB().Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	countries, err := dbBackend.ListCountries(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list countries")
+	}
+
+	fixes, unknown := countryFixes(countries)
+
+	logrus.Infof("Country normalization start (countries=%d, to fix=%d, enable-write=%v)",
+		len(countries), len(fixes), enableWrite)
+
+	for _, c := range unknown {
+		logrus.Warnf("unknown country %q on %d release(s); add it to countryNameToISO", c.Code, c.ReleaseCount)
+	}
+
+	var updated int64
+
+	for _, f := range fixes {
+		if ctx.Err() != nil {
+			logrus.Warnf("Country normalization interrupted: %v", ctx.Err())
+			break
+		}
+
+		logrus.Infof("country %q -> %q on %d release(s)", f.From, f.To, f.Releases)
+
+		if !enableWrite {
+			continue
+		}
+
+		n, err := dbBackend.UpdateReleaseCountry(ctx, gensql.UpdateReleaseCountryParams{
+			NewCountry: sql.NullString{String: f.To, Valid: f.To != ""},
+			OldCountry: f.From,
+		})
+		if err != nil {
+			logrus.Errorf("failed to update country %q: %v", f.From, err)
+			continue
+		}
+
+		updated += n
+	}
+
+	logrus.Infof("Country normalization done. Fixed: %d, Unknown: %d, Releases updated: %d",
+		len(fixes), len(unknown), updated)
+
+	return nil
+}
+
+// countryFixes returns the stored countries whose normalized code differs,
+// and the ones countryNameToISO can't map. Blank values and two-letter
+// values that aren't ISO codes are cleared.
+func countryFixes(countries []gensql.ListCountriesRow) (fixes []countryFix, unknown []gensql.ListCountriesRow) {
+	for _, c := range countries {
+		if trimmed := strings.TrimSpace(c.Code); trimmed == "" ||
+			(len(trimmed) == 2 && countryNameToISO(trimmed) == "") {
+			fixes = append(fixes, countryFix{From: c.Code, Releases: c.ReleaseCount})
+			continue
+		}
+
+		code := countryNameToISO(c.Code)
+
+		switch {
+		case code == "":
+			unknown = append(unknown, c)
+		case code != c.Code:
+			fixes = append(fixes, countryFix{From: c.Code, To: code, Releases: c.ReleaseCount})
+		}
+	}
+
+	return fixes, unknown
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	deezerSearchBase = "https://api.deezer.com/search"
+	deezerAlbumBase  = "https://www.deezer.com/album/"
+)
+
+// deezerTrack is a Deezer track search result. Album results have no
+// previews, so albums are looked up through their tracks.
+type deezerTrack struct {
+	Preview string `json:"preview"`
+	Artist  struct {
+		Name string `json:"name"`
+	} `json:"artist"`
+	Album struct {
+		ID       int64  `json:"id"`
+		Title    string `json:"title"`
+		CoverXL  string `json:"cover_xl"`
+		CoverBig string `json:"cover_big"`
+	} `json:"album"`
+}
+
+// deezerAlbum is what enrichRelease takes from Deezer when Spotify has no
+// match. The preview is a 30s MP3 whose URL is signed and expires, so it is
+// only reported, not stored.
+type deezerAlbum struct {
+	URL        string
+	CoverURL   string
+	PreviewURL string
+}
+
+// lookupDeezerAlbum finds the artist's album on Deezer's public search API
+// (no key needed); nil when there is no close match
+func lookupDeezerAlbum(ctx context.Context, artist, album string) *deezerAlbum {
+	q := url.Values{
+		"q":     {`artist:"` + artist + `" album:"` + album + `"`},
+		"limit": {"25"},
+	}
+
+	u := deezerSearchBase + "?" + q.Encode()
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+	logrus.Debugf("REQ GET %s", u)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logrus.Warnf("Deezer search: %v", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logrus.Warnf("Deezer search %d", resp.StatusCode)
+		return nil
+	}
+
+	b, _ := io.ReadAll(resp.Body)
+
+	tracks, err := parseDeezerTracks(b)
+	if err != nil {
+		logrus.Warnf("Deezer search: %v", err)
+		recordProviderError(ctx, "deezer", err)
+
+		return nil
+	}
+
+	return pickDeezerAlbum(tracks, artist, album)
+}
+
+// parseDeezerTracks decodes a track search response. Deezer reports errors
+// (e.g. an exceeded quota) with a 200 status and an error object.
+func parseDeezerTracks(b []byte) ([]deezerTrack, error) {
+	var out struct {
+		Data  []deezerTrack `json:"data"`
+		Error *struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, errors.Wrap(err, "failed to decode response")
+	}
+
+	if out.Error != nil {
+		return nil, errors.Errorf("%s (code %d): %s", out.Error.Type, out.Error.Code, out.Error.Message)
+	}
+
+	return out.Data, nil
+}
+
+// pickDeezerAlbum returns the album by artist whose title is most similar
+// to album, the same way Spotify albums are picked, with the first preview
+// among its tracks
+func pickDeezerAlbum(tracks []deezerTrack, artist, album string) *deezerAlbum {
+	var (
+		best      *deezerTrack
+		bestScore float64
+	)
+
+	for i := range tracks {
+		if norm(tracks[i].Artist.Name) != norm(artist) {
+			continue
+		}
+
+		score := albumSimilarity(tracks[i].Album.Title, album)
+		if score >= minAlbumSimilarity && score > bestScore {
+			best, bestScore = &tracks[i], score
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	out := &deezerAlbum{
+		URL:      deezerAlbumBase + strconv.FormatInt(best.Album.ID, 10),
+		CoverURL: best.Album.CoverXL,
+	}
+
+	if out.CoverURL == "" {
+		out.CoverURL = best.Album.CoverBig
+	}
+
+	for _, t := range tracks {
+		if t.Album.ID == best.Album.ID && t.Preview != "" {
+			out.PreviewURL = t.Preview
+			break
+		}
+	}
+
+	return out
+}
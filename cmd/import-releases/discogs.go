@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// discogsDefaultCooldown is how long a token rests after a 429 that has no
+// Retry-After header
+const discogsDefaultCooldown = time.Minute
+
+// discogsToken is one DISCOGS_TOKEN; each token has its own request budget
+// (DISCOGS_RATE_PER_MIN) and concurrency limit (DISCOGS_MAX_CONCURRENT) and
+// is rested after Discogs throttles it
+type discogsToken struct {
+	value     string
+	limiter   *rate.Limiter
+	inFlight  hostSemaphore
+	coolUntil time.Time
+}
+
+// discogsTokenPool rotates requests across all configured Discogs tokens
+type discogsTokenPool struct {
+	mu     sync.Mutex
+	tokens []*discogsToken
+	next   int
+}
+
+var (
+	discogsPool     *discogsTokenPool
+	discogsPoolOnce sync.Once
+)
+
+// discogsTokens returns the pool built from the comma-separated
+// DISCOGS_TOKEN env var
+func discogsTokens() *discogsTokenPool {
+	discogsPoolOnce.Do(func() {
+		discogsPool = newDiscogsTokenPool(parseDiscogsTokens(os.Getenv("DISCOGS_TOKEN")))
+	})
+
+	return discogsPool
+}
+
+func parseDiscogsTokens(v string) []string {
+	var tokens []string
+
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+
+	return tokens
+}
+
+func newDiscogsTokenPool(values []string) *discogsTokenPool {
+	perMinute, maxConcurrent := 0, 0
+
+	for _, l := range providerLimits {
+		if l.Name == "discogs" {
+			perMinute, maxConcurrent = l.PerMinute, l.MaxConcurrent
+		}
+	}
+
+	p := &discogsTokenPool{}
+
+	for _, v := range values {
+		t := &discogsToken{value: v}
+		if perMinute > 0 {
+			t.limiter = newProviderLimiter(perMinute)
+		}
+
+		if maxConcurrent > 0 {
+			t.inFlight = newHostSemaphore(maxConcurrent)
+		}
+
+		p.tokens = append(p.tokens, t)
+	}
+
+	return p
+}
+
+func (p *discogsTokenPool) empty() bool {
+	return len(p.tokens) == 0
+}
+
+// acquire returns the next token that isn't resting. When every token is
+// resting it returns the one that recovers first and how long to wait.
+func (p *discogsTokenPool) acquire(now time.Time) (*discogsToken, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var soonest *discogsToken
+
+	for i := 0; i < len(p.tokens); i++ {
+		t := p.tokens[(p.next+i)%len(p.tokens)]
+
+		if !now.Before(t.coolUntil) {
+			p.next = (p.next + i + 1) % len(p.tokens)
+			return t, 0
+		}
+
+		if soonest == nil || t.coolUntil.Before(soonest.coolUntil) {
+			soonest = t
+		}
+	}
+
+	return soonest, soonest.coolUntil.Sub(now)
+}
+
+// cooldown rests t until d from now
+func (p *discogsTokenPool) cooldown(t *discogsToken, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(t.coolUntil) {
+		t.coolUntil = until
+	}
+}
+
+func (p *discogsTokenPool) indexOf(t *discogsToken) int {
+	for i := range p.tokens {
+		if p.tokens[i] == t {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// discogsGet performs a GET against the Discogs API, authenticating with the
+// next available token. A 429 rests that token and retries with another one;
+// if every token is throttled the request waits for the first to recover.
+func discogsGet(ctx context.Context, rawURL, contact string) (*http.Response, error) {
+	pool := discogsTokens()
+	if pool.empty() {
+		return nil, errors.New("DISCOGS_TOKEN not set")
+	}
+
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+
+	for attempt := 0; attempt <= len(pool.tokens); attempt++ {
+		tok, wait := pool.acquire(time.Now())
+
+		if wait > 0 {
+			logrus.Debugf("all Discogs tokens throttled, waiting %s", wait)
+
+			if err := sleepCtx(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+
+		if tok.limiter != nil {
+			if err := tok.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		if tok.inFlight != nil {
+			if err := tok.inFlight.acquire(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		u := rawURL + sep + "token=" + url.QueryEscape(tok.value)
+		req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+		req.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
+		logrus.Debugf("REQ GET %s", rawURL)
+
+		resp, err := httpClient.Do(req)
+		if tok.inFlight != nil {
+			if err != nil {
+				tok.inFlight.release()
+			} else {
+				resp.Body = releaseOnClose(resp.Body, tok.inFlight.release)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+
+		cool := retryAfter(resp, discogsDefaultCooldown)
+		pool.cooldown(tok, cool)
+
+		logrus.Warnf("Discogs token %d/%d throttled, resting it for %s",
+			pool.indexOf(tok)+1, len(pool.tokens), cool)
+	}
+
+	err := errors.New("Discogs throttled every token")
+	recordProviderError(ctx, "discogs", err)
+
+	return nil, err
+}
+
+// retryAfter reads a Retry-After header in seconds, falling back to def
+func retryAfter(resp *http.Response, def time.Duration) time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Retry-After")))
+	if err != nil || secs <= 0 {
+		return def
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type responseDumpKey struct{}
+
+// dumpedResponse is one line of a -dump-responses file: a provider call and
+// its full, raw response body, along with the CSV row it was made for so
+// -replay can enrich the row again
+type dumpedResponse struct {
+	Row         int    `json:"row"`
+	Date        string `json:"date"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	Label       string `json:"label"`
+	Barcode     string `json:"barcode,omitempty"`
+	Provider    string `json:"provider"`
+	Lookup      string `json:"lookup"`
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Body        string `json:"body,omitempty"`
+}
+
+// responseDump collects the provider responses of one row; it is attached
+// to the row's context with withResponseDump
+type responseDump struct {
+	mu        sync.Mutex
+	responses []dumpedResponse
+}
+
+func (d *responseDump) add(r dumpedResponse) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.responses = append(d.responses, r)
+}
+
+func (d *responseDump) list() []dumpedResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]dumpedResponse(nil), d.responses...)
+}
+
+func withResponseDump(ctx context.Context) (context.Context, *responseDump) {
+	d := &responseDump{}
+	return context.WithValue(ctx, responseDumpKey{}, d), d
+}
+
+// dumpTransport keeps the full response of every request whose context
+// carries a responseDump; requests without one pass straight through. The
+// body is read up front, so the caller gets an in-memory copy.
+type dumpTransport struct {
+	base http.RoundTripper
+}
+
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	d, _ := req.Context().Value(responseDumpKey{}).(*responseDump)
+	if d == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	lookup, _ := req.Context().Value(lookupKey{}).(string)
+	if lookup == "" {
+		lookup = "unknown"
+	}
+
+	r := dumpedResponse{
+		Provider: providerForHost(req.URL.Hostname()),
+		Lookup:   lookup,
+		Method:   req.Method,
+		URL:      redactURL(req.URL),
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		r.Error = err.Error()
+		d.add(r)
+
+		return resp, err
+	}
+
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	r.StatusCode = resp.StatusCode
+	r.ContentType = resp.Header.Get("Content-Type")
+	r.Body = strings.ToValidUTF8(string(body), string(utf8.RuneError))
+
+	if readErr != nil {
+		r.Error = readErr.Error()
+	}
+
+	if req.URL.String() == spotifyTokenURL {
+		r.Body = redactAccessToken(r.Body)
+	}
+
+	d.add(r)
+
+	return resp, nil
+}
+
+// dumpingEnricher wraps an enricher and writes the provider responses of
+// each row to <dir>/row-<n>.jsonl, one dumpedResponse per line
+type dumpingEnricher struct {
+	base rowEnricher
+	dir  string
+}
+
+func (e dumpingEnricher) enrich(ctx context.Context, row csvRow) *enrichedRelease {
+	ctx, d := withResponseDump(ctx)
+
+	out := e.base.enrich(ctx, row)
+
+	if err := writeResponseDump(e.dir, row, d.list()); err != nil {
+		logrus.Warnf("row %d: unable to dump provider responses: %v", row.rowNum, err)
+	}
+
+	return out
+}
+
+// redactAccessToken replaces the access_token of a Spotify token response,
+// keeping the rest so the response still replays as a valid token
+func redactAccessToken(body string) string {
+	var tok map[string]any
+	if err := json.Unmarshal([]byte(body), &tok); err != nil {
+		return body
+	}
+
+	if _, ok := tok["access_token"]; !ok {
+		return body
+	}
+
+	tok["access_token"] = "REDACTED"
+
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return "REDACTED"
+	}
+
+	return string(b)
+}
+
+// writeResponseDump writes responses to the row's file in dir, replacing
+// the file from an earlier run
+func writeResponseDump(dir string, row csvRow, responses []dumpedResponse) error {
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("row-%d.jsonl", row.rowNum)))
+	if err != nil {
+		return errors.Wrap(err, "failed to create dump file")
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+
+	for _, r := range responses {
+		r.Row, r.Date, r.Artist, r.Album, r.Label, r.Barcode =
+			row.rowNum, row.dateISO, row.artist, row.album, row.label, row.barcode
+
+		if err := enc.Encode(r); err != nil {
+			return errors.Wrap(err, "failed to write dump file")
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write dump file")
+	}
+
+	return f.Close()
+}
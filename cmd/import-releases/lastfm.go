@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	lastFmAPIBase = "https://ws.audioscrobbler.com/2.0/"
+
+	// lastFmMaxTags is how many of the top tags are used; further down the
+	// list Last.fm tags get personal ("seen live", "albums i own")
+	lastFmMaxTags = 5
+
+	// lastFmErrNotFound is Last.fm's error code for an unknown artist or
+	// album, which is a miss rather than a failure
+	lastFmErrNotFound = 6
+)
+
+// lookupLastFmTags returns the album's top Last.fm tags, or the artist's
+// when Last.fm has none for the album (common for new releases). It returns
+// nil without LASTFM_API_KEY.
+func lookupLastFmTags(ctx context.Context, artist, album string) []string {
+	key := os.Getenv("LASTFM_API_KEY")
+	if key == "" {
+		return nil
+	}
+
+	tags := lastFmTags(ctx, key, url.Values{
+		"method":      {"album.getinfo"},
+		"artist":      {artist},
+		"album":       {album},
+		"autocorrect": {"1"},
+	})
+
+	if len(tags) == 0 {
+		logrus.Debugf("No Last.fm album tags for %s - %s, trying artist tags", artist, album)
+
+		tags = lastFmTags(ctx, key, url.Values{
+			"method":      {"artist.gettoptags"},
+			"artist":      {artist},
+			"autocorrect": {"1"},
+		})
+	}
+
+	return filterLastFmTags(tags, artist)
+}
+
+// lastFmTags runs a Last.fm tag lookup and returns its tags, best first
+func lastFmTags(ctx context.Context, key string, q url.Values) []string {
+	q.Set("api_key", key)
+	q.Set("format", "json")
+
+	u := lastFmAPIBase + "?" + q.Encode()
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+	logrus.Debugf("REQ GET %s", redactURL(req.URL))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logrus.Warnf("Last.fm %s: %v", q.Get("method"), err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	tags, err := parseLastFmTags(b)
+	if err != nil {
+		logrus.Warnf("Last.fm %s: %v", q.Get("method"), err)
+
+		// Error statuses are already recorded by the transport
+		if resp.StatusCode == http.StatusOK {
+			recordProviderError(ctx, "lastfm", err)
+		}
+
+		return nil
+	}
+
+	return tags
+}
+
+// lastFmTagList is the tag list of album.getinfo and artist.gettoptags
+// responses. Last.fm's JSON is converted from XML, so a single tag comes as
+// an object instead of an array, and an empty list as "".
+type lastFmTagList struct {
+	Tag json.RawMessage `json:"tag"`
+}
+
+type lastFmTag struct {
+	Name string `json:"name"`
+}
+
+func (l lastFmTagList) names() []string {
+	var tags []lastFmTag
+	if err := json.Unmarshal(l.Tag, &tags); err != nil {
+		var tag lastFmTag
+		if err := json.Unmarshal(l.Tag, &tag); err != nil {
+			return nil
+		}
+
+		tags = []lastFmTag{tag}
+	}
+
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+
+	return names
+}
+
+// parseLastFmTags returns the tags of an album.getinfo or artist.gettoptags
+// response; an unknown album or artist has none
+func parseLastFmTags(b []byte) ([]string, error) {
+	var out struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+		Album   struct {
+			Tags json.RawMessage `json:"tags"`
+		} `json:"album"`
+		TopTags lastFmTagList `json:"toptags"`
+	}
+
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, errors.Wrap(err, "failed to decode response")
+	}
+
+	switch out.Error {
+	case 0:
+	case lastFmErrNotFound:
+		return nil, nil
+	default:
+		return nil, errors.Errorf("error %d: %s", out.Error, out.Message)
+	}
+
+	if len(out.Album.Tags) > 0 {
+		var tags lastFmTagList
+
+		// "" when the album has no tags
+		_ = json.Unmarshal(out.Album.Tags, &tags)
+
+		return tags.names(), nil
+	}
+
+	return out.TopTags.names(), nil
+}
+
+// filterLastFmTags normalizes tags and keeps the top lastFmMaxTags, dropping
+// the artist's own name, which listeners often tag artists with
+func filterLastFmTags(tags []string, artist string) []string {
+	out := make([]string, 0, lastFmMaxTags)
+
+	for _, t := range normalizeList(tags) {
+		if norm(t) == norm(artist) {
+			continue
+		}
+
+		out = append(out, t)
+
+		if len(out) == lastFmMaxTags {
+			break
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+	"github.com/dselans/blastbeat-api/services/linkcheck"
+)
+
+// linkCheckEntry is a release with dead links in the -link-report file
+type linkCheckEntry struct {
+	ReleaseID string             `json:"release_id"`
+	Artist    string             `json:"artist"`
+	Title     string             `json:"title"`
+	Dead      []linkcheck.Result `json:"dead"`
+}
+
+// runLinkCheck checks the stored links of up to limit releases and reports
+// the dead ones. With -enable-write dead links are cleared so that
+// -only-missing-fields can look them up again.
+func runLinkCheck(limit int, reportPath string) error {
+	if !enableWrite {
+		logrus.Info("DRY RUN MODE - no database writes will occur")
+	}
+
+	dbBackend, err := openDB(0)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to database")
+	}
+	defer dbBackend.GetDB().Close()
+
+	checker, err := linkcheck.New(&linkcheck.Options{})
+	if err != nil {
+		return errors.Wrap(err, "failed to set up link checker")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	releases, err := dbBackend.ListReleases(ctx, int32(limit))
+	if err != nil {
+		return errors.Wrap(err, "failed to list releases")
+	}
+
+	logrus.Infof("Link check start (releases=%d, enable-write=%v)", len(releases), enableWrite)
+
+	var (
+		checked, updated int
+		report           = []linkCheckEntry{}
+	)
+
+	for _, r := range releases {
+		if ctx.Err() != nil {
+			logrus.Warnf("Link check interrupted: %v", ctx.Err())
+			break
+		}
+
+		links := linkcheck.Links(r)
+		if len(links) == 0 {
+			continue
+		}
+
+		checked++
+
+		dead := linkcheck.Dead(checker.Check(ctx, links))
+		if len(dead) == 0 {
+			continue
+		}
+
+		for _, d := range dead {
+			logrus.Warnf("dead %s link (%d) for %s - %s: %s",
+				d.Name, d.StatusCode, r.Artist, r.Title, d.URL)
+		}
+
+		report = append(report, linkCheckEntry{
+			ReleaseID: r.ID.String(),
+			Artist:    r.Artist,
+			Title:     r.Title,
+			Dead:      dead,
+		})
+
+		if !enableWrite {
+			continue
+		}
+
+		if _, err := dbBackend.UpdateRelease(ctx, clearDeadLinks(r, dead)); err != nil {
+			logrus.Errorf("failed to update %s: %v", r.ID, err)
+			continue
+		}
+
+		updated++
+	}
+
+	logrus.Infof("Link check done. Checked: %d, With dead links: %d, Updated: %d",
+		checked, len(report), updated)
+
+	if reportPath == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal link report")
+	}
+
+	if err := os.WriteFile(reportPath, b, 0o644); err != nil {
+		return errors.Wrap(err, "failed to write link report")
+	}
+
+	logrus.Infof("Wrote link report to %s", reportPath)
+
+	return nil
+}
+
+// clearDeadLinks returns update params for r with every dead URL removed
+// from the link columns and external_links
+func clearDeadLinks(r gensql.Release, dead []linkcheck.Result) gensql.UpdateReleaseParams {
+	params := updateParamsFor(r)
+
+	isDead := make(map[string]bool, len(dead))
+	for _, d := range dead {
+		isDead[d.URL] = true
+	}
+
+	for _, col := range []*sql.NullString{
+		&params.SpotifyUrl, &params.YoutubeUrl, &params.BandcampUrl, &params.AppleMusicUrl, &params.LabelUrl,
+	} {
+		if isDead[col.String] {
+			*col = sql.NullString{}
+		}
+	}
+
+	// Only the importer's name -> url form is rewritten
+	links := map[string]string{}
+	if err := json.Unmarshal(r.ExternalLinks, &links); err == nil {
+		for name, u := range links {
+			if isDead[u] {
+				delete(links, name)
+			}
+		}
+
+		if b, err := json.Marshal(links); err == nil {
+			params.ExternalLinks = b
+		}
+	}
+
+	return params
+}
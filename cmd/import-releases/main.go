@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -13,24 +12,42 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/text/encoding/htmlindex"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	unorm "golang.org/x/text/unicode/norm"
 
 	"github.com/dselans/blastbeat-api/backends/db"
 	"github.com/dselans/blastbeat-api/backends/gensql"
+	sr "github.com/dselans/blastbeat-api/services/release"
 )
 
-var httpClient = &http.Client{Timeout: 20 * time.Second}
+// httpClient has no Timeout of its own: rateLimitTransport applies
+// requestTimeout once a request is allowed out, so waiting for a busy
+// provider's rate limit doesn't time requests out
+var httpClient = &http.Client{
+	Transport: &auditTransport{base: &dumpTransport{base: &rateLimitTransport{
+		base:    &concurrencyTransport{base: http.DefaultTransport},
+		timeout: requestTimeout,
+	}}},
+}
 
 const (
 	defaultContactEmail = "admin@example.com"
@@ -54,9 +71,18 @@ var (
 	logLevel    string
 	levelDebug  bool
 	enableWrite bool
+	auditCalls  bool
 	workers     int
 	spotTok     string
 	spotExp     time.Time
+
+	// discogsYearTolerance is how many years a Discogs release match may be
+	// off from the CSV date before it is treated as the wrong album
+	discogsYearTolerance int
+
+	// genreSourceOrder is the order genre lists are combined in, so earlier
+	// sources' genres come first
+	genreSourceOrder = defaultGenreSourceOrder
 )
 
 func getenv(k, def string) string {
@@ -67,6 +93,9 @@ func getenv(k, def string) string {
 	return def
 }
 
+// validateEnvVars requires the Spotify credentials; YouTube, Discogs and
+// Last.fm are optional and only warned about, their rows recording them as
+// sourceUnavailable
 func validateEnvVars() error {
 	var missing []string
 
@@ -79,11 +108,15 @@ func validateEnvVars() error {
 	}
 
 	if os.Getenv("DISCOGS_TOKEN") == "" {
-		missing = append(missing, "DISCOGS_TOKEN")
+		logrus.Warn("DISCOGS_TOKEN not set; Discogs lookups are disabled")
 	}
 
 	if os.Getenv("YOUTUBE_API_KEY") == "" {
-		missing = append(missing, "YOUTUBE_API_KEY")
+		logrus.Warn("YOUTUBE_API_KEY not set; YouTube previews are disabled")
+	}
+
+	if os.Getenv("LASTFM_API_KEY") == "" {
+		logrus.Warn("LASTFM_API_KEY not set; Last.fm tags are disabled")
 	}
 
 	if len(missing) > 0 {
@@ -117,16 +150,161 @@ func setLogLevel() {
 func main() {
 	godotenv.Load()
 
-	inPath := flag.String("in", "", "input CSV path (YYYY-MM-DD,Artist,Album,Label)")
+	inPath := flag.String("in", "", "input CSV path (YYYY-MM-DD,Artist,Album,Label), or JSON with -format json")
+	format := flag.String("format", "csv", "input format: csv, or json for an array of {date,artist,album,label} objects")
 	flag.BoolVar(&enableWrite, "enable-write", false, "enable writing to database (default: dry-run mode)")
-	flag.IntVar(&workers, "workers", 1, "number of concurrent workers (default: 1)")
+	workersFlag := flag.String("workers", "1",
+		fmt.Sprintf("number of concurrent workers (max %d) or 'auto' to size by provider rate limits", maxWorkers))
+	providerConcurrency := flag.Int("provider-concurrency", 0,
+		"max provider requests in flight across all providers, however many workers there are (default: only per-provider caps)")
+	summaryOut := flag.String("summary-out", "", "write a JSON summary of the run to this path")
+	flag.IntVar(&discogsYearTolerance, "discogs-year-tolerance", 1,
+		"reject Discogs release matches whose year is further than this from the CSV date")
+	genreOrderFlag := flag.String("genre-source-order", strings.Join(defaultGenreSourceOrder, ","),
+		"comma-separated genre source priority (metal_archives, discogs, lastfm, spotify)")
+	flag.BoolVar(&auditCalls, "audit", false, "record every provider call in the enrichment_audit table (requires -enable-write)")
+	backfillArt := flag.Bool("backfill-art", false, "re-resolve art for releases with placeholder art instead of importing a CSV")
+	backfillLimit := flag.Int("backfill-limit", 1000, "max releases to process with -backfill-art")
+	onlyMissing := flag.Bool("only-missing-fields", false,
+		"re-enrich existing releases, only looking up fields that are empty, instead of importing a CSV")
+	fieldsFlag := flag.String("fields", "",
+		"with -only-missing-fields, limit to these fields (country, genres, art, spotify_url, youtube_url, apple_music_url, label_url)")
+	missingLimit := flag.Int("missing-limit", 1000, "max releases to process with -only-missing-fields")
+	linkCheck := flag.Bool("link-check", false,
+		"check stored Spotify/YouTube/label links for dead ones instead of importing a CSV (clears them with -enable-write)")
+	linkCheckLimit := flag.Int("link-check-limit", 1000, "max releases to process with -link-check")
+	normalizeCountries := flag.Bool("normalize-countries", false,
+		"re-normalize stored countries to ISO codes instead of importing a CSV (updates rows with -enable-write)")
+	linkReport := flag.String("link-report", "", "write the releases with dead links found by -link-check to this JSON file")
+	failFast := flag.Bool("fail-fast", false, "stop the import at the first row error and exit non-zero")
+	charset := flag.String("charset", "utf-8",
+		"input CSV encoding, e.g. windows-1252 or iso-8859-1 (a byte order mark always wins)")
+	dbPoolSize := flag.Int("db-pool-size", 0, "DB connection pool size with -enable-write (default: workers+1)")
+	sinceDate := flag.String("since-date", "", "skip rows released before this date (YYYY-MM-DD)")
+	onlyArtists := flag.String("only-artist", "", "comma-separated artists; import only their rows")
+	skipArtists := flag.String("skip-artist", "", "comma-separated artists whose rows are skipped")
+	onlyLabels := flag.String("only-label", "", "comma-separated labels; import only rows with these CSV labels")
+	skipLabels := flag.String("skip-label", "", "comma-separated labels whose rows are skipped")
+	dumpResponses := flag.String("dump-responses", "",
+		"write every provider response to <dir>/row-<n>.jsonl for offline debugging")
+	noCache := flag.Bool("no-cache", false,
+		"ignore cached Metal Archives/MusicBrainz lookups and fetch them again (fresh results are still cached)")
+	cacheFile := flag.String("cache-file", defaultCacheFile,
+		"file caching Metal Archives/MusicBrainz artist lookups between runs (TTL: CACHE_TTL_DAYS)")
+	replay := flag.String("replay", "",
+		"re-run enrichment offline from a -dump-responses directory (or one row's file) and print the results as JSON lines")
 	flag.Parse()
 
+	if discogsYearTolerance < 0 {
+		log.Fatal("-discogs-year-tolerance cannot be negative")
+	}
+
+	if *dbPoolSize < 0 {
+		log.Fatal("-db-pool-size cannot be negative")
+	}
+
+	var since time.Time
+
+	if *sinceDate != "" {
+		t, err := time.Parse("2006-01-02", strings.TrimSpace(*sinceDate))
+		if err != nil {
+			log.Fatalf("invalid -since-date %q (expected YYYY-MM-DD)", *sinceDate)
+		}
+
+		since = t
+	}
+
+	if *providerConcurrency < 0 {
+		log.Fatal("-provider-concurrency cannot be negative")
+	}
+
+	setProviderConcurrency(*providerConcurrency)
+
+	var err error
+
+	genreSourceOrder, err = parseGenreSourceOrder(*genreOrderFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *backfillArt {
+		setLogLevel()
+		loadProviderLimits()
+
+		if err := runArtBackfill(*backfillLimit); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if *onlyMissing {
+		setLogLevel()
+		loadProviderLimits()
+
+		fields, err := parseFields(*fieldsFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		loadLookupCache(*cacheFile, *noCache)
+
+		err = runMissingFieldsEnrichment(*missingLimit, fields)
+		saveLookupCache()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if *linkCheck {
+		setLogLevel()
+
+		if err := runLinkCheck(*linkCheckLimit, *linkReport); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if *normalizeCountries {
+		setLogLevel()
+
+		if err := runCountryNormalization(); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if *replay != "" {
+		setLogLevel()
+
+		if err := runReplay(*replay); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	if *inPath == "" {
 		log.Fatal("missing -in flag")
 	}
 
+	newReader, err := inputReader(*format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	setLogLevel()
+	loadProviderLimits()
+
+	workers, err = parseWorkers(*workersFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	if err := validateEnvVars(); err != nil {
 		log.Fatalf("missing required environment variables: %v", err)
@@ -134,31 +312,34 @@ func main() {
 
 	contact := getenv("CONTACT_EMAIL", defaultContactEmail)
 
+	// A dump has to hold every provider response for its rows to replay, so
+	// -dump-responses fetches even when the cache has a result
+	loadLookupCache(*cacheFile, *noCache || *dumpResponses != "")
+
 	if !enableWrite {
 		logrus.Info("DRY RUN MODE - no database writes will occur")
+
+		if auditCalls {
+			logrus.Warn("-audit has no effect without -enable-write")
+			auditCalls = false
+		}
 	}
 
-	logrus.Infof("CSV enrich start (LOG_LEVEL=%s, contact=%s, file=%s, enable-write=%v, workers=%d)",
-		logLevel, contact, *inPath, enableWrite, workers)
+	logrus.Infof("CSV enrich start (LOG_LEVEL=%s, contact=%s, file=%s, enable-write=%v, workers=%d, provider-concurrency=%d)",
+		logLevel, contact, *inPath, enableWrite, workers, *providerConcurrency)
 
 	var dbBackend *db.DB
 	if enableWrite {
-		dbPort := 5432
-		if portStr := getenv("BLASTBEAT_API_DB_PORT", "5432"); portStr != "" {
-			if p, err := strconv.Atoi(portStr); err == nil {
-				dbPort = p
-			}
+		// Each worker holds at most one connection at a time; the extra one
+		// leaves room for the summary/audit writes
+		poolSize := *dbPoolSize
+		if poolSize == 0 {
+			poolSize = workers + 1
 		}
 
-		var err error
-		dbBackend, err = db.New(&db.Options{
-			User:     getenv("BLASTBEAT_API_DB_USER", "blastbeat"),
-			Password: getenv("BLASTBEAT_API_DB_PASSWORD", "blastbeat"),
-			Host:     getenv("BLASTBEAT_API_DB_HOST", "localhost"),
-			Port:     dbPort,
-			DBName:   getenv("BLASTBEAT_API_DB_NAME", "blastbeat"),
-			SSLMode:  getenv("BLASTBEAT_API_DB_SSL_MODE", "disable"),
-		})
+		logrus.Infof("DB pool size: %d", poolSize)
+
+		dbBackend, err = openDB(poolSize)
 		if err != nil {
 			log.Fatalf("failed to connect to database: %v", err)
 		}
@@ -171,157 +352,121 @@ func main() {
 	}
 	defer f.Close()
 
-	r := csv.NewReader(f)
-	r.FieldsPerRecord = 4
-	r.TrimLeadingSpace = true
-
-	if workers < 1 {
-		workers = 1
+	in, err := decodeCSVInput(f, *charset)
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	reader := newReader(in)
+
 	logrus.Infof("Starting import with %d worker(s)", workers)
 
-	ctx := context.Background()
+	// Cancelled on SIGINT/SIGTERM; aborts in-flight provider calls
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	type csvRow struct {
-		rowNum  int
-		dateISO string
-		artist  string
-		album   string
-		label   string
-	}
+	// With -fail-fast the first row error cancels the import the same way a
+	// signal does
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	var (
+		failedFast   atomic.Bool
+		failFastOnce sync.Once
+	)
+
+	failRow := func(rowNum int, err error) {
+		if !*failFast {
+			return
+		}
 
-	type result struct {
-		rowNum int
-		err    error
-		status string
+		failFastOnce.Do(func() {
+			logrus.Errorf("-fail-fast: aborting import after row %d failed: %v", rowNum, err)
+			failedFast.Store(true)
+			abort()
+		})
 	}
 
 	csvRows := make(chan csvRow, workers*2)
-	results := make(chan result, workers*2)
+	results := make(chan rowResult, workers*2)
 	var wg sync.WaitGroup
 
-	seen := make(map[string]bool)
-	var seenMu sync.Mutex
-
 	var totalRows int64
 	successCount := int64(0)
 	skipCount := int64(0)
 	errorCount := int64(0)
 
-	rowNum := 0
+	summary := newSummaryCollector(*inPath, !enableWrite, workers)
 
-	for w := 0; w < workers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	// A nil *db.DB must not end up as a non-nil importStore
+	var store importStore
+	if dbBackend != nil {
+		store = dbBackend
+	}
 
-			for row := range csvRows {
-				dateISO := row.dateISO
-				artist := row.artist
-				album := row.album
-				label := row.label
-
-				key := releaseKey(dateISO, artist, album)
-				seenMu.Lock()
-				if seen[key] {
-					seenMu.Unlock()
-					logrus.Warnf("DUPE DETECTED! %d: %s | %s | %s",
-						row.rowNum, dateISO, artist, album)
-					results <- result{rowNum: row.rowNum, status: "dupe_skip"}
-					continue
-				}
-				seen[key] = true
-				seenMu.Unlock()
-
-				logrus.Infof("Enriching release: %s - %s", artist, album)
-				enriched := enrichRelease(dateISO, artist, album, label, contact)
-				logrus.Infof("Enrichment complete - genres: %v, country: %s, sources: %v",
-					enriched.Genres, enriched.Country, enriched.Sources)
-
-				if !enableWrite {
-					b, _ := json.MarshalIndent(enriched, "", "  ")
-					logrus.Infof("DRY RUN - would insert release:\n%s", string(b))
-					results <- result{rowNum: row.rowNum, status: "success"}
-					continue
-				}
+	filter := newRowFilter(since, *onlyArtists, *skipArtists, *onlyLabels, *skipLabels)
 
-				releaseDate, err := time.Parse("2006-01-02", dateISO)
-				if err != nil {
-					logrus.Errorf("row %d failed to parse date: %v", row.rowNum, err)
-					results <- result{rowNum: row.rowNum, err: err, status: "error"}
-					continue
-				}
+	var enricher rowEnricher = providerEnricher{contact: contact}
+	if *dumpResponses != "" {
+		if err := os.MkdirAll(*dumpResponses, 0o755); err != nil {
+			log.Fatalf("unable to create -dump-responses directory: %v", err)
+		}
 
-				exists, err := releaseExists(ctx, dbBackend, artist, album, releaseDate)
-				if err != nil {
-					logrus.Errorf("row %d failed to check for existing release: %v",
-						row.rowNum, err)
-					results <- result{rowNum: row.rowNum, err: err, status: "error"}
-					continue
-				}
+		enricher = dumpingEnricher{base: enricher, dir: *dumpResponses}
+	}
 
-				if exists {
-					logrus.Warnf("row %d: release already exists - %s: %s (date: %s), skipping",
-						row.rowNum, artist, album, dateISO)
-					results <- result{rowNum: row.rowNum, status: "exists_skip"}
-					continue
-				}
+	processor := newRowProcessor(filter, enricher, newReleaseSink(store), auditCalls, summary)
 
-				release, err := createReleaseFromEnriched(ctx, dbBackend, enriched)
-				if err != nil {
-					logrus.Errorf("row %d failed to insert: %v", row.rowNum, err)
-					results <- result{rowNum: row.rowNum, err: err, status: "error"}
-					continue
-				}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-				logrus.Infof("row %d: inserted release %s - %s: %s",
-					row.rowNum, release.ID, release.Artist, release.Title)
-				results <- result{rowNum: row.rowNum, status: "success"}
+			for row := range csvRows {
+				results <- processor.process(ctx, row)
 			}
 		}()
 	}
 
 	go func() {
 		for {
-			rec, err := r.Read()
-			if err == io.EOF {
+			if ctx.Err() != nil {
+				logrus.Warnf("import cancelled, no longer reading rows: %v", ctx.Err())
 				close(csvRows)
 				return
 			}
-			if err != nil {
-				logrus.Warnf("csv read: %v", err)
-				atomic.AddInt64(&errorCount, 1)
-				continue
-			}
-			rowNum++
 
-			dateISO := strings.TrimSpace(rec[0])
-			artist := strings.TrimSpace(rec[1])
-			album := strings.TrimSpace(rec[2])
-			label := strings.TrimSpace(rec[3])
+			row, err := reader.next()
+			if err == io.EOF {
+				close(csvRows)
+				return
+			}
 
-			logrus.Infof("Processing row %d: %s | %s | %s", rowNum, dateISO, artist, album)
+			var rowErr *rowError
+			if errors.As(err, &rowErr) {
+				logrus.Warnf("row %d: %v", rowErr.rowNum, rowErr.err)
+				summary.recordStatus(rowErr.status)
 
-			if dateISO == "" || artist == "" || album == "" {
-				logrus.Warnf("row %d missing required fields", rowNum)
-				atomic.AddInt64(&skipCount, 1)
-				continue
-			}
+				if rowErr.status == "invalid_skip" {
+					atomic.AddInt64(&skipCount, 1)
+					continue
+				}
 
-			if _, err := time.Parse("2006-01-02", dateISO); err != nil {
-				logrus.Warnf("row %d bad date %q: %v", rowNum, dateISO, err)
-				atomic.AddInt64(&skipCount, 1)
+				atomic.AddInt64(&errorCount, 1)
+				summary.recordError(rowErr.rowNum, rowErr.err)
+				failRow(rowErr.rowNum, rowErr.err)
 				continue
 			}
 
 			atomic.AddInt64(&totalRows, 1)
-			csvRows <- csvRow{
-				rowNum:  rowNum,
-				dateISO: dateISO,
-				artist:  artist,
-				album:   album,
-				label:   label,
+
+			select {
+			case csvRows <- row:
+			case <-ctx.Done():
+				logrus.Warnf("import cancelled, no longer reading rows: %v", ctx.Err())
+				close(csvRows)
+				return
 			}
 		}
 	}()
@@ -332,23 +477,106 @@ func main() {
 	}()
 
 	for res := range results {
+		summary.recordStatus(res.status)
+		summary.recordError(res.rowNum, res.err)
+
 		switch res.status {
 		case "success":
 			atomic.AddInt64(&successCount, 1)
-		case "exists_skip", "dupe_skip":
+		case "exists_skip", "dupe_skip", "filtered_skip", "old_skip":
 			atomic.AddInt64(&skipCount, 1)
 		case "error":
 			atomic.AddInt64(&errorCount, 1)
+			failRow(res.rowNum, res.err)
+		case "cancelled":
+			atomic.AddInt64(&errorCount, 1)
 		}
 	}
 
+	if failedFast.Load() {
+		logrus.Warnf("Import stopped early by -fail-fast")
+	} else if ctx.Err() != nil {
+		logrus.Warnf("Import interrupted before completion: %v", ctx.Err())
+	}
+
+	saveLookupCache()
+
 	logrus.Infof("Done. Processed: %d, Success: %d, Skipped: %d, Errors: %d",
 		atomic.LoadInt64(&totalRows), atomic.LoadInt64(&successCount),
 		atomic.LoadInt64(&skipCount), atomic.LoadInt64(&errorCount))
+
+	if *summaryOut != "" {
+		final := summary.finish(summaryTotals{
+			Processed: atomic.LoadInt64(&totalRows),
+			Success:   atomic.LoadInt64(&successCount),
+			Skipped:   atomic.LoadInt64(&skipCount),
+			Errors:    atomic.LoadInt64(&errorCount),
+		})
+
+		if err := writeSummary(*summaryOut, final); err != nil {
+			logrus.Errorf("unable to write summary: %v", err)
+		} else {
+			logrus.Infof("Wrote summary to %s", *summaryOut)
+		}
+	}
+
+	if code := exitCode(atomic.LoadInt64(&successCount), atomic.LoadInt64(&skipCount),
+		atomic.LoadInt64(&errorCount)); code != exitOK {
+		os.Exit(code)
+	}
 }
 
-func createReleaseFromEnriched(ctx context.Context, dbBackend *db.DB,
-	enriched *enrichedRelease) (*gensql.Release, error) {
+// Exit codes for a CSV import; startup failures (bad flags, missing env
+// vars, unreachable DB) exit 1 via log.Fatal
+const (
+	exitOK         = 0
+	exitRowErrors  = 2
+	exitAllSkipped = 3
+)
+
+// exitCode maps an import's outcome to the process exit code: any row error
+// (including rows cancelled by -fail-fast or a signal) is exitRowErrors, and
+// a run that skipped every row without importing any is exitAllSkipped
+func exitCode(success, skipped, errs int64) int {
+	switch {
+	case errs > 0:
+		return exitRowErrors
+	case success == 0 && skipped > 0:
+		return exitAllSkipped
+	default:
+		return exitOK
+	}
+}
+
+// openDB connects to the database using the API's BLASTBEAT_API_DB_* env
+// vars; poolSize > 0 keeps that many connections open and idle so concurrent
+// workers don't queue for one
+func openDB(poolSize int) (*db.DB, error) {
+	dbPort := 5432
+	if portStr := getenv("BLASTBEAT_API_DB_PORT", "5432"); portStr != "" {
+		if p, err := strconv.Atoi(portStr); err == nil {
+			dbPort = p
+		}
+	}
+
+	return db.New(&db.Options{
+		User:     getenv("BLASTBEAT_API_DB_USER", "blastbeat"),
+		Password: getenv("BLASTBEAT_API_DB_PASSWORD", "blastbeat"),
+		Host:     getenv("BLASTBEAT_API_DB_HOST", "localhost"),
+		Port:     dbPort,
+		DBName:   getenv("BLASTBEAT_API_DB_NAME", "blastbeat"),
+		SSLMode:  getenv("BLASTBEAT_API_DB_SSL_MODE", "disable"),
+
+		MaxOpenConns: poolSize,
+		MaxIdleConns: poolSize,
+	})
+}
+
+// upsertReleaseFromEnriched inserts enriched, or fills in the empty fields
+// of the release already stored under the same artist, title and date;
+// Inserted on the result tells the two apart
+func upsertReleaseFromEnriched(ctx context.Context, store importStore,
+	enriched *enrichedRelease) (*gensql.UpsertReleaseRow, error) {
 
 	releaseDate, err := time.Parse("2006-01-02", enriched.DateYMD)
 	if err != nil {
@@ -369,6 +597,18 @@ func createReleaseFromEnriched(ctx context.Context, dbBackend *db.DB,
 		externalLinks["youtube"] = enriched.YoutubePreviewURL
 	}
 
+	if enriched.BandcampURL != "" {
+		externalLinks["bandcamp"] = enriched.BandcampURL
+	}
+
+	if enriched.AppleMusicURL != "" {
+		externalLinks["apple_music"] = enriched.AppleMusicURL
+	}
+
+	if enriched.DeezerAlbumURL != "" {
+		externalLinks["deezer"] = enriched.DeezerAlbumURL
+	}
+
 	if enriched.LabelDiscogsURL != "" {
 		externalLinks["discogs"] = enriched.LabelDiscogsURL
 	}
@@ -392,6 +632,20 @@ func createReleaseFromEnriched(ctx context.Context, dbBackend *db.DB,
 		youtubeURL.Valid = true
 	}
 
+	bandcampURL := sql.NullString{}
+
+	if enriched.BandcampURL != "" {
+		bandcampURL.String = enriched.BandcampURL
+		bandcampURL.Valid = true
+	}
+
+	appleMusicURL := sql.NullString{}
+
+	if enriched.AppleMusicURL != "" {
+		appleMusicURL.String = enriched.AppleMusicURL
+		appleMusicURL.Valid = true
+	}
+
 	labelURL := sql.NullString{}
 
 	if enriched.LabelURL != "" {
@@ -406,11 +660,18 @@ func createReleaseFromEnriched(ctx context.Context, dbBackend *db.DB,
 	country := sql.NullString{}
 
 	if enriched.Country != "" {
-		country.String = strings.ToUpper(enriched.Country)
-		country.Valid = true
+		if sr.ValidCountryCode(enriched.Country) {
+			country.String = strings.ToUpper(enriched.Country)
+			country.Valid = true
+		} else {
+			logrus.Warnf("dropping invalid country %q for %s - %s", enriched.Country, enriched.Artist, enriched.Album)
+		}
 	}
 
-	release, err := dbBackend.CreateRelease(ctx, gensql.CreateReleaseParams{
+	// Negative counts are stored as 0, like release edits reject them
+	followers := max(0, enriched.SpotifyFollowers)
+
+	release, err := store.UpsertRelease(ctx, gensql.UpsertReleaseParams{
 		ID:            uuid.New(),
 		Title:         enriched.Album,
 		Artist:        enriched.Artist,
@@ -418,13 +679,14 @@ func createReleaseFromEnriched(ctx context.Context, dbBackend *db.DB,
 		ReleaseDate:   releaseDate,
 		Label:         enriched.Label,
 		LabelUrl:      labelURL,
-		FollowerCount: int32(enriched.SpotifyFollowers),
+		FollowerCount: followers,
 		Genres:        genresJSON,
 		Country:       country,
 		ExternalLinks: externalLinksJSON,
 		SpotifyUrl:    spotifyURL,
 		YoutubeUrl:    youtubeURL,
-		BandcampUrl:   sql.NullString{},
+		BandcampUrl:   bandcampURL,
+		AppleMusicUrl: appleMusicURL,
 	})
 	if err != nil {
 		return nil, err
@@ -432,23 +694,27 @@ func createReleaseFromEnriched(ctx context.Context, dbBackend *db.DB,
 	return &release, nil
 }
 
-func releaseExists(ctx context.Context, dbBackend *db.DB,
+// releaseExists reports whether a release with the same releaseKey is
+// already stored, so the DB check dedupes exactly like the in-memory one.
+// It also catches accent and punctuation variants, which the unique index
+// behind UpsertRelease doesn't.
+func releaseExists(ctx context.Context, store importStore,
 	artist, album string, releaseDate time.Time) (bool, error) {
-	query := `
-		SELECT COUNT(*) 
-		FROM releases 
-		WHERE LOWER(artist) = LOWER($1) 
-		  AND LOWER(title) = LOWER($2) 
-		  AND release_date = $3
-	`
-
-	var count int
-	err := dbBackend.GetDB().QueryRowContext(ctx, query, artist, album, releaseDate).Scan(&count)
+	rows, err := store.ListReleaseKeysByDate(ctx, releaseDate)
 	if err != nil {
 		return false, err
 	}
 
-	return count > 0, nil
+	date := releaseDate.Format("2006-01-02")
+	key := releaseKey(date, artist, album)
+
+	for _, r := range rows {
+		if releaseKey(date, r.Artist, r.Title) == key {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
 
 type enrichedRelease struct {
@@ -456,10 +722,15 @@ type enrichedRelease struct {
 	Artist            string            `json:"artist"`
 	Album             string            `json:"album"`
 	Label             string            `json:"label"`
+	Barcode           string            `json:"barcode,omitempty"`
 	Genres            []string          `json:"genres"`
 	Country           string            `json:"country"`
 	SpotifyPreviewURL string            `json:"spotify_preview_url"`
 	YoutubePreviewURL string            `json:"youtube_preview_url"`
+	BandcampURL       string            `json:"bandcamp_url"`
+	AppleMusicURL     string            `json:"apple_music_url,omitempty"`
+	DeezerAlbumURL    string            `json:"deezer_album_url,omitempty"`
+	DeezerPreviewURL  string            `json:"deezer_preview_url,omitempty"`
 	SpotifyAlbumURL   string            `json:"spotify_album_url"`
 	CoverArtURL       string            `json:"cover_art_url"`
 	SpotifyFollowers  int64             `json:"spotify_followers"`
@@ -468,21 +739,34 @@ type enrichedRelease struct {
 	LabelDiscogsURL   string            `json:"label_discogs_url"`
 	LabelURL          string            `json:"label_url"`
 	Sources           map[string]string `json:"sources"`
+
+	// ProviderErrors are the provider calls that failed; a release with
+	// some is only partially enriched
+	ProviderErrors []providerError `json:"provider_errors,omitempty"`
 }
 
-func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelease {
+// enrichRelease looks the row up with every provider; barcode (a UPC/EAN,
+// may be empty) pins the exact Spotify album and Discogs release when the
+// providers know it
+func enrichRelease(ctx context.Context, dateISO, artist, album, label, barcode, contact string) *enrichedRelease {
 	out := &enrichedRelease{
 		DateYMD: dateISO,
 		Artist:  artist,
 		Album:   album,
 		Label:   label,
+		Barcode: barcode,
 		Genres:  []string{},
 		Sources: map[string]string{"csv": "1"},
 	}
 
+	ctx, errs := withProviderErrors(ctx)
+	defer func() { out.ProviderErrors = errs.list() }()
+
+	markUnavailableProviders(ctx, out.Sources)
+
 	logrus.Debugf("Starting Spotify lookup for %s - %s", artist, album)
-	aid, fol, pop, albURL, cover, spGenres, spotAlbumID :=
-		resolveSpotifyMetricsAndAlbum(artist, album)
+	aid, fol, pop, albURL, cover, spGenres, spotAlbumID, byUPC :=
+		resolveSpotifyMetricsAndAlbum(withLookup(ctx, "spotify_artist_album"), artist, album, barcode)
 
 	out.SpotifyFollowers = fol
 	out.SpotifyPopularity = pop
@@ -502,9 +786,18 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 		logrus.Debugf("Spotify album found: %s", albURL)
 	}
 
+	if byUPC {
+		out.Sources["spotify_upc"] = "1"
+	}
+
+	if albURL == "" || cover == "" {
+		logrus.Debugf("Spotify album or art missing, trying Deezer for %s - %s", artist, album)
+		enrichFromDeezer(withLookup(ctx, "deezer_album"), out)
+	}
+
 	if strings.TrimSpace(out.Label) == "" && spotAlbumID != "" {
 		logrus.Debugf("Label missing, fetching from Spotify album %s", spotAlbumID)
-		if l := getSpotifyAlbumLabel(spotAlbumID); l != "" {
+		if l := getSpotifyAlbumLabel(withLookup(ctx, "label"), spotAlbumID); l != "" {
 			out.Label = l
 			out.Sources["spotify_label"] = "1"
 			logrus.Debugf("Label found from Spotify: %s", l)
@@ -512,7 +805,7 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 	}
 
 	logrus.Debugf("Starting YouTube lookup for %s - %s", artist, album)
-	if yt := findYouTubePreview(artist, album); yt != "" {
+	if yt := findYouTubePreview(withLookup(ctx, "youtube_preview"), artist, album); yt != "" {
 		out.YoutubePreviewURL = yt
 		out.Sources["youtube_preview"] = "1"
 		logrus.Debugf("YouTube preview found: %s", yt)
@@ -520,8 +813,26 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 		logrus.Debugf("YouTube preview not found")
 	}
 
+	logrus.Debugf("Starting Bandcamp lookup for %s - %s", artist, album)
+	if bc := findBandcampURL(withLookup(ctx, "bandcamp_url"), artist, album); bc != "" {
+		out.BandcampURL = bc
+		out.Sources["bandcamp_url"] = "1"
+		logrus.Debugf("Bandcamp album found: %s", bc)
+	} else {
+		logrus.Debugf("Bandcamp album not found")
+	}
+
+	logrus.Debugf("Starting Apple Music lookup for %s - %s", artist, album)
+	if am := findAppleMusicURL(withLookup(ctx, "apple_music_url"), artist, album); am != "" {
+		out.AppleMusicURL = am
+		out.Sources["apple_music_url"] = "1"
+		logrus.Debugf("Apple Music album found: %s", am)
+	} else {
+		logrus.Debugf("Apple Music album not found")
+	}
+
 	logrus.Debugf("Starting Metal Archives lookup for %s", artist)
-	ma := lookupMetalArchivesBandGenres(artist, contact)
+	ma := lookupMetalArchivesBandGenres(withLookup(ctx, "genres"), artist, contact)
 
 	if len(ma) > 0 {
 		out.Sources["metal_archives_band"] = "1"
@@ -532,7 +843,7 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 
 	logrus.Debugf("Starting Metal Archives country lookup for %s", artist)
 	if out.Country == "" {
-		if country := lookupCountryFromMetalArchives(artist); country != "" {
+		if country := lookupCountryFromMetalArchives(withLookup(ctx, "country"), artist); country != "" {
 			out.Country = country
 			out.Sources["metal_archives_country"] = "1"
 			logrus.Debugf("Metal Archives country found: %s", country)
@@ -542,7 +853,7 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 	}
 
 	logrus.Debugf("Starting Discogs styles lookup for %s - %s", artist, album)
-	dc := lookupDiscogsStyles(artist, album, contact)
+	dc, byBarcode := lookupDiscogsStyles(withLookup(ctx, "genres"), artist, album, dateISO, barcode, contact)
 
 	if len(dc) > 0 {
 		out.Sources["discogs_style"] = "1"
@@ -551,9 +862,23 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 		logrus.Debugf("Discogs styles not found")
 	}
 
+	if byBarcode {
+		out.Sources["discogs_barcode"] = "1"
+	}
+
+	logrus.Debugf("Starting Last.fm tags lookup for %s - %s", artist, album)
+	lf := lookupLastFmTags(withLookup(ctx, "genres"), artist, album)
+
+	if len(lf) > 0 {
+		out.Sources["lastfm_tags"] = "1"
+		logrus.Debugf("Last.fm tags found: %v", lf)
+	} else {
+		logrus.Debugf("Last.fm tags not found")
+	}
+
 	logrus.Debugf("Starting MusicBrainz country lookup for %s", artist)
 	if out.Country == "" {
-		if country := lookupCountryFromMusicBrainz(artist, contact); country != "" {
+		if country := lookupCountryFromMusicBrainz(withLookup(ctx, "country"), artist, contact); country != "" {
 			out.Country = country
 			out.Sources["musicbrainz_country"] = "1"
 			logrus.Debugf("MusicBrainz country found: %s", country)
@@ -564,7 +889,7 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 
 	logrus.Debugf("Starting Discogs artist country lookup for %s", artist)
 	if out.Country == "" {
-		if country := lookupCountryFromDiscogsArtist(artist, contact); country != "" {
+		if country := lookupCountryFromDiscogsArtist(withLookup(ctx, "country"), artist, contact); country != "" {
 			out.Country = country
 			out.Sources["discogs_country"] = "1"
 			logrus.Debugf("Discogs country found: %s", country)
@@ -582,12 +907,17 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 		logrus.Debugf("Spotify genres: %v", sp)
 	}
 
-	out.Genres = unionPreserve(ma, dc, sp)
+	out.Genres = combineGenres(map[string][]string{
+		genreSourceMetalArchives: ma,
+		genreSourceDiscogs:       dc,
+		genreSourceLastFm:        lf,
+		genreSourceSpotify:       sp,
+	})
 	logrus.Debugf("Combined genres: %v", out.Genres)
 
 	logrus.Debugf("Starting label info resolution (current label: %s)", out.Label)
 	discogsLink, website, finalName :=
-		resolveLabelInfo(artist, album, out.Label, contact)
+		resolveLabelInfo(withLookup(ctx, "label"), artist, album, dateISO, barcode, out.Label, contact)
 
 	if discogsLink != "" {
 		out.LabelDiscogsURL = discogsLink
@@ -619,11 +949,100 @@ func enrichRelease(dateISO, artist, album, label, contact string) *enrichedRelea
 	return out
 }
 
+// enrichFromDeezer fills in the cover and preview Spotify didn't find from
+// Deezer; the Deezer album link is kept alongside
+func enrichFromDeezer(ctx context.Context, out *enrichedRelease) {
+	dz := lookupDeezerAlbum(ctx, out.Artist, out.Album)
+	if dz == nil {
+		logrus.Debugf("Deezer album not found")
+		return
+	}
+
+	out.DeezerAlbumURL = dz.URL
+	logrus.Debugf("Deezer album found: %s", dz.URL)
+
+	if out.CoverArtURL == "" && dz.CoverURL != "" {
+		out.CoverArtURL = dz.CoverURL
+		out.Sources["deezer_cover"] = "1"
+	}
+
+	if out.SpotifyPreviewURL == "" && dz.PreviewURL != "" {
+		out.DeezerPreviewURL = dz.PreviewURL
+		out.Sources["deezer_preview"] = "1"
+	}
+}
+
+// sourceUnavailable marks a provider in enrichedRelease.Sources (where
+// contributing sources are "1") that was skipped because it isn't
+// configured or its credentials don't work, as opposed to one that was
+// asked and found nothing
+const sourceUnavailable = "provider_unavailable"
+
+// markUnavailableProviders records the providers enrichRelease can't use in
+// sources
+func markUnavailableProviders(ctx context.Context, sources map[string]string) {
+	if getSpotifyToken(withLookup(ctx, "spotify_token")) == "" {
+		sources["spotify"] = sourceUnavailable
+	}
+
+	if os.Getenv("YOUTUBE_API_KEY") == "" {
+		sources["youtube"] = sourceUnavailable
+	}
+
+	if discogsTokens().empty() {
+		sources["discogs"] = sourceUnavailable
+	}
+
+	if os.Getenv("LASTFM_API_KEY") == "" {
+		sources["lastfm"] = sourceUnavailable
+	}
+}
+
+// decodeCSVInput transcodes the input CSV from charset to UTF-8 and strips
+// a leading byte order mark (as written by Excel)
+func decodeCSVInput(in io.Reader, charset string) (io.Reader, error) {
+	enc, err := htmlindex.Get(strings.TrimSpace(charset))
+	if err != nil {
+		return nil, errors.Errorf("unsupported -charset %q", charset)
+	}
+
+	return transform.NewReader(in, xunicode.BOMOverride(enc.NewDecoder())), nil
+}
+
+// inputReader returns the rowReader constructor of a -format value
+func inputReader(format string) (func(io.Reader) rowReader, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "csv":
+		return func(in io.Reader) rowReader { return newCSVRowReader(in) }, nil
+	case "json":
+		return func(in io.Reader) rowReader { return newJSONRowReader(in) }, nil
+	}
+
+	return nil, errors.Errorf("invalid -format %q (must be csv or json)", format)
+}
+
+// csvFieldCount is the number of fields in an input row:
+// date,artist,album,label plus an optional barcode
+const (
+	csvFieldCount    = 4
+	csvMaxFieldCount = 5
+)
+
+// checkFieldCount rejects ragged CSV rows, naming the line they start on
+func checkFieldCount(rec []string, line int) error {
+	if len(rec) == csvFieldCount || len(rec) == csvMaxFieldCount {
+		return nil
+	}
+
+	return errors.Errorf("line %d: expected %d or %d fields (date,artist,album,label[,barcode]), got %d",
+		line, csvFieldCount, csvMaxFieldCount, len(rec))
+}
+
 func releaseKey(date, artist, album string) string {
 	return strings.Join([]string{date, norm(artist), norm(album)}, "|")
 }
 
-func getSpotifyToken() string {
+func getSpotifyToken(ctx context.Context) string {
 	if spotTok != "" && time.Now().Before(spotExp) {
 		return spotTok
 	}
@@ -634,12 +1053,12 @@ func getSpotifyToken() string {
 		return ""
 	}
 	form := url.Values{"grant_type": {"client_credentials"}}
-	req, _ := http.NewRequest("POST", spotifyTokenURL,
+	req, _ := http.NewRequestWithContext(ctx, "POST", spotifyTokenURL,
 		strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.SetBasicAuth(id, sec)
 	logrus.Debugf("REQ POST %s", req.URL.String())
-	resp, err := httpClient.Do(req)
+	resp, err := doWithRetry(req)
 	if err != nil {
 		logrus.Warnf("Spotify token: %v", err)
 		return ""
@@ -668,22 +1087,24 @@ func getSpotifyToken() string {
 	return spotTok
 }
 
-func resolveSpotifyMetricsAndAlbum(artist, album string) (artistID string,
+// resolveSpotifyMetricsAndAlbum looks up the artist and album on Spotify. A
+// barcode is tried first with a upc: search; byUPC reports a match by it.
+func resolveSpotifyMetricsAndAlbum(ctx context.Context, artist, album, barcode string) (artistID string,
 	followers int64, popularity int, albumURL, coverURL string,
-	artistGenres []string, albumID string) {
-	tok := getSpotifyToken()
+	artistGenres []string, albumID string, byUPC bool) {
+	tok := getSpotifyToken(ctx)
 
 	if tok == "" {
 		return
 	}
 
 	qA := url.QueryEscape(`artist:"` + artist + `"`)
-	reqA, _ := http.NewRequest("GET",
+	reqA, _ := http.NewRequestWithContext(ctx, "GET",
 		spotifySearchBase+"?type=artist&limit=1&q="+qA, nil)
 	reqA.Header.Set("Authorization", "Bearer "+tok)
 	logrus.Debugf("REQ GET %s", reqA.URL.String())
 
-	respA, err := httpClient.Do(reqA)
+	respA, err := doWithRetry(reqA)
 	if err != nil {
 		logrus.Warnf("Spotify artist search: %v", err)
 		return
@@ -716,62 +1137,90 @@ func resolveSpotifyMetricsAndAlbum(artist, album string) (artistID string,
 	popularity = a.Popularity
 	artistGenres = a.Genres
 
-	qAlb := url.QueryEscape(fmt.Sprintf(`album:"%s" artist:"%s"`, album, artist))
-	reqB, _ := http.NewRequest("GET",
-		spotifySearchBase+"?type=album&limit=1&q="+qAlb, nil)
-	reqB.Header.Set("Authorization", "Bearer "+tok)
-	logrus.Debugf("REQ GET %s", reqB.URL.String())
+	var match *spotifyAlbum
 
-	respB, err := httpClient.Do(reqB)
-	if err != nil {
-		logrus.Warnf("Spotify album search: %v", err)
-		return
+	if barcode != "" {
+		for _, code := range barcodeVariants(barcode) {
+			if match = pickSpotifyAlbumByUPC(searchSpotifyAlbums(ctx, tok, "upc:"+code), artist); match != nil {
+				byUPC = true
+				break
+			}
+		}
+
+		if match == nil {
+			logrus.Debugf("No Spotify album with UPC %s for %s, searching by name", barcode, artist)
+		}
 	}
-	defer respB.Body.Close()
-	var sb struct {
-		Albums struct {
-			Items []struct {
-				ID           string            `json:"id"`
-				ExternalURLs map[string]string `json:"external_urls"`
-				Images       []struct {
-					URL string `json:"url"`
-				} `json:"images"`
-			} `json:"items"`
-		} `json:"albums"`
+
+	// Try the exact album query first, then a looser one with edition
+	// decorations dropped; either way only a similar title by the same
+	// artist is accepted
+	if match == nil && ctx.Err() == nil {
+		match = pickSpotifyAlbum(searchSpotifyAlbums(ctx, tok,
+			fmt.Sprintf(`album:"%s" artist:"%s"`, album, artist)), artist, album)
 	}
 
-	bB, _ := io.ReadAll(respB.Body)
-	_ = json.Unmarshal(bB, &sb)
+	if match == nil && ctx.Err() == nil {
+		logrus.Debugf("No close Spotify album match for %s - %s, retrying with a looser query",
+			artist, album)
+		match = pickSpotifyAlbum(searchSpotifyAlbums(ctx, tok,
+			fmt.Sprintf(`%s artist:"%s"`, albumKey(album), artist)), artist, album)
+	}
 
-	if len(sb.Albums.Items) > 0 {
-		albumID = sb.Albums.Items[0].ID
-		albumURL = sb.Albums.Items[0].ExternalURLs["spotify"]
+	if match != nil {
+		albumID = match.ID
+		albumURL = match.ExternalURLs["spotify"]
 
-		if len(sb.Albums.Items[0].Images) > 0 {
-			coverURL = sb.Albums.Items[0].Images[0].URL
+		if len(match.Images) > 0 {
+			coverURL = match.Images[0].URL
 		}
 	}
 
 	return
 }
 
-func getSpotifyAlbumLabel(albumID string) string {
+func searchSpotifyAlbums(ctx context.Context, tok, q string) []spotifyAlbum {
+	req, _ := http.NewRequestWithContext(ctx, "GET",
+		spotifySearchBase+"?type=album&limit=10&q="+url.QueryEscape(q), nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	logrus.Debugf("REQ GET %s", req.URL.String())
+
+	resp, err := doWithRetry(req)
+	if err != nil {
+		logrus.Warnf("Spotify album search: %v", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var sb struct {
+		Albums struct {
+			Items []spotifyAlbum `json:"items"`
+		} `json:"albums"`
+	}
+
+	b, _ := io.ReadAll(resp.Body)
+	_ = json.Unmarshal(b, &sb)
+
+	return sb.Albums.Items
+}
+
+func getSpotifyAlbumLabel(ctx context.Context, albumID string) string {
 	if albumID == "" {
 		return ""
 	}
 
-	tok := getSpotifyToken()
+	tok := getSpotifyToken(ctx)
 
 	if tok == "" {
 		return ""
 	}
 
 	u := spotifyAlbumBase + url.PathEscape(albumID)
-	req, _ := http.NewRequest("GET", u, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
 	req.Header.Set("Authorization", "Bearer "+tok)
 	logrus.Debugf("REQ GET %s", u)
 
-	resp, err := httpClient.Do(req)
+	resp, err := doWithRetry(req)
 	if err != nil {
 		return ""
 	}
@@ -801,7 +1250,7 @@ func computeScore(followers int64, popularity int) int {
 	return popularity
 }
 
-func findYouTubePreview(artist, album string) string {
+func findYouTubePreview(ctx context.Context, artist, album string) string {
 	key := os.Getenv("YOUTUBE_API_KEY")
 
 	if key == "" {
@@ -810,7 +1259,7 @@ func findYouTubePreview(artist, album string) string {
 
 	q := url.QueryEscape(artist + " " + album + " full album")
 	u := youtubeSearchBase + "?part=snippet&maxResults=1&type=video&q=" + q + "&key=" + key
-	req, _ := http.NewRequest("GET", u, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
 	logrus.Debugf("REQ GET %s", u)
 
 	resp, err := httpClient.Do(req)
@@ -838,22 +1287,30 @@ func findYouTubePreview(artist, album string) string {
 	return youtubeWatchBase + out.Items[0].ID.VideoID
 }
 
-func lookupMetalArchivesBandGenres(artist, contact string) []string {
+// lookupMetalArchivesBandGenres returns the artist's Metal Archives genres,
+// from the lookup cache when it has them
+func lookupMetalArchivesBandGenres(ctx context.Context, artist, contact string) []string {
+	return lookups.genres("metal_archives_genres", artist, func() []string {
+		return fetchMetalArchivesBandGenres(ctx, artist, contact)
+	})
+}
+
+func fetchMetalArchivesBandGenres(ctx context.Context, artist, contact string) []string {
 	ua := "metal-aggregator/1.0 (" + getenv("CONTACT_EMAIL", "admin@example.com") + ")"
 	want := norm(artist)
 
-	if g := maAdvancedJSONGenres(artist, true, ua, want); len(g) > 0 {
+	if g := maAdvancedJSONGenres(ctx, artist, true, ua, want); len(g) > 0 {
 		return g
 	}
 
-	if g := maAdvancedJSONGenres(artist, false, ua, want); len(g) > 0 {
+	if g := maAdvancedJSONGenres(ctx, artist, false, ua, want); len(g) > 0 {
 		return g
 	}
 
-	return maHTMLGenresFallback(artist, ua, want)
+	return maHTMLGenresFallback(ctx, artist, ua, want)
 }
 
-func maAdvancedJSONGenres(artist string, exact bool, ua, want string) []string {
+func maAdvancedJSONGenres(ctx context.Context, artist string, exact bool, ua, want string) []string {
 	exactStr := "0"
 
 	if exact {
@@ -862,11 +1319,12 @@ func maAdvancedJSONGenres(artist string, exact bool, ua, want string) []string {
 
 	u := maAdvancedSearch + "?bandName=" +
 		url.QueryEscape(artist) + "&exactBandMatch=" + exactStr
-	req, _ := http.NewRequest("GET", u, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
 	req.Header.Set("User-Agent", ua)
 
 	resp, err := httpClient.Do(req)
 	if err != nil || resp.StatusCode != 200 {
+		closeBody(resp)
 		return nil
 	}
 	defer resp.Body.Close()
@@ -926,14 +1384,15 @@ func maAdvancedJSONGenres(artist string, exact bool, ua, want string) []string {
 	return nil
 }
 
-func maHTMLGenresFallback(artist, ua, want string) []string {
+func maHTMLGenresFallback(ctx context.Context, artist, ua, want string) []string {
 	search := maSearchBase + "?type=band&searchString=" +
 		url.QueryEscape(artist)
-	req, _ := http.NewRequest("GET", search, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", search, nil)
 	req.Header.Set("User-Agent", ua)
 
 	resp, err := httpClient.Do(req)
 	if err != nil || resp.StatusCode != 200 {
+		closeBody(resp)
 		return nil
 	}
 	defer resp.Body.Close()
@@ -979,11 +1438,12 @@ func maHTMLGenresFallback(artist, ua, want string) []string {
 		return nil
 	}
 
-	req2, _ := http.NewRequest("GET", best, nil)
+	req2, _ := http.NewRequestWithContext(ctx, "GET", best, nil)
 	req2.Header.Set("User-Agent", ua)
 
 	resp2, err := httpClient.Do(req2)
 	if err != nil || resp2.StatusCode != 200 {
+		closeBody(resp2)
 		return nil
 	}
 	defer resp2.Body.Close()
@@ -999,17 +1459,26 @@ func maHTMLGenresFallback(artist, ua, want string) []string {
 	return nil
 }
 
-func lookupCountryFromMetalArchives(artist string) string {
+// lookupCountryFromMetalArchives returns the artist's Metal Archives
+// country, from the lookup cache when it has it
+func lookupCountryFromMetalArchives(ctx context.Context, artist string) string {
+	return lookups.country("metal_archives_country", artist, func() string {
+		return fetchCountryFromMetalArchives(ctx, artist)
+	})
+}
+
+func fetchCountryFromMetalArchives(ctx context.Context, artist string) string {
 	ua := "metal-aggregator/1.0 (" + getenv("CONTACT_EMAIL", defaultContactEmail) + ")"
 	search := maSearchBase + "?type=band&searchString=" +
 		url.QueryEscape(artist)
 	logrus.Debugf("Metal Archives country search: %s", search)
-	req, _ := http.NewRequest("GET", search, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", search, nil)
 	req.Header.Set("User-Agent", ua)
 
 	resp, err := httpClient.Do(req)
 	if err != nil || resp.StatusCode != 200 {
-		logrus.Debugf("Metal Archives search failed: err=%v, status=%d", err, resp.StatusCode)
+		logrus.Debugf("Metal Archives search failed: err=%v, status=%d", err, statusCode(resp))
+		closeBody(resp)
 		return ""
 	}
 	defer resp.Body.Close()
@@ -1058,13 +1527,14 @@ func lookupCountryFromMetalArchives(artist string) string {
 	}
 
 	logrus.Debugf("Fetching Metal Archives band page: %s", best)
-	req2, _ := http.NewRequest("GET", best, nil)
+	req2, _ := http.NewRequestWithContext(ctx, "GET", best, nil)
 	req2.Header.Set("User-Agent", ua)
 
 	resp2, err := httpClient.Do(req2)
 	if err != nil || resp2.StatusCode != 200 {
 		logrus.Debugf("Metal Archives band page fetch failed: err=%v, status=%d",
-			err, resp2.StatusCode)
+			err, statusCode(resp2))
+		closeBody(resp2)
 		return ""
 	}
 	defer resp2.Body.Close()
@@ -1120,14 +1590,13 @@ func parseMAGenres(s string) []string {
 	return out
 }
 
-func resolveLabelInfo(artist, album, labelHint, contact string) (string, string, string) {
-	tok := os.Getenv("DISCOGS_TOKEN")
-	if tok == "" {
+func resolveLabelInfo(ctx context.Context, artist, album, dateISO, barcode, labelHint, contact string) (string, string, string) {
+	if discogsTokens().empty() {
 		logrus.Warnf("DISCOGS_TOKEN not set; cannot resolve label links")
 		return "", "", ""
 	}
 
-	name, dlink, site := resolveFromDiscogsRelease(artist, album, tok, contact)
+	name, dlink, site := resolveFromDiscogsRelease(ctx, artist, album, dateISO, barcode, contact)
 
 	if dlink != "" || site != "" {
 		if name == "" {
@@ -1143,23 +1612,15 @@ func resolveLabelInfo(artist, album, labelHint, contact string) (string, string,
 		q = artist + " " + album
 	}
 
-	return resolveFromDiscogsLabelSearch(q, tok, contact)
+	return resolveFromDiscogsLabelSearch(ctx, q, contact)
 }
 
-func resolveFromDiscogsRelease(artist, album, tok, contact string) (labelName,
+func resolveFromDiscogsRelease(ctx context.Context, artist, album, dateISO, barcode, contact string) (labelName,
 	discogsLink, website string) {
-	q := url.QueryEscape(artist + " " + album)
-	u := discogsSearchBase + "?q=" + q +
-		"&type=release&per_page=1&token=" + tok
-	req, _ := http.NewRequest("GET", u, nil)
-	req.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
-	logrus.Debugf("REQ GET %s", u)
-
-	resp, err := httpClient.Do(req)
+	b, byBarcode, err := searchDiscogsRelease(ctx, artist, album, barcode, contact)
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
 
 	var sr struct {
 		Results []struct {
@@ -1167,16 +1628,22 @@ func resolveFromDiscogsRelease(artist, album, tok, contact string) (labelName,
 			ResourceURL string   `json:"resource_url"`
 			Label       []string `json:"label"`
 			Title       string   `json:"title"`
+			Year        string   `json:"year"`
 		} `json:"results"`
 	}
 
-	b, _ := io.ReadAll(resp.Body)
 	_ = json.Unmarshal(b, &sr)
 
 	if len(sr.Results) == 0 {
 		return
 	}
 
+	if !byBarcode && !discogsYearMatches(sr.Results[0].Year, dateISO) {
+		logrus.Debugf("Discogs release %q is from %s, too far from %s; ignoring",
+			sr.Results[0].Title, sr.Results[0].Year, dateISO)
+		return
+	}
+
 	if len(sr.Results[0].Label) > 0 {
 		labelName = strings.TrimSpace(sr.Results[0].Label[0])
 	}
@@ -1190,12 +1657,7 @@ func resolveFromDiscogsRelease(artist, album, tok, contact string) (labelName,
 	}
 
 	if sr.Results[0].ResourceURL != "" {
-		rr := sr.Results[0].ResourceURL + "?token=" + tok
-		req2, _ := http.NewRequest("GET", rr, nil)
-		req2.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
-		logrus.Debugf("REQ GET %s", rr)
-
-		resp2, err := httpClient.Do(req2)
+		resp2, err := discogsGet(ctx, sr.Results[0].ResourceURL, contact)
 		if err == nil {
 			defer resp2.Body.Close()
 			var rel struct {
@@ -1216,12 +1678,7 @@ func resolveFromDiscogsRelease(artist, album, tok, contact string) (labelName,
 					labelName = strings.TrimSpace(rel.Labels[0].Name)
 				}
 
-				ll := fmt.Sprintf("%s/%d?token=%s", discogsLabelsBase, lid, tok)
-				req3, _ := http.NewRequest("GET", ll, nil)
-				req3.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
-				logrus.Debugf("REQ GET %s", ll)
-
-				resp3, err := httpClient.Do(req3)
+				resp3, err := discogsGet(ctx, fmt.Sprintf("%s/%d", discogsLabelsBase, lid), contact)
 				if err == nil {
 					defer resp3.Body.Close()
 
@@ -1256,16 +1713,11 @@ func resolveFromDiscogsRelease(artist, album, tok, contact string) (labelName,
 	return
 }
 
-func resolveFromDiscogsLabelSearch(query, tok, contact string) (discogsLink,
+func resolveFromDiscogsLabelSearch(ctx context.Context, query, contact string) (discogsLink,
 	website, labelName string) {
 	q := url.QueryEscape(query)
-	u := discogsSearchBase + "?q=" + q +
-		"&type=label&per_page=1&token=" + tok
-	req, _ := http.NewRequest("GET", u, nil)
-	req.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
-	logrus.Debugf("REQ GET %s", u)
 
-	resp, err := httpClient.Do(req)
+	resp, err := discogsGet(ctx, discogsSearchBase+"?q="+q+"&type=label&per_page=1", contact)
 	if err != nil {
 		return
 	}
@@ -1294,12 +1746,7 @@ func resolveFromDiscogsLabelSearch(query, tok, contact string) (discogsLink,
 		discogsLink = "https://www.discogs.com" + discogsLink
 	}
 
-	ll := fmt.Sprintf("%s/%d?token=%s", discogsLabelsBase, id, tok)
-	req2, _ := http.NewRequest("GET", ll, nil)
-	req2.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
-	logrus.Debugf("REQ GET %s", ll)
-
-	resp2, err := httpClient.Do(req2)
+	resp2, err := discogsGet(ctx, fmt.Sprintf("%s/%d", discogsLabelsBase, id), contact)
 	if err != nil {
 		return
 	}
@@ -1452,56 +1899,125 @@ func normalizeURL(rawURL string) string {
 	return normalized
 }
 
-func lookupDiscogsStyles(artist, album, contact string) []string {
-	tok := os.Getenv("DISCOGS_TOKEN")
-
-	if tok == "" {
-		return nil
+// lookupDiscogsStyles returns the styles of the row's Discogs release;
+// byBarcode reports that the release was found by barcode
+func lookupDiscogsStyles(ctx context.Context, artist, album, dateISO, barcode, contact string) (styles []string,
+	byBarcode bool) {
+	if discogsTokens().empty() {
+		return nil, false
 	}
 
-	q := url.QueryEscape(artist + " " + album)
-	u := discogsSearchBase + "?q=" + q +
-		"&type=release&per_page=1&token=" + tok
-	req, _ := http.NewRequest("GET", u, nil)
-	req.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
-	logrus.Debugf("REQ GET %s", u)
-
-	resp, err := httpClient.Do(req)
+	b, byBarcode, err := searchDiscogsRelease(ctx, artist, album, barcode, contact)
 	if err != nil {
 		logrus.Warnf("Discogs style: %v", err)
-		return nil
+		return nil, false
 	}
-	defer resp.Body.Close()
 
 	var out struct {
 		Results []struct {
+			Title string   `json:"title"`
+			Year  string   `json:"year"`
 			Style []string `json:"style"`
 		} `json:"results"`
 	}
 
-	b, _ := io.ReadAll(resp.Body)
 	_ = json.Unmarshal(b, &out)
 
 	if len(out.Results) == 0 {
-		return nil
+		return nil, false
+	}
+
+	if !byBarcode && !discogsYearMatches(out.Results[0].Year, dateISO) {
+		logrus.Debugf("Discogs release %q is from %s, too far from %s; ignoring styles",
+			out.Results[0].Title, out.Results[0].Year, dateISO)
+		return nil, false
+	}
+
+	return normalizeList(out.Results[0].Style), byBarcode
+}
+
+// searchDiscogsRelease returns the body of a one-result Discogs release
+// search for the row: by barcode when there is one, by artist and album
+// when there isn't or the barcode finds nothing. A barcode hit (byBarcode)
+// is the exact release, so it needs no year check.
+func searchDiscogsRelease(ctx context.Context, artist, album, barcode, contact string) (body []byte,
+	byBarcode bool, err error) {
+	if barcode != "" {
+		b, err := discogsSearch(ctx, "barcode="+url.QueryEscape(barcode)+"&type=release&per_page=1", contact)
+		if err == nil && discogsHasResults(b) {
+			return b, true, nil
+		}
+
+		logrus.Debugf("No Discogs release with barcode %s, searching by name", barcode)
+	}
+
+	b, err := discogsSearch(ctx, "q="+url.QueryEscape(artist+" "+album)+"&type=release&per_page=1", contact)
+
+	return b, false, err
+}
+
+func discogsSearch(ctx context.Context, query, contact string) ([]byte, error) {
+	resp, err := discogsGet(ctx, discogsSearchBase+"?"+query, contact)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func discogsHasResults(body []byte) bool {
+	var sr struct {
+		Results []json.RawMessage `json:"results"`
 	}
 
-	return normalizeList(out.Results[0].Style)
+	return json.Unmarshal(body, &sr) == nil && len(sr.Results) > 0
 }
 
-func lookupCountryFromMusicBrainz(artist, contact string) string {
+// discogsYearMatches reports whether a Discogs result year is within
+// discogsYearTolerance of the release date. Results without a year, or
+// dates that can't be parsed, are given the benefit of the doubt.
+func discogsYearMatches(year, dateISO string) bool {
+	y, err := strconv.Atoi(strings.TrimSpace(year))
+	if err != nil || y == 0 {
+		return true
+	}
+
+	d, err := time.Parse("2006-01-02", dateISO)
+	if err != nil {
+		return true
+	}
+
+	diff := d.Year() - y
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff <= discogsYearTolerance
+}
+
+// lookupCountryFromMusicBrainz returns the artist's MusicBrainz country,
+// from the lookup cache when it has it
+func lookupCountryFromMusicBrainz(ctx context.Context, artist, contact string) string {
+	return lookups.country("musicbrainz_country", artist, func() string {
+		return fetchCountryFromMusicBrainz(ctx, artist, contact)
+	})
+}
+
+func fetchCountryFromMusicBrainz(ctx context.Context, artist, contact string) string {
 	ua := "metal-aggregator/1.0 (" + contact + ")"
 
 	searchURL := musicBrainzBase + "/artist/?query=artist:" +
 		url.QueryEscape(artist) + "&fmt=json&limit=1"
 	logrus.Debugf("MusicBrainz artist search: %s", searchURL)
 
-	req, _ := http.NewRequest("GET", searchURL, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", searchURL, nil)
 	req.Header.Set("User-Agent", ua)
 
-	resp, err := httpClient.Do(req)
+	resp, err := doWithRetry(req)
 	if err != nil || resp.StatusCode != 200 {
-		logrus.Debugf("MusicBrainz search failed: err=%v, status=%d", err, resp.StatusCode)
+		logrus.Debugf("MusicBrainz search failed: err=%v, status=%d", err, statusCode(resp))
+		closeBody(resp)
 		return ""
 	}
 	defer resp.Body.Close()
@@ -1530,13 +2046,14 @@ func lookupCountryFromMusicBrainz(artist, contact string) string {
 	artistURL := musicBrainzBase + "/artist/" + mbid + "?fmt=json&inc=area-rels"
 	logrus.Debugf("Fetching MusicBrainz artist details: %s", artistURL)
 
-	req2, _ := http.NewRequest("GET", artistURL, nil)
+	req2, _ := http.NewRequestWithContext(ctx, "GET", artistURL, nil)
 	req2.Header.Set("User-Agent", ua)
 
-	resp2, err := httpClient.Do(req2)
+	resp2, err := doWithRetry(req2)
 	if err != nil || resp2.StatusCode != 200 {
 		logrus.Debugf("MusicBrainz artist fetch failed: err=%v, status=%d",
-			err, resp2.StatusCode)
+			err, statusCode(resp2))
+		closeBody(resp2)
 		return ""
 	}
 	defer resp2.Body.Close()
@@ -1554,7 +2071,9 @@ func lookupCountryFromMusicBrainz(artist, contact string) string {
 		return ""
 	}
 
-	if len(artistResp.Area.ISO31661Codes) > 0 {
+	// MusicBrainz has user-assigned codes for areas that aren't countries
+	// (XW for worldwide, XE for Europe)
+	if len(artistResp.Area.ISO31661Codes) > 0 && sr.ValidCountryCode(artistResp.Area.ISO31661Codes[0]) {
 		isoCode := strings.ToUpper(artistResp.Area.ISO31661Codes[0])
 		logrus.Debugf("MusicBrainz country: %s -> %s",
 			artistResp.Area.Name, isoCode)
@@ -1574,22 +2093,16 @@ func lookupCountryFromMusicBrainz(artist, contact string) string {
 	return ""
 }
 
-func lookupCountryFromDiscogsArtist(artist, contact string) string {
-	tok := os.Getenv("DISCOGS_TOKEN")
-
-	if tok == "" {
+func lookupCountryFromDiscogsArtist(ctx context.Context, artist, contact string) string {
+	if discogsTokens().empty() {
 		logrus.Debugf("DISCOGS_TOKEN not set, skipping Discogs artist country lookup")
 		return ""
 	}
 
 	q := url.QueryEscape(artist)
-	u := discogsSearchBase + "?q=" + q + "&type=artist&per_page=1&token=" + tok
-	logrus.Debugf("Discogs artist search: %s", u)
+	logrus.Debugf("Discogs artist search: %s", artist)
 
-	req, _ := http.NewRequest("GET", u, nil)
-	req.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
-
-	resp, err := httpClient.Do(req)
+	resp, err := discogsGet(ctx, discogsSearchBase+"?q="+q+"&type=artist&per_page=1", contact)
 	if err != nil {
 		logrus.Debugf("Discogs artist search failed: %v", err)
 		return ""
@@ -1612,13 +2125,10 @@ func lookupCountryFromDiscogsArtist(artist, contact string) string {
 	}
 
 	artistID := sr.Results[0].ID
-	artistURL := fmt.Sprintf("%s/%d?token=%s", discogsArtistBase, artistID, tok)
+	artistURL := fmt.Sprintf("%s/%d", discogsArtistBase, artistID)
 	logrus.Debugf("Fetching Discogs artist: %s", artistURL)
 
-	req2, _ := http.NewRequest("GET", artistURL, nil)
-	req2.Header.Set("User-Agent", "metal-aggregator/1.0 ("+contact+")")
-
-	resp2, err := httpClient.Do(req2)
+	resp2, err := discogsGet(ctx, artistURL, contact)
 	if err != nil {
 		logrus.Debugf("Discogs artist fetch failed: %v", err)
 		return ""
@@ -1725,7 +2235,7 @@ func countryNameToISO(countryName string) string {
 		return code
 	}
 
-	if len(countryName) == 2 {
+	if sr.ValidCountryCode(countryName) {
 		upper := strings.ToUpper(countryName)
 		logrus.Debugf("Country already ISO code: %s -> %s", originalName, upper)
 		return upper
@@ -1735,14 +2245,39 @@ func countryNameToISO(countryName string) string {
 	return ""
 }
 
+var (
+	// normReplacer folds typographic punctuation to ASCII and handles
+	// letters that have no Unicode decomposition (so NFD can't strip them)
+	normReplacer = strings.NewReplacer(
+		"\u2018", "'", "\u2019", "'", "\u201A", "'", "\u201B", "'",
+		"\u201C", `"`, "\u201D", `"`, "\u201E", `"`, "\u201F", `"`,
+		"\u2010", "-", "\u2011", "-", "\u2012", "-", "\u2013", "-",
+		"\u2014", "-", "\u2015", "-",
+		"&", " and ",
+		"ø", "o", "ł", "l", "đ", "d", "ð", "d", "þ", "th",
+		"æ", "ae", "œ", "oe", "ß", "ss", "ı", "i",
+	)
+
+	normSpaceRe = regexp.MustCompile(`\s+`)
+)
+
+// foldAccents strips combining marks (é -> e, ñ -> n, å -> a, etc.)
+func foldAccents(s string) string {
+	// transform.Chain is stateful, so it can't be shared between workers
+	t := transform.Chain(unorm.NFD, runes.Remove(runes.In(unicode.Mn)), unorm.NFC)
+
+	out, _, err := transform.String(t, s)
+	if err != nil {
+		return s
+	}
+
+	return out
+}
+
 func norm(s string) string {
 	s = strings.ToLower(strings.TrimSpace(s))
-	s = strings.NewReplacer(
-		"'", "'", "'", "'", `"`, `"`, `"`, `"`,
-		"–", "-", "—", "-", "&", " and ",
-		"é", "e", "è", "e", "á", "a", "à", "a", "ó", "o", "ö", "o",
-		"ü", "u", "í", "i", "ï", "i", "ç", "c",
-	).Replace(s)
+	s = normReplacer.Replace(s)
+	s = foldAccents(s)
 	s = strings.TrimPrefix(s, "the ")
 	buf := make([]rune, 0, len(s))
 
@@ -1752,9 +2287,25 @@ func norm(s string) string {
 		}
 	}
 
-	spaceRe := regexp.MustCompile(`\s+`)
+	return strings.TrimSpace(normSpaceRe.ReplaceAllString(string(buf), " "))
+}
+
+// statusCode returns the response status code, or 0 when the request failed
+// before a response was received (ie. context cancelled)
+func statusCode(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
 
-	return strings.TrimSpace(spaceRe.ReplaceAllString(string(buf), " "))
+	return resp.StatusCode
+}
+
+// closeBody closes the body of a response that won't be read; it also frees
+// the request's provider concurrency slot
+func closeBody(resp *http.Response) {
+	if resp != nil {
+		resp.Body.Close()
+	}
 }
 
 func stripTags(s string) string {
@@ -1784,11 +2335,66 @@ func normalizeList(in []string) []string {
 	return out
 }
 
-func unionPreserve(a, b, c []string) []string {
+// Genre sources, in their default -genre-source-order
+const (
+	genreSourceMetalArchives = "metal_archives"
+	genreSourceDiscogs       = "discogs"
+	genreSourceLastFm        = "lastfm"
+	genreSourceSpotify       = "spotify"
+)
+
+var defaultGenreSourceOrder = []string{genreSourceMetalArchives, genreSourceDiscogs, genreSourceLastFm, genreSourceSpotify}
+
+// parseGenreSourceOrder parses the -genre-source-order flag. Sources left
+// out keep their default relative order after the listed ones.
+func parseGenreSourceOrder(v string) ([]string, error) {
+	order := make([]string, 0, len(defaultGenreSourceOrder))
+	seen := map[string]bool{}
+
+	for _, p := range strings.Split(v, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+
+		if !slices.Contains(defaultGenreSourceOrder, p) {
+			return nil, errors.Errorf("invalid -genre-source-order source %q (must be one of %s)",
+				p, strings.Join(defaultGenreSourceOrder, ", "))
+		}
+
+		if seen[p] {
+			return nil, errors.Errorf("duplicate -genre-source-order source %q", p)
+		}
+
+		seen[p] = true
+		order = append(order, p)
+	}
+
+	for _, p := range defaultGenreSourceOrder {
+		if !seen[p] {
+			order = append(order, p)
+		}
+	}
+
+	return order, nil
+}
+
+// combineGenres unions the per-source genre lists in genreSourceOrder
+func combineGenres(bySource map[string][]string) []string {
+	lists := make([][]string, 0, len(genreSourceOrder))
+
+	for _, src := range genreSourceOrder {
+		lists = append(lists, bySource[src])
+	}
+
+	return unionPreserve(lists...)
+}
+
+func unionPreserve(lists ...[]string) []string {
 	seen := map[string]bool{}
 	out := []string{}
 
-	for _, list := range [][]string{a, b, c} {
+	for _, list := range lists {
 		for _, s := range list {
 			if !seen[s] {
 				seen[s] = true
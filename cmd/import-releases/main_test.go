@@ -0,0 +1,1640 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+	"github.com/dselans/blastbeat-api/services/linkcheck"
+)
+
+var _ = Describe("Import Releases", func() {
+	Describe("norm", func() {
+		Context("when given accented names", func() {
+			It("should fold diacritics to ASCII", func() {
+				Expect(norm("Mötley Crüe")).To(Equal("motley crue"))
+				Expect(norm("Mgła")).To(Equal("mgla"))
+				Expect(norm("Ænima")).To(Equal("aenima"))
+				Expect(norm("Året Runt")).To(Equal("aret runt"))
+				Expect(norm("Dødheimsgard")).To(Equal("dodheimsgard"))
+				Expect(norm("Mägo de Oz")).To(Equal("mago de oz"))
+				Expect(norm("Señor")).To(Equal("senor"))
+				Expect(norm("Blóð")).To(Equal("blod"))
+			})
+		})
+
+		Context("when given typographic punctuation", func() {
+			It("should treat curly and straight quotes the same", func() {
+				Expect(norm("Don’t Break the Oath")).
+					To(Equal(norm("Don't Break the Oath")))
+				Expect(norm("“Heroes”")).To(Equal(norm(`"Heroes"`)))
+			})
+
+			It("should treat en/em dashes like hyphens", func() {
+				Expect(norm("Post–Black")).To(Equal(norm("Post-Black")))
+				Expect(norm("Post—Black")).To(Equal(norm("Post-Black")))
+			})
+		})
+
+		Context("when given mixed case, articles and ampersands", func() {
+			It("should normalize them", func() {
+				Expect(norm("  The   Black Dahlia Murder ")).
+					To(Equal("black dahlia murder"))
+				Expect(norm("Blood & Iron")).To(Equal("blood and iron"))
+				Expect(norm("")).To(Equal(""))
+			})
+		})
+	})
+
+	Describe("parseBandcampArt", func() {
+		page := `
+<ul class="result-items">
+<li class="searchresult data-search">
+  <div class="art">
+    <img src="https://f4.bcbits.com/img/a111_7.jpg">
+  </div>
+  <div class="heading">
+    <a href="https://other.bandcamp.com/album/x">Some Other Album</a>
+  </div>
+  <div class="subhead">
+    by Mgła
+  </div>
+</li>
+<li class="searchresult data-search">
+  <div class="art">
+    <img src="https://f4.bcbits.com/img/a222_7.jpg">
+  </div>
+  <div class="heading">
+    <a href="https://mgla.bandcamp.com/album/age-of-excuse">Age of Excuse</a>
+  </div>
+  <div class="subhead">
+    by Mgła
+  </div>
+</li>
+</ul>`
+
+		It("should return the art of the matching result", func() {
+			Expect(parseBandcampArt(page, "Mgla", "Age Of Excuse")).
+				To(Equal("https://f4.bcbits.com/img/a222_7.jpg"))
+		})
+
+		It("should return nothing when no result matches", func() {
+			Expect(parseBandcampArt(page, "Mgła", "Exercises in Futility")).To(BeEmpty())
+		})
+	})
+
+	Describe("parseBandcampURL", func() {
+		page := `
+<ul class="result-items">
+<li class="searchresult data-search">
+  <div class="heading">
+    <a href="https://tribute.bandcamp.com/album/age-of-excuse?from=search&amp;search_item_id=1">Age of Excuse</a>
+  </div>
+  <div class="subhead">
+    by Mgła Tribute
+  </div>
+</li>
+<li class="searchresult data-search">
+  <div class="heading">
+    <a href="https://mgla.bandcamp.com/album/age-of-excuse?from=search&amp;search_item_id=2">Age of Excuse</a>
+  </div>
+  <div class="subhead">
+    from Age of Excuse by Mgła
+  </div>
+</li>
+</ul>`
+
+		It("should prefer the result by the exact artist", func() {
+			Expect(parseBandcampURL(page, "Mgla", "Age Of Excuse")).
+				To(Equal("https://mgla.bandcamp.com/album/age-of-excuse"))
+		})
+
+		It("should return nothing when only other artists match", func() {
+			Expect(parseBandcampURL(page, "Mgła Tribute Band", "Age of Excuse")).To(BeEmpty())
+		})
+	})
+
+	Describe("redactURL", func() {
+		It("should redact API keys and tokens", func() {
+			u, _ := url.Parse("https://www.googleapis.com/youtube/v3/search?key=secret&q=mgla")
+			Expect(redactURL(u)).To(Equal("https://www.googleapis.com/youtube/v3/search?key=REDACTED&q=mgla"))
+
+			u, _ = url.Parse("https://api.discogs.com/database/search?token=secret")
+			Expect(redactURL(u)).To(Equal("https://api.discogs.com/database/search?token=REDACTED"))
+
+			u, _ = url.Parse("https://ws.audioscrobbler.com/2.0/?api_key=secret&method=album.getinfo")
+			Expect(redactURL(u)).To(Equal("https://ws.audioscrobbler.com/2.0/?api_key=REDACTED&method=album.getinfo"))
+		})
+
+		It("should leave other URLs alone", func() {
+			u, _ := url.Parse("https://musicbrainz.org/ws/2/artist?query=mgla")
+			Expect(redactURL(u)).To(Equal("https://musicbrainz.org/ws/2/artist?query=mgla"))
+		})
+	})
+
+	Describe("provider errors", func() {
+		get := func(ctx context.Context, rawURL string) {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+			if resp, err := httpClient.Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}
+
+		var orig http.RoundTripper
+
+		BeforeEach(func() {
+			orig = httpClient.Transport
+			httpClient.Transport = &auditTransport{base: fakeTransport{
+				"api.spotify.com":        http.StatusServiceUnavailable,
+				"musicbrainz.org":        http.StatusNotFound,
+				"www.metal-archives.com": http.StatusOK,
+			}}
+		})
+
+		AfterEach(func() {
+			httpClient.Transport = orig
+		})
+
+		It("should collect failed calls but not misses", func() {
+			ctx, errs := withProviderErrors(context.Background())
+
+			get(withLookup(ctx, "spotify_artist_album"), "https://api.spotify.com/v1/search")
+			get(withLookup(ctx, "country"), "https://musicbrainz.org/ws/2/artist")
+			get(withLookup(ctx, "genres"), "https://www.metal-archives.com/search")
+			get(withLookup(ctx, "label"), "https://api.discogs.com/database/search")
+
+			Expect(errs.list()).To(Equal([]providerError{
+				{Provider: "spotify", Lookup: "spotify_artist_album", Error: "unexpected status 503"},
+				{Provider: "discogs", Lookup: "label", Error: "connection refused"},
+			}))
+		})
+
+		It("should ignore calls without a collector", func() {
+			Expect(func() {
+				get(context.Background(), "https://api.spotify.com/v1/search")
+			}).ToNot(Panic())
+		})
+
+		It("should count partial rows in the summary", func() {
+			c := newSummaryCollector("in.csv", true, 1)
+			c.recordProviderErrors(nil)
+			c.recordProviderErrors([]providerError{{Provider: "spotify"}, {Provider: "discogs"}})
+			c.recordProviderErrors([]providerError{{Provider: "spotify"}})
+
+			summary := c.finish(summaryTotals{})
+
+			Expect(summary.PartialRows).To(Equal(int64(2)))
+			Expect(summary.ProviderErrors).To(Equal(map[string]int64{"spotify": 2, "discogs": 1}))
+		})
+	})
+
+	Describe("dumpingEnricher", func() {
+		var (
+			orig http.RoundTripper
+			dir  string
+		)
+
+		BeforeEach(func() {
+			orig = httpClient.Transport
+			httpClient.Transport = &dumpTransport{base: bodyTransport{
+				"api.discogs.com": `{"results":[{"title":"Mgła - Age of Excuse"}]}`,
+			}}
+
+			var err error
+			dir, err = os.MkdirTemp("", "dump-responses")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			httpClient.Transport = orig
+			os.RemoveAll(dir)
+		})
+
+		It("should write each provider response to the row's file", func() {
+			var seen string
+
+			e := dumpingEnricher{dir: dir, base: enrichFunc(func(ctx context.Context, _ csvRow) *enrichedRelease {
+				req, _ := http.NewRequestWithContext(withLookup(ctx, "genres"), http.MethodGet,
+					"https://api.discogs.com/database/search?q=mgla&token=secret", nil)
+
+				resp, err := httpClient.Do(req)
+				Expect(err).ToNot(HaveOccurred())
+				defer resp.Body.Close()
+
+				b, _ := io.ReadAll(resp.Body)
+				seen = string(b)
+
+				return &enrichedRelease{}
+			})}
+
+			e.enrich(context.Background(), csvRow{rowNum: 3, dateISO: "2024-03-01", artist: "Mgła", album: "Age of Excuse"})
+
+			b, err := os.ReadFile(dir + "/row-3.jsonl")
+			Expect(err).ToNot(HaveOccurred())
+
+			var r dumpedResponse
+			Expect(json.Unmarshal(b, &r)).To(Succeed())
+
+			Expect(seen).To(Equal(`{"results":[{"title":"Mgła - Age of Excuse"}]}`))
+			Expect(r).To(Equal(dumpedResponse{
+				Row:         3,
+				Date:        "2024-03-01",
+				Artist:      "Mgła",
+				Album:       "Age of Excuse",
+				Provider:    "discogs",
+				Lookup:      "genres",
+				Method:      http.MethodGet,
+				URL:         "https://api.discogs.com/database/search?q=mgla&token=REDACTED",
+				StatusCode:  http.StatusOK,
+				ContentType: "application/json",
+				Body:        seen,
+			}))
+		})
+	})
+
+	Describe("replay", func() {
+		var (
+			orig  http.RoundTripper
+			saved map[string]string
+			dir   string
+		)
+
+		BeforeEach(func() {
+			orig = httpClient.Transport
+			saved = map[string]string{}
+
+			for _, k := range []string{"SPOTIFY_CLIENT_ID", "SPOTIFY_CLIENT_SECRET"} {
+				saved[k] = os.Getenv(k)
+				os.Setenv(k, "test")
+			}
+
+			var err error
+			dir, err = os.MkdirTemp("", "replay")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			httpClient.Transport = orig
+			spotTok = ""
+
+			for k, v := range saved {
+				os.Setenv(k, v)
+			}
+
+			os.RemoveAll(dir)
+		})
+
+		It("should reproduce a dumped enrichment without the network", func() {
+			ctx := context.Background()
+			row := csvRow{rowNum: 7, dateISO: "2024-03-01", artist: "Mgła", album: "Age of Excuse", label: "Northern Heritage"}
+
+			httpClient.Transport = &auditTransport{base: &dumpTransport{base: bodyTransport{
+				"accounts.spotify.com": `{"access_token":"secret-token","expires_in":3600}`,
+				"api.spotify.com":      `{"albums":{"items":[]}}`,
+			}}}
+			spotTok = ""
+
+			dumped := dumpingEnricher{base: providerEnricher{contact: defaultContactEmail}, dir: dir}.enrich(ctx, row)
+
+			b, err := os.ReadFile(dir + "/row-7.jsonl")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).ToNot(ContainSubstring("secret-token"))
+			Expect(strings.Count(string(b), "\n")).To(BeNumerically(">", 3))
+
+			httpClient.Transport = &auditTransport{base: replayTransport{}}
+			spotTok = ""
+
+			replayedRow, replayed, err := replayRow(ctx, dir+"/row-7.jsonl", defaultContactEmail)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(replayedRow).To(Equal(row))
+			Expect(replayed).To(Equal(dumped))
+		})
+
+		It("should fail requests that weren't dumped", func() {
+			httpClient.Transport = replayTransport{}
+
+			req, _ := http.NewRequestWithContext(withReplay(context.Background(), newReplaySet(nil)),
+				http.MethodGet, "https://musicbrainz.org/ws/2/artist?query=mgla", nil)
+
+			_, err := httpClient.Do(req)
+			Expect(err).To(MatchError(ContainSubstring("no dumped response for GET")))
+		})
+	})
+
+	Describe("markUnavailableProviders", func() {
+		var saved map[string]string
+
+		BeforeEach(func() {
+			saved = map[string]string{}
+
+			for _, k := range []string{"SPOTIFY_CLIENT_ID", "SPOTIFY_CLIENT_SECRET", "YOUTUBE_API_KEY"} {
+				saved[k] = os.Getenv(k)
+				os.Unsetenv(k)
+			}
+		})
+
+		AfterEach(func() {
+			for k, v := range saved {
+				os.Setenv(k, v)
+			}
+		})
+
+		It("should mark providers without credentials", func() {
+			sources := map[string]string{"csv": "1"}
+			markUnavailableProviders(context.Background(), sources)
+
+			Expect(sources).To(HaveKeyWithValue("spotify", sourceUnavailable))
+			Expect(sources).To(HaveKeyWithValue("youtube", sourceUnavailable))
+			Expect(sources).To(HaveKeyWithValue("csv", "1"))
+		})
+
+		It("should count unavailable providers apart from hits in the summary", func() {
+			c := newSummaryCollector("in.csv", true, 1)
+			c.recordSources(map[string]string{"csv": "1", "spotify": sourceUnavailable})
+			c.recordSources(map[string]string{"csv": "1", "spotify_album": "1"})
+
+			summary := c.finish(summaryTotals{})
+
+			Expect(summary.ProviderUnavailable).To(Equal(map[string]int64{"spotify": 1}))
+			Expect(summary.ProviderHits).ToNot(HaveKey("spotify"))
+			Expect(summary.ProviderHits["spotify_album"].Hits).To(Equal(int64(1)))
+			Expect(summary.ProviderHits["csv"].Rate).To(Equal(1.0))
+		})
+	})
+
+	Describe("discogsYearMatches", func() {
+		BeforeEach(func() {
+			discogsYearTolerance = 1
+		})
+
+		It("should accept years within the tolerance", func() {
+			Expect(discogsYearMatches("2019", "2019-11-29")).To(BeTrue())
+			Expect(discogsYearMatches("2018", "2019-01-04")).To(BeTrue())
+		})
+
+		It("should reject far-off years", func() {
+			Expect(discogsYearMatches("2001", "2019-11-29")).To(BeFalse())
+		})
+
+		It("should accept results without a year", func() {
+			Expect(discogsYearMatches("", "2019-11-29")).To(BeTrue())
+		})
+	})
+
+	Describe("pickSpotifyAlbum", func() {
+		newAlbum := func(id, name, artist string) spotifyAlbum {
+			a := spotifyAlbum{ID: id, Name: name}
+			a.Artists = append(a.Artists, struct {
+				Name string `json:"name"`
+			}{Name: artist})
+
+			return a
+		}
+
+		It("should match titles that differ only by edition", func() {
+			items := []spotifyAlbum{newAlbum("1", "Blackwater Park (Legacy Edition)", "Opeth")}
+
+			match := pickSpotifyAlbum(items, "Opeth", "Blackwater Park")
+			Expect(match).ToNot(BeNil())
+			Expect(match.ID).To(Equal("1"))
+		})
+
+		It("should prefer the closest title", func() {
+			items := []spotifyAlbum{
+				newAlbum("1", "Damnation", "Opeth"),
+				newAlbum("2", "Deliverance", "Opeth"),
+			}
+
+			Expect(pickSpotifyAlbum(items, "Opeth", "Deliverance").ID).To(Equal("2"))
+		})
+
+		It("should reject dissimilar titles and other artists", func() {
+			Expect(pickSpotifyAlbum([]spotifyAlbum{newAlbum("1", "Heritage", "Opeth")},
+				"Opeth", "Blackwater Park")).To(BeNil())
+			Expect(pickSpotifyAlbum([]spotifyAlbum{newAlbum("1", "Blackwater Park", "Someone Else")},
+				"Opeth", "Blackwater Park")).To(BeNil())
+		})
+	})
+
+	Describe("barcode matching", func() {
+		const barcode = "0822603149523"
+
+		var (
+			orig      http.RoundTripper
+			origPool  *discogsTokenPool
+			responses []dumpedResponse
+		)
+
+		respond := func(rawURL, body string) {
+			u, err := url.Parse(rawURL)
+			Expect(err).ToNot(HaveOccurred())
+
+			responses = append(responses, dumpedResponse{
+				Method: http.MethodGet, URL: redactURL(u), StatusCode: http.StatusOK, Body: body,
+			})
+		}
+
+		spotifyAlbumSearch := func(q string) string {
+			return spotifySearchBase + "?type=album&limit=10&q=" + url.QueryEscape(q)
+		}
+
+		BeforeEach(func() {
+			orig = httpClient.Transport
+			httpClient.Transport = replayTransport{}
+			responses = nil
+
+			spotTok, spotExp = "test", time.Now().Add(time.Hour)
+
+			discogsTokens()
+			origPool = discogsPool
+			discogsPool = &discogsTokenPool{tokens: []*discogsToken{{value: "test"}}}
+
+			respond(spotifySearchBase+"?type=artist&limit=1&q="+url.QueryEscape(`artist:"Mgła"`),
+				`{"artists":{"items":[{"id":"mgla","followers":{"total":100}}]}}`)
+		})
+
+		AfterEach(func() {
+			httpClient.Transport = orig
+			discogsPool = origPool
+			spotTok = ""
+		})
+
+		It("should normalize barcodes", func() {
+			Expect(normalizeBarcode(" 0 822603-149523 ")).To(Equal(barcode))
+			Expect(normalizeBarcode("1234567")).To(BeEmpty())
+			Expect(normalizeBarcode("08226031495X3")).To(BeEmpty())
+			Expect(barcodeVariants(barcode)).To(Equal([]string{barcode, "822603149523"}))
+		})
+
+		It("should resolve the exact Spotify edition by UPC", func() {
+			respond(spotifyAlbumSearch("upc:"+barcode),
+				`{"albums":{"items":[{"id":"deluxe","name":"Age of Excuse (Deluxe)","artists":[{"name":"Mgła"}]}]}}`)
+
+			ctx := withReplay(context.Background(), newReplaySet(responses))
+			_, _, _, _, _, _, albumID, byUPC := resolveSpotifyMetricsAndAlbum(ctx, "Mgła", "Age of Excuse", barcode)
+
+			Expect(albumID).To(Equal("deluxe"))
+			Expect(byUPC).To(BeTrue())
+		})
+
+		It("should fall back to the name search when the UPC is unknown", func() {
+			respond(spotifyAlbumSearch("upc:"+barcode), `{"albums":{"items":[]}}`)
+			respond(spotifyAlbumSearch("upc:822603149523"), `{"albums":{"items":[]}}`)
+			respond(spotifyAlbumSearch(`album:"Age of Excuse" artist:"Mgła"`),
+				`{"albums":{"items":[{"id":"standard","name":"Age of Excuse","artists":[{"name":"Mgła"}]}]}}`)
+
+			ctx := withReplay(context.Background(), newReplaySet(responses))
+			_, _, _, _, _, _, albumID, byUPC := resolveSpotifyMetricsAndAlbum(ctx, "Mgła", "Age of Excuse", barcode)
+
+			Expect(albumID).To(Equal("standard"))
+			Expect(byUPC).To(BeFalse())
+		})
+
+		It("should take a Discogs barcode hit without the year check", func() {
+			respond(discogsSearchBase+"?barcode="+barcode+"&type=release&per_page=1&token=test",
+				`{"results":[{"title":"Mgła - Age Of Excuse","year":"2023","style":["Black Metal"]}]}`)
+
+			ctx := withReplay(context.Background(), newReplaySet(responses))
+			styles, byBarcode := lookupDiscogsStyles(ctx, "Mgła", "Age of Excuse", "2019-11-29", barcode, defaultContactEmail)
+
+			Expect(styles).To(Equal([]string{"black metal"}))
+			Expect(byBarcode).To(BeTrue())
+		})
+	})
+
+	Describe("parseGenreSourceOrder", func() {
+		It("should put listed sources first and keep the rest in default order", func() {
+			Expect(parseGenreSourceOrder("discogs,spotify,metal_archives")).
+				To(Equal([]string{"discogs", "spotify", "metal_archives", "lastfm"}))
+			Expect(parseGenreSourceOrder("spotify")).
+				To(Equal([]string{"spotify", "metal_archives", "discogs", "lastfm"}))
+		})
+
+		It("should reject unknown and duplicate sources", func() {
+			_, err := parseGenreSourceOrder("rateyourmusic")
+			Expect(err).To(HaveOccurred())
+
+			_, err = parseGenreSourceOrder("discogs,discogs")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Last.fm tags", func() {
+		It("should parse album and artist tag lists", func() {
+			Expect(parseLastFmTags([]byte(`{"album":{"name":"Age of Excuse","tags":{"tag":[` +
+				`{"name":"Black Metal","url":"x"},{"name":"polish","url":"y"}]}}}`))).
+				To(Equal([]string{"Black Metal", "polish"}))
+
+			Expect(parseLastFmTags([]byte(`{"album":{"name":"Age of Excuse","tags":{"tag":{"name":"black metal"}}}}`))).
+				To(Equal([]string{"black metal"}))
+
+			Expect(parseLastFmTags([]byte(`{"toptags":{"tag":[{"name":"black metal","count":100}],"@attr":{"artist":"Mgła"}}}`))).
+				To(Equal([]string{"black metal"}))
+		})
+
+		It("should treat untagged and unknown albums as a miss", func() {
+			Expect(parseLastFmTags([]byte(`{"album":{"name":"Age of Excuse","tags":""}}`))).To(BeEmpty())
+			Expect(parseLastFmTags([]byte(`{"error":6,"message":"Album not found"}`))).To(BeEmpty())
+
+			_, err := parseLastFmTags([]byte(`{"error":10,"message":"Invalid API key"}`))
+			Expect(err).To(MatchError(ContainSubstring("Invalid API key")))
+		})
+
+		It("should keep the top tags other than the artist's name", func() {
+			tags := []string{"Black Metal", "Mgla", "polish", "black metal", "atmospheric black metal",
+				"post-black metal", "metal", "seen live"}
+
+			Expect(filterLastFmTags(tags, "Mgła")).To(Equal([]string{
+				"black metal", "polish", "atmospheric black metal", "post-black metal", "metal",
+			}))
+		})
+	})
+
+	Describe("combineGenres", func() {
+		AfterEach(func() {
+			genreSourceOrder = defaultGenreSourceOrder
+		})
+
+		It("should union genres in the configured order", func() {
+			bySource := map[string][]string{
+				"metal_archives": {"black metal"},
+				"discogs":        {"atmospheric black metal", "black metal"},
+				"spotify":        {"polish black metal"},
+			}
+
+			Expect(combineGenres(bySource)).To(Equal([]string{
+				"black metal", "atmospheric black metal", "polish black metal",
+			}))
+
+			genreSourceOrder = []string{"discogs", "spotify", "metal_archives"}
+
+			Expect(combineGenres(bySource)).To(Equal([]string{
+				"atmospheric black metal", "black metal", "polish black metal",
+			}))
+		})
+	})
+
+	Describe("Apple Music", func() {
+		It("should pick the artist's album and drop the affiliate query", func() {
+			albums, err := parseITunesAlbums([]byte(`{"resultCount":3,"results":[
+				{"artistName":"Mgła Tribute","collectionName":"Age of Excuse",
+				 "collectionViewUrl":"https://music.apple.com/us/album/age-of-excuse/1?uo=4"},
+				{"artistName":"Mgla","collectionName":"Exercises in Futility",
+				 "collectionViewUrl":"https://music.apple.com/us/album/exercises-in-futility/2?uo=4"},
+				{"artistName":"Mgla","collectionName":"Age of Excuse - EP",
+				 "collectionViewUrl":"https://music.apple.com/us/album/age-of-excuse/3?uo=4"}
+			]}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(pickAppleMusicURL(albums, "Mgła", "Age Of Excuse")).
+				To(Equal("https://music.apple.com/us/album/age-of-excuse/3"))
+			Expect(pickAppleMusicURL(albums, "Mgła", "With Hearts Toward None")).To(BeEmpty())
+		})
+	})
+
+	Describe("Deezer", func() {
+		It("should pick the artist's album with its first preview", func() {
+			tracks, err := parseDeezerTracks([]byte(`{"data":[
+				{"preview":"https://cdn.deezer.test/a.mp3","artist":{"name":"Mgła Tribute"},
+				 "album":{"id":1,"title":"Age of Excuse","cover_xl":"https://cdn.deezer.test/1.jpg"}},
+				{"preview":"","artist":{"name":"Mgla"},
+				 "album":{"id":2,"title":"Age of Excuse","cover_big":"https://cdn.deezer.test/2.jpg"}},
+				{"preview":"https://cdn.deezer.test/b.mp3","artist":{"name":"Mgla"},
+				 "album":{"id":2,"title":"Age of Excuse","cover_big":"https://cdn.deezer.test/2.jpg"}}
+			]}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(pickDeezerAlbum(tracks, "Mgła", "Age Of Excuse")).To(Equal(&deezerAlbum{
+				URL:        "https://www.deezer.com/album/2",
+				CoverURL:   "https://cdn.deezer.test/2.jpg",
+				PreviewURL: "https://cdn.deezer.test/b.mp3",
+			}))
+
+			Expect(pickDeezerAlbum(tracks, "Mgła", "Exercises in Futility")).To(BeNil())
+		})
+
+		It("should report errors sent with a 200", func() {
+			_, err := parseDeezerTracks([]byte(`{"error":{"type":"Exception","message":"Quota limit exceeded","code":4}}`))
+			Expect(err).To(MatchError(ContainSubstring("Quota limit exceeded")))
+		})
+	})
+
+	Describe("countryFixes", func() {
+		It("should map names and lowercase codes to ISO codes", func() {
+			fixes, unknown := countryFixes([]gensql.ListCountriesRow{
+				{Code: "PL", ReleaseCount: 10},
+				{Code: "Poland", ReleaseCount: 3},
+				{Code: "no", ReleaseCount: 2},
+				{Code: "UK", ReleaseCount: 1},
+				{Code: " ", ReleaseCount: 1},
+				{Code: "XW", ReleaseCount: 4},
+				{Code: "Atlantis", ReleaseCount: 1},
+			})
+
+			Expect(fixes).To(Equal([]countryFix{
+				{From: "Poland", To: "PL", Releases: 3},
+				{From: "no", To: "NO", Releases: 2},
+				{From: "UK", To: "GB", Releases: 1},
+				{From: " ", Releases: 1},
+				{From: "XW", Releases: 4},
+			}))
+			Expect(unknown).To(Equal([]gensql.ListCountriesRow{{Code: "Atlantis", ReleaseCount: 1}}))
+		})
+	})
+
+	Describe("missingFields", func() {
+		release := gensql.Release{
+			AlbumArtUrl: "https://via.placeholder.com/300",
+			Genres:      json.RawMessage(`["black metal"]`),
+			SpotifyUrl:  sql.NullString{String: "https://open.spotify.com/album/x", Valid: true},
+		}
+
+		It("should only report empty fields", func() {
+			Expect(missingFields(release, enrichableFields)).To(Equal([]string{
+				"country", "art", "youtube_url", "apple_music_url", "label_url",
+			}))
+		})
+
+		It("should only consider the requested fields", func() {
+			Expect(missingFields(release, []string{"country", "genres"})).
+				To(Equal([]string{"country"}))
+		})
+	})
+
+	Describe("parseFields", func() {
+		It("should default to all enrichable fields", func() {
+			Expect(parseFields("")).To(Equal(enrichableFields))
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := parseFields("country,bogus")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("discogsTokenPool", func() {
+		It("should parse comma-separated tokens", func() {
+			Expect(parseDiscogsTokens(" a, b,,c ")).To(Equal([]string{"a", "b", "c"}))
+		})
+
+		It("should rotate across tokens", func() {
+			pool := newDiscogsTokenPool([]string{"a", "b"})
+			now := time.Now()
+
+			t1, _ := pool.acquire(now)
+			t2, _ := pool.acquire(now)
+			t3, _ := pool.acquire(now)
+
+			Expect([]string{t1.value, t2.value, t3.value}).To(Equal([]string{"a", "b", "a"}))
+		})
+
+		It("should skip throttled tokens", func() {
+			pool := newDiscogsTokenPool([]string{"a", "b"})
+			pool.cooldown(pool.tokens[0], time.Minute)
+
+			for i := 0; i < 3; i++ {
+				t, wait := pool.acquire(time.Now())
+				Expect(t.value).To(Equal("b"))
+				Expect(wait).To(BeZero())
+			}
+		})
+
+		It("should wait for the first token to recover when all are throttled", func() {
+			pool := newDiscogsTokenPool([]string{"a", "b"})
+			pool.cooldown(pool.tokens[0], time.Minute)
+			pool.cooldown(pool.tokens[1], time.Second)
+
+			t, wait := pool.acquire(time.Now())
+			Expect(t.value).To(Equal("b"))
+			Expect(wait).To(BeNumerically(">", 0))
+			Expect(wait).To(BeNumerically("<=", time.Second))
+		})
+	})
+
+	Describe("concurrencyTransport", func() {
+		BeforeEach(func() {
+			semaphoresMu.Lock()
+			semaphores["limited.test"] = newHostSemaphore(2)
+			semaphoresMu.Unlock()
+		})
+
+		It("should never have more than the limit in flight", func() {
+			base := &countingTransport{}
+			t := &concurrencyTransport{base: base}
+
+			var wg sync.WaitGroup
+
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+
+					req, _ := http.NewRequest(http.MethodGet, "https://limited.test/", nil)
+					resp, err := t.RoundTrip(req)
+					Expect(err).ToNot(HaveOccurred())
+
+					// The slot is held while the body is being read
+					time.Sleep(5 * time.Millisecond)
+					resp.Body.Close()
+					resp.Body.Close()
+				}()
+			}
+
+			wg.Wait()
+
+			Expect(base.calls).To(Equal(10))
+			Expect(base.maxOpen).To(Equal(2))
+		})
+
+		It("should give up waiting when the request is cancelled", func() {
+			t := &concurrencyTransport{base: &countingTransport{}}
+
+			held, _ := http.NewRequest(http.MethodGet, "https://limited.test/", nil)
+			for i := 0; i < 2; i++ {
+				_, err := t.RoundTrip(held)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://limited.test/", nil)
+			_, err := t.RoundTrip(req)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		It("should cap requests across hosts with -provider-concurrency", func() {
+			setProviderConcurrency(3)
+			defer setProviderConcurrency(0)
+
+			base := &countingTransport{}
+			t := &concurrencyTransport{base: base}
+
+			var wg sync.WaitGroup
+
+			for i := 0; i < 12; i++ {
+				wg.Add(1)
+
+				go func(i int) {
+					defer wg.Done()
+					defer GinkgoRecover()
+
+					hosts := []string{"limited.test", "a.test", "b.test"}
+
+					req, _ := http.NewRequest(http.MethodGet, "https://"+hosts[i%len(hosts)]+"/", nil)
+					resp, err := t.RoundTrip(req)
+					Expect(err).ToNot(HaveOccurred())
+
+					time.Sleep(5 * time.Millisecond)
+					resp.Body.Close()
+				}(i)
+			}
+
+			wg.Wait()
+
+			Expect(base.calls).To(Equal(12))
+			Expect(base.maxOpen).To(Equal(3))
+		})
+
+		It("should not limit unknown hosts", func() {
+			Expect(semaphoreFor("example.com")).To(BeNil())
+		})
+	})
+
+	Describe("rateLimitTransport", func() {
+		setLimiter := func(host string, perMinute int) {
+			limitersMu.Lock()
+			limiters[host] = newProviderLimiter(perMinute)
+			limitersMu.Unlock()
+		}
+
+		AfterEach(func() {
+			limitersMu.Lock()
+			delete(limiters, "paced.test")
+			delete(limiters, "slow.test")
+			limitersMu.Unlock()
+		})
+
+		It("should space out requests to a host across workers", func() {
+			setLimiter("paced.test", 6000)
+
+			base := &countingTransport{}
+			t := &rateLimitTransport{base: base}
+
+			start := time.Now()
+
+			var wg sync.WaitGroup
+
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+
+					req, _ := http.NewRequest(http.MethodGet, "https://paced.test/", nil)
+					resp, err := t.RoundTrip(req)
+					Expect(err).ToNot(HaveOccurred())
+					resp.Body.Close()
+				}()
+			}
+
+			wg.Wait()
+
+			// 6000/min is one request every 10ms; the first goes out at once
+			Expect(time.Since(start)).To(BeNumerically(">=", 40*time.Millisecond))
+			Expect(base.calls).To(Equal(5))
+		})
+
+		It("should give up waiting when the request is cancelled", func() {
+			setLimiter("slow.test", 1)
+
+			t := &rateLimitTransport{base: &countingTransport{}}
+
+			req, _ := http.NewRequest(http.MethodGet, "https://slow.test/", nil)
+			_, err := t.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "https://slow.test/", nil)
+			_, err = t.RoundTrip(req)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		It("should time requests out from when they are sent until their body is closed", func() {
+			t := &rateLimitTransport{base: &countingTransport{}, timeout: time.Minute}
+
+			req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+			resp, err := t.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, hasDeadline := resp.Request.Context().Deadline()
+			Expect(hasDeadline).To(BeTrue())
+			Expect(resp.Request.Context().Err()).ToNot(HaveOccurred())
+
+			resp.Body.Close()
+			Expect(resp.Request.Context().Err()).To(MatchError(context.Canceled))
+		})
+
+		It("should not limit unknown hosts", func() {
+			Expect(limiterFor("example.com")).To(BeNil())
+		})
+	})
+
+	Describe("doWithRetry", func() {
+		var (
+			orig    http.RoundTripper
+			base    *throttledTransport
+			backoff time.Duration
+		)
+
+		BeforeEach(func() {
+			orig, backoff = httpClient.Transport, retryBackoff
+			base = &throttledTransport{}
+			httpClient.Transport = base
+			retryBackoff = time.Millisecond
+		})
+
+		AfterEach(func() {
+			httpClient.Transport, retryBackoff = orig, backoff
+		})
+
+		get := func() *http.Response {
+			req, _ := http.NewRequest(http.MethodGet, "https://api.spotify.com/v1/search", nil)
+			resp, err := doWithRetry(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+
+			return resp
+		}
+
+		It("should retry 429s until the provider answers", func() {
+			base.throttled = 2
+
+			Expect(get().StatusCode).To(Equal(http.StatusOK))
+			Expect(base.calls).To(Equal(3))
+		})
+
+		It("should give up after maxRetries retries", func() {
+			base.throttled = 10
+
+			Expect(get().StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(base.calls).To(Equal(maxRetries + 1))
+		})
+
+		It("should not wait for a Retry-After beyond maxRetryWait", func() {
+			base.throttled, base.retryAfter = 10, "3600"
+
+			Expect(get().StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(base.calls).To(Equal(1))
+		})
+
+		It("should resend the request body", func() {
+			base.throttled = 1
+
+			req, _ := http.NewRequest(http.MethodPost, "https://accounts.spotify.com/api/token",
+				strings.NewReader("grant_type=client_credentials"))
+			resp, err := doWithRetry(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close()
+
+			Expect(base.bodies).To(Equal([]string{"grant_type=client_credentials", "grant_type=client_credentials"}))
+		})
+	})
+
+	Describe("releaseKey", func() {
+		It("should treat case, accent and article variants as the same release", func() {
+			Expect(releaseKey("2019-11-29", "The Ocean", "Phanerozoic II")).
+				To(Equal(releaseKey("2019-11-29", "ocean", "phanerozoic ii")))
+			Expect(releaseKey("2019-11-29", "Mgła", "Age of Excuse")).
+				To(Equal(releaseKey("2019-11-29", "MGLA", "Age Of Excuse")))
+		})
+
+		It("should keep different dates apart", func() {
+			Expect(releaseKey("2019-11-29", "Mgła", "Age of Excuse")).
+				ToNot(Equal(releaseKey("2019-11-30", "Mgła", "Age of Excuse")))
+		})
+	})
+
+	Describe("checkFieldCount", func() {
+		It("should accept complete rows", func() {
+			Expect(checkFieldCount([]string{"2019-11-29", "Mgła", "Age of Excuse", "No Solace"}, 2)).
+				To(Succeed())
+		})
+
+		It("should accept rows with a barcode", func() {
+			Expect(checkFieldCount([]string{"2019-11-29", "Mgła", "Age of Excuse", "No Solace", "0822603149523"}, 2)).
+				To(Succeed())
+		})
+
+		It("should report ragged rows with their line", func() {
+			err := checkFieldCount([]string{"2019-11-29", "Mgła"}, 7)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("line 7"))
+			Expect(err.Error()).To(ContainSubstring("got 2"))
+		})
+	})
+
+	Describe("decodeCSVInput", func() {
+		read := func(b []byte, charset string) string {
+			in, err := decodeCSVInput(bytes.NewReader(b), charset)
+			Expect(err).ToNot(HaveOccurred())
+
+			out, err := io.ReadAll(in)
+			Expect(err).ToNot(HaveOccurred())
+
+			return string(out)
+		}
+
+		It("should strip a UTF-8 byte order mark", func() {
+			Expect(read([]byte("\xEF\xBB\xBF2019-11-29,Mgła"), "utf-8")).To(Equal("2019-11-29,Mgła"))
+		})
+
+		It("should transcode Windows-1252", func() {
+			Expect(read([]byte("2019-11-29,Mot\xF6rhead"), "windows-1252")).To(Equal("2019-11-29,Motörhead"))
+		})
+
+		It("should reject unknown charsets", func() {
+			_, err := decodeCSVInput(bytes.NewReader(nil), "klingon")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("exitCode", func() {
+		It("should succeed when every row imported or some were skipped", func() {
+			Expect(exitCode(10, 0, 0)).To(Equal(exitOK))
+			Expect(exitCode(8, 2, 0)).To(Equal(exitOK))
+			Expect(exitCode(0, 0, 0)).To(Equal(exitOK))
+		})
+
+		It("should fail on any row error", func() {
+			Expect(exitCode(9, 0, 1)).To(Equal(exitRowErrors))
+			Expect(exitCode(0, 5, 1)).To(Equal(exitRowErrors))
+		})
+
+		It("should flag runs where every row was skipped", func() {
+			Expect(exitCode(0, 5, 0)).To(Equal(exitAllSkipped))
+		})
+	})
+
+	Describe("rowProcessor", func() {
+		var (
+			store *fakeStore
+			p     *rowProcessor
+			ctx   context.Context
+		)
+
+		enrich := enrichFunc(func(_ context.Context, row csvRow) *enrichedRelease {
+			return &enrichedRelease{
+				DateYMD: row.dateISO,
+				Artist:  row.artist,
+				Album:   row.album,
+				Label:   row.label,
+				Genres:  []string{"black metal"},
+				Sources: map[string]string{},
+			}
+		})
+
+		row := func(n int, artist, album string) csvRow {
+			return csvRow{rowNum: n, dateISO: "2024-03-01", artist: artist, album: album, label: "Season of Mist"}
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			store = newFakeStore()
+			p = newRowProcessor(nil, enrich, newReleaseSink(store), false, nil)
+		})
+
+		It("should insert a new release", func() {
+			res := p.process(ctx, row(1, "Mgła", "Exercises in Futility"))
+
+			Expect(res.status).To(Equal("success"))
+			Expect(res.err).ToNot(HaveOccurred())
+			Expect(store.created).To(HaveLen(1))
+			Expect(store.created[0].Artist).To(Equal("Mgła"))
+			Expect(store.created[0].Title).To(Equal("Exercises in Futility"))
+		})
+
+		It("should skip rows repeated within the CSV", func() {
+			Expect(p.process(ctx, row(1, "Mgła", "Exercises in Futility")).status).To(Equal("success"))
+
+			res := p.process(ctx, row(2, "MGLA", "Exercises In Futility"))
+
+			Expect(res.status).To(Equal("dupe_skip"))
+			Expect(store.created).To(HaveLen(1))
+		})
+
+		It("should skip releases already in the database", func() {
+			store.existing = append(store.existing, gensql.ListReleaseKeysByDateRow{
+				Artist: "Mgla",
+				Title:  "Exercises in Futility",
+			})
+
+			res := p.process(ctx, row(1, "Mgła", "Exercises in Futility"))
+
+			Expect(res.status).To(Equal("exists_skip"))
+			Expect(store.created).To(BeEmpty())
+		})
+
+		It("should insert a release only once when workers race", func() {
+			// Separate processors so the in-memory dedupe doesn't catch it
+			var wg sync.WaitGroup
+
+			results := make([]rowResult, 8)
+
+			for i := range results {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+
+					results[i] = newRowProcessor(nil, enrich, newReleaseSink(store), false, nil).
+						process(ctx, row(i+1, "Mgła", "Exercises in Futility"))
+				}()
+			}
+
+			wg.Wait()
+
+			statuses := map[string]int{}
+			for _, r := range results {
+				statuses[r.status]++
+			}
+
+			Expect(store.created).To(HaveLen(1))
+			Expect(statuses).To(Equal(map[string]int{"success": 1, "exists_skip": 7}))
+		})
+
+		It("should report insert failures as row errors", func() {
+			store.createErr = errors.New("connection reset")
+
+			res := p.process(ctx, row(1, "Mgła", "Exercises in Futility"))
+
+			Expect(res.status).To(Equal("error"))
+			Expect(res.err).To(MatchError("connection reset"))
+		})
+
+		It("should skip rows left out by the filter before enriching them", func() {
+			p = newRowProcessor(newRowFilter(time.Time{}, "", "", "season of mist", ""), enrich, newReleaseSink(store), false, nil)
+
+			Expect(p.process(ctx, row(1, "Mgła", "Exercises in Futility")).status).To(Equal("success"))
+
+			other := row(2, "Batushka", "Litourgiya")
+			other.label = "Witching Hour"
+
+			Expect(p.process(ctx, other).status).To(Equal("filtered_skip"))
+			Expect(store.created).To(HaveLen(1))
+		})
+
+		It("should skip rows released before -since-date as old_skip", func() {
+			since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			p = newRowProcessor(newRowFilter(since, "", "", "", ""), enrich, newReleaseSink(store), false, nil)
+
+			old := row(1, "Mgła", "Exercises in Futility")
+			old.dateISO = "2024-12-31"
+
+			current := row(2, "Batushka", "Litourgiya")
+			current.dateISO = "2025-01-01"
+
+			Expect(p.process(ctx, old).status).To(Equal("old_skip"))
+			Expect(p.process(ctx, current).status).To(Equal("success"))
+			Expect(store.created).To(HaveLen(1))
+		})
+
+		It("should not touch the store in dry-run mode", func() {
+			p = newRowProcessor(nil, enrich, newReleaseSink(nil), false, nil)
+
+			res := p.process(ctx, row(1, "Mgła", "Exercises in Futility"))
+
+			Expect(res.status).To(Equal("success"))
+			Expect(store.created).To(BeEmpty())
+		})
+	})
+
+	Describe("csvRowReader", func() {
+		It("should return valid rows and classify bad ones", func() {
+			r := newCSVRowReader(strings.NewReader(
+				"2024-03-01, Mgła ,Exercises in Futility,Northern Heritage\n" +
+					"2024-03-01,Mgła\n" +
+					"2024-03-01,,Age of Excuse,\n" +
+					"March 1,Mgła,Age of Excuse,\n"))
+
+			row, err := r.next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(row).To(Equal(csvRow{rowNum: 1, dateISO: "2024-03-01", artist: "Mgła",
+				album: "Exercises in Futility", label: "Northern Heritage"}))
+
+			for _, want := range []rowError{
+				{rowNum: 2, status: "csv_error"},
+				{rowNum: 3, status: "invalid_skip"},
+				{rowNum: 4, status: "invalid_skip"},
+			} {
+				_, err = r.next()
+
+				var rowErr *rowError
+				Expect(errors.As(err, &rowErr)).To(BeTrue())
+				Expect(rowErr.rowNum).To(Equal(want.rowNum))
+				Expect(rowErr.status).To(Equal(want.status))
+			}
+
+			_, err = r.next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should read an optional barcode column", func() {
+			r := newCSVRowReader(strings.NewReader(
+				"2019-11-29,Mgła,Age of Excuse,No Solace,0 822603 149523\n" +
+					"2019-11-29,Mgła,Age of Excuse,No Solace,n/a\n"))
+
+			row, err := r.next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(row.barcode).To(Equal("0822603149523"))
+
+			row, err = r.next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(row.barcode).To(BeEmpty())
+		})
+	})
+
+	Describe("jsonRowReader", func() {
+		expectRowError := func(err error, rowNum int, status string) {
+			var rowErr *rowError
+			Expect(errors.As(err, &rowErr)).To(BeTrue())
+			Expect(rowErr.rowNum).To(Equal(rowNum))
+			Expect(rowErr.status).To(Equal(status))
+		}
+
+		It("should validate rows the same way as CSV", func() {
+			r := newJSONRowReader(strings.NewReader(`[
+				{"date":"2024-03-01","artist":" Mgła ","album":"Exercises in Futility","label":"Northern Heritage"},
+				{"date":"2024-03-01","artist":"Mgła","album":7},
+				{"date":"2024-03-01","album":"Age of Excuse"},
+				{"date":"March 1","artist":"Mgła","album":"Age of Excuse"},
+				{"date":"2019-11-29","artist":"Mgła","album":"Age of Excuse, Pt. I","barcode":"0 822603 149523"}
+			]`))
+
+			row, err := r.next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(row).To(Equal(csvRow{rowNum: 1, dateISO: "2024-03-01", artist: "Mgła",
+				album: "Exercises in Futility", label: "Northern Heritage"}))
+
+			_, err = r.next()
+			expectRowError(err, 2, "csv_error")
+
+			_, err = r.next()
+			expectRowError(err, 3, "invalid_skip")
+
+			_, err = r.next()
+			expectRowError(err, 4, "invalid_skip")
+
+			row, err = r.next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(row.album).To(Equal("Age of Excuse, Pt. I"))
+			Expect(row.barcode).To(Equal("0822603149523"))
+
+			_, err = r.next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should stop at malformed JSON", func() {
+			r := newJSONRowReader(strings.NewReader(
+				`[{"date":"2024-03-01","artist":"Mgła","album":"Age of Excuse"}, {"date": oops}]`))
+
+			_, err := r.next()
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = r.next()
+			expectRowError(err, 2, "csv_error")
+
+			_, err = r.next()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should reject input that isn't an array", func() {
+			r := newJSONRowReader(strings.NewReader(`{"date":"2024-03-01"}`))
+
+			_, err := r.next()
+			expectRowError(err, 0, "csv_error")
+
+			_, err = r.next()
+			Expect(err).To(Equal(io.EOF))
+		})
+	})
+
+	Describe("inputReader", func() {
+		It("should accept csv and json", func() {
+			for _, format := range []string{"csv", "JSON"} {
+				_, err := inputReader(format)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			_, err := inputReader("xml")
+			Expect(err).To(MatchError(`invalid -format "xml" (must be csv or json)`))
+		})
+	})
+
+	Describe("lookupCache", func() {
+		var (
+			dir  string
+			path string
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "lookup-cache")
+			Expect(err).ToNot(HaveOccurred())
+
+			path = dir + "/cache.json"
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		fetchCountry := func(calls *int, country string) func() string {
+			return func() string {
+				*calls++
+				return country
+			}
+		}
+
+		It("should serve hits from disk on the next run, keyed by normalized artist", func() {
+			c, err := openLookupCache(path, time.Hour, false)
+			Expect(err).ToNot(HaveOccurred())
+
+			calls := 0
+			Expect(c.country("musicbrainz_country", "Mgła", fetchCountry(&calls, "PL"))).To(Equal("PL"))
+			Expect(c.genres("metal_archives_genres", "Mgła", func() []string {
+				return []string{"Black Metal"}
+			})).To(Equal([]string{"Black Metal"}))
+			Expect(c.save()).To(Succeed())
+
+			c, err = openLookupCache(path, time.Hour, false)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(c.country("musicbrainz_country", "MGLA", fetchCountry(&calls, "XX"))).To(Equal("PL"))
+			Expect(c.genres("metal_archives_genres", "mgla", func() []string {
+				calls++
+				return nil
+			})).To(Equal([]string{"Black Metal"}))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should not cache misses", func() {
+			c, err := openLookupCache(path, time.Hour, false)
+			Expect(err).ToNot(HaveOccurred())
+
+			calls := 0
+			c.country("musicbrainz_country", "Unknown Band", fetchCountry(&calls, ""))
+			c.country("musicbrainz_country", "Unknown Band", fetchCountry(&calls, ""))
+
+			Expect(calls).To(Equal(2))
+		})
+
+		It("should fetch again once entries expire", func() {
+			os.WriteFile(path, []byte(`{"musicbrainz_country:mgla":{"country":"PL","fetched_at":"2020-01-01T00:00:00Z"}}`), 0644)
+
+			c, err := openLookupCache(path, time.Hour, false)
+			Expect(err).ToNot(HaveOccurred())
+
+			calls := 0
+			Expect(c.country("musicbrainz_country", "Mgła", fetchCountry(&calls, "DE"))).To(Equal("DE"))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should ignore cached entries with refresh but store fresh results", func() {
+			c, err := openLookupCache(path, time.Hour, false)
+			Expect(err).ToNot(HaveOccurred())
+
+			calls := 0
+			c.country("musicbrainz_country", "Mgła", fetchCountry(&calls, "PL"))
+
+			c.refresh = true
+			Expect(c.country("musicbrainz_country", "Mgła", fetchCountry(&calls, "DE"))).To(Equal("DE"))
+
+			c.refresh = false
+			Expect(c.country("musicbrainz_country", "Mgła", fetchCountry(&calls, "XX"))).To(Equal("DE"))
+			Expect(calls).To(Equal(2))
+		})
+
+		It("should always fetch without a cache", func() {
+			var c *lookupCache
+
+			calls := 0
+			c.country("musicbrainz_country", "Mgła", fetchCountry(&calls, "PL"))
+			c.country("musicbrainz_country", "Mgła", fetchCountry(&calls, "PL"))
+
+			Expect(calls).To(Equal(2))
+			Expect(c.save()).To(Succeed())
+		})
+	})
+
+	Describe("rowFilter", func() {
+		row := func(artist, label string) csvRow {
+			return csvRow{artist: artist, label: label}
+		}
+
+		It("should keep every row without lists", func() {
+			f := newRowFilter(time.Time{}, "", " , ", "", "")
+
+			Expect(f).To(BeNil())
+			Expect(f.keep(row("Mgła", ""))).To(BeTrue())
+		})
+
+		It("should keep only listed artists, ignoring case and accents", func() {
+			f := newRowFilter(time.Time{}, "Mgla, The Ruins of Beverast", "", "", "")
+
+			Expect(f.keep(row("MGŁA", "Northern Heritage"))).To(BeTrue())
+			Expect(f.keep(row("Ruins of Beverast", "Van"))).To(BeTrue())
+			Expect(f.keep(row("Batushka", "Witching Hour"))).To(BeFalse())
+		})
+
+		It("should let skip lists win over only lists", func() {
+			f := newRowFilter(time.Time{}, "", "Batushka", "Season of Mist", "")
+
+			Expect(f.keep(row("Gojira", "Season of Mist"))).To(BeTrue())
+			Expect(f.keep(row("Batushka", "Season of Mist"))).To(BeFalse())
+			Expect(f.keep(row("Mgła", ""))).To(BeFalse())
+		})
+
+		It("should skip listed labels", func() {
+			f := newRowFilter(time.Time{}, "", "", "", "Nuclear Blast")
+
+			Expect(f.keep(row("Blind Guardian", "Nuclear Blast"))).To(BeFalse())
+			Expect(f.keep(row("Mgła", "Northern Heritage"))).To(BeTrue())
+		})
+	})
+
+	Describe("rowDeduper", func() {
+		It("should only let the first of equivalent rows through", func() {
+			d := newRowDeduper()
+
+			Expect(d.firstSeen(csvRow{dateISO: "2024-03-01", artist: "Mgła", album: "Age of Excuse"})).To(BeTrue())
+			Expect(d.firstSeen(csvRow{dateISO: "2024-03-01", artist: "mgla", album: "AGE OF EXCUSE"})).To(BeFalse())
+			Expect(d.firstSeen(csvRow{dateISO: "2024-03-02", artist: "Mgła", album: "Age of Excuse"})).To(BeTrue())
+		})
+	})
+
+	Describe("storeSink", func() {
+		It("should write the audit against the inserted release", func() {
+			store := newFakeStore()
+			audit := &auditLog{}
+			audit.add(auditEntry{provider: "discogs", lookup: "genres", statusCode: 200})
+
+			res := storeSink{store: store}.persist(context.Background(),
+				csvRow{rowNum: 1, dateISO: "2024-03-01", artist: "Mgła", album: "Age of Excuse"},
+				&enrichedRelease{DateYMD: "2024-03-01", Artist: "Mgła", Album: "Age of Excuse"},
+				audit)
+
+			Expect(res.status).To(Equal("success"))
+			Expect(store.created).To(HaveLen(1))
+			Expect(store.audits).To(HaveLen(1))
+			Expect(store.audits[0].ReleaseID.UUID).To(Equal(store.created[0].ID))
+		})
+	})
+
+	Describe("upsertReleaseFromEnriched", func() {
+		It("should store follower counts beyond the int32 range", func() {
+			store := newFakeStore()
+
+			_, err := upsertReleaseFromEnriched(context.Background(), store, &enrichedRelease{
+				DateYMD:          "2024-03-01",
+				Artist:           "Mgła",
+				Album:            "Age of Excuse",
+				SpotifyFollowers: 5_000_000_000,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.created).To(HaveLen(1))
+			Expect(store.created[0].FollowerCount).To(Equal(int64(5_000_000_000)))
+		})
+
+		It("should store negative follower counts as 0", func() {
+			store := newFakeStore()
+
+			_, err := upsertReleaseFromEnriched(context.Background(), store, &enrichedRelease{
+				DateYMD:          "2024-03-01",
+				Artist:           "Mgła",
+				Album:            "Age of Excuse",
+				SpotifyFollowers: -1,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.created[0].FollowerCount).To(BeZero())
+		})
+	})
+
+	Describe("clearDeadLinks", func() {
+		It("should clear only the dead links", func() {
+			r := gensql.Release{
+				SpotifyUrl:    sql.NullString{String: "https://open.spotify.com/album/gone", Valid: true},
+				YoutubeUrl:    sql.NullString{String: "https://www.youtube.com/watch?v=ok", Valid: true},
+				ExternalLinks: []byte(`{"spotify":"https://open.spotify.com/album/gone","discogs":"https://www.discogs.com/label/1"}`),
+			}
+
+			params := clearDeadLinks(r, []linkcheck.Result{
+				{Name: "spotify", URL: "https://open.spotify.com/album/gone", Status: linkcheck.StatusDead},
+			})
+
+			Expect(params.SpotifyUrl.Valid).To(BeFalse())
+			Expect(params.YoutubeUrl).To(Equal(r.YoutubeUrl))
+			Expect(params.ExternalLinks).To(MatchJSON(`{"discogs":"https://www.discogs.com/label/1"}`))
+		})
+	})
+})
+
+// fakeStore is an in-memory importStore
+type fakeStore struct {
+	mu        sync.Mutex
+	existing  []gensql.ListReleaseKeysByDateRow
+	created   []gensql.UpsertReleaseParams
+	audits    []gensql.CreateEnrichmentAuditParams
+	createErr error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{}
+}
+
+// UpsertRelease inserts like the unique index does: on lower(artist),
+// lower(title) and the release date
+func (f *fakeStore) UpsertRelease(_ context.Context, arg gensql.UpsertReleaseParams) (gensql.UpsertReleaseRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.createErr != nil {
+		return gensql.UpsertReleaseRow{}, f.createErr
+	}
+
+	for _, c := range f.created {
+		if strings.EqualFold(c.Artist, arg.Artist) && strings.EqualFold(c.Title, arg.Title) &&
+			c.ReleaseDate.Equal(arg.ReleaseDate) {
+			return gensql.UpsertReleaseRow{ID: c.ID, Title: c.Title, Artist: c.Artist}, nil
+		}
+	}
+
+	f.created = append(f.created, arg)
+
+	return gensql.UpsertReleaseRow{ID: arg.ID, Title: arg.Title, Artist: arg.Artist, Inserted: true}, nil
+}
+
+func (f *fakeStore) ListReleaseKeysByDate(_ context.Context, _ time.Time) ([]gensql.ListReleaseKeysByDateRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.existing, nil
+}
+
+func (f *fakeStore) CreateEnrichmentAudit(_ context.Context, arg gensql.CreateEnrichmentAuditParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.audits = append(f.audits, arg)
+
+	return nil
+}
+
+// fakeTransport answers with a fixed status per host; other hosts fail as
+// if the connection was refused
+type fakeTransport map[string]int
+
+func (f fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	code, ok := f[req.URL.Hostname()]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+
+	return &http.Response{
+		StatusCode: code,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// bodyTransport answers 200 with a fixed JSON body per host
+type bodyTransport map[string]string
+
+func (b bodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(b[req.URL.Hostname()])),
+		Request:    req,
+	}, nil
+}
+
+// countingTransport tracks how many of its responses have open bodies
+type countingTransport struct {
+	mu      sync.Mutex
+	calls   int
+	open    int
+	maxOpen int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls++
+	c.open++
+
+	if c.open > c.maxOpen {
+		c.maxOpen = c.open
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body: releaseOnClose(io.NopCloser(strings.NewReader("")), func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			c.open--
+		}),
+		Request: req,
+	}, nil
+}
+
+// throttledTransport answers its first throttled requests with a 429
+type throttledTransport struct {
+	throttled  int
+	retryAfter string
+	calls      int
+	bodies     []string
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		t.bodies = append(t.bodies, string(b))
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+
+	if t.calls <= t.throttled {
+		resp.StatusCode = http.StatusTooManyRequests
+		resp.Header.Set("Retry-After", t.retryAfter)
+	}
+
+	return resp, nil
+}
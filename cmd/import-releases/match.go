@@ -0,0 +1,171 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// minAlbumSimilarity is how close (0-1) a Spotify album title must be to the
+// CSV title to be accepted
+const minAlbumSimilarity = 0.85
+
+var (
+	// editionSuffixRe matches trailing "(Legacy Edition)", "[Remastered]" or
+	// " - 2019 Remaster" style decorations
+	editionSuffixRe = regexp.MustCompile(`\s*(\([^)]*\)|\[[^\]]*\]|\s-\s.*)$`)
+)
+
+// spotifyAlbum is an album item from a Spotify album search
+type spotifyAlbum struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	ExternalURLs map[string]string `json:"external_urls"`
+	Artists      []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	Images []struct {
+		URL string `json:"url"`
+	} `json:"images"`
+}
+
+// albumKey normalizes an album title for comparison, dropping edition and
+// remaster decorations
+func albumKey(title string) string {
+	title = strings.TrimSpace(title)
+
+	for {
+		stripped := editionSuffixRe.ReplaceAllString(title, "")
+		if stripped == title || stripped == "" {
+			break
+		}
+		title = stripped
+	}
+
+	return norm(title)
+}
+
+// albumSimilarity scores two album titles from 0 (unrelated) to 1 (same
+// title once normalized)
+func albumSimilarity(a, b string) float64 {
+	ka, kb := albumKey(a), albumKey(b)
+
+	if ka == "" || kb == "" {
+		return 0
+	}
+
+	if ka == kb {
+		return 1
+	}
+
+	ra, rb := []rune(ka), []rune(kb)
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+// pickSpotifyAlbum returns the item by artist whose title is most similar to
+// album, or nil when none reaches minAlbumSimilarity
+func pickSpotifyAlbum(items []spotifyAlbum, artist, album string) *spotifyAlbum {
+	var (
+		best      *spotifyAlbum
+		bestScore float64
+	)
+
+	for i := range items {
+		if !hasSpotifyArtist(items[i], artist) {
+			continue
+		}
+
+		score := albumSimilarity(items[i].Name, album)
+		if score >= minAlbumSimilarity && score > bestScore {
+			best, bestScore = &items[i], score
+		}
+	}
+
+	return best
+}
+
+func hasSpotifyArtist(item spotifyAlbum, artist string) bool {
+	for _, a := range item.Artists {
+		if norm(a.Name) == norm(artist) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeBarcode strips spaces and dashes from a UPC/EAN barcode and
+// returns "" unless 8 to 14 digits are left
+func normalizeBarcode(s string) string {
+	s = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(s))
+
+	if len(s) < 8 || len(s) > 14 {
+		return ""
+	}
+
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return ""
+		}
+	}
+
+	return s
+}
+
+// barcodeVariants returns the forms a barcode may be catalogued under: as
+// given, plus the 12-digit UPC of a 13-digit EAN with a leading zero (and
+// the other way round)
+func barcodeVariants(code string) []string {
+	switch {
+	case len(code) == 13 && code[0] == '0':
+		return []string{code, code[1:]}
+	case len(code) == 12:
+		return []string{code, "0" + code}
+	}
+
+	return []string{code}
+}
+
+// pickSpotifyAlbumByUPC returns the first UPC search result by artist. The
+// title isn't compared: a barcode names one exact edition, whatever it is
+// called. The artist check guards against a mistyped barcode.
+func pickSpotifyAlbumByUPC(items []spotifyAlbum, artist string) *spotifyAlbum {
+	for i := range items {
+		if hasSpotifyArtist(items[i], artist) {
+			return &items[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+// A CSV import runs each row through five stages:
+//
+//	read (rowReader) -> filter (rowFilter) -> dedupe (rowDeduper) -> enrich (rowEnricher) -> persist (releaseSink)
+//
+// Rows left out by the -only-*/-skip-* flags and rows repeated within the
+// CSV are dropped before enrichment so they don't cost provider calls; rows
+// already in the database are dropped by the persist stage. rowProcessor
+// wires the last four together for the workers.
+
+// importStore is the subset of *db.DB the CSV import uses, so the import
+// flow can be exercised without Postgres
+type importStore interface {
+	UpsertRelease(ctx context.Context, arg gensql.UpsertReleaseParams) (gensql.UpsertReleaseRow, error)
+	ListReleaseKeysByDate(ctx context.Context, releaseDate time.Time) ([]gensql.ListReleaseKeysByDateRow, error)
+	CreateEnrichmentAudit(ctx context.Context, arg gensql.CreateEnrichmentAuditParams) error
+}
+
+type csvRow struct {
+	rowNum  int
+	dateISO string
+	artist  string
+	album   string
+	label   string
+
+	// barcode is the optional UPC/EAN column, normalized by
+	// normalizeBarcode; "" when missing or invalid
+	barcode string
+}
+
+type rowResult struct {
+	rowNum int
+	err    error
+	status string
+}
+
+// rowError is a row a rowReader could not turn into a csvRow; status is
+// "csv_error" (counted as an error) or "invalid_skip" (counted as a skip)
+type rowError struct {
+	rowNum int
+	status string
+	err    error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// rowReader is the read stage: it parses and validates input rows. next
+// returns the next valid row, a *rowError for a row that can't be imported,
+// or io.EOF.
+type rowReader interface {
+	next() (csvRow, error)
+}
+
+// csvRowReader reads rows of a -format csv input
+type csvRowReader struct {
+	r      *csv.Reader
+	rowNum int
+}
+
+func newCSVRowReader(in io.Reader) *csvRowReader {
+	r := csv.NewReader(in)
+	// Field counts are checked per row so ragged rows can be reported with
+	// their line number
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	return &csvRowReader{r: r}
+}
+
+// next returns the next valid row, a *rowError for a row that can't be
+// imported, or io.EOF
+func (c *csvRowReader) next() (csvRow, error) {
+	rec, err := c.r.Read()
+	if err == io.EOF {
+		return csvRow{}, io.EOF
+	}
+
+	if err != nil {
+		return csvRow{}, &rowError{rowNum: c.rowNum + 1, status: "csv_error", err: err}
+	}
+
+	c.rowNum++
+
+	line, _ := c.r.FieldPos(0)
+	if err := checkFieldCount(rec, line); err != nil {
+		return csvRow{}, &rowError{rowNum: c.rowNum, status: "csv_error", err: err}
+	}
+
+	var barcode string
+	if len(rec) == csvMaxFieldCount {
+		barcode = rec[4]
+	}
+
+	return validateRow(c.rowNum, rec[0], rec[1], rec[2], rec[3], barcode)
+}
+
+// jsonRow is an element of a -format json input, the JSON form of a CSV row
+type jsonRow struct {
+	Date    string `json:"date"`
+	Artist  string `json:"artist"`
+	Album   string `json:"album"`
+	Label   string `json:"label"`
+	Barcode string `json:"barcode"`
+}
+
+// jsonRowReader reads rows of a -format json input: an array of jsonRow
+// objects, decoded one at a time so large inputs aren't loaded at once
+type jsonRowReader struct {
+	dec    *json.Decoder
+	rowNum int
+
+	// err ends the input: io.EOF, or a syntax error after which the rest
+	// of the array can't be read
+	err     error
+	started bool
+}
+
+func newJSONRowReader(in io.Reader) *jsonRowReader {
+	return &jsonRowReader{dec: json.NewDecoder(in)}
+}
+
+func (j *jsonRowReader) next() (csvRow, error) {
+	if j.err != nil {
+		return csvRow{}, j.err
+	}
+
+	if !j.started {
+		j.started = true
+
+		if tok, err := j.dec.Token(); err != nil || tok != json.Delim('[') {
+			return csvRow{}, j.fail(errors.New("input is not a JSON array"))
+		}
+	}
+
+	if !j.dec.More() {
+		j.err = io.EOF
+		return csvRow{}, io.EOF
+	}
+
+	j.rowNum++
+
+	var obj jsonRow
+	if err := j.dec.Decode(&obj); err != nil {
+		var typeErr *json.UnmarshalTypeError
+
+		// A value of the wrong type is skipped whole; anything else leaves
+		// the decoder mid-value
+		if errors.As(err, &typeErr) {
+			return csvRow{}, &rowError{rowNum: j.rowNum, status: "csv_error",
+				err: errors.Wrap(err, "invalid row")}
+		}
+
+		return csvRow{}, j.fail(err)
+	}
+
+	return validateRow(j.rowNum, obj.Date, obj.Artist, obj.Album, obj.Label, obj.Barcode)
+}
+
+// fail reports err for the current row and ends the input
+func (j *jsonRowReader) fail(err error) error {
+	j.err = io.EOF
+
+	return &rowError{rowNum: j.rowNum, status: "csv_error",
+		err: errors.Wrap(err, "invalid JSON, no further rows can be read")}
+}
+
+// validateRow builds the csvRow of an input row, however it was read, and
+// checks its required fields and date
+func validateRow(rowNum int, date, artist, album, label, barcode string) (csvRow, error) {
+	row := csvRow{
+		rowNum:  rowNum,
+		dateISO: strings.TrimSpace(date),
+		artist:  strings.TrimSpace(artist),
+		album:   strings.TrimSpace(album),
+		label:   strings.TrimSpace(label),
+	}
+
+	if raw := strings.TrimSpace(barcode); raw != "" {
+		row.barcode = normalizeBarcode(raw)
+
+		if row.barcode == "" {
+			logrus.Warnf("row %d: ignoring invalid barcode %q", row.rowNum, raw)
+		}
+	}
+
+	logrus.Infof("Processing row %d: %s | %s | %s", row.rowNum, row.dateISO, row.artist, row.album)
+
+	if row.dateISO == "" || row.artist == "" || row.album == "" {
+		return csvRow{}, &rowError{rowNum: row.rowNum, status: "invalid_skip",
+			err: errors.New("missing required fields")}
+	}
+
+	if _, err := time.Parse("2006-01-02", row.dateISO); err != nil {
+		return csvRow{}, &rowError{rowNum: row.rowNum, status: "invalid_skip",
+			err: errors.Wrapf(err, "bad date %q", row.dateISO)}
+	}
+
+	return row, nil
+}
+
+// rowFilter is the filter stage: it drops rows released before since and
+// keeps only the rows whose artist/label is in an only list (when given) and
+// not in a skip list. Names are compared with norm, so case, accents and a
+// leading "The" don't matter.
+type rowFilter struct {
+	since       time.Time
+	onlyArtists map[string]bool
+	skipArtists map[string]bool
+	onlyLabels  map[string]bool
+	skipLabels  map[string]bool
+}
+
+// newRowFilter builds a filter from a cutoff date (zero for none) and
+// comma-separated name lists; it returns nil when there is nothing to filter
+func newRowFilter(since time.Time, onlyArtists, skipArtists, onlyLabels, skipLabels string) *rowFilter {
+	f := &rowFilter{
+		since:       since,
+		onlyArtists: parseNameList(onlyArtists),
+		skipArtists: parseNameList(skipArtists),
+		onlyLabels:  parseNameList(onlyLabels),
+		skipLabels:  parseNameList(skipLabels),
+	}
+
+	if since.IsZero() && len(f.onlyArtists)+len(f.skipArtists)+len(f.onlyLabels)+len(f.skipLabels) == 0 {
+		return nil
+	}
+
+	return f
+}
+
+func parseNameList(v string) map[string]bool {
+	names := map[string]bool{}
+
+	for _, name := range strings.Split(v, ",") {
+		if n := norm(name); n != "" {
+			names[n] = true
+		}
+	}
+
+	return names
+}
+
+// tooOld reports whether row was released before the filter's cutoff date.
+// Rows reaching the filter have a valid date.
+func (f *rowFilter) tooOld(row csvRow) bool {
+	if f == nil || f.since.IsZero() {
+		return false
+	}
+
+	date, err := time.Parse("2006-01-02", row.dateISO)
+
+	return err == nil && date.Before(f.since)
+}
+
+// keep reports whether row passes the name lists; a nil filter keeps every
+// row
+func (f *rowFilter) keep(row csvRow) bool {
+	if f == nil {
+		return true
+	}
+
+	return matchesNameList(norm(row.artist), f.onlyArtists, f.skipArtists) &&
+		matchesNameList(norm(row.label), f.onlyLabels, f.skipLabels)
+}
+
+func matchesNameList(name string, only, skip map[string]bool) bool {
+	if len(only) > 0 && !only[name] {
+		return false
+	}
+
+	return !skip[name]
+}
+
+// rowDeduper is the dedupe stage: it drops rows already seen in this import
+type rowDeduper struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newRowDeduper() *rowDeduper {
+	return &rowDeduper{seen: make(map[string]bool)}
+}
+
+// firstSeen reports whether row is new to this import, recording it
+func (d *rowDeduper) firstSeen(row csvRow) bool {
+	key := releaseKey(row.dateISO, row.artist, row.album)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.seen[key] {
+		return false
+	}
+
+	d.seen[key] = true
+
+	return true
+}
+
+// rowEnricher is the enrich stage
+type rowEnricher interface {
+	enrich(ctx context.Context, row csvRow) *enrichedRelease
+}
+
+// enrichFunc adapts a function to rowEnricher
+type enrichFunc func(ctx context.Context, row csvRow) *enrichedRelease
+
+func (f enrichFunc) enrich(ctx context.Context, row csvRow) *enrichedRelease {
+	return f(ctx, row)
+}
+
+// providerEnricher enriches rows from the metadata providers
+type providerEnricher struct {
+	contact string
+}
+
+func (e providerEnricher) enrich(ctx context.Context, row csvRow) *enrichedRelease {
+	return enrichRelease(ctx, row.dateISO, row.artist, row.album, row.label, row.barcode, e.contact)
+}
+
+// releaseSink is the persist stage; audit holds the row's provider calls
+// when -audit is on and is nil otherwise
+type releaseSink interface {
+	persist(ctx context.Context, row csvRow, enriched *enrichedRelease, audit *auditLog) rowResult
+}
+
+// dryRunSink logs what would be inserted
+type dryRunSink struct{}
+
+func (dryRunSink) persist(_ context.Context, row csvRow, enriched *enrichedRelease,
+	_ *auditLog) rowResult {
+	b, _ := json.MarshalIndent(enriched, "", "  ")
+	logrus.Infof("DRY RUN - would insert release:\n%s", string(b))
+
+	return rowResult{rowNum: row.rowNum, status: "success"}
+}
+
+// storeSink inserts releases that aren't already stored. The upsert makes
+// the insert safe against other workers (or imports) storing the same
+// release between the releaseExists check and the write.
+type storeSink struct {
+	store importStore
+}
+
+func (s storeSink) persist(ctx context.Context, row csvRow, enriched *enrichedRelease,
+	audit *auditLog) rowResult {
+	artist, album := row.artist, row.album
+
+	releaseDate, err := time.Parse("2006-01-02", row.dateISO)
+	if err != nil {
+		logrus.Errorf("row %d failed to parse date: %v", row.rowNum, err)
+		return rowResult{rowNum: row.rowNum, err: err, status: "error"}
+	}
+
+	exists, err := releaseExists(ctx, s.store, artist, album, releaseDate)
+	if err != nil {
+		logrus.Errorf("row %d failed to check for existing release: %v",
+			row.rowNum, err)
+		return rowResult{rowNum: row.rowNum, err: err, status: "error"}
+	}
+
+	if exists {
+		logrus.Warnf("row %d: release already exists - %s: %s (date: %s), skipping",
+			row.rowNum, artist, album, row.dateISO)
+		writeAudit(ctx, s.store, audit, uuid.Nil, artist, album)
+		return rowResult{rowNum: row.rowNum, status: "exists_skip"}
+	}
+
+	release, err := upsertReleaseFromEnriched(ctx, s.store, enriched)
+	if err != nil {
+		logrus.Errorf("row %d failed to insert: %v", row.rowNum, err)
+		writeAudit(ctx, s.store, audit, uuid.Nil, artist, album)
+		return rowResult{rowNum: row.rowNum, err: err, status: "error"}
+	}
+
+	if !release.Inserted {
+		logrus.Warnf("row %d: release already exists - %s: %s (date: %s), filled in missing fields only",
+			row.rowNum, artist, album, row.dateISO)
+		writeAudit(ctx, s.store, audit, uuid.Nil, artist, album)
+		return rowResult{rowNum: row.rowNum, status: "exists_skip"}
+	}
+
+	writeAudit(ctx, s.store, audit, release.ID, artist, album)
+
+	logrus.Infof("row %d: inserted release %s - %s: %s",
+		row.rowNum, release.ID, release.Artist, release.Title)
+
+	return rowResult{rowNum: row.rowNum, status: "success"}
+}
+
+// newReleaseSink returns a storeSink, or a dryRunSink when store is nil
+func newReleaseSink(store importStore) releaseSink {
+	if store == nil {
+		return dryRunSink{}
+	}
+
+	return storeSink{store: store}
+}
+
+// rowProcessor takes rows from the read stage through filter, dedupe,
+// enrich and persist. It is shared by all workers.
+type rowProcessor struct {
+	filter   *rowFilter
+	dedupe   *rowDeduper
+	enricher rowEnricher
+	sink     releaseSink
+	audit    bool
+	summary  *summaryCollector
+}
+
+// newRowProcessor returns a processor; filter may be nil to keep every row
+func newRowProcessor(filter *rowFilter, enricher rowEnricher, sink releaseSink, audit bool,
+	summary *summaryCollector) *rowProcessor {
+	return &rowProcessor{
+		filter:   filter,
+		dedupe:   newRowDeduper(),
+		enricher: enricher,
+		sink:     sink,
+		audit:    audit,
+		summary:  summary,
+	}
+}
+
+func (p *rowProcessor) process(ctx context.Context, row csvRow) rowResult {
+	if ctx.Err() != nil {
+		return rowResult{rowNum: row.rowNum, err: ctx.Err(), status: "cancelled"}
+	}
+
+	if p.filter.tooOld(row) {
+		logrus.Debugf("row %d released before -since-date: %s | %s | %s",
+			row.rowNum, row.dateISO, row.artist, row.album)
+		return rowResult{rowNum: row.rowNum, status: "old_skip"}
+	}
+
+	if !p.filter.keep(row) {
+		logrus.Debugf("row %d filtered out: %s | %s", row.rowNum, row.artist, row.label)
+		return rowResult{rowNum: row.rowNum, status: "filtered_skip"}
+	}
+
+	if !p.dedupe.firstSeen(row) {
+		logrus.Warnf("DUPE DETECTED! %d: %s | %s | %s",
+			row.rowNum, row.dateISO, row.artist, row.album)
+		return rowResult{rowNum: row.rowNum, status: "dupe_skip"}
+	}
+
+	rowCtx := ctx
+
+	var audit *auditLog
+	if p.audit {
+		rowCtx, audit = withAudit(ctx)
+	}
+
+	logrus.Infof("Enriching release: %s - %s", row.artist, row.album)
+	enriched := p.enricher.enrich(rowCtx, row)
+	logrus.Infof("Enrichment complete - genres: %v, country: %s, sources: %v",
+		enriched.Genres, enriched.Country, enriched.Sources)
+
+	for _, e := range enriched.ProviderErrors {
+		logrus.Warnf("row %d: %s %s lookup failed: %s",
+			row.rowNum, e.Provider, e.Lookup, e.Error)
+	}
+
+	if p.summary != nil {
+		p.summary.recordSources(enriched.Sources)
+		p.summary.recordProviderErrors(enriched.ProviderErrors)
+	}
+
+	return p.sink.persist(ctx, row, enriched, audit)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type providerErrorsKey struct{}
+
+// providerError is a provider call that failed while enriching a row: a
+// transport error or an error status. A 404 is a miss, not a failure.
+type providerError struct {
+	Provider string `json:"provider"`
+	Lookup   string `json:"lookup"`
+	Error    string `json:"error"`
+}
+
+// providerErrorLog collects the failed provider calls of one row; it is
+// attached to the row's context with withProviderErrors
+type providerErrorLog struct {
+	mu   sync.Mutex
+	errs []providerError
+}
+
+func (l *providerErrorLog) add(e providerError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errs = append(l.errs, e)
+}
+
+func (l *providerErrorLog) list() []providerError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]providerError(nil), l.errs...)
+}
+
+func withProviderErrors(ctx context.Context) (context.Context, *providerErrorLog) {
+	l := &providerErrorLog{}
+	return context.WithValue(ctx, providerErrorsKey{}, l), l
+}
+
+// recordProviderCall records a call made with ctx that failed: a transport
+// error or a 4xx/5xx status other than 404. Discogs 429s are retried with
+// another token, so discogsGet records those itself once it gives up.
+func recordProviderCall(ctx context.Context, req *http.Request, resp *http.Response, err error) {
+	provider := providerForHost(req.URL.Hostname())
+
+	switch {
+	case err != nil:
+	case resp.StatusCode < 400, resp.StatusCode == http.StatusNotFound:
+		return
+	case resp.StatusCode == http.StatusTooManyRequests && provider == "discogs":
+		return
+	default:
+		err = errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	recordProviderError(ctx, provider, err)
+}
+
+// recordProviderError adds err to the providerErrorLog of ctx's row, if any,
+// under ctx's lookup
+func recordProviderError(ctx context.Context, provider string, err error) {
+	l, _ := ctx.Value(providerErrorsKey{}).(*providerErrorLog)
+	if l == nil || err == nil {
+		return
+	}
+
+	lookup, _ := ctx.Value(lookupKey{}).(string)
+	if lookup == "" {
+		lookup = "unknown"
+	}
+
+	l.add(providerError{Provider: provider, Lookup: lookup, Error: err.Error()})
+}
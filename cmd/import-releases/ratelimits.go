@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// maxWorkers is the hard cap on -workers; beyond this every provider
+	// starts throttling us regardless of configured rates
+	maxWorkers = 32
+
+	// estimatedRequestLatency is a rough per-request latency used to
+	// estimate how quickly a single worker issues provider requests
+	estimatedRequestLatency = 500 * time.Millisecond
+
+	// requestTimeout bounds a provider request, including reading its body,
+	// from when its rate limit lets it go out
+	requestTimeout = 20 * time.Second
+
+	// maxRetries is how many times doWithRetry retries a 429 response
+	maxRetries = 3
+
+	// maxRetryWait is the longest Retry-After doWithRetry waits for; a
+	// provider asking for longer has throttled us for good this run
+	maxRetryWait = time.Minute
+)
+
+// providerLimit describes how hard a provider host may be hit
+type providerLimit struct {
+	Name      string
+	Host      string
+	EnvVar    string
+	PerMinute int
+
+	// CallsPerRow is the upper bound of requests made to this host while
+	// enriching a single row
+	CallsPerRow int
+
+	// MaxConcurrent caps requests in flight to this host, overridable via
+	// ConcurrencyEnvVar; a request holds its slot until its body is closed
+	MaxConcurrent     int
+	ConcurrencyEnvVar string
+}
+
+// providerLimits are the default per-host request budgets; each can be
+// overridden via its env vars (requests per minute, requests in flight)
+var providerLimits = []*providerLimit{
+	{Name: "spotify", Host: "api.spotify.com", EnvVar: "SPOTIFY_RATE_PER_MIN", PerMinute: 180, CallsPerRow: 3,
+		MaxConcurrent: 8, ConcurrencyEnvVar: "SPOTIFY_MAX_CONCURRENT"},
+	{Name: "youtube", Host: "www.googleapis.com", EnvVar: "YOUTUBE_RATE_PER_MIN", PerMinute: 100, CallsPerRow: 1,
+		MaxConcurrent: 4, ConcurrencyEnvVar: "YOUTUBE_MAX_CONCURRENT"},
+	{Name: "metal_archives", Host: "www.metal-archives.com", EnvVar: "METAL_ARCHIVES_RATE_PER_MIN", PerMinute: 30, CallsPerRow: 5,
+		MaxConcurrent: 2, ConcurrencyEnvVar: "METAL_ARCHIVES_MAX_CONCURRENT"},
+	{Name: "discogs", Host: "api.discogs.com", EnvVar: "DISCOGS_RATE_PER_MIN", PerMinute: 60, CallsPerRow: 6,
+		MaxConcurrent: 2, ConcurrencyEnvVar: "DISCOGS_MAX_CONCURRENT"},
+	{Name: "musicbrainz", Host: "musicbrainz.org", EnvVar: "MUSICBRAINZ_RATE_PER_MIN", PerMinute: 60, CallsPerRow: 2,
+		MaxConcurrent: 1, ConcurrencyEnvVar: "MUSICBRAINZ_MAX_CONCURRENT"},
+	{Name: "lastfm", Host: "ws.audioscrobbler.com", EnvVar: "LASTFM_RATE_PER_MIN", PerMinute: 240, CallsPerRow: 2,
+		MaxConcurrent: 4, ConcurrencyEnvVar: "LASTFM_MAX_CONCURRENT"},
+	{Name: "deezer", Host: "api.deezer.com", EnvVar: "DEEZER_RATE_PER_MIN", PerMinute: 300, CallsPerRow: 1,
+		MaxConcurrent: 4, ConcurrencyEnvVar: "DEEZER_MAX_CONCURRENT"},
+	{Name: "itunes", Host: "itunes.apple.com", EnvVar: "ITUNES_RATE_PER_MIN", PerMinute: 20, CallsPerRow: 1,
+		MaxConcurrent: 2, ConcurrencyEnvVar: "ITUNES_MAX_CONCURRENT"},
+	{Name: "bandcamp", Host: "bandcamp.com", EnvVar: "BANDCAMP_RATE_PER_MIN", PerMinute: 20, CallsPerRow: 1,
+		MaxConcurrent: 2, ConcurrencyEnvVar: "BANDCAMP_MAX_CONCURRENT"},
+}
+
+// budget is the provider's total requests per minute; Discogs budgets are
+// per token, so they scale with the number of DISCOGS_TOKENs
+func (l *providerLimit) budget() int {
+	if l.Name == "discogs" {
+		if n := len(discogsTokens().tokens); n > 1 {
+			return l.PerMinute * n
+		}
+	}
+
+	return l.PerMinute
+}
+
+// concurrency is how many requests may be in flight to the host; like
+// budget, Discogs' MaxConcurrent is per token
+func (l *providerLimit) concurrency() int {
+	if l.Name == "discogs" {
+		if n := len(discogsTokens().tokens); n > 1 {
+			return l.MaxConcurrent * n
+		}
+	}
+
+	return l.MaxConcurrent
+}
+
+// loadProviderLimits applies env var overrides to the default limits
+func loadProviderLimits() {
+	for _, l := range providerLimits {
+		if n, ok := positiveEnv(l.EnvVar); ok {
+			l.PerMinute = n
+		}
+
+		if n, ok := positiveEnv(l.ConcurrencyEnvVar); ok {
+			l.MaxConcurrent = n
+		}
+	}
+}
+
+// positiveEnv reads a positive integer env var, warning about invalid values
+func positiveEnv(name string) (int, bool) {
+	v := strings.TrimSpace(getenv(name, ""))
+	if v == "" {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		logrus.Warnf("ignoring invalid %s=%q (must be a positive integer)", name, v)
+		return 0, false
+	}
+
+	return n, true
+}
+
+// newProviderLimiter returns a limiter that spaces requests out evenly so
+// they never exceed perMinute, without bursts
+func newProviderLimiter(perMinute int) *rate.Limiter {
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), 1)
+}
+
+var (
+	// limiters holds one limiter per provider host, shared by every worker
+	limiters   = map[string]*rate.Limiter{}
+	limitersMu sync.Mutex
+)
+
+// limiterFor returns the limiter of a provider host, or nil for hosts
+// without a rate limit
+func limiterFor(host string) *rate.Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	if l, ok := limiters[host]; ok {
+		return l
+	}
+
+	for _, l := range providerLimits {
+		if l.Host == host && l.PerMinute > 0 {
+			limiter := newProviderLimiter(l.budget())
+			limiters[host] = limiter
+
+			return limiter
+		}
+	}
+
+	return nil
+}
+
+// rateLimitTransport sends every request through doRateLimited, so the
+// requests of all workers together stay within each provider's PerMinute
+// budget (MusicBrainz's 1/s, Discogs' 60/min, ...). A non-zero timeout then
+// bounds the request until its body is closed.
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.doRateLimited(req.URL.Hostname(), req)
+}
+
+// doRateLimited waits for host's limiter, if it has one, and sends req
+func (t *rateLimitTransport) doRateLimited(host string, req *http.Request) (*http.Response, error) {
+	if l := limiterFor(host); l != nil {
+		if err := l.Wait(req.Context()); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+
+			return nil, err
+		}
+	}
+
+	if t.timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = releaseOnClose(resp.Body, cancel)
+
+	return resp, nil
+}
+
+// retryBackoff is the first wait before retrying a 429 without a
+// Retry-After header; it doubles with every retry
+var retryBackoff = time.Second
+
+// doWithRetry sends req with httpClient and, while the provider answers
+// 429, waits for its Retry-After (or an exponential backoff) and retries up
+// to maxRetries times. The last 429 is returned like any other response.
+func doWithRetry(req *http.Request) (*http.Response, error) {
+	backoff := retryBackoff
+
+	for attempt := 1; ; attempt++ {
+		resp, err := httpClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt > maxRetries {
+			return resp, err
+		}
+
+		wait := retryAfter(resp, backoff)
+		if wait > maxRetryWait {
+			logrus.Warnf("%s returned 429 with Retry-After %s, not retrying", req.URL.Host, wait)
+			return resp, nil
+		}
+
+		resp.Body.Close()
+
+		logrus.Warnf("%s returned 429, retrying in %s (%d/%d)", req.URL.Host, wait, attempt, maxRetries)
+
+		if err := sleepCtx(req.Context(), wait); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, errors.Wrap(err, "failed to rewind request body")
+			}
+		}
+
+		backoff *= 2
+	}
+}
+
+// hostSemaphore caps the number of requests in flight
+type hostSemaphore chan struct{}
+
+func newHostSemaphore(n int) hostSemaphore {
+	return make(hostSemaphore, n)
+}
+
+// acquire blocks until a slot is free or ctx is done
+func (s hostSemaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s hostSemaphore) release() {
+	<-s
+}
+
+var (
+	semaphores   = map[string]hostSemaphore{}
+	semaphoresMu sync.Mutex
+
+	// providerCallSemaphore caps the requests in flight across all hosts
+	// (-provider-concurrency); nil when only the per-host caps apply
+	providerCallSemaphore hostSemaphore
+)
+
+// setProviderConcurrency caps the requests in flight to all providers
+// combined at n, independently of the number of workers; 0 removes the cap
+func setProviderConcurrency(n int) {
+	if n <= 0 {
+		providerCallSemaphore = nil
+		return
+	}
+
+	providerCallSemaphore = newHostSemaphore(n)
+}
+
+// semaphoreFor returns the semaphore of a provider host, or nil for hosts
+// without a concurrency limit
+func semaphoreFor(host string) hostSemaphore {
+	semaphoresMu.Lock()
+	defer semaphoresMu.Unlock()
+
+	if s, ok := semaphores[host]; ok {
+		return s
+	}
+
+	for _, l := range providerLimits {
+		if l.Host == host && l.MaxConcurrent > 0 {
+			s := newHostSemaphore(l.concurrency())
+			semaphores[host] = s
+
+			return s
+		}
+	}
+
+	return nil
+}
+
+// concurrencyTransport holds a slot of the host's semaphore, and of the
+// providerCallSemaphore when there is one, for every request, from sending
+// it until its response body is closed. It is separate from the rate limits:
+// those space requests out, this caps the ones in flight however many
+// workers there are.
+type concurrencyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *concurrencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var held []hostSemaphore
+
+	release := func() {
+		for _, s := range held {
+			s.release()
+		}
+	}
+
+	// The host slot is taken first so a request waiting on its host doesn't
+	// hold one of the shared slots other providers could use
+	for _, sem := range []hostSemaphore{semaphoreFor(req.URL.Hostname()), providerCallSemaphore} {
+		if sem == nil {
+			continue
+		}
+
+		if err := sem.acquire(req.Context()); err != nil {
+			release()
+
+			if req.Body != nil {
+				req.Body.Close()
+			}
+
+			return nil, err
+		}
+
+		held = append(held, sem)
+	}
+
+	if len(held) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	resp.Body = releaseOnClose(resp.Body, release)
+
+	return resp, nil
+}
+
+// releasingBody calls release once, when the body is first closed
+type releasingBody struct {
+	io.ReadCloser
+
+	once    sync.Once
+	release func()
+}
+
+func releaseOnClose(body io.ReadCloser, release func()) io.ReadCloser {
+	return &releasingBody{ReadCloser: body, release: release}
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+
+	return err
+}
+
+// parseWorkers parses the -workers flag: either a positive integer (capped
+// at maxWorkers) or "auto"
+func parseWorkers(v string) (int, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+
+	if v == "auto" {
+		return autoWorkers(), nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.Errorf("invalid -workers value %q (must be a number or 'auto')", v)
+	}
+
+	if n < 1 {
+		n = 1
+	}
+
+	if n > maxWorkers {
+		logrus.Warnf("-workers %d exceeds max of %d, capping", n, maxWorkers)
+		n = maxWorkers
+	}
+
+	return n, nil
+}
+
+// autoWorkers sizes the worker pool so that the combined request rate of all
+// workers does not exceed the budget of the most constrained provider.
+//
+// A worker processes rows sequentially and spends roughly
+// totalCallsPerRow*latency per row, so it issues CallsPerRow requests to a
+// given host every row. Solving workers*CallsPerRow/rowDuration <= rate for
+// each host and taking the minimum gives the pool size.
+func autoWorkers() int {
+	totalCalls := 0
+	for _, l := range providerLimits {
+		totalCalls += l.CallsPerRow
+	}
+
+	rowDuration := float64(totalCalls) * estimatedRequestLatency.Seconds()
+	workers := maxWorkers
+	slowest := ""
+
+	for _, l := range providerLimits {
+		if l.CallsPerRow == 0 {
+			continue
+		}
+
+		perSec := float64(l.budget()) / 60
+		allowed := int(math.Floor(perSec * rowDuration / float64(l.CallsPerRow)))
+
+		if allowed < workers {
+			workers = allowed
+			slowest = l.Name
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	logrus.Infof("-workers auto: using %d worker(s) (constrained by %s)",
+		workers, slowest)
+
+	return workers
+}
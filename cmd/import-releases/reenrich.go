@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+	sr "github.com/dselans/blastbeat-api/services/release"
+)
+
+// Fields that -only-missing-fields can fill
+const (
+	fieldCountry    = "country"
+	fieldGenres     = "genres"
+	fieldArt        = "art"
+	fieldSpotifyURL = "spotify_url"
+	fieldYoutubeURL = "youtube_url"
+	fieldAppleMusic = "apple_music_url"
+	fieldLabelURL   = "label_url"
+)
+
+var enrichableFields = []string{
+	fieldCountry, fieldGenres, fieldArt, fieldSpotifyURL, fieldYoutubeURL, fieldAppleMusic, fieldLabelURL,
+}
+
+// parseFields parses the -fields flag; an empty value means all enrichable
+// fields
+func parseFields(v string) ([]string, error) {
+	var fields []string
+
+	for _, f := range strings.Split(v, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || slices.Contains(fields, f) {
+			continue
+		}
+
+		if !slices.Contains(enrichableFields, f) {
+			return nil, errors.Errorf("invalid -fields value %q (must be one of %s)",
+				f, strings.Join(enrichableFields, ", "))
+		}
+
+		fields = append(fields, f)
+	}
+
+	if len(fields) == 0 {
+		return enrichableFields, nil
+	}
+
+	return fields, nil
+}
+
+// missingFields returns which of fields are empty on r
+func missingFields(r gensql.Release, fields []string) []string {
+	var missing []string
+
+	for _, f := range fields {
+		var empty bool
+
+		switch f {
+		case fieldCountry:
+			empty = strings.TrimSpace(r.Country.String) == ""
+		case fieldGenres:
+			var genres []string
+			_ = json.Unmarshal(r.Genres, &genres)
+			empty = len(genres) == 0
+		case fieldArt:
+			empty = r.AlbumArtUrl == "" || strings.HasPrefix(r.AlbumArtUrl, "https://via.placeholder.com/")
+		case fieldSpotifyURL:
+			empty = r.SpotifyUrl.String == ""
+		case fieldYoutubeURL:
+			empty = r.YoutubeUrl.String == ""
+		case fieldAppleMusic:
+			empty = r.AppleMusicUrl.String == ""
+		case fieldLabelURL:
+			empty = r.LabelUrl.String == ""
+		}
+
+		if empty {
+			missing = append(missing, f)
+		}
+	}
+
+	return missing
+}
+
+// runMissingFieldsEnrichment re-enriches existing releases, only running
+// the provider lookups needed for fields that are currently empty (and
+// listed in fields). Rows are only updated with -enable-write.
+func runMissingFieldsEnrichment(limit int, fields []string) error {
+	if !enableWrite {
+		logrus.Info("DRY RUN MODE - no database writes will occur")
+	}
+
+	dbBackend, err := openDB(0)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to database")
+	}
+	defer dbBackend.GetDB().Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	releases, err := dbBackend.ListReleasesWithMissingFields(ctx, int32(limit))
+	if err != nil {
+		return errors.Wrap(err, "failed to list releases with missing fields")
+	}
+
+	logrus.Infof("Missing-fields enrichment start (releases=%d, fields=%s, enable-write=%v)",
+		len(releases), strings.Join(fields, ","), enableWrite)
+
+	contact := getenv("CONTACT_EMAIL", defaultContactEmail)
+
+	var checked, filled, updated int
+
+	for _, r := range releases {
+		if ctx.Err() != nil {
+			logrus.Warnf("Missing-fields enrichment interrupted: %v", ctx.Err())
+			break
+		}
+
+		missing := missingFields(r, fields)
+		if len(missing) == 0 {
+			continue
+		}
+
+		checked++
+
+		params, got := fillMissingFields(ctx, r, missing, contact)
+		if len(got) == 0 {
+			logrus.Infof("nothing found for %s - %s (missing: %s)",
+				r.Artist, r.Title, strings.Join(missing, ","))
+			continue
+		}
+
+		filled++
+		logrus.Infof("filled %s for %s - %s", strings.Join(got, ","), r.Artist, r.Title)
+
+		if !enableWrite {
+			continue
+		}
+
+		if _, err := dbBackend.UpdateRelease(ctx, params); err != nil {
+			logrus.Errorf("failed to update %s: %v", r.ID, err)
+			continue
+		}
+
+		updated++
+	}
+
+	logrus.Infof("Missing-fields enrichment done. Checked: %d, Filled: %d, Updated: %d",
+		checked, filled, updated)
+
+	return nil
+}
+
+// fillMissingFields runs the lookups for missing and returns the release
+// with whatever was found filled in, plus the fields that were filled
+func fillMissingFields(ctx context.Context, r gensql.Release, missing []string,
+	contact string) (gensql.UpdateReleaseParams, []string) {
+	params := updateParamsFor(r)
+
+	links := map[string]string{}
+	_ = json.Unmarshal(r.ExternalLinks, &links)
+
+	dateISO := r.ReleaseDate.Format("2006-01-02")
+	needs := func(f string) bool { return slices.Contains(missing, f) }
+
+	var (
+		got      []string
+		spGenres []string
+	)
+
+	if needs(fieldArt) || needs(fieldSpotifyURL) {
+		_, _, _, albURL, cover, genres, _, _ :=
+			resolveSpotifyMetricsAndAlbum(withLookup(ctx, "spotify_artist_album"), r.Artist, r.Title, "")
+		spGenres = normalizeList(genres)
+
+		if needs(fieldArt) && cover != "" {
+			params.AlbumArtUrl = cover
+			got = append(got, fieldArt)
+		}
+
+		if needs(fieldSpotifyURL) && albURL != "" {
+			params.SpotifyUrl = sql.NullString{String: albURL, Valid: true}
+			links["spotify"] = albURL
+			got = append(got, fieldSpotifyURL)
+		}
+	}
+
+	if needs(fieldYoutubeURL) {
+		if yt := findYouTubePreview(withLookup(ctx, "youtube_preview"), r.Artist, r.Title); yt != "" {
+			params.YoutubeUrl = sql.NullString{String: yt, Valid: true}
+			links["youtube"] = yt
+			got = append(got, fieldYoutubeURL)
+		}
+	}
+
+	if needs(fieldAppleMusic) {
+		if am := findAppleMusicURL(withLookup(ctx, "apple_music_url"), r.Artist, r.Title); am != "" {
+			params.AppleMusicUrl = sql.NullString{String: am, Valid: true}
+			links["apple_music"] = am
+			got = append(got, fieldAppleMusic)
+		}
+	}
+
+	if needs(fieldGenres) {
+		dc, _ := lookupDiscogsStyles(withLookup(ctx, "genres"), r.Artist, r.Title, dateISO, "", contact)
+
+		// Spotify genres are only used when Spotify was already queried for
+		// another field
+		genres := combineGenres(map[string][]string{
+			genreSourceMetalArchives: lookupMetalArchivesBandGenres(withLookup(ctx, "genres"), r.Artist, contact),
+			genreSourceDiscogs:       dc,
+			genreSourceLastFm:        lookupLastFmTags(withLookup(ctx, "genres"), r.Artist, r.Title),
+			genreSourceSpotify:       spGenres,
+		})
+
+		if len(genres) > 0 {
+			if b, err := json.Marshal(genres); err == nil {
+				params.Genres = b
+				got = append(got, fieldGenres)
+			}
+		}
+	}
+
+	if needs(fieldCountry) {
+		if country := lookupCountry(withLookup(ctx, "country"), r.Artist, contact); sr.ValidCountryCode(country) {
+			params.Country = sql.NullString{String: strings.ToUpper(country), Valid: true}
+			got = append(got, fieldCountry)
+		}
+	}
+
+	if needs(fieldLabelURL) {
+		discogsLink, website, _ :=
+			resolveLabelInfo(withLookup(ctx, "label"), r.Artist, r.Title, dateISO, "", r.Label, contact)
+
+		if normalized := normalizeURL(website); normalized != "" {
+			params.LabelUrl = sql.NullString{String: normalized, Valid: true}
+			got = append(got, fieldLabelURL)
+		}
+
+		if discogsLink != "" && links["discogs"] == "" {
+			links["discogs"] = discogsLink
+		}
+	}
+
+	if b, err := json.Marshal(links); err == nil {
+		params.ExternalLinks = b
+	}
+
+	return params, got
+}
+
+// updateParamsFor returns UpdateRelease params that leave r unchanged
+func updateParamsFor(r gensql.Release) gensql.UpdateReleaseParams {
+	return gensql.UpdateReleaseParams{
+		ID:            r.ID,
+		Title:         r.Title,
+		Artist:        r.Artist,
+		AlbumArtUrl:   r.AlbumArtUrl,
+		ReleaseDate:   r.ReleaseDate,
+		Label:         r.Label,
+		LabelUrl:      r.LabelUrl,
+		FollowerCount: r.FollowerCount,
+		Genres:        r.Genres,
+		Country:       r.Country,
+		ExternalLinks: r.ExternalLinks,
+		SpotifyUrl:    r.SpotifyUrl,
+		YoutubeUrl:    r.YoutubeUrl,
+		BandcampUrl:   r.BandcampUrl,
+		AppleMusicUrl: r.AppleMusicUrl,
+	}
+}
+
+// lookupCountry tries Metal Archives, then MusicBrainz, then Discogs, the
+// same order as a full enrichment
+func lookupCountry(ctx context.Context, artist, contact string) string {
+	if country := lookupCountryFromMetalArchives(ctx, artist); country != "" {
+		return country
+	}
+
+	if country := lookupCountryFromMusicBrainz(ctx, artist, contact); country != "" {
+		return country
+	}
+
+	return lookupCountryFromDiscogsArtist(ctx, artist, contact)
+}
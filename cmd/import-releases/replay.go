@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// replayTokenBody answers Spotify token requests that weren't dumped
+// because the token was cached at the time
+const replayTokenBody = `{"access_token":"REDACTED","token_type":"Bearer","expires_in":3600}`
+
+type replayKey struct{}
+
+// replaySet holds one row's dumped responses by request. Repeated requests
+// get the responses in the order they were dumped; once those run out the
+// last one is repeated.
+type replaySet struct {
+	mu        sync.Mutex
+	responses map[string][]dumpedResponse
+}
+
+func newReplaySet(responses []dumpedResponse) *replaySet {
+	s := &replaySet{responses: map[string][]dumpedResponse{}}
+
+	for _, r := range responses {
+		k := r.Method + " " + r.URL
+		s.responses[k] = append(s.responses[k], r)
+	}
+
+	return s
+}
+
+func (s *replaySet) next(method, u string) (dumpedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := method + " " + u
+
+	queue := s.responses[k]
+	if len(queue) == 0 {
+		return dumpedResponse{}, false
+	}
+
+	if len(queue) > 1 {
+		s.responses[k] = queue[1:]
+	}
+
+	return queue[0], true
+}
+
+func withReplay(ctx context.Context, s *replaySet) context.Context {
+	return context.WithValue(ctx, replayKey{}, s)
+}
+
+// replayTransport answers requests from the replaySet of their context
+// instead of the network. Requests are matched on method and redacted URL,
+// the way dumpTransport recorded them.
+type replayTransport struct{}
+
+func (replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := redactURL(req.URL)
+
+	s, _ := req.Context().Value(replayKey{}).(*replaySet)
+	if s == nil {
+		return nil, errors.Errorf("no dumped responses to replay %s %s", req.Method, u)
+	}
+
+	r, ok := s.next(req.Method, u)
+	if !ok {
+		if req.URL.String() == spotifyTokenURL {
+			r = dumpedResponse{StatusCode: http.StatusOK, ContentType: "application/json", Body: replayTokenBody}
+		} else {
+			return nil, errors.Errorf("no dumped response for %s %s", req.Method, u)
+		}
+	}
+
+	// A transport error was dumped without a status
+	if r.StatusCode == 0 {
+		return nil, errors.New(r.Error)
+	}
+
+	header := http.Header{}
+	if r.ContentType != "" {
+		header.Set("Content-Type", r.ContentType)
+	}
+
+	return &http.Response{
+		StatusCode: r.StatusCode,
+		Status:     http.StatusText(r.StatusCode),
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(r.Body)),
+		Request:    req,
+	}, nil
+}
+
+// readResponseDump reads a -dump-responses file along with the row it was
+// dumped for
+func readResponseDump(path string) (csvRow, []dumpedResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return csvRow{}, nil, errors.Wrap(err, "failed to open dump file")
+	}
+	defer f.Close()
+
+	var responses []dumpedResponse
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+
+	for sc.Scan() {
+		var r dumpedResponse
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return csvRow{}, nil, errors.Wrapf(err, "invalid line %d", len(responses)+1)
+		}
+
+		responses = append(responses, r)
+	}
+
+	if err := sc.Err(); err != nil {
+		return csvRow{}, nil, errors.Wrap(err, "failed to read dump file")
+	}
+
+	if len(responses) == 0 {
+		return csvRow{}, nil, errors.New("dump file has no responses")
+	}
+
+	first := responses[0]
+
+	return csvRow{
+		rowNum:  first.Row,
+		dateISO: first.Date,
+		artist:  first.Artist,
+		album:   first.Album,
+		label:   first.Label,
+		barcode: first.Barcode,
+	}, responses, nil
+}
+
+// replayRow enriches the row dumped to path again, answering its provider
+// calls from the dump. httpClient must be using a replayTransport.
+func replayRow(ctx context.Context, path, contact string) (csvRow, *enrichedRelease, error) {
+	row, responses, err := readResponseDump(path)
+	if err != nil {
+		return csvRow{}, nil, err
+	}
+
+	ctx = withReplay(ctx, newReplaySet(responses))
+
+	return row, providerEnricher{contact: contact}.enrich(ctx, row), nil
+}
+
+// replayResult is a line of -replay output
+type replayResult struct {
+	Row int `json:"row"`
+	*enrichedRelease
+}
+
+// runReplay re-runs the enrichment of every row dumped to path (a
+// -dump-responses directory or a single row's file) without touching the
+// network, and writes the results to stdout as JSON lines
+func runReplay(path string) error {
+	files, err := replayFiles(path)
+	if err != nil {
+		return err
+	}
+
+	prepareReplay(files)
+
+	contact := getenv("CONTACT_EMAIL", defaultContactEmail)
+	enc := json.NewEncoder(os.Stdout)
+
+	for _, file := range files {
+		row, enriched, err := replayRow(context.Background(), file, contact)
+		if err != nil {
+			logrus.Warnf("skipping %s: %v", file, err)
+			continue
+		}
+
+		for _, e := range enriched.ProviderErrors {
+			logrus.Warnf("row %d: %s %s lookup failed: %s", row.rowNum, e.Provider, e.Lookup, e.Error)
+		}
+
+		if err := enc.Encode(replayResult{Row: row.rowNum, enrichedRelease: enriched}); err != nil {
+			return errors.Wrap(err, "failed to write replay result")
+		}
+	}
+
+	logrus.Infof("Replayed %d dump files from %s", len(files), path)
+
+	return nil
+}
+
+// prepareReplay points httpClient at the dumps and configures providers the
+// way the dumped run had them: YouTube, Discogs and Last.fm get a
+// placeholder key exactly when the dumps have calls to them (Spotify always,
+// since an import can't run without it). Rate limits are lifted as nothing
+// goes over the network.
+func prepareReplay(files []string) {
+	called := map[string]bool{}
+
+	for _, file := range files {
+		_, responses, _ := readResponseDump(file)
+		for _, r := range responses {
+			called[r.Provider] = true
+		}
+	}
+
+	for env, on := range map[string]bool{
+		"SPOTIFY_CLIENT_ID":     true,
+		"SPOTIFY_CLIENT_SECRET": true,
+		"YOUTUBE_API_KEY":       called["youtube"],
+		"DISCOGS_TOKEN":         called["discogs"],
+		"LASTFM_API_KEY":        called["lastfm"],
+	} {
+		if on {
+			os.Setenv(env, "replay")
+		} else {
+			os.Unsetenv(env)
+		}
+	}
+
+	for _, l := range providerLimits {
+		l.PerMinute, l.MaxConcurrent = 0, 0
+	}
+
+	httpClient.Transport = &auditTransport{base: replayTransport{}}
+}
+
+// replayFiles returns the dump files under path in row order
+func replayFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read -replay path")
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "row-*.jsonl"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list dump files")
+	}
+
+	if len(files) == 0 {
+		return nil, errors.Errorf("no dump files in %s", path)
+	}
+
+	rowNum := func(file string) int {
+		var n int
+		_, _ = fmt.Sscanf(filepath.Base(file), "row-%d.jsonl", &n)
+		return n
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return rowNum(files[i]) < rowNum(files[j])
+	})
+
+	return files, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxErrorSamples limits how many row errors are kept in the summary
+const maxErrorSamples = 20
+
+type importSummary struct {
+	Input               string                  `json:"input"`
+	DryRun              bool                    `json:"dry_run"`
+	Workers             int                     `json:"workers"`
+	StartedAt           time.Time               `json:"started_at"`
+	FinishedAt          time.Time               `json:"finished_at"`
+	DurationSeconds     float64                 `json:"duration_seconds"`
+	Totals              summaryTotals           `json:"totals"`
+	StatusCounts        map[string]int64        `json:"status_counts"`
+	Enriched            int64                   `json:"enriched"`
+	ProviderHits        map[string]providerHits `json:"provider_hits"`
+	ProviderUnavailable map[string]int64        `json:"provider_unavailable"`
+	ProviderErrors      map[string]int64        `json:"provider_errors"`
+	PartialRows         int64                   `json:"partial_rows"`
+	ErrorSamples        []errorSample           `json:"error_samples"`
+}
+
+type summaryTotals struct {
+	Processed int64 `json:"processed"`
+	Success   int64 `json:"success"`
+	Skipped   int64 `json:"skipped"`
+	Errors    int64 `json:"errors"`
+}
+
+type providerHits struct {
+	Hits int64   `json:"hits"`
+	Rate float64 `json:"rate"`
+}
+
+type errorSample struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// summaryCollector accumulates per-row outcomes; safe for concurrent use
+type summaryCollector struct {
+	mu      sync.Mutex
+	summary *importSummary
+}
+
+func newSummaryCollector(input string, dryRun bool, workers int) *summaryCollector {
+	return &summaryCollector{
+		summary: &importSummary{
+			Input:               input,
+			DryRun:              dryRun,
+			Workers:             workers,
+			StartedAt:           time.Now().UTC(),
+			StatusCounts:        map[string]int64{},
+			ProviderHits:        map[string]providerHits{},
+			ProviderUnavailable: map[string]int64{},
+			ProviderErrors:      map[string]int64{},
+			ErrorSamples:        []errorSample{},
+		},
+	}
+}
+
+func (c *summaryCollector) recordStatus(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summary.StatusCounts[status]++
+}
+
+func (c *summaryCollector) recordError(row int, err error) {
+	if err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.summary.ErrorSamples) < maxErrorSamples {
+		c.summary.ErrorSamples = append(c.summary.ErrorSamples,
+			errorSample{Row: row, Error: err.Error()})
+	}
+}
+
+// recordSources tallies which providers contributed data to an enriched row
+// and which were unavailable for it
+func (c *summaryCollector) recordSources(sources map[string]string) {
+	if sources == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summary.Enriched++
+
+	for source, v := range sources {
+		if v == sourceUnavailable {
+			c.summary.ProviderUnavailable[source]++
+			continue
+		}
+
+		hits := c.summary.ProviderHits[source]
+		hits.Hits++
+		c.summary.ProviderHits[source] = hits
+	}
+}
+
+// recordProviderErrors tallies the failed provider calls of an enriched row
+func (c *summaryCollector) recordProviderErrors(errs []providerError) {
+	if len(errs) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summary.PartialRows++
+
+	for _, e := range errs {
+		c.summary.ProviderErrors[e.Provider]++
+	}
+}
+
+// finish computes totals, durations and hit rates
+func (c *summaryCollector) finish(totals summaryTotals) *importSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summary.FinishedAt = time.Now().UTC()
+	c.summary.DurationSeconds = c.summary.FinishedAt.Sub(c.summary.StartedAt).Seconds()
+	c.summary.Totals = totals
+
+	for source, hits := range c.summary.ProviderHits {
+		if c.summary.Enriched > 0 {
+			hits.Rate = float64(hits.Hits) / float64(c.summary.Enriched)
+		}
+
+		c.summary.ProviderHits[source] = hits
+	}
+
+	sort.Slice(c.summary.ErrorSamples, func(i, j int) bool {
+		return c.summary.ErrorSamples[i].Row < c.summary.ErrorSamples[j].Row
+	})
+
+	return c.summary
+}
+
+func writeSummary(path string, summary *importSummary) error {
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal summary")
+	}
+
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write summary to %s", path)
+	}
+
+	return nil
+}
@@ -2,21 +2,41 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/alecthomas/kong"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
 const (
 	EnvFile         = ".env"
 	EnvConfigPrefix = "BLASTBEAT_API"
+
+	RedactedValue = "***"
 )
 
+// SensitiveFields are config fields whose values must never be exposed
+// (logs, admin endpoints)
+var SensitiveFields = map[string]bool{
+	"DBPassword":         true,
+	"NewRelicLicenseKey": true,
+	"AdminToken":         true,
+	"RedisURL":           true,
+	"YoutubeAPIKey":      true,
+}
+
 type Config struct {
 	Version          kong.VersionFlag `help:"Show version and exit" short:"v" env:"-"`
+	ConfigFile       kong.ConfigFlag  `help:"Load config from a YAML/JSON file (env and flags take precedence)." env:"-"`
 	EnvName          string           `kong:"help='Environment name.',default='dev'"`
 	ServiceName      string           `kong:"help='Service name.',default='blastbeat-api'"`
 	HealthFreqSec    int              `kong:"help='Health check frequency in seconds.',default=10"`
@@ -24,6 +44,21 @@ type Config struct {
 	APIListenAddress string           `kong:"help='API listen address (serves health, metrics, version).',default=:8080"`
 	LogConfig        string           `kong:"help='Logging config to use.',enum='dev,prod',default='dev'"`
 
+	APIReadHeaderTimeoutSec      int  `kong:"help='Max time to read API request headers in seconds.',default=10"`
+	APIIdleTimeoutSec            int  `kong:"help='How long idle keep-alive connections to the API stay open in seconds.',default=120"`
+	APIMaxHeaderBytes            int  `kong:"help='Max size of API request headers in bytes.',default=1048576"`
+	APIHTTP2Cleartext            bool `kong:"help='Also serve HTTP/2 without TLS (h2c prior knowledge, for h2-capable proxies).',default=false"`
+	APIHTTP2MaxConcurrentStreams int  `kong:"help='Max concurrent HTTP/2 streams per API connection.',default=250"`
+
+	AppBaseURL string `kong:"help='Public base URL of the site (e.g. https://www.blastbeat.io), used for absolute links; sitemap is disabled when empty.'"`
+
+	FrameOptions          string `kong:"help='X-Frame-Options header value (omitted when empty).',default='DENY'"`
+	ReferrerPolicy        string `kong:"help='Referrer-Policy header value (omitted when empty).',default='strict-origin-when-cross-origin'"`
+	ContentSecurityPolicy string `help:"Content-Security-Policy for HTML responses (omitted when empty)." default:"default-src 'self'; frame-ancestors 'none'"`
+
+	APITLSCertFile string `kong:"help='Path to a PEM cert for serving the API over HTTPS (plaintext when empty).'"`
+	APITLSKeyFile  string `kong:"help='Path to the PEM key for APITLSCertFile.'"`
+
 	NewRelicAppName    string `kong:"help='New Relic application name.',default='blastbeat-api (DEV)'"`
 	NewRelicLicenseKey string `kong:"help='New Relic license key.'"`
 
@@ -34,9 +69,38 @@ type Config struct {
 	DBPort     int    `kong:"help='Database port.',default=5432"`
 	DBSSLMode  string `kong:"help='Database SSL mode.',env=BLASTBEAT_API_DB_SSL_MODE,default=disable"`
 
+	DBExplainThresholdMs   int `kong:"help='Log EXPLAIN ANALYZE for list queries slower than this (dev only).',default=200"`
+	DBSlowQueryThresholdMs int `kong:"help='Warn on queries slower than this (0 disables).',default=1000"`
+
+	MaxQueryResults int `kong:"help='Hard cap on rows loaded by a single list query.',default=10000"`
+
+	FollowerRanges map[string]string `kong:"help='Extra or overridden followerRange buckets as name=min-max (max may be empty for no upper bound), e.g. 500K+=500000-999999.'"`
+
+	DefaultPageLimit int `kong:"help='Page size for list endpoints when no limit is given.',default=50"`
+	MaxPageLimit     int `kong:"help='Largest limit list endpoints accept.',default=200"`
+
+	AdminToken string `kong:"help='Token required in the X-Admin-Token header for admin endpoints (disabled when empty).'"`
+
+	RedisURL string `kong:"help='Redis address as host:port or redis://[user:pass@]host:port[/db] (Redis features disabled when empty).'"`
+
+	ViewsFlushIntervalSec int `kong:"help='How often release view counts are flushed from Redis to the DB in seconds.',default=60"`
+	TrendingHalfLifeHours int `kong:"help='Hours for a release view to lose half its trending weight.',default=24"`
+	TrendingWindowHours   int `kong:"help='How many hours of views trending considers.',default=72"`
+
+	LinkRefresh              bool   `kong:"help='Re-resolve dead YouTube links in the background when a release is viewed (needs RedisURL and YoutubeAPIKey).',default=false"`
+	YoutubeAPIKey            string `kong:"help='YouTube Data API key used by LinkRefresh.'"`
+	LinkRefreshPerMinute     int    `kong:"help='Max link refreshes started per minute.',default=10"`
+	LinkRefreshIntervalHours int    `kong:"help='Hours before the links of the same release are checked again.',default=24"`
+
 	KongContext *kong.Context `kong:"-"`
 }
 
+// Field is a single config name/value pair
+type Field struct {
+	Name  string
+	Value string
+}
+
 func New(version string) *Config {
 	if err := godotenv.Load(EnvFile); err != nil {
 		zap.L().Warn("unable to load dotenv file",
@@ -44,11 +108,17 @@ func New(version string) *Config {
 	}
 
 	cfg := &Config{}
-	cfg.KongContext = kong.Parse(
-		cfg,
+	cfg.KongContext = kong.Parse(cfg, kongOptions(version)...)
+
+	return cfg
+}
+
+func kongOptions(version string) []kong.Option {
+	return []kong.Option{
 		kong.Name("blastbeat-api"),
 		kong.Description("Golang service"),
 		kong.DefaultEnvars(EnvConfigPrefix),
+		kong.Configuration(loadConfigFile),
 		kong.ConfigureHelp(kong.HelpOptions{
 			Compact:             true,
 			NoExpandSubcommands: true,
@@ -56,9 +126,7 @@ func New(version string) *Config {
 		kong.Vars{
 			"version": version,
 		},
-	)
-
-	return cfg
+	}
 }
 
 func (c *Config) Validate() error {
@@ -66,9 +134,113 @@ func (c *Config) Validate() error {
 		return errors.New("Config cannot be nil")
 	}
 
+	if c.APIReadHeaderTimeoutSec < 0 || c.APIIdleTimeoutSec < 0 {
+		return errors.New("API timeouts cannot be negative")
+	}
+
+	if c.APIMaxHeaderBytes < 0 || c.APIHTTP2MaxConcurrentStreams < 0 {
+		return errors.New("API header/stream limits cannot be negative")
+	}
+
+	if c.AppBaseURL != "" {
+		u, err := url.Parse(c.AppBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("invalid AppBaseURL %q (expected http(s)://host[/path])", c.AppBaseURL)
+		}
+	}
+
+	if c.ViewsFlushIntervalSec < 0 || c.TrendingHalfLifeHours < 0 || c.TrendingWindowHours < 0 {
+		return errors.New("view/trending settings cannot be negative")
+	}
+
+	if c.DefaultPageLimit < 0 || c.MaxPageLimit < 0 {
+		return errors.New("page limits cannot be negative")
+	}
+
+	if c.DefaultPageLimit > 0 && c.MaxPageLimit > 0 && c.DefaultPageLimit > c.MaxPageLimit {
+		return errors.New("DefaultPageLimit cannot be greater than MaxPageLimit")
+	}
+
+	for name, v := range c.FollowerRanges {
+		if _, _, err := ParseFollowerRange(v); err != nil {
+			return errors.Wrapf(err, "invalid FollowerRanges entry %q", name)
+		}
+	}
+
+	if c.LinkRefreshPerMinute < 0 || c.LinkRefreshIntervalHours < 0 {
+		return errors.New("link refresh settings cannot be negative")
+	}
+
+	if c.LinkRefresh && (c.RedisURL == "" || c.YoutubeAPIKey == "") {
+		return errors.New("LinkRefresh requires RedisURL and YoutubeAPIKey")
+	}
+
+	if (c.APITLSCertFile == "") != (c.APITLSKeyFile == "") {
+		return errors.New("APITLSCertFile and APITLSKeyFile must be set together")
+	}
+
+	if c.RedisURL != "" {
+		if _, err := ParseRedisURL(c.RedisURL); err != nil {
+			return errors.Wrap(err, "invalid RedisURL")
+		}
+	}
+
 	return nil
 }
 
+// ParseRedisURL parses RedisURL; it accepts either a plain host:port or a
+// full redis://, rediss:// or unix:// URL
+func ParseRedisURL(v string) (*redis.Options, error) {
+	if strings.Contains(v, "://") {
+		opts, err := redis.ParseURL(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse redis URL")
+		}
+
+		return opts, nil
+	}
+
+	host, port, err := net.SplitHostPort(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "expected host:port or redis:// URL, got %q", v)
+	}
+
+	if host == "" {
+		return nil, errors.Errorf("missing host in %q", v)
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return nil, errors.Errorf("invalid port in %q", v)
+	}
+
+	return &redis.Options{Addr: v}, nil
+}
+
+// ParseFollowerRange parses a FollowerRanges value, "min-max" or "min-" for
+// no upper bound
+func ParseFollowerRange(v string) (int64, int64, error) {
+	minStr, maxStr, ok := strings.Cut(strings.TrimSpace(v), "-")
+	if !ok {
+		return 0, 0, errors.Errorf("expected min-max, got %q", v)
+	}
+
+	minCount, err := strconv.ParseInt(strings.TrimSpace(minStr), 10, 64)
+	if err != nil || minCount < 0 {
+		return 0, 0, errors.Errorf("invalid min in %q", v)
+	}
+
+	maxCount := int64(math.MaxInt64)
+
+	if maxStr = strings.TrimSpace(maxStr); maxStr != "" {
+		maxCount, err = strconv.ParseInt(maxStr, 10, 64)
+		if err != nil || maxCount < minCount {
+			return 0, 0, errors.Errorf("invalid max in %q", v)
+		}
+	}
+
+	return minCount, maxCount, nil
+}
+
 func (c *Config) GetMap() map[string]string {
 	fields := make(map[string]string)
 
@@ -87,3 +259,42 @@ func (c *Config) GetMap() map[string]string {
 
 	return fields
 }
+
+// GetRedactedFields returns the redacted config as name/value pairs, sorted
+// by name for stable output
+func (c *Config) GetRedactedFields() []Field {
+	return SortFields(c.GetRedactedMap())
+}
+
+// SortFields converts a GetMap-style map into a slice sorted by name
+func SortFields(m map[string]string) []Field {
+	fields := make([]Field, 0, len(m))
+
+	for k, v := range m {
+		fields = append(fields, Field{Name: k, Value: v})
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	return fields
+}
+
+// GetRedactedMap is GetMap with sensitive values replaced by RedactedValue
+// and internal (non-config) fields omitted
+func (c *Config) GetRedactedMap() map[string]string {
+	fields := c.GetMap()
+
+	delete(fields, "KongContext")
+	delete(fields, "Version")
+	delete(fields, "ConfigFile")
+
+	for k, v := range fields {
+		if SensitiveFields[k] && v != "" {
+			fields[k] = RedactedValue
+		}
+	}
+
+	return fields
+}
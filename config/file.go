@@ -0,0 +1,58 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile is the kong configuration loader used by --config-file. It
+// accepts YAML or JSON (JSON is valid YAML). Keys are flag names in
+// snake_case (db_host) or camelCase (dbHost).
+//
+// Precedence is flags > env > config file > defaults.
+func loadConfigFile(r io.Reader) (kong.Resolver, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read config file")
+	}
+
+	values := map[string]interface{}{}
+
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, errors.Wrap(err, "unable to parse config file")
+	}
+
+	// Round-trip through JSON so that we can re-use kong's key matching
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode config file")
+	}
+
+	resolver, err := kong.JSON(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load config file")
+	}
+
+	return envFirst(resolver), nil
+}
+
+// envFirst wraps a resolver so that it does not override values that were
+// set via env vars (kong applies env vars before running resolvers).
+func envFirst(r kong.Resolver) kong.Resolver {
+	return kong.ResolverFunc(func(ctx *kong.Context, parent *kong.Path,
+		flag *kong.Flag) (interface{}, error) {
+		for _, env := range flag.Tag.Envs {
+			if _, ok := os.LookupEnv(env); ok {
+				return nil, nil
+			}
+		}
+
+		return r.Resolve(ctx, parent, flag)
+	})
+}
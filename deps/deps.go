@@ -19,9 +19,15 @@ import (
 
 	"github.com/superpowerdotcom/go-common-lib/clog"
 
+	"github.com/dselans/blastbeat-api/backends/cache"
 	"github.com/dselans/blastbeat-api/backends/db"
+	"github.com/dselans/blastbeat-api/backends/state"
 	"github.com/dselans/blastbeat-api/config"
+	sf "github.com/dselans/blastbeat-api/services/favorite"
+	sl "github.com/dselans/blastbeat-api/services/linkcheck"
+	slr "github.com/dselans/blastbeat-api/services/linkrefresh"
 	sr "github.com/dselans/blastbeat-api/services/release"
+	sv "github.com/dselans/blastbeat-api/services/view"
 )
 
 const (
@@ -34,8 +40,22 @@ type Dependencies struct {
 	// Backends
 	DBBackend *db.DB
 
+	// State is nil when RedisURL is not configured
+	State *state.State
+
+	// Cache is an in-process cache for computed responses
+	Cache cache.ICache
+
 	// Services
-	ReleaseService sr.IRelease
+	ReleaseService  sr.IRelease
+	FavoriteService sf.IFavorite
+	LinkCheck       sl.ILinkCheck
+
+	// ViewService is nil when State is
+	ViewService sv.IView
+
+	// LinkRefresh is nil unless Config.LinkRefresh is set
+	LinkRefresh slr.ILinkRefresh
 
 	Health health.IHealth
 
@@ -195,6 +215,11 @@ func (d *Dependencies) setupBackends(cfg *config.Config) error {
 		Port:     cfg.DBPort,
 		DBName:   cfg.DBName,
 		SSLMode:  cfg.DBSSLMode,
+		Log:      d.Log,
+
+		ExplainSlowQueries: cfg.LogConfig == "dev",
+		ExplainThreshold:   time.Duration(cfg.DBExplainThresholdMs) * time.Millisecond,
+		SlowQueryThreshold: time.Duration(cfg.DBSlowQueryThresholdMs) * time.Millisecond,
 	})
 	if err != nil {
 		return errors.Wrap(err, "unable to setup database backend")
@@ -209,6 +234,29 @@ func (d *Dependencies) setupBackends(cfg *config.Config) error {
 	}
 	llog.Debug("Database migrations completed")
 
+	d.Cache = cache.New()
+
+	if cfg.RedisURL == "" {
+		llog.Debug("RedisURL not set, skipping state backend")
+		return nil
+	}
+
+	llog.Debug("Setting up state backend")
+
+	redisOpts, err := config.ParseRedisURL(cfg.RedisURL)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse redis url")
+	}
+
+	st, err := state.New(&state.Options{
+		Redis: redisOpts,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to setup state backend")
+	}
+
+	d.State = st
+
 	return nil
 }
 
@@ -218,10 +266,23 @@ func (d *Dependencies) setupServices(cfg *config.Config) error {
 
 	logger.Debug("Setting up release service")
 
+	followerBuckets := make(map[string]sr.FollowerBucket, len(cfg.FollowerRanges))
+
+	for name, v := range cfg.FollowerRanges {
+		minCount, maxCount, err := config.ParseFollowerRange(v)
+		if err != nil {
+			return errors.Wrapf(err, "invalid follower range %q", name)
+		}
+
+		followerBuckets[name] = sr.FollowerBucket{Min: minCount, Max: maxCount}
+	}
+
 	// Setup release service
 	releaseService, err := sr.New(&sr.Options{
-		Backend: d.DBBackend,
-		Log:     d.Log,
+		Backend:         d.DBBackend,
+		Log:             d.Log,
+		MaxResults:      cfg.MaxQueryResults,
+		FollowerBuckets: followerBuckets,
 	})
 	if err != nil {
 		return errors.Wrap(err, "unable to setup release service")
@@ -229,6 +290,67 @@ func (d *Dependencies) setupServices(cfg *config.Config) error {
 
 	d.ReleaseService = releaseService
 
+	logger.Debug("Setting up favorite service")
+
+	favoriteService, err := sf.New(&sf.Options{
+		Backend: d.DBBackend,
+		Log:     d.Log,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to setup favorite service")
+	}
+
+	d.FavoriteService = favoriteService
+
+	logger.Debug("Setting up link check service")
+
+	linkCheck, err := sl.New(&sl.Options{})
+	if err != nil {
+		return errors.Wrap(err, "unable to setup link check service")
+	}
+
+	d.LinkCheck = linkCheck
+
+	if d.State != nil {
+		logger.Debug("Setting up view service")
+
+		viewService, err := sv.New(&sv.Options{
+			Backend:       d.DBBackend,
+			State:         d.State,
+			Log:           d.Log,
+			FlushInterval: time.Duration(cfg.ViewsFlushIntervalSec) * time.Second,
+			HalfLife:      time.Duration(cfg.TrendingHalfLifeHours) * time.Hour,
+			Window:        time.Duration(cfg.TrendingWindowHours) * time.Hour,
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to setup view service")
+		}
+
+		go viewService.RunFlusher(d.ShutdownCtx)
+
+		d.ViewService = viewService
+
+		if cfg.LinkRefresh {
+			logger.Debug("Setting up link refresh service")
+
+			linkRefresh, err := slr.New(&slr.Options{
+				Backend:       d.DBBackend,
+				State:         d.State,
+				LinkCheck:     d.LinkCheck,
+				Log:           d.Log,
+				ShutdownCtx:   d.ShutdownCtx,
+				YoutubeAPIKey: cfg.YoutubeAPIKey,
+				PerMinute:     cfg.LinkRefreshPerMinute,
+				Interval:      time.Duration(cfg.LinkRefreshIntervalHours) * time.Hour,
+			})
+			if err != nil {
+				return errors.Wrap(err, "unable to setup link refresh service")
+			}
+
+			d.LinkRefresh = linkRefresh
+		}
+	}
+
 	return nil
 }
 
@@ -258,24 +380,27 @@ func (c *customCheck) Status() (interface{}, error) {
 	return map[string]int{}, nil
 }
 
-// LogConfig pretty prints the config to the log
+// LogConfig pretty prints the config to the log; sensitive values (see
+// config.SensitiveFields) are printed as config.RedactedValue
 func (d *Dependencies) LogConfig() {
 	d.ZapLog.Info("Config")
 
+	fields := d.Config.GetRedactedFields()
 	longestKey := 0
 
-	for k, _ := range d.Config.GetMap() {
-		if len(k) > longestKey {
-			longestKey = len(k)
+	for _, f := range fields {
+		if len(f.Name) > longestKey {
+			longestKey = len(f.Name)
 		}
 	}
 
 	maxPadding := longestKey + 3
-	totalKeys := len(d.Config.GetMap())
+	totalKeys := len(fields)
 	index := 0
 	prefix := "├─"
 
-	for k, v := range d.Config.GetMap() {
+	for _, f := range fields {
+		k, v := f.Name, f.Value
 		index++
 
 		if index == totalKeys {
@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- unaccent() is only STABLE (it depends on the dictionary search path), so
+-- wrap it with an explicit dictionary in an IMMUTABLE function that can be
+-- used in queries and index expressions.
+CREATE OR REPLACE FUNCTION f_unaccent(text)
+RETURNS text AS $$
+  SELECT public.unaccent('public.unaccent'::regdictionary, $1)
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS favorites (
+  token TEXT NOT NULL,
+  release_id UUID NOT NULL REFERENCES releases (id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (token, release_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_favorites_token_created_at
+  ON favorites (token, created_at DESC);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS release_views (
+  release_id UUID PRIMARY KEY REFERENCES releases (id) ON DELETE CASCADE,
+  view_count BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS enrichment_audit (
+  id BIGSERIAL PRIMARY KEY,
+  release_id UUID REFERENCES releases (id) ON DELETE CASCADE,
+  artist TEXT NOT NULL,
+  album TEXT NOT NULL,
+  provider TEXT NOT NULL,
+  lookup TEXT NOT NULL,
+  url TEXT NOT NULL,
+  status_code INTEGER NOT NULL,
+  error TEXT,
+  response_excerpt TEXT,
+  duration_ms INTEGER NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_enrichment_audit_release_id
+  ON enrichment_audit (release_id, created_at);
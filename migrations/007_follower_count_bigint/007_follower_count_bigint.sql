@@ -0,0 +1 @@
+ALTER TABLE releases ALTER COLUMN follower_count TYPE BIGINT;
@@ -0,0 +1,45 @@
+-- Collapse releases that share a key into the one with the most followers
+-- (the oldest on a tie) so the unique index can be built. The ranking must
+-- match logDuplicateReleases in backends/db/migrate.go.
+CREATE TEMP TABLE release_duplicates ON COMMIT DROP AS
+SELECT id, keep_id
+FROM (
+  SELECT
+    id,
+    first_value(id) OVER w AS keep_id,
+    row_number() OVER w AS n
+  FROM releases
+  WINDOW w AS (
+    PARTITION BY lower(artist), lower(title), release_date
+    ORDER BY follower_count DESC, created_at, id
+  )
+) ranked
+WHERE n > 1;
+
+INSERT INTO favorites (token, release_id, created_at)
+SELECT f.token, d.keep_id, min(f.created_at)
+FROM favorites f
+JOIN release_duplicates d ON d.id = f.release_id
+GROUP BY f.token, d.keep_id
+ON CONFLICT (token, release_id) DO NOTHING;
+
+INSERT INTO release_views (release_id, view_count)
+SELECT d.keep_id, sum(v.view_count)
+FROM release_views v
+JOIN release_duplicates d ON d.id = v.release_id
+GROUP BY d.keep_id
+ON CONFLICT (release_id) DO UPDATE
+SET view_count = release_views.view_count + EXCLUDED.view_count,
+    updated_at = now();
+
+UPDATE enrichment_audit a
+SET release_id = d.keep_id
+FROM release_duplicates d
+WHERE a.release_id = d.id;
+
+DELETE FROM releases r
+USING release_duplicates d
+WHERE r.id = d.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_releases_unique_key
+  ON releases (lower(artist), lower(title), release_date);
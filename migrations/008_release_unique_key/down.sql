@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_releases_unique_key;
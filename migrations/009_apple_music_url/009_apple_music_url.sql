@@ -0,0 +1 @@
+ALTER TABLE releases ADD COLUMN IF NOT EXISTS apple_music_url TEXT;
@@ -0,0 +1 @@
+ALTER TABLE releases DROP COLUMN IF EXISTS apple_music_url;
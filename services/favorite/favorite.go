@@ -0,0 +1,176 @@
+package favorite
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/db"
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+const (
+	// DefaultMaxPerToken caps how many favorites a single token may store
+	DefaultMaxPerToken = 500
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid favorites token")
+	ErrReleaseNotFound  = errors.New("release not found")
+	ErrTooManyFavorites = errors.New("too many favorites")
+
+	// tokenRe is what we accept as an opaque client token: long enough to
+	// not be guessable, short enough to not be abused as storage
+	tokenRe = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+)
+
+type IFavorite interface {
+	Add(ctx context.Context, token string, releaseID uuid.UUID) error
+	Remove(ctx context.Context, token string, releaseID uuid.UUID) error
+	List(ctx context.Context, token string) ([]uuid.UUID, error)
+}
+
+type Favorite struct {
+	opts    *Options
+	backend backend
+	log     clog.ICustomLog
+}
+
+// backend is the part of *db.DB that Favorite uses
+type backend interface {
+	GetRelease(ctx context.Context, id uuid.UUID) (gensql.Release, error)
+	AddFavorite(ctx context.Context, arg gensql.AddFavoriteParams) (int64, error)
+	FavoriteExists(ctx context.Context, arg gensql.FavoriteExistsParams) (bool, error)
+	RemoveFavorite(ctx context.Context, arg gensql.RemoveFavoriteParams) error
+	ListFavoriteReleaseIDs(ctx context.Context, token string) ([]uuid.UUID, error)
+}
+
+type Options struct {
+	Backend *db.DB
+	Log     clog.ICustomLog
+
+	// MaxPerToken defaults to DefaultMaxPerToken
+	MaxPerToken int
+}
+
+func New(opts *Options) (*Favorite, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, errors.Wrap(err, "failed to validate options")
+	}
+
+	return &Favorite{
+		opts:    opts,
+		backend: opts.Backend,
+		log:     opts.Log.With(zap.String("pkg", "favorite")),
+	}, nil
+}
+
+func validateOptions(opts *Options) error {
+	if opts == nil {
+		return errors.New("options cannot be nil")
+	}
+
+	if opts.Backend == nil {
+		return errors.New("backend cannot be nil")
+	}
+
+	if opts.Log == nil {
+		return errors.New("log cannot be nil")
+	}
+
+	if opts.MaxPerToken <= 0 {
+		opts.MaxPerToken = DefaultMaxPerToken
+	}
+
+	return nil
+}
+
+// ValidToken reports whether token is an acceptable favorites token
+func ValidToken(token string) bool {
+	return tokenRe.MatchString(token)
+}
+
+// Add bookmarks a release for token; adding an existing favorite is a no-op
+func (f *Favorite) Add(ctx context.Context, token string, releaseID uuid.UUID) error {
+	if !ValidToken(token) {
+		return ErrInvalidToken
+	}
+
+	if _, err := f.backend.GetRelease(ctx, releaseID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReleaseNotFound
+		}
+
+		return errors.Wrap(err, "failed to look up release")
+	}
+
+	// The insert checks the cap itself rather than trusting an earlier
+	// count, which concurrent adds could all pass
+	added, err := f.backend.AddFavorite(ctx, gensql.AddFavoriteParams{
+		Token:        token,
+		ReleaseID:    releaseID,
+		MaxFavorites: int32(f.opts.MaxPerToken),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to add favorite")
+	}
+
+	if added > 0 {
+		return nil
+	}
+
+	// Nothing was inserted: either the favorite already exists, which is
+	// fine even at the cap, or the token is full
+	exists, err := f.backend.FavoriteExists(ctx, gensql.FavoriteExistsParams{
+		Token:     token,
+		ReleaseID: releaseID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to look up favorite")
+	}
+
+	if !exists {
+		return ErrTooManyFavorites
+	}
+
+	return nil
+}
+
+// Remove deletes a favorite; removing a missing favorite is a no-op
+func (f *Favorite) Remove(ctx context.Context, token string, releaseID uuid.UUID) error {
+	if !ValidToken(token) {
+		return ErrInvalidToken
+	}
+
+	if err := f.backend.RemoveFavorite(ctx, gensql.RemoveFavoriteParams{
+		Token:     token,
+		ReleaseID: releaseID,
+	}); err != nil {
+		return errors.Wrap(err, "failed to remove favorite")
+	}
+
+	return nil
+}
+
+// List returns the favorited release ids for token, newest first
+func (f *Favorite) List(ctx context.Context, token string) ([]uuid.UUID, error) {
+	if !ValidToken(token) {
+		return nil, ErrInvalidToken
+	}
+
+	ids, err := f.backend.ListFavoriteReleaseIDs(ctx, token)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list favorites")
+	}
+
+	if ids == nil {
+		ids = []uuid.UUID{}
+	}
+
+	return ids, nil
+}
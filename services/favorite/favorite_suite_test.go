@@ -0,0 +1,16 @@
+package favorite
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+func TestFavoriteSuite(t *testing.T) {
+	// Reduce test noise
+	zap.IncreaseLevel(zap.FatalLevel)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Favorite Suite")
+}
@@ -0,0 +1,109 @@
+package favorite
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeBackend keeps favorites in memory, enforcing the cap like the
+// AddFavorite query
+type fakeBackend struct {
+	favorites map[string][]uuid.UUID
+}
+
+func (f *fakeBackend) GetRelease(_ context.Context, id uuid.UUID) (gensql.Release, error) {
+	return gensql.Release{ID: id}, nil
+}
+
+func (f *fakeBackend) AddFavorite(_ context.Context, arg gensql.AddFavoriteParams) (int64, error) {
+	if f.has(arg.Token, arg.ReleaseID) || len(f.favorites[arg.Token]) >= int(arg.MaxFavorites) {
+		return 0, nil
+	}
+
+	f.favorites[arg.Token] = append(f.favorites[arg.Token], arg.ReleaseID)
+
+	return 1, nil
+}
+
+func (f *fakeBackend) FavoriteExists(_ context.Context, arg gensql.FavoriteExistsParams) (bool, error) {
+	return f.has(arg.Token, arg.ReleaseID), nil
+}
+
+func (f *fakeBackend) RemoveFavorite(context.Context, gensql.RemoveFavoriteParams) error {
+	return nil
+}
+
+func (f *fakeBackend) ListFavoriteReleaseIDs(_ context.Context, token string) ([]uuid.UUID, error) {
+	return f.favorites[token], nil
+}
+
+func (f *fakeBackend) has(token string, id uuid.UUID) bool {
+	for _, fav := range f.favorites[token] {
+		if fav == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+var _ = Describe("Favorite", func() {
+	const token = "0123456789abcdef0123"
+
+	var (
+		ctx     context.Context
+		backend *fakeBackend
+		f       *Favorite
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		backend = &fakeBackend{favorites: map[string][]uuid.UUID{}}
+		f = &Favorite{
+			opts:    &Options{MaxPerToken: 2},
+			backend: backend,
+			log:     clog.New(zap.NewNop()),
+		}
+	})
+
+	Describe("Add", func() {
+		It("should reject invalid tokens", func() {
+			Expect(f.Add(ctx, "short", uuid.New())).To(MatchError(ErrInvalidToken))
+		})
+
+		It("should stop at MaxPerToken", func() {
+			Expect(f.Add(ctx, token, uuid.New())).To(Succeed())
+			Expect(f.Add(ctx, token, uuid.New())).To(Succeed())
+			Expect(f.Add(ctx, token, uuid.New())).To(MatchError(ErrTooManyFavorites))
+
+			Expect(backend.favorites[token]).To(HaveLen(2))
+		})
+
+		It("should accept re-adding an existing favorite at the cap", func() {
+			id := uuid.New()
+
+			Expect(f.Add(ctx, token, id)).To(Succeed())
+			Expect(f.Add(ctx, token, uuid.New())).To(Succeed())
+			Expect(f.Add(ctx, token, id)).To(Succeed())
+
+			Expect(backend.favorites[token]).To(HaveLen(2))
+		})
+	})
+
+	Describe("List", func() {
+		It("should return an empty list rather than nil", func() {
+			ids, err := f.List(ctx, token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids).ToNot(BeNil())
+			Expect(ids).To(BeEmpty())
+		})
+	})
+})
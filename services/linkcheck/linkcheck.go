@@ -0,0 +1,286 @@
+package linkcheck
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+const (
+	DefaultTimeout     = 10 * time.Second
+	DefaultConcurrency = 4
+
+	userAgent = "blastbeat-linkcheck/1.0"
+)
+
+// Link names used for a release's dedicated link columns
+const (
+	LinkSpotify    = "spotify"
+	LinkYoutube    = "youtube"
+	LinkBandcamp   = "bandcamp"
+	LinkAppleMusic = "apple_music"
+	LinkLabel      = "label"
+)
+
+// Link statuses
+const (
+	StatusOK   = "ok"
+	StatusDead = "dead"
+
+	// StatusUnknown means the check failed for a reason that doesn't say
+	// anything about the link (timeout, 5xx, rate limit, ...)
+	StatusUnknown = "unknown"
+)
+
+type ILinkCheck interface {
+	Check(ctx context.Context, links []Link) []Result
+}
+
+type LinkCheck struct {
+	opts *Options
+}
+
+type Options struct {
+	// Client defaults to an http.Client with Timeout
+	Client *http.Client
+
+	// Timeout bounds each check; defaults to DefaultTimeout
+	Timeout time.Duration
+
+	// Concurrency is how many links are checked at once; defaults to
+	// DefaultConcurrency
+	Concurrency int
+}
+
+type Link struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type Result struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Status     string `json:"status"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func New(opts *Options) (*LinkCheck, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, errors.Wrap(err, "failed to validate options")
+	}
+
+	return &LinkCheck{
+		opts: opts,
+	}, nil
+}
+
+func validateOptions(opts *Options) error {
+	if opts == nil {
+		return errors.New("options cannot be nil")
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: opts.Timeout}
+	}
+
+	return nil
+}
+
+// Links returns every link stored on r: the preview/label columns plus the
+// external_links entries, without duplicate URLs
+func Links(r gensql.Release) []Link {
+	var links []Link
+
+	seen := make(map[string]bool)
+
+	add := func(name, u string) {
+		u = strings.TrimSpace(u)
+		if u == "" || seen[u] {
+			return
+		}
+
+		seen[u] = true
+		links = append(links, Link{Name: name, URL: u})
+	}
+
+	add(LinkSpotify, r.SpotifyUrl.String)
+	add(LinkYoutube, r.YoutubeUrl.String)
+	add(LinkBandcamp, r.BandcampUrl.String)
+	add(LinkAppleMusic, r.AppleMusicUrl.String)
+	add(LinkLabel, r.LabelUrl.String)
+
+	for _, l := range externalLinks(r.ExternalLinks) {
+		add(l.Name, l.URL)
+	}
+
+	return links
+}
+
+// externalLinks decodes external_links, which the importer stores as a
+// name -> url object and older rows store as a list of links
+func externalLinks(raw []byte) []Link {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var byName map[string]string
+	if err := json.Unmarshal(raw, &byName); err == nil {
+		links := make([]Link, 0, len(byName))
+		for _, name := range slices.Sorted(maps.Keys(byName)) {
+			links = append(links, Link{Name: name, URL: byName[name]})
+		}
+
+		return links
+	}
+
+	var list []Link
+	_ = json.Unmarshal(raw, &list)
+
+	return list
+}
+
+// Check checks links concurrently, returning results in the same order
+func (l *LinkCheck) Check(ctx context.Context, links []Link) []Result {
+	results := make([]Result, len(links))
+	sem := make(chan struct{}, l.opts.Concurrency)
+
+	var wg sync.WaitGroup
+
+	for i, link := range links {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = l.checkOne(ctx, link)
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+func (l *LinkCheck) checkOne(ctx context.Context, link Link) Result {
+	res := Result{Name: link.Name, URL: link.URL}
+
+	ctx, cancel := context.WithTimeout(ctx, l.opts.Timeout)
+	defer cancel()
+
+	// Spotify and YouTube answer 200 for removed albums/videos, so ask
+	// their oEmbed endpoints instead, which 404 (401 for private videos)
+	method, target := http.MethodHead, link.URL
+
+	oembed := oEmbedURL(link.URL)
+	if oembed != "" {
+		method, target = http.MethodGet, oembed
+	}
+
+	code, err := l.do(ctx, method, target)
+
+	// Some servers don't implement HEAD
+	if err == nil && method == http.MethodHead &&
+		(code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+		code, err = l.do(ctx, http.MethodGet, target)
+	}
+
+	if err != nil {
+		res.Status = StatusUnknown
+		res.Error = err.Error()
+
+		return res
+	}
+
+	res.StatusCode = code
+	res.Status = statusFor(code, oembed != "")
+
+	return res
+}
+
+func (l *LinkCheck) do(ctx context.Context, method, target string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid url")
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := l.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// statusFor maps a response code to a link status; only "gone" responses
+// count as dead (plus 401 from oEmbed, which means a private video)
+func statusFor(code int, oembed bool) string {
+	switch {
+	case code >= 200 && code < 400:
+		return StatusOK
+	case code == http.StatusNotFound, code == http.StatusGone:
+		return StatusDead
+	case code == http.StatusUnauthorized && oembed:
+		return StatusDead
+	default:
+		return StatusUnknown
+	}
+}
+
+// oEmbedURL returns the oEmbed endpoint for Spotify and YouTube links, or ""
+// for anything else
+func oEmbedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	switch host {
+	case "open.spotify.com":
+		return "https://open.spotify.com/oembed?url=" + url.QueryEscape(raw)
+	case "youtube.com", "m.youtube.com", "youtu.be":
+		return "https://www.youtube.com/oembed?format=json&url=" + url.QueryEscape(raw)
+	}
+
+	return ""
+}
+
+// Dead returns the results with StatusDead
+func Dead(results []Result) []Result {
+	var dead []Result
+
+	for _, r := range results {
+		if r.Status == StatusDead {
+			dead = append(dead, r)
+		}
+	}
+
+	return dead
+}
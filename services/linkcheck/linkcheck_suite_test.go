@@ -0,0 +1,13 @@
+package linkcheck
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestLinkCheckSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LinkCheck Suite")
+}
@@ -0,0 +1,133 @@
+package linkcheck
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LinkCheck", func() {
+	Describe("statusFor", func() {
+		It("should only count gone responses as dead", func() {
+			cases := []struct {
+				code   int
+				oembed bool
+				want   string
+			}{
+				{http.StatusOK, false, StatusOK},
+				{http.StatusMovedPermanently, false, StatusOK},
+				{http.StatusNotFound, false, StatusDead},
+				{http.StatusGone, false, StatusDead},
+				{http.StatusUnauthorized, true, StatusDead},
+				{http.StatusUnauthorized, false, StatusUnknown},
+				{http.StatusForbidden, false, StatusUnknown},
+				{http.StatusTooManyRequests, false, StatusUnknown},
+				{http.StatusInternalServerError, false, StatusUnknown},
+			}
+
+			for _, c := range cases {
+				Expect(statusFor(c.code, c.oembed)).To(Equal(c.want), "code %d, oembed %v", c.code, c.oembed)
+			}
+		})
+	})
+
+	Describe("oEmbedURL", func() {
+		It("should use oEmbed for Spotify and YouTube only", func() {
+			Expect(oEmbedURL("https://open.spotify.com/album/abc")).To(Equal(
+				"https://open.spotify.com/oembed?url=https%3A%2F%2Fopen.spotify.com%2Falbum%2Fabc"))
+			Expect(oEmbedURL("https://www.youtube.com/watch?v=abc")).To(Equal(
+				"https://www.youtube.com/oembed?format=json&url=https%3A%2F%2Fwww.youtube.com%2Fwatch%3Fv%3Dabc"))
+			Expect(oEmbedURL("https://youtu.be/abc")).To(HavePrefix("https://www.youtube.com/oembed?"))
+			Expect(oEmbedURL("https://M.YouTube.com/watch?v=abc")).To(HavePrefix("https://www.youtube.com/oembed?"))
+
+			Expect(oEmbedURL("https://mgla.bandcamp.com/album/age-of-excuse")).To(BeEmpty())
+			Expect(oEmbedURL("://bad")).To(BeEmpty())
+		})
+	})
+
+	Describe("Links", func() {
+		It("should list the link columns and external links without duplicates", func() {
+			links := Links(gensql.Release{
+				SpotifyUrl:    sql.NullString{String: "https://open.spotify.com/album/x", Valid: true},
+				BandcampUrl:   sql.NullString{String: " ", Valid: true},
+				AppleMusicUrl: sql.NullString{String: "https://music.apple.com/us/album/1", Valid: true},
+				LabelUrl:      sql.NullString{String: "https://label.example", Valid: true},
+				ExternalLinks: json.RawMessage(`{"spotify":"https://open.spotify.com/album/x","discogs":"https://www.discogs.com/release/1"}`),
+			})
+
+			Expect(links).To(Equal([]Link{
+				{Name: LinkSpotify, URL: "https://open.spotify.com/album/x"},
+				{Name: LinkAppleMusic, URL: "https://music.apple.com/us/album/1"},
+				{Name: LinkLabel, URL: "https://label.example"},
+				{Name: "discogs", URL: "https://www.discogs.com/release/1"},
+			}))
+		})
+
+		It("should read external links stored as a list", func() {
+			links := Links(gensql.Release{
+				ExternalLinks: json.RawMessage(`[{"name":"discogs","url":"https://www.discogs.com/release/1"}]`),
+			})
+
+			Expect(links).To(Equal([]Link{{Name: "discogs", URL: "https://www.discogs.com/release/1"}}))
+		})
+	})
+
+	Describe("Check", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/ok":
+					rw.WriteHeader(http.StatusOK)
+				case "/gone":
+					rw.WriteHeader(http.StatusNotFound)
+				case "/get-only":
+					if r.Method == http.MethodHead {
+						rw.WriteHeader(http.StatusMethodNotAllowed)
+						return
+					}
+
+					rw.WriteHeader(http.StatusOK)
+				default:
+					rw.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should check every link and keep their order", func() {
+			l, err := New(&Options{})
+			Expect(err).ToNot(HaveOccurred())
+
+			results := l.Check(context.Background(), []Link{
+				{Name: "a", URL: server.URL + "/ok"},
+				{Name: "b", URL: server.URL + "/gone"},
+				{Name: "c", URL: server.URL + "/get-only"},
+				{Name: "d", URL: server.URL + "/down"},
+				{Name: "e", URL: "http://%zz"},
+			})
+
+			Expect(results).To(HaveLen(5))
+			Expect(results[0]).To(Equal(Result{Name: "a", URL: server.URL + "/ok", Status: StatusOK, StatusCode: 200}))
+			Expect(results[1].Status).To(Equal(StatusDead))
+			Expect(results[2]).To(Equal(Result{Name: "c", URL: server.URL + "/get-only", Status: StatusOK, StatusCode: 200}))
+			Expect(results[3].Status).To(Equal(StatusUnknown))
+			Expect(results[3].StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(results[4].Status).To(Equal(StatusUnknown))
+			Expect(results[4].Error).ToNot(BeEmpty())
+
+			Expect(Dead(results)).To(Equal([]Result{results[1]}))
+		})
+	})
+})
@@ -0,0 +1,300 @@
+package linkrefresh
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/db"
+	"github.com/dselans/blastbeat-api/backends/gensql"
+	"github.com/dselans/blastbeat-api/backends/state"
+	"github.com/dselans/blastbeat-api/services/linkcheck"
+)
+
+const (
+	DefaultPerMinute = 10
+	DefaultInterval  = 24 * time.Hour
+
+	// refreshTimeout bounds a single background refresh
+	refreshTimeout = 30 * time.Second
+
+	lockPrefix = "linkrefresh:"
+
+	youtubeSearchURL = "https://www.googleapis.com/youtube/v3/search"
+	youtubeWatchBase = "https://www.youtube.com/watch?v="
+)
+
+// ILinkRefresh repairs dead preview links in the background
+type ILinkRefresh interface {
+	// Trigger schedules a check of releaseID's YouTube link and returns
+	// immediately
+	Trigger(releaseID uuid.UUID)
+}
+
+type LinkRefresh struct {
+	opts    *Options
+	backend backend
+	locks   locker
+	log     clog.ICustomLog
+
+	mu          sync.Mutex
+	windowStart time.Time
+	used        int
+}
+
+// backend is the part of *db.DB that LinkRefresh uses
+type backend interface {
+	GetRelease(ctx context.Context, id uuid.UUID) (gensql.Release, error)
+	UpdateReleaseYoutubeURL(ctx context.Context, arg gensql.UpdateReleaseYoutubeURLParams) (int64, error)
+}
+
+// locker is the part of *state.State that LinkRefresh uses
+type locker interface {
+	SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Del(ctx context.Context, keys ...string) error
+}
+
+type Options struct {
+	Backend   *db.DB
+	State     *state.State
+	LinkCheck linkcheck.ILinkCheck
+	Log       clog.ICustomLog
+
+	// ShutdownCtx cancels in-flight refreshes
+	ShutdownCtx context.Context
+
+	YoutubeAPIKey string
+
+	// Client is used for YouTube searches; defaults to http.DefaultClient
+	Client *http.Client
+
+	// PerMinute caps refreshes started per minute (per instance); defaults
+	// to DefaultPerMinute
+	PerMinute int
+
+	// Interval is how long before the same release is checked again;
+	// defaults to DefaultInterval
+	Interval time.Duration
+}
+
+func New(opts *Options) (*LinkRefresh, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, errors.Wrap(err, "failed to validate options")
+	}
+
+	return &LinkRefresh{
+		opts:    opts,
+		backend: opts.Backend,
+		locks:   opts.State,
+		log:     opts.Log.With(zap.String("pkg", "linkrefresh")),
+	}, nil
+}
+
+func validateOptions(opts *Options) error {
+	if opts == nil {
+		return errors.New("options cannot be nil")
+	}
+
+	if opts.Backend == nil {
+		return errors.New("backend cannot be nil")
+	}
+
+	if opts.State == nil {
+		return errors.New("state cannot be nil")
+	}
+
+	if opts.LinkCheck == nil {
+		return errors.New("link check cannot be nil")
+	}
+
+	if opts.Log == nil {
+		return errors.New("log cannot be nil")
+	}
+
+	if opts.ShutdownCtx == nil {
+		return errors.New("shutdown context cannot be nil")
+	}
+
+	if opts.YoutubeAPIKey == "" {
+		return errors.New("youtube api key cannot be empty")
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.PerMinute <= 0 {
+		opts.PerMinute = DefaultPerMinute
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+
+	return nil
+}
+
+func (l *LinkRefresh) Trigger(releaseID uuid.UUID) {
+	go l.refresh(releaseID)
+}
+
+func (l *LinkRefresh) refresh(releaseID uuid.UUID) {
+	logger := l.log.With(zap.String("method", "refresh"), zap.String("releaseId", releaseID.String()))
+
+	ctx, cancel := context.WithTimeout(l.opts.ShutdownCtx, refreshTimeout)
+	defer cancel()
+
+	// The lock dedupes refreshes across views and instances; it is left to
+	// expire so the release isn't checked again until Interval has passed
+	lockKey := lockPrefix + releaseID.String()
+
+	locked, err := l.locks.SetNX(ctx, lockKey, l.opts.Interval)
+	if err != nil {
+		logger.Warn("unable to take link refresh lock", zap.Error(err))
+		return
+	}
+
+	if !locked {
+		return
+	}
+
+	if !l.allow(time.Now()) {
+		// Give the release back so a later view can retry
+		if err := l.locks.Del(ctx, lockKey); err != nil {
+			logger.Warn("unable to release link refresh lock", zap.Error(err))
+		}
+
+		return
+	}
+
+	if err := l.refreshYoutube(ctx, releaseID); err != nil {
+		logger.Warn("link refresh failed", zap.Error(err))
+	}
+}
+
+// allow implements a fixed one-minute window of PerMinute refreshes
+func (l *LinkRefresh) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.windowStart) >= time.Minute {
+		l.windowStart = now
+		l.used = 0
+	}
+
+	if l.used >= l.opts.PerMinute {
+		return false
+	}
+
+	l.used++
+
+	return true
+}
+
+func (l *LinkRefresh) refreshYoutube(ctx context.Context, releaseID uuid.UUID) error {
+	release, err := l.backend.GetRelease(ctx, releaseID)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch release")
+	}
+
+	if !release.YoutubeUrl.Valid || release.YoutubeUrl.String == "" {
+		return nil
+	}
+
+	results := l.opts.LinkCheck.Check(ctx, []linkcheck.Link{
+		{Name: linkcheck.LinkYoutube, URL: release.YoutubeUrl.String},
+	})
+
+	if len(linkcheck.Dead(results)) == 0 {
+		return nil
+	}
+
+	replacement, err := l.searchYoutube(ctx, release.Artist, release.Title)
+	if err != nil {
+		return errors.Wrap(err, "failed to search youtube")
+	}
+
+	if replacement == "" || replacement == release.YoutubeUrl.String {
+		l.log.Info("dead youtube link has no replacement",
+			zap.String("releaseId", releaseID.String()),
+			zap.String("url", release.YoutubeUrl.String))
+		return nil
+	}
+
+	// Only the link is written, and only if it is still the dead one, so an
+	// edit made while we were searching is left alone
+	updated, err := l.backend.UpdateReleaseYoutubeURL(ctx, gensql.UpdateReleaseYoutubeURLParams{
+		ID:     releaseID,
+		NewUrl: replacement,
+		OldUrl: release.YoutubeUrl.String,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update release")
+	}
+
+	if updated == 0 {
+		l.log.Info("youtube link changed during refresh, leaving it",
+			zap.String("releaseId", releaseID.String()))
+		return nil
+	}
+
+	l.log.Info("replaced dead youtube link",
+		zap.String("releaseId", releaseID.String()),
+		zap.String("old", release.YoutubeUrl.String),
+		zap.String("new", replacement))
+
+	return nil
+}
+
+// searchYoutube runs the same search as the importer and returns the top
+// video, or "" when there is none
+func (l *LinkRefresh) searchYoutube(ctx context.Context, artist, title string) (string, error) {
+	q := url.Values{
+		"part":       {"snippet"},
+		"maxResults": {"1"},
+		"type":       {"video"},
+		"q":          {artist + " " + title + " full album"},
+		"key":        {l.opts.YoutubeAPIKey},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, youtubeSearchURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := l.opts.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var out struct {
+		Items []struct {
+			ID struct {
+				VideoID string `json:"videoId"`
+			} `json:"id"`
+		} `json:"items"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", errors.Wrap(err, "failed to decode search response")
+	}
+
+	if len(out.Items) == 0 || out.Items[0].ID.VideoID == "" {
+		return "", nil
+	}
+
+	return youtubeWatchBase + out.Items[0].ID.VideoID, nil
+}
@@ -0,0 +1,215 @@
+package linkrefresh
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+	"github.com/dselans/blastbeat-api/services/linkcheck"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeLocker is an in-memory locker
+type fakeLocker struct {
+	held    map[string]bool
+	deleted []string
+}
+
+func (f *fakeLocker) SetNX(_ context.Context, key string, _ time.Duration) (bool, error) {
+	if f.held[key] {
+		return false, nil
+	}
+
+	f.held[key] = true
+
+	return true, nil
+}
+
+func (f *fakeLocker) Del(_ context.Context, keys ...string) error {
+	for _, k := range keys {
+		delete(f.held, k)
+		f.deleted = append(f.deleted, k)
+	}
+
+	return nil
+}
+
+// fakeBackend serves release, counts lookups and records YouTube updates,
+// which change updatedRows rows
+type fakeBackend struct {
+	release     gensql.Release
+	gets        int
+	updates     []gensql.UpdateReleaseYoutubeURLParams
+	updatedRows int64
+}
+
+func (f *fakeBackend) GetRelease(context.Context, uuid.UUID) (gensql.Release, error) {
+	f.gets++
+	return f.release, nil
+}
+
+func (f *fakeBackend) UpdateReleaseYoutubeURL(_ context.Context, arg gensql.UpdateReleaseYoutubeURLParams) (int64, error) {
+	f.updates = append(f.updates, arg)
+	return f.updatedRows, nil
+}
+
+// fakeLinkCheck reports every link with status (alive when empty)
+type fakeLinkCheck struct {
+	status string
+}
+
+func (f fakeLinkCheck) Check(_ context.Context, links []linkcheck.Link) []linkcheck.Result {
+	status := f.status
+	if status == "" {
+		status = linkcheck.StatusOK
+	}
+
+	results := make([]linkcheck.Result, 0, len(links))
+	for _, l := range links {
+		results = append(results, linkcheck.Result{Name: l.Name, URL: l.URL, Status: status})
+	}
+
+	return results
+}
+
+// youtubeSearch answers every request with a search result for videoID
+type youtubeSearch struct {
+	videoID string
+}
+
+func (y youtubeSearch) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := `{"items":[{"id":{"videoId":"` + y.videoID + `"}}]}`
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+var _ = Describe("LinkRefresh", func() {
+	var (
+		locks   *fakeLocker
+		backend *fakeBackend
+		l       *LinkRefresh
+	)
+
+	BeforeEach(func() {
+		locks = &fakeLocker{held: map[string]bool{}}
+		backend = &fakeBackend{}
+		l = &LinkRefresh{
+			opts: &Options{
+				LinkCheck:   fakeLinkCheck{},
+				ShutdownCtx: context.Background(),
+				PerMinute:   2,
+				Interval:    DefaultInterval,
+			},
+			backend: backend,
+			locks:   locks,
+			log:     clog.New(zap.NewNop()),
+		}
+	})
+
+	Describe("allow", func() {
+		It("should allow PerMinute refreshes per fixed one-minute window", func() {
+			start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+			Expect(l.allow(start)).To(BeTrue())
+			Expect(l.allow(start.Add(10 * time.Second))).To(BeTrue())
+			Expect(l.allow(start.Add(20 * time.Second))).To(BeFalse())
+
+			// The window starts at the first refresh, not on a rolling basis
+			Expect(l.allow(start.Add(59 * time.Second))).To(BeFalse())
+			Expect(l.allow(start.Add(time.Minute))).To(BeTrue())
+			Expect(l.allow(start.Add(time.Minute + time.Second))).To(BeTrue())
+			Expect(l.allow(start.Add(time.Minute + 2*time.Second))).To(BeFalse())
+		})
+	})
+
+	Describe("refresh", func() {
+		It("should check the release once the lock is taken", func() {
+			id := uuid.New()
+
+			l.refresh(id)
+
+			Expect(backend.gets).To(Equal(1))
+			Expect(locks.held).To(HaveKey(lockPrefix + id.String()))
+			Expect(locks.deleted).To(BeEmpty())
+		})
+
+		It("should skip a release whose lock is held", func() {
+			id := uuid.New()
+			locks.held[lockPrefix+id.String()] = true
+
+			l.refresh(id)
+
+			Expect(backend.gets).To(BeZero())
+			Expect(locks.deleted).To(BeEmpty())
+		})
+
+		It("should give the lock back when over the per-minute limit", func() {
+			now := time.Now()
+			Expect(l.allow(now)).To(BeTrue())
+			Expect(l.allow(now)).To(BeTrue())
+
+			id := uuid.New()
+
+			l.refresh(id)
+
+			Expect(backend.gets).To(BeZero())
+			Expect(locks.deleted).To(Equal([]string{lockPrefix + id.String()}))
+			Expect(locks.held).To(BeEmpty())
+		})
+	})
+
+	Describe("refreshYoutube", func() {
+		const oldURL = "https://www.youtube.com/watch?v=old"
+
+		BeforeEach(func() {
+			l.opts.LinkCheck = fakeLinkCheck{status: linkcheck.StatusDead}
+			l.opts.Client = &http.Client{Transport: youtubeSearch{videoID: "new"}}
+			backend.release = gensql.Release{
+				Artist:     "Mgła",
+				Title:      "Age of Excuse",
+				YoutubeUrl: sql.NullString{String: oldURL, Valid: true},
+			}
+		})
+
+		It("should replace a dead link only if it is still the stored one", func() {
+			backend.updatedRows = 1
+			id := uuid.New()
+
+			Expect(l.refreshYoutube(context.Background(), id)).To(Succeed())
+
+			Expect(backend.updates).To(Equal([]gensql.UpdateReleaseYoutubeURLParams{{
+				ID:     id,
+				NewUrl: youtubeWatchBase + "new",
+				OldUrl: oldURL,
+			}}))
+		})
+
+		It("should leave a link edited during the refresh alone", func() {
+			backend.updatedRows = 0
+
+			Expect(l.refreshYoutube(context.Background(), uuid.New())).To(Succeed())
+			Expect(backend.updates).To(HaveLen(1))
+		})
+
+		It("should not touch a live link", func() {
+			l.opts.LinkCheck = fakeLinkCheck{}
+
+			Expect(l.refreshYoutube(context.Background(), uuid.New())).To(Succeed())
+			Expect(backend.updates).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,81 @@
+package release
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// DateFormat is used for all date-only fields (ie. releaseDate)
+	DateFormat = "2006-01-02"
+
+	// TimestampFormat is used for all timestamp fields (ie. createdAt);
+	// timestamps are always rendered in UTC
+	TimestampFormat = time.RFC3339
+)
+
+// Date is a date-only value that marshals to/from JSON as YYYY-MM-DD
+type Date struct {
+	time.Time
+}
+
+// Timestamp is a point in time that marshals to/from JSON as RFC3339 UTC
+type Timestamp struct {
+	time.Time
+}
+
+func NewDate(t time.Time) Date {
+	return Date{Time: t}
+}
+
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Time: t}
+}
+
+func (d Date) String() string {
+	return d.Format(DateFormat)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	t, err := time.Parse(DateFormat, s)
+	if err != nil {
+		return err
+	}
+
+	d.Time = t
+
+	return nil
+}
+
+func (t Timestamp) String() string {
+	return t.UTC().Format(TimestampFormat)
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.Parse(TimestampFormat, s)
+	if err != nil {
+		return err
+	}
+
+	t.Time = parsed.UTC()
+
+	return nil
+}
@@ -1,11 +1,16 @@
 package release
 
 import (
+	"cmp"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"math"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/superpowerdotcom/go-common-lib/clog"
 	"go.uber.org/zap"
@@ -14,8 +19,32 @@ import (
 	"github.com/dselans/blastbeat-api/backends/gensql"
 )
 
+const (
+	// DefaultMaxResults is the hard cap on rows loaded by a single list query
+	DefaultMaxResults = 10000
+
+	// PlaceholderArtPrefix is the art the importer stores when no real
+	// cover was found
+	PlaceholderArtPrefix = "https://via.placeholder.com/"
+)
+
+var (
+	ErrNotFound  = errors.New("release not found")
+	ErrInvalidID = errors.New("invalid release id")
+
+	// ErrConflict means another release already has the same artist, title
+	// and release date (idx_releases_unique_key)
+	ErrConflict = errors.New("release conflicts with an existing release")
+)
+
 type IRelease interface {
-	GetReleases(ctx context.Context, filters *ReleaseFilters) ([]*ReleaseResponse, error)
+	GetReleaseByID(ctx context.Context, id string) (*ReleaseResponse, error)
+	GetReleases(ctx context.Context, filters *ReleaseFilters) (*ReleasesResult, error)
+	GetReleasesNeedingArt(ctx context.Context, limit int) ([]*ReleaseResponse, error)
+	GetReleasesWithInvalidCountry(ctx context.Context, limit int) ([]*ReleaseResponse, error)
+	GetReleasesByQuality(ctx context.Context, limit int, descending bool) ([]*ReleaseResponse, error)
+	UpdateRelease(ctx context.Context, id string, update *ReleaseUpdate) (*ReleaseResponse, error)
+	DeleteRelease(ctx context.Context, id string) error
 }
 
 type Release struct {
@@ -26,9 +55,38 @@ type Release struct {
 type Options struct {
 	Backend *db.DB
 	Log     clog.ICustomLog
+
+	// MaxResults caps the number of rows any list query may load into
+	// memory; defaults to DefaultMaxResults
+	MaxResults int
+
+	// FollowerBuckets adds to (or replaces) DefaultFollowerBuckets by name
+	FollowerBuckets map[string]FollowerBucket
+}
+
+// FollowerBucket is the inclusive follower count range of a
+// ReleaseFilters.FollowerRange value
+type FollowerBucket struct {
+	Min int64
+	Max int64
+}
+
+// DefaultFollowerBuckets are the FollowerRange values every deployment has
+var DefaultFollowerBuckets = map[string]FollowerBucket{
+	"<1K":   {0, 999},
+	"1K+":   {1000, 9999},
+	"10K+":  {10000, 99999},
+	"100K+": {100000, 999999},
+	"1M+":   {1000000, 1999999},
+	"2M+":   {2000000, 4999999},
+	"5M+":   {5000000, math.MaxInt64},
 }
 
 type ReleaseFilters struct {
+	// IDs fetches exactly these releases, returned in the given order
+	IDs []uuid.UUID
+
+	Query            string
 	DateFrom         *time.Time
 	DateTo           *time.Time
 	DateExact        *time.Time
@@ -36,6 +94,129 @@ type ReleaseFilters struct {
 	ExcludedGenres   []string
 	ExcludedKeywords []string
 	FollowerRange    string
+
+	// KeywordFields are the fields ExcludedKeywords are matched against;
+	// empty means title and artist
+	KeywordFields []string
+
+	// IncludedGenresMatch is GenreMatchAll (the default when empty) or
+	// GenreMatchAny
+	IncludedGenresMatch string
+
+	// HasPreview keeps releases with a preview link of this kind
+	// (PreviewSpotify, PreviewYoutube, PreviewBandcamp, PreviewAppleMusic or
+	// PreviewAny);
+	// empty doesn't filter
+	HasPreview string
+
+	// FollowerMin and FollowerMax bound FollowerCount (inclusive; unset is
+	// unbounded). When either is set FollowerRange is ignored.
+	FollowerMin *int64
+	FollowerMax *int64
+
+	// IncludedCountries and ExcludedCountries are ISO 3166-1 alpha-2 codes,
+	// matched case-insensitively. Releases without a country only match
+	// when IncludedCountries is empty.
+	IncludedCountries []string
+	ExcludedCountries []string
+
+	// Labels keeps releases whose label contains any of these
+	// (case-insensitive); releases without a label never match
+	Labels []string
+
+	// ExcludedLabels drops releases whose label contains any of these
+	// (case-insensitive)
+	ExcludedLabels []string
+
+	// MinGenres and MaxGenres bound the number of genres on a release
+	MinGenres *int
+	MaxGenres *int
+
+	// Limit and Offset page the filtered results; Limit 0 means no limit
+	Limit  int
+	Offset int
+
+	// IncludeQuality sets ReleaseResponse.Quality on every result
+	IncludeQuality bool
+
+	// SortBy and SortDir order the results; empty means release date
+	// descending (or request order for IDs)
+	SortBy  string
+	SortDir string
+}
+
+// IncludedGenres match modes for ReleaseFilters.IncludedGenresMatch
+const (
+	GenreMatchAll = "all"
+	GenreMatchAny = "any"
+)
+
+// Preview kinds for ReleaseFilters.HasPreview
+const (
+	PreviewSpotify    = "spotify"
+	PreviewYoutube    = "youtube"
+	PreviewBandcamp   = "bandcamp"
+	PreviewAppleMusic = "apple_music"
+	PreviewAny        = "any"
+)
+
+// ValidPreview reports whether kind is a supported HasPreview value
+func ValidPreview(kind string) bool {
+	switch kind {
+	case PreviewSpotify, PreviewYoutube, PreviewBandcamp, PreviewAppleMusic, PreviewAny:
+		return true
+	}
+
+	return false
+}
+
+// Fields for ReleaseFilters.KeywordFields
+const (
+	KeywordFieldTitle  = "title"
+	KeywordFieldArtist = "artist"
+	KeywordFieldLabel  = "label"
+)
+
+// ValidKeywordField reports whether field is a supported keyword field
+func ValidKeywordField(field string) bool {
+	switch field {
+	case KeywordFieldTitle, KeywordFieldArtist, KeywordFieldLabel:
+		return true
+	}
+
+	return false
+}
+
+// Sort keys and directions for ReleaseFilters.SortBy/SortDir
+const (
+	SortByReleaseDate   = "releaseDate"
+	SortByFollowerCount = "followerCount"
+	SortByTitle         = "title"
+
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// ValidSortBy reports whether key is a supported sort key
+func ValidSortBy(key string) bool {
+	switch key {
+	case SortByReleaseDate, SortByFollowerCount, SortByTitle:
+		return true
+	}
+
+	return false
+}
+
+type ReleasesResult struct {
+	// Releases is empty, not nil, when nothing matches
+	Releases []*ReleaseResponse
+
+	// Total is the number of matching releases before Limit/Offset
+	Total int
+
+	// Truncated is set when the underlying query hit MaxResults and more
+	// matching rows exist
+	Truncated bool
 }
 
 type ReleaseResponse struct {
@@ -43,14 +224,19 @@ type ReleaseResponse struct {
 	Title         string         `json:"title"`
 	Artist        string         `json:"artist"`
 	AlbumArt      string         `json:"albumArt"`
-	ReleaseDate   string         `json:"releaseDate"`
+	ReleaseDate   Date           `json:"releaseDate"`
 	Label         string         `json:"label"`
 	LabelUrl      *string        `json:"labelUrl,omitempty"`
-	FollowerCount int32          `json:"followerCount"`
+	FollowerCount int64          `json:"followerCount"`
 	Genres        []string       `json:"genres"`
 	Country       *string        `json:"country,omitempty"`
 	ExternalLinks []ExternalLink `json:"externalLinks,omitempty"`
 	PreviewLinks  PreviewLinks   `json:"previewLinks"`
+	CreatedAt     Timestamp      `json:"createdAt"`
+	UpdatedAt     Timestamp      `json:"updatedAt"`
+
+	// Quality is the 0-100 completeness score; only set when requested
+	Quality *int `json:"quality,omitempty"`
 }
 
 type ExternalLink struct {
@@ -59,9 +245,10 @@ type ExternalLink struct {
 }
 
 type PreviewLinks struct {
-	Spotify  *string `json:"spotify,omitempty"`
-	Youtube  *string `json:"youtube,omitempty"`
-	Bandcamp *string `json:"bandcamp,omitempty"`
+	Spotify    *string `json:"spotify,omitempty"`
+	Youtube    *string `json:"youtube,omitempty"`
+	Bandcamp   *string `json:"bandcamp,omitempty"`
+	AppleMusic *string `json:"appleMusic,omitempty"`
 }
 
 func New(opts *Options) (*Release, error) {
@@ -88,47 +275,74 @@ func validateOptions(opts *Options) error {
 		return errors.New("log cannot be nil")
 	}
 
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = DefaultMaxResults
+	}
+
+	buckets := make(map[string]FollowerBucket, len(DefaultFollowerBuckets)+len(opts.FollowerBuckets))
+	for name, b := range DefaultFollowerBuckets {
+		buckets[name] = b
+	}
+
+	for name, b := range opts.FollowerBuckets {
+		if b.Min > b.Max {
+			return errors.Errorf("follower bucket %q has min greater than max", name)
+		}
+
+		buckets[name] = b
+	}
+
+	opts.FollowerBuckets = buckets
+
 	return nil
 }
 
 func (r *Release) GetReleases(ctx context.Context,
-	filters *ReleaseFilters) ([]*ReleaseResponse, error) {
+	filters *ReleaseFilters) (*ReleasesResult, error) {
 	logger := r.log.With(zap.String("method", "GetReleases"))
 	logger.Debug("Fetching releases", zap.Any("filters", filters))
 
 	var dbReleases []gensql.Release
 	var err error
 
-	if filters.DateExact != nil {
-		dbReleases, err = r.opts.Backend.ListReleasesByExactDate(ctx,
-			*filters.DateExact)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to fetch releases by exact date")
-		}
-	} else if filters.DateFrom != nil {
-		var dateTo time.Time
+	// Fetch one extra row so we can tell whether the cap truncated results
+	limit := int32(r.opts.MaxResults + 1)
 
-		if filters.DateTo != nil {
-			dateTo = *filters.DateTo
-		} else {
-			dateTo = *filters.DateFrom
+	if len(filters.IDs) > 0 {
+		dbReleases, err = r.opts.Backend.ListReleasesByIDs(ctx, filters.IDs)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch releases by ids")
 		}
 
-		dbReleases, err = r.opts.Backend.ListReleasesByDateRange(ctx,
-			gensql.ListReleasesByDateRangeParams{
-				ReleaseDate:   *filters.DateFrom,
-				ReleaseDate_2: dateTo,
+		dbReleases = orderByIDs(dbReleases, filters.IDs)
+	} else if filters.Query != "" {
+		// Search is accent-insensitive; date filters are applied in
+		// applyFilters for this path
+		dbReleases, err = r.opts.Backend.SearchReleases(ctx,
+			gensql.SearchReleasesParams{
+				Column1: filters.Query,
+				Limit:   limit,
 			})
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to fetch releases by date range")
+			return nil, errors.Wrap(err, "failed to search releases")
 		}
 	} else {
-		dbReleases, err = r.opts.Backend.ListReleases(ctx)
+		// Dates, genres and followers are filtered in SQL so MaxResults caps
+		// matching rows rather than the newest rows overall
+		dbReleases, err = r.opts.Backend.ListReleasesFiltered(ctx,
+			filteredParams(filters, r.opts.FollowerBuckets, limit))
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to fetch releases")
+			return nil, errors.Wrap(err, "failed to fetch filtered releases")
 		}
 	}
 
+	truncated := len(dbReleases) > r.opts.MaxResults
+	if truncated {
+		logger.Warn("List query hit max results cap, truncating",
+			zap.Int("maxResults", r.opts.MaxResults))
+		dbReleases = dbReleases[:r.opts.MaxResults]
+	}
+
 	// Convert to response format
 	releases := make([]*ReleaseResponse, 0, len(dbReleases))
 	for _, dbRelease := range dbReleases {
@@ -136,12 +350,245 @@ func (r *Release) GetReleases(ctx context.Context,
 		releases = append(releases, release)
 	}
 
+	// The ID and search paths are only filtered here; for the rest this is
+	// a no-op for the SQL filters and applies keywords, countries and labels
 	releases = r.applyFilters(releases, filters)
+	total := len(releases)
+
+	// Batch fetches keep the requested order unless a sort is asked for;
+	// everything else gets a total order so pages never overlap or skip rows
+	if len(filters.IDs) == 0 || filters.SortBy != "" {
+		sortReleases(releases, filters.SortBy, filters.SortDir)
+	}
+
+	if filters.IncludeQuality {
+		for _, release := range releases {
+			setQuality(release)
+		}
+	}
+
+	releases = Paginate(releases, filters.Limit, filters.Offset)
+
+	logger.Debug("Returning releases", zap.Int("count", len(releases)),
+		zap.Int("total", total))
+
+	return &ReleasesResult{
+		Releases:  releases,
+		Total:     total,
+		Truncated: truncated,
+	}, nil
+}
+
+// GetReleaseByID returns a single release; ErrInvalidID when id is not a
+// UUID and ErrNotFound when there is no such release
+func (r *Release) GetReleaseByID(ctx context.Context, id string) (*ReleaseResponse, error) {
+	releaseID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	dbRelease, err := r.opts.Backend.GetRelease(ctx, releaseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+
+		return nil, errors.Wrap(err, "failed to fetch release")
+	}
+
+	return convertDBReleaseToResponse(dbRelease), nil
+}
+
+// GetReleasesNeedingArt returns up to limit releases that still use
+// placeholder (or no) art, most followed first
+func (r *Release) GetReleasesNeedingArt(ctx context.Context, limit int) ([]*ReleaseResponse, error) {
+	if limit <= 0 || limit > r.opts.MaxResults {
+		limit = r.opts.MaxResults
+	}
+
+	dbReleases, err := r.opts.Backend.ListReleasesNeedingArt(ctx, int32(limit))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch releases needing art")
+	}
+
+	releases := make([]*ReleaseResponse, 0, len(dbReleases))
+	for _, dbRelease := range dbReleases {
+		releases = append(releases, convertDBReleaseToResponse(dbRelease))
+	}
+
+	return releases, nil
+}
+
+// GetReleasesWithInvalidCountry returns up to limit releases whose country
+// isn't an ISO 3166-1 alpha-2 code, grouped by country
+func (r *Release) GetReleasesWithInvalidCountry(ctx context.Context, limit int) ([]*ReleaseResponse, error) {
+	if limit <= 0 || limit > r.opts.MaxResults {
+		limit = r.opts.MaxResults
+	}
+
+	dbReleases, err := r.opts.Backend.ListReleasesWithInvalidCountry(ctx, gensql.ListReleasesWithInvalidCountryParams{
+		Codes:    CountryCodes(),
+		RowLimit: int32(limit),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch releases with invalid country")
+	}
+
+	releases := make([]*ReleaseResponse, 0, len(dbReleases))
+	for _, dbRelease := range dbReleases {
+		releases = append(releases, convertDBReleaseToResponse(dbRelease))
+	}
 
-	logger.Debug("Returning releases", zap.Int("count", len(releases)))
 	return releases, nil
 }
 
+// GetReleasesByQuality returns up to limit releases sorted by completeness
+// score, least complete first unless descending. Scores are computed in
+// memory over at most MaxResults releases.
+func (r *Release) GetReleasesByQuality(ctx context.Context, limit int,
+	descending bool) ([]*ReleaseResponse, error) {
+	dbReleases, err := r.opts.Backend.ListReleases(ctx, int32(r.opts.MaxResults))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch releases")
+	}
+
+	releases := make([]*ReleaseResponse, 0, len(dbReleases))
+	for _, dbRelease := range dbReleases {
+		releases = append(releases, convertDBReleaseToResponse(dbRelease))
+	}
+
+	sortByQuality(releases, descending)
+
+	return Paginate(releases, limit, 0), nil
+}
+
+// sortByQuality sets each release's quality and sorts by it, least complete
+// first unless descending; ties keep their order
+func sortByQuality(releases []*ReleaseResponse, descending bool) {
+	for _, release := range releases {
+		setQuality(release)
+	}
+
+	sort.SliceStable(releases, func(i, j int) bool {
+		if descending {
+			return *releases[i].Quality > *releases[j].Quality
+		}
+
+		return *releases[i].Quality < *releases[j].Quality
+	})
+}
+
+// CompletenessScore rates how many curatable fields are populated, 0-100.
+// Each of country, real art, genres, the four preview links and label URL
+// counts equally.
+func CompletenessScore(r *ReleaseResponse) int {
+	checks := []bool{
+		r.Country != nil && *r.Country != "",
+		r.AlbumArt != "" && !strings.HasPrefix(r.AlbumArt, PlaceholderArtPrefix),
+		len(r.Genres) > 0,
+		r.PreviewLinks.Spotify != nil,
+		r.PreviewLinks.Youtube != nil,
+		r.PreviewLinks.Bandcamp != nil,
+		r.PreviewLinks.AppleMusic != nil,
+		r.LabelUrl != nil && *r.LabelUrl != "",
+	}
+
+	populated := 0
+
+	for _, ok := range checks {
+		if ok {
+			populated++
+		}
+	}
+
+	return int(math.Round(float64(populated) * 100 / float64(len(checks))))
+}
+
+func setQuality(r *ReleaseResponse) {
+	q := CompletenessScore(r)
+	r.Quality = &q
+}
+
+// sortReleases orders releases by sortBy/sortDir (default release date
+// descending). Ties fall back to newest release date, then creation time,
+// then id, so the order is total.
+func sortReleases(releases []*ReleaseResponse, sortBy, sortDir string) {
+	if sortBy == "" {
+		sortBy, sortDir = SortByReleaseDate, SortDesc
+	}
+
+	desc := sortDir == SortDesc
+
+	sort.SliceStable(releases, func(i, j int) bool {
+		a, b := releases[i], releases[j]
+
+		if c := compareBy(a, b, sortBy); c != 0 {
+			if desc {
+				return c > 0
+			}
+
+			return c < 0
+		}
+
+		if !a.ReleaseDate.Equal(b.ReleaseDate.Time) {
+			return a.ReleaseDate.After(b.ReleaseDate.Time)
+		}
+
+		if !a.CreatedAt.Equal(b.CreatedAt.Time) {
+			return a.CreatedAt.After(b.CreatedAt.Time)
+		}
+
+		return a.ID < b.ID
+	})
+}
+
+// compareBy compares a and b on a single sort key: -1, 0 or 1
+func compareBy(a, b *ReleaseResponse, sortBy string) int {
+	switch sortBy {
+	case SortByFollowerCount:
+		return cmp.Compare(a.FollowerCount, b.FollowerCount)
+	case SortByTitle:
+		return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	default:
+		return a.ReleaseDate.Compare(b.ReleaseDate.Time)
+	}
+}
+
+// Paginate returns the limit/offset window of items; limit <= 0 means
+// everything after offset. The API's other list endpoints page with it too.
+func Paginate[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+
+	items = items[offset:]
+
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+
+	return items
+}
+
+// orderByIDs returns releases in the order of ids; ids with no matching
+// release are skipped
+func orderByIDs(releases []gensql.Release, ids []uuid.UUID) []gensql.Release {
+	byID := make(map[uuid.UUID]gensql.Release, len(releases))
+	for _, r := range releases {
+		byID[r.ID] = r
+	}
+
+	ordered := make([]gensql.Release, 0, len(releases))
+
+	for _, id := range ids {
+		if r, ok := byID[id]; ok {
+			ordered = append(ordered, r)
+		}
+	}
+
+	return ordered
+}
+
 func convertDBReleaseToResponse(
 	dbRelease gensql.Release) *ReleaseResponse {
 	var genres []string
@@ -162,12 +609,14 @@ func convertDBReleaseToResponse(
 		Title:         dbRelease.Title,
 		Artist:        dbRelease.Artist,
 		AlbumArt:      dbRelease.AlbumArtUrl,
-		ReleaseDate:   dbRelease.ReleaseDate.Format("2006-01-02"),
+		ReleaseDate:   NewDate(dbRelease.ReleaseDate),
 		Label:         dbRelease.Label,
 		FollowerCount: dbRelease.FollowerCount,
 		Genres:        genres,
 		ExternalLinks: externalLinks,
 		PreviewLinks:  PreviewLinks{},
+		CreatedAt:     NewTimestamp(dbRelease.CreatedAt),
+		UpdatedAt:     NewTimestamp(dbRelease.UpdatedAt),
 	}
 
 	// Handle optional fields
@@ -191,6 +640,10 @@ func convertDBReleaseToResponse(
 		response.PreviewLinks.Bandcamp = &dbRelease.BandcampUrl.String
 	}
 
+	if dbRelease.AppleMusicUrl.Valid {
+		response.PreviewLinks.AppleMusic = &dbRelease.AppleMusicUrl.String
+	}
+
 	return response
 }
 
@@ -199,8 +652,20 @@ func (r *Release) applyFilters(releases []*ReleaseResponse,
 	filtered := make([]*ReleaseResponse, 0)
 
 	for _, release := range releases {
+		if !matchesDates(release.ReleaseDate.Time, filters) {
+			continue
+		}
+
+		if !matchesGenreCount(len(release.Genres), filters) {
+			continue
+		}
+
 		if len(filters.IncludedGenres) > 0 {
-			if !hasAllGenres(release.Genres,
+			if filters.IncludedGenresMatch == GenreMatchAny {
+				if !hasAnyGenre(release.Genres, filters.IncludedGenres) {
+					continue
+				}
+			} else if !hasAllGenres(release.Genres,
 				filters.IncludedGenres) {
 				continue
 			}
@@ -214,17 +679,31 @@ func (r *Release) applyFilters(releases []*ReleaseResponse,
 		}
 
 		if len(filters.ExcludedKeywords) > 0 {
-			if containsKeywords(release.Title, release.Artist,
+			if containsKeywords(keywordText(release, filters.KeywordFields),
 				filters.ExcludedKeywords) {
 				continue
 			}
 		}
 
-		if filters.FollowerRange != "" {
-			if !matchesFollowerRange(release.FollowerCount,
-				filters.FollowerRange) {
-				continue
-			}
+		if !matchesFollowers(release.FollowerCount, filters, r.opts.FollowerBuckets) {
+			continue
+		}
+
+		if !matchesCountry(release.Country, filters) {
+			continue
+		}
+
+		if !hasPreview(release.PreviewLinks, filters.HasPreview) {
+			continue
+		}
+
+		if len(filters.Labels) > 0 && !matchesAnyLabel(release.Label, filters.Labels) {
+			continue
+		}
+
+		if len(filters.ExcludedLabels) > 0 &&
+			matchesAnyLabel(release.Label, filters.ExcludedLabels) {
+			continue
 		}
 
 		filtered = append(filtered, release)
@@ -233,6 +712,174 @@ func (r *Release) applyFilters(releases []*ReleaseResponse,
 	return filtered
 }
 
+// matchesDates mirrors the date semantics of the date queries: dateExact wins,
+// otherwise dateFrom..dateTo (dateTo defaults to dateFrom)
+func matchesDates(releaseDate time.Time, filters *ReleaseFilters) bool {
+	if filters.DateExact != nil {
+		return releaseDate.Equal(*filters.DateExact)
+	}
+
+	if filters.DateFrom == nil {
+		return true
+	}
+
+	dateTo := *filters.DateFrom
+	if filters.DateTo != nil {
+		dateTo = *filters.DateTo
+	}
+
+	return !releaseDate.Before(*filters.DateFrom) && !releaseDate.After(dateTo)
+}
+
+// dateBounds returns the inclusive date range matchesDates applies; nil
+// bounds are open-ended
+func dateBounds(filters *ReleaseFilters) (*time.Time, *time.Time) {
+	if filters.DateExact != nil {
+		return filters.DateExact, filters.DateExact
+	}
+
+	if filters.DateFrom == nil {
+		return nil, nil
+	}
+
+	if filters.DateTo != nil {
+		return filters.DateFrom, filters.DateTo
+	}
+
+	return filters.DateFrom, filters.DateFrom
+}
+
+// filteredParams maps filters onto ListReleasesFiltered, which applies the
+// date, genre and follower filters the same way applyFilters does
+func filteredParams(filters *ReleaseFilters, buckets map[string]FollowerBucket,
+	limit int32) gensql.ListReleasesFilteredParams {
+	minGenres, maxGenres := genreCountBounds(filters)
+	followerMin, followerMax := followerBounds(filters, buckets)
+
+	params := gensql.ListReleasesFilteredParams{
+		MinGenres:      int32(minGenres),
+		MaxGenres:      int32(maxGenres),
+		FollowerMin:    followerMin,
+		FollowerMax:    followerMax,
+		IncludedGenres: lowerAll(filters.IncludedGenres),
+		GenresMatchAny: filters.IncludedGenresMatch == GenreMatchAny,
+		ExcludedGenres: lowerAll(filters.ExcludedGenres),
+		HasPreview:     filters.HasPreview,
+		RowLimit:       limit,
+	}
+
+	dateFrom, dateTo := dateBounds(filters)
+
+	if dateFrom != nil {
+		params.DateFrom = sql.NullTime{Time: *dateFrom, Valid: true}
+	}
+
+	if dateTo != nil {
+		params.DateTo = sql.NullTime{Time: *dateTo, Valid: true}
+	}
+
+	return params
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, 0, len(values))
+	for _, v := range values {
+		lowered = append(lowered, strings.ToLower(v))
+	}
+
+	return lowered
+}
+
+// genreCountBounds returns the inclusive genre count range for filters;
+// unset bounds are open-ended
+func genreCountBounds(filters *ReleaseFilters) (int, int) {
+	minGenres, maxGenres := 0, math.MaxInt32
+
+	if filters.MinGenres != nil {
+		minGenres = *filters.MinGenres
+	}
+
+	if filters.MaxGenres != nil {
+		maxGenres = *filters.MaxGenres
+	}
+
+	return minGenres, maxGenres
+}
+
+// matchesGenreCount mirrors the genre count bounds of ListReleasesFiltered
+// for the paths that don't filter in SQL
+func matchesGenreCount(count int, filters *ReleaseFilters) bool {
+	minGenres, maxGenres := genreCountBounds(filters)
+
+	return count >= minGenres && count <= maxGenres
+}
+
+// matchesCountry applies IncludedCountries/ExcludedCountries to a release's
+// country code
+func matchesCountry(country *string, filters *ReleaseFilters) bool {
+	if country == nil || *country == "" {
+		return len(filters.IncludedCountries) == 0
+	}
+
+	if len(filters.IncludedCountries) > 0 &&
+		!containsFold(filters.IncludedCountries, *country) {
+		return false
+	}
+
+	return !containsFold(filters.ExcludedCountries, *country)
+}
+
+// hasPreview mirrors the HasPreview filter of ListReleasesFiltered
+func hasPreview(links PreviewLinks, kind string) bool {
+	set := func(u *string) bool {
+		return u != nil && *u != ""
+	}
+
+	switch kind {
+	case "":
+		return true
+	case PreviewSpotify:
+		return set(links.Spotify)
+	case PreviewYoutube:
+		return set(links.Youtube)
+	case PreviewBandcamp:
+		return set(links.Bandcamp)
+	case PreviewAppleMusic:
+		return set(links.AppleMusic)
+	case PreviewAny:
+		return set(links.Spotify) || set(links.Youtube) || set(links.Bandcamp) || set(links.AppleMusic)
+	}
+
+	return false
+}
+
+// matchesAnyLabel reports whether label contains any of labels as a
+// case-insensitive substring
+func matchesAnyLabel(label string, labels []string) bool {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if label == "" {
+		return false
+	}
+
+	for _, l := range labels {
+		if strings.Contains(label, strings.ToLower(l)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(s)) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func hasAllGenres(releaseGenres []string,
 	requiredGenres []string) bool {
 	releaseGenreMap := make(map[string]bool)
@@ -262,8 +909,35 @@ func hasAnyGenre(releaseGenres []string, excludedGenres []string) bool {
 	return false
 }
 
-func containsKeywords(title, artist string, keywords []string) bool {
-	text := strings.ToLower(title + " " + artist)
+// keywordText joins the fields of release that excluded keywords are matched
+// against, in title, artist, label order; no fields means title and artist
+func keywordText(release *ReleaseResponse, fields []string) string {
+	if len(fields) == 0 {
+		fields = []string{KeywordFieldTitle, KeywordFieldArtist}
+	}
+
+	var parts []string
+
+	for _, field := range []string{KeywordFieldTitle, KeywordFieldArtist, KeywordFieldLabel} {
+		if !containsFold(fields, field) {
+			continue
+		}
+
+		switch field {
+		case KeywordFieldTitle:
+			parts = append(parts, release.Title)
+		case KeywordFieldArtist:
+			parts = append(parts, release.Artist)
+		case KeywordFieldLabel:
+			parts = append(parts, release.Label)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func containsKeywords(text string, keywords []string) bool {
+	text = strings.ToLower(text)
 	for _, keyword := range keywords {
 		if strings.Contains(text, strings.ToLower(keyword)) {
 			return true
@@ -272,24 +946,35 @@ func containsKeywords(title, artist string, keywords []string) bool {
 	return false
 }
 
-func matchesFollowerRange(followerCount int32, rangeKey string) bool {
-	buckets := map[string]struct {
-		min int32
-		max int32
-	}{
-		"<1K":   {0, 999},
-		"1K+":   {1000, 9999},
-		"10K+":  {10000, 99999},
-		"100K+": {100000, 999999},
-		"1M+":   {1000000, 1999999},
-		"2M+":   {2000000, 4999999},
-		"5M+":   {5000000, 2147483647}, // Max int32
+// followerBounds returns the inclusive follower count range matchesFollowers
+// applies, looking FollowerRange up in buckets
+func followerBounds(filters *ReleaseFilters, buckets map[string]FollowerBucket) (int64, int64) {
+	followerMin, followerMax := int64(math.MinInt64), int64(math.MaxInt64)
+
+	if filters.FollowerMin == nil && filters.FollowerMax == nil {
+		if bucket, ok := buckets[filters.FollowerRange]; ok {
+			followerMin, followerMax = bucket.Min, bucket.Max
+		}
+
+		return followerMin, followerMax
+	}
+
+	if filters.FollowerMin != nil {
+		followerMin = *filters.FollowerMin
 	}
 
-	bucket, exists := buckets[rangeKey]
-	if !exists {
-		return true // Unknown range, don't filter
+	if filters.FollowerMax != nil {
+		followerMax = *filters.FollowerMax
 	}
 
-	return followerCount >= bucket.min && followerCount <= bucket.max
+	return followerMin, followerMax
+}
+
+// matchesFollowers applies FollowerMin/FollowerMax, falling back to the
+// FollowerRange bucket when neither is set; an unknown bucket doesn't filter
+func matchesFollowers(followerCount int64, filters *ReleaseFilters,
+	buckets map[string]FollowerBucket) bool {
+	followerMin, followerMax := followerBounds(filters, buckets)
+
+	return followerCount >= followerMin && followerCount <= followerMax
 }
@@ -0,0 +1,535 @@
+package release
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/db"
+	"github.com/dselans/blastbeat-api/backends/gensql"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Release", func() {
+	day := func(s string) *time.Time {
+		t, err := time.Parse("2006-01-02", s)
+		Expect(err).ToNot(HaveOccurred())
+		return &t
+	}
+
+	int64Ptr := func(v int64) *int64 { return &v }
+
+	newRelease := func() *Release {
+		return &Release{opts: &Options{FollowerBuckets: DefaultFollowerBuckets}}
+	}
+	intPtr := func(v int) *int { return &v }
+
+	Describe("filteredParams", func() {
+		It("should use dateExact for both bounds", func() {
+			p := filteredParams(&ReleaseFilters{
+				DateExact: day("2024-05-01"),
+				DateFrom:  day("2024-01-01"),
+			}, DefaultFollowerBuckets, 10)
+
+			Expect(p.DateFrom.Valid).To(BeTrue())
+			Expect(p.DateTo.Valid).To(BeTrue())
+			Expect(p.DateFrom.Time).To(Equal(*day("2024-05-01")))
+			Expect(p.DateTo.Time).To(Equal(*day("2024-05-01")))
+			Expect(p.RowLimit).To(Equal(int32(10)))
+		})
+
+		It("should default dateTo to dateFrom and leave unset dates open", func() {
+			p := filteredParams(&ReleaseFilters{DateFrom: day("2024-01-01")}, DefaultFollowerBuckets, 10)
+			Expect(p.DateTo.Time).To(Equal(*day("2024-01-01")))
+
+			p = filteredParams(&ReleaseFilters{}, DefaultFollowerBuckets, 10)
+			Expect(p.DateFrom.Valid).To(BeFalse())
+			Expect(p.DateTo.Valid).To(BeFalse())
+		})
+
+		It("should lowercase genres and set the match mode", func() {
+			p := filteredParams(&ReleaseFilters{
+				IncludedGenres:      []string{"Black Metal"},
+				IncludedGenresMatch: GenreMatchAny,
+				ExcludedGenres:      []string{"Metalcore"},
+			}, DefaultFollowerBuckets, 10)
+
+			Expect(p.IncludedGenres).To(Equal([]string{"black metal"}))
+			Expect(p.ExcludedGenres).To(Equal([]string{"metalcore"}))
+			Expect(p.GenresMatchAny).To(BeTrue())
+		})
+
+		It("should pass empty genre lists rather than nil", func() {
+			p := filteredParams(&ReleaseFilters{}, DefaultFollowerBuckets, 10)
+
+			Expect(p.IncludedGenres).ToNot(BeNil())
+			Expect(p.ExcludedGenres).ToNot(BeNil())
+			Expect(p.MinGenres).To(Equal(int32(0)))
+			Expect(p.MaxGenres).To(Equal(int32(math.MaxInt32)))
+		})
+
+		It("should turn follower ranges into bounds", func() {
+			p := filteredParams(&ReleaseFilters{FollowerRange: "10K+"}, DefaultFollowerBuckets, 10)
+			Expect(p.FollowerMin).To(Equal(int64(10000)))
+			Expect(p.FollowerMax).To(Equal(int64(99999)))
+
+			p = filteredParams(&ReleaseFilters{
+				FollowerRange: "10K+",
+				FollowerMin:   int64Ptr(5),
+			}, DefaultFollowerBuckets, 10)
+			Expect(p.FollowerMin).To(Equal(int64(5)))
+			Expect(p.FollowerMax).To(Equal(int64(math.MaxInt64)))
+
+			p = filteredParams(&ReleaseFilters{FollowerRange: "bogus"}, DefaultFollowerBuckets, 10)
+			Expect(p.FollowerMin).To(Equal(int64(math.MinInt64)))
+			Expect(p.FollowerMax).To(Equal(int64(math.MaxInt64)))
+		})
+	})
+
+	Describe("validateOptions", func() {
+		It("should add configured follower buckets to the defaults", func() {
+			opts := &Options{
+				Backend:         &db.DB{},
+				Log:             clog.New(zap.NewNop()),
+				FollowerBuckets: map[string]FollowerBucket{"500K+": {500000, 999999}},
+			}
+
+			Expect(validateOptions(opts)).To(Succeed())
+			Expect(opts.FollowerBuckets).To(HaveKeyWithValue("500K+", FollowerBucket{500000, 999999}))
+			Expect(opts.FollowerBuckets).To(HaveKeyWithValue("1M+", DefaultFollowerBuckets["1M+"]))
+
+			r := &Release{opts: opts}
+			filtered := r.applyFilters([]*ReleaseResponse{
+				{ID: "a", FollowerCount: 400000},
+				{ID: "b", FollowerCount: 600000},
+			}, &ReleaseFilters{FollowerRange: "500K+"})
+
+			Expect(filtered).To(HaveLen(1))
+			Expect(filtered[0].ID).To(Equal("b"))
+		})
+
+		It("should reject a bucket with min greater than max", func() {
+			Expect(validateOptions(&Options{
+				Backend:         &db.DB{},
+				Log:             clog.New(zap.NewNop()),
+				FollowerBuckets: map[string]FollowerBucket{"bad": {10, 1}},
+			})).ToNot(Succeed())
+		})
+	})
+
+	Describe("applyFilters", func() {
+		releases := []*ReleaseResponse{
+			{ID: "a", Title: "Live Bootleg", Label: "Bootleg Records", Genres: []string{"Doom Metal"}},
+			{ID: "b", Title: "Forest", Label: "Nuclear Blast", Genres: []string{"Doom Metal"}},
+			{ID: "c", Title: "Demo", Label: "", Genres: []string{"Doom Metal"}},
+			{ID: "d", Title: "Best Of", Label: "Relapse Records", Genres: []string{"Sludge"}},
+		}
+
+		ids := func(releases []*ReleaseResponse) []string {
+			out := []string{}
+			for _, r := range releases {
+				out = append(out, r.ID)
+			}
+
+			return out
+		}
+
+		It("should drop an included genre with an excluded label", func() {
+			filtered := newRelease().applyFilters(releases, &ReleaseFilters{
+				IncludedGenres: []string{"doom metal"},
+				ExcludedLabels: []string{"BOOTLEG"},
+			})
+
+			Expect(ids(filtered)).To(Equal([]string{"b", "c"}))
+		})
+
+		It("should combine excluded labels with the other exclusions", func() {
+			filtered := newRelease().applyFilters(releases, &ReleaseFilters{
+				ExcludedLabels:   []string{"nuclear"},
+				ExcludedGenres:   []string{"sludge"},
+				ExcludedKeywords: []string{"demo"},
+			})
+
+			Expect(ids(filtered)).To(Equal([]string{"a"}))
+		})
+
+		It("should keep only releases with the requested preview", func() {
+			spotify, apple, empty := "https://open.spotify.com/album/1", "https://music.apple.com/us/album/x/1", ""
+
+			withPreviews := []*ReleaseResponse{
+				{ID: "a", PreviewLinks: PreviewLinks{Spotify: &spotify}},
+				{ID: "b", PreviewLinks: PreviewLinks{Bandcamp: &empty}},
+				{ID: "c"},
+				{ID: "d", PreviewLinks: PreviewLinks{AppleMusic: &apple}},
+			}
+
+			Expect(ids(newRelease().applyFilters(withPreviews,
+				&ReleaseFilters{HasPreview: PreviewSpotify}))).To(Equal([]string{"a"}))
+			Expect(ids(newRelease().applyFilters(withPreviews,
+				&ReleaseFilters{HasPreview: PreviewBandcamp}))).To(BeEmpty())
+			Expect(ids(newRelease().applyFilters(withPreviews,
+				&ReleaseFilters{HasPreview: PreviewAppleMusic}))).To(Equal([]string{"d"}))
+			Expect(ids(newRelease().applyFilters(withPreviews,
+				&ReleaseFilters{HasPreview: PreviewAny}))).To(Equal([]string{"a", "d"}))
+		})
+
+		It("should only match keywords against the label when asked to", func() {
+			filtered := newRelease().applyFilters(releases, &ReleaseFilters{
+				ExcludedKeywords: []string{"RELAPSE"},
+			})
+			Expect(ids(filtered)).To(Equal([]string{"a", "b", "c", "d"}))
+
+			filtered = newRelease().applyFilters(releases, &ReleaseFilters{
+				ExcludedKeywords: []string{"RELAPSE"},
+				KeywordFields:    []string{KeywordFieldTitle, KeywordFieldLabel},
+			})
+			Expect(ids(filtered)).To(Equal([]string{"a", "b", "c"}))
+		})
+	})
+
+	// Runs against a real database when BLASTBEAT_API_TEST_DB_HOST is set,
+	// e.g. the docker compose Postgres:
+	//
+	//	BLASTBEAT_API_TEST_DB_HOST=localhost go test ./services/release/...
+	Describe("applyUpdate", func() {
+		strPtr := func(v string) *string { return &v }
+
+		current := gensql.Release{
+			ID:            uuid.New(),
+			Title:         "De Mysteriis Dom Sathanas",
+			Artist:        "Mayhem",
+			AlbumArtUrl:   PlaceholderArtPrefix + "300",
+			ReleaseDate:   *day("1994-05-24"),
+			Label:         "Deathlike Silence",
+			FollowerCount: 1000,
+			Genres:        []byte(`["black metal"]`),
+			Country:       sql.NullString{String: "NO", Valid: true},
+			ExternalLinks: []byte(`{"spotify":"https://open.spotify.com/album/old","discogs":"https://discogs.com/x"}`),
+			SpotifyUrl:    sql.NullString{String: "https://open.spotify.com/album/old", Valid: true},
+		}
+
+		It("should only overwrite the fields that are set", func() {
+			p, err := applyUpdate(current, &ReleaseUpdate{
+				AlbumArt: strPtr("https://i.scdn.co/image/real"),
+				Genres:   []string{"black metal", "true norwegian black metal"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(p.AlbumArtUrl).To(Equal("https://i.scdn.co/image/real"))
+			Expect(string(p.Genres)).To(Equal(`["black metal","true norwegian black metal"]`))
+			Expect(p.Title).To(Equal(current.Title))
+			Expect(p.Country).To(Equal(current.Country))
+			Expect(p.FollowerCount).To(Equal(current.FollowerCount))
+			Expect(p.SpotifyUrl).To(Equal(current.SpotifyUrl))
+		})
+
+		It("should clear optional fields set to an empty string", func() {
+			p, err := applyUpdate(current, &ReleaseUpdate{Country: strPtr("")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.Country.Valid).To(BeFalse())
+		})
+
+		It("should keep preview links and external links in sync", func() {
+			p, err := applyUpdate(current, &ReleaseUpdate{PreviewLinks: &PreviewLinksUpdate{
+				Spotify: strPtr(""),
+				Youtube: strPtr("https://www.youtube.com/watch?v=new"),
+			}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(p.SpotifyUrl.Valid).To(BeFalse())
+			Expect(p.YoutubeUrl.String).To(Equal("https://www.youtube.com/watch?v=new"))
+			Expect(string(p.ExternalLinks)).To(MatchJSON(
+				`{"youtube":"https://www.youtube.com/watch?v=new","discogs":"https://discogs.com/x"}`))
+		})
+	})
+
+	Describe("isUniqueViolation", func() {
+		It("should only match SQLSTATE 23505", func() {
+			Expect(isUniqueViolation(errors.Wrap(&pgconn.PgError{Code: "23505"}, "failed"))).To(BeTrue())
+			Expect(isUniqueViolation(&pgconn.PgError{Code: "23503"})).To(BeFalse())
+			Expect(isUniqueViolation(sql.ErrNoRows)).To(BeFalse())
+		})
+	})
+
+	Describe("ReleaseUpdate.Validate", func() {
+		It("should reject empty required fields and bad values", func() {
+			empty, negative, country, notISO := "  ", int64(-1), "NOR", "XW"
+
+			Expect((&ReleaseUpdate{Title: &empty}).Validate()).To(HaveOccurred())
+			Expect((&ReleaseUpdate{Artist: &empty}).Validate()).To(HaveOccurred())
+			Expect((&ReleaseUpdate{FollowerCount: &negative}).Validate()).To(HaveOccurred())
+			Expect((&ReleaseUpdate{Country: &country}).Validate()).To(HaveOccurred())
+			Expect((&ReleaseUpdate{Country: &notISO}).Validate()).To(HaveOccurred())
+		})
+
+		It("should accept ISO 3166-1 alpha-2 codes in any case", func() {
+			for _, c := range []string{"PL", "no", "Gb"} {
+				country := c
+				Expect((&ReleaseUpdate{Country: &country}).Validate()).To(Succeed())
+			}
+		})
+
+		It("should accept an empty update", func() {
+			Expect((&ReleaseUpdate{}).Validate()).To(Succeed())
+		})
+	})
+
+	Describe("CompletenessScore", func() {
+		str := func(s string) *string { return &s }
+
+		It("should count each curatable field equally", func() {
+			cases := []struct {
+				name    string
+				release *ReleaseResponse
+				want    int
+			}{
+				{"empty", &ReleaseResponse{}, 0},
+				{"placeholder art and blank strings", &ReleaseResponse{
+					AlbumArt: PlaceholderArtPrefix + "300",
+					Country:  str(""),
+					LabelUrl: str(""),
+				}, 0},
+				{"country only", &ReleaseResponse{Country: str("SE")}, 13},
+				{"apple music only", &ReleaseResponse{
+					PreviewLinks: PreviewLinks{AppleMusic: str("https://music.apple.com/us/album/1")},
+				}, 13},
+				{"half", &ReleaseResponse{
+					Country:  str("SE"),
+					AlbumArt: "https://i.scdn.co/image/x",
+					Genres:   []string{"black metal"},
+					LabelUrl: str("https://label.example"),
+				}, 50},
+				{"everything", &ReleaseResponse{
+					Country:  str("SE"),
+					AlbumArt: "https://i.scdn.co/image/x",
+					Genres:   []string{"black metal"},
+					LabelUrl: str("https://label.example"),
+					PreviewLinks: PreviewLinks{
+						Spotify:    str("https://open.spotify.com/album/x"),
+						Youtube:    str("https://www.youtube.com/watch?v=x"),
+						Bandcamp:   str("https://x.bandcamp.com/album/x"),
+						AppleMusic: str("https://music.apple.com/us/album/1"),
+					},
+				}, 100},
+			}
+
+			for _, c := range cases {
+				Expect(CompletenessScore(c.release)).To(Equal(c.want), c.name)
+			}
+		})
+	})
+
+	Describe("Paginate", func() {
+		It("should return the requested window", func() {
+			items := []int{1, 2, 3, 4, 5}
+
+			Expect(Paginate(items, 2, 1)).To(Equal([]int{2, 3}))
+			Expect(Paginate(items, 0, 3)).To(Equal([]int{4, 5}))
+			Expect(Paginate(items, 10, 5)).To(BeEmpty())
+		})
+	})
+
+	Describe("sortByQuality", func() {
+		str := func(s string) *string { return &s }
+
+		releases := func() []*ReleaseResponse {
+			return []*ReleaseResponse{
+				{ID: "some", Country: str("SE"), Genres: []string{"doom metal"}},
+				{ID: "none"},
+				{ID: "most", Country: str("SE"), Genres: []string{"doom metal"}, LabelUrl: str("https://label.example")},
+				{ID: "none-2"},
+			}
+		}
+
+		ids := func(releases []*ReleaseResponse) []string {
+			out := []string{}
+			for _, r := range releases {
+				out = append(out, r.ID)
+			}
+
+			return out
+		}
+
+		It("should put the least complete first and keep ties in order", func() {
+			rs := releases()
+			sortByQuality(rs, false)
+
+			Expect(ids(rs)).To(Equal([]string{"none", "none-2", "some", "most"}))
+			Expect(*rs[0].Quality).To(BeZero())
+			Expect(*rs[3].Quality).To(Equal(38))
+		})
+
+		It("should put the most complete first when descending", func() {
+			rs := releases()
+			sortByQuality(rs, true)
+
+			Expect(ids(rs)).To(Equal([]string{"most", "some", "none", "none-2"}))
+		})
+	})
+
+	Describe("ListReleasesFiltered", func() {
+		var (
+			ctx      context.Context
+			backend  *db.DB
+			fixtures []*ReleaseResponse
+		)
+
+		// preview is the link column that gets a URL; "blank" stores an
+		// empty Bandcamp URL, which doesn't count as a preview
+		fixture := []struct {
+			date      string
+			followers int64
+			genres    []string
+			preview   string
+		}{
+			{"1901-01-01", 500, []string{"Black Metal"}, PreviewSpotify},
+			{"1901-01-02", 5000, []string{"black metal", "Doom Metal"}, PreviewBandcamp},
+			{"1901-01-02", 50000, []string{"Doom Metal", "Sludge"}, ""},
+			{"1901-01-03", 150000, []string{"Death Metal", "Grindcore", "Black Metal"}, PreviewYoutube},
+			{"1901-01-04", 1500000, []string{}, "blank"},
+			{"1901-01-05", 3000000, []string{"Metalcore"}, PreviewBandcamp},
+			{"1901-01-06", 7000000000, []string{"DEATH METAL", "metalcore"}, ""},
+			{"1901-01-07", 0, []string{"Sludge", "Drone", "Doom Metal", "Post-Metal"}, PreviewSpotify},
+		}
+
+		BeforeEach(func() {
+			host := os.Getenv("BLASTBEAT_API_TEST_DB_HOST")
+			if host == "" {
+				Skip("BLASTBEAT_API_TEST_DB_HOST not set")
+			}
+
+			ctx = context.Background()
+
+			var err error
+
+			backend, err = db.New(&db.Options{
+				User:     "blastbeat",
+				Password: "blastbeat",
+				Host:     host,
+				Port:     db.DefaultPostgreSQLPort,
+				DBName:   "blastbeat",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backend.Migrate(ctx, clog.New(zap.NewNop()))).To(Succeed())
+
+			fixtures = nil
+
+			for i, f := range fixture {
+				genres, err := json.Marshal(f.genres)
+				Expect(err).ToNot(HaveOccurred())
+
+				params := gensql.CreateReleaseParams{
+					ID:            uuid.New(),
+					Title:         "Fixture " + strconv.Itoa(i),
+					Artist:        "filter-fixture",
+					ReleaseDate:   *day(f.date),
+					FollowerCount: f.followers,
+					Genres:        genres,
+					ExternalLinks: json.RawMessage("{}"),
+				}
+
+				link := sql.NullString{String: "https://example.com/" + strconv.Itoa(i), Valid: true}
+
+				switch f.preview {
+				case PreviewSpotify:
+					params.SpotifyUrl = link
+				case PreviewYoutube:
+					params.YoutubeUrl = link
+				case PreviewBandcamp:
+					params.BandcampUrl = link
+				case "blank":
+					params.BandcampUrl = sql.NullString{Valid: true}
+				}
+
+				created, err := backend.CreateRelease(ctx, params)
+				Expect(err).ToNot(HaveOccurred())
+
+				fixtures = append(fixtures, convertDBReleaseToResponse(created))
+			}
+		})
+
+		AfterEach(func() {
+			if backend == nil {
+				return
+			}
+
+			for _, f := range fixtures {
+				_, err := backend.DeleteRelease(ctx, uuid.MustParse(f.ID))
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			backend.GetDB().Close()
+		})
+
+		ids := func(releases []*ReleaseResponse) []string {
+			out := []string{}
+
+			for _, r := range releases {
+				out = append(out, r.ID)
+			}
+
+			sort.Strings(out)
+
+			return out
+		}
+
+		It("should match the in-memory filters", func() {
+			cases := []*ReleaseFilters{
+				{},
+				{DateExact: day("1901-01-02")},
+				{DateFrom: day("1901-01-02"), DateTo: day("1901-01-05")},
+				{DateFrom: day("1901-01-03")},
+				{IncludedGenres: []string{"BLACK METAL"}},
+				{IncludedGenres: []string{"doom metal", "sludge"}},
+				{IncludedGenres: []string{"doom metal", "sludge"}, IncludedGenresMatch: GenreMatchAny},
+				{ExcludedGenres: []string{"Metalcore", "sludge"}},
+				{FollowerRange: "1M+"},
+				{FollowerMin: int64Ptr(5000), FollowerMax: int64Ptr(1500000)},
+				{MinGenres: intPtr(2), MaxGenres: intPtr(3)},
+				{MaxGenres: intPtr(0)},
+				{HasPreview: PreviewBandcamp},
+				{HasPreview: PreviewAny},
+				{HasPreview: PreviewSpotify, IncludedGenres: []string{"doom metal"}},
+				{
+					DateFrom:            day("1901-01-01"),
+					DateTo:              day("1901-01-07"),
+					IncludedGenres:      []string{"Doom Metal", "Death Metal"},
+					IncludedGenresMatch: GenreMatchAny,
+					ExcludedGenres:      []string{"drone"},
+					FollowerMin:         int64Ptr(1000),
+				},
+			}
+
+			r := newRelease()
+
+			for _, filters := range cases {
+				dbReleases, err := backend.ListReleasesFiltered(ctx,
+					filteredParams(filters, DefaultFollowerBuckets, math.MaxInt32))
+				Expect(err).ToNot(HaveOccurred())
+
+				fromSQL := []*ReleaseResponse{}
+				for _, dbRelease := range dbReleases {
+					if dbRelease.Artist == "filter-fixture" {
+						fromSQL = append(fromSQL, convertDBReleaseToResponse(dbRelease))
+					}
+				}
+
+				Expect(ids(fromSQL)).To(Equal(ids(r.applyFilters(fixtures, filters))),
+					"filters: %+v", filters)
+			}
+		})
+	})
+})
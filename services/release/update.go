@@ -0,0 +1,246 @@
+package release
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+)
+
+// ReleaseUpdate is a partial update of a release: nil fields are left
+// alone. An empty string clears labelUrl, country and preview links.
+type ReleaseUpdate struct {
+	Title         *string             `json:"title"`
+	Artist        *string             `json:"artist"`
+	AlbumArt      *string             `json:"albumArt"`
+	ReleaseDate   *Date               `json:"releaseDate"`
+	Label         *string             `json:"label"`
+	LabelUrl      *string             `json:"labelUrl"`
+	FollowerCount *int64              `json:"followerCount"`
+	Genres        []string            `json:"genres"`
+	Country       *string             `json:"country"`
+	PreviewLinks  *PreviewLinksUpdate `json:"previewLinks"`
+}
+
+// PreviewLinksUpdate is the previewLinks part of a ReleaseUpdate
+type PreviewLinksUpdate struct {
+	Spotify    *string `json:"spotify"`
+	Youtube    *string `json:"youtube"`
+	Bandcamp   *string `json:"bandcamp"`
+	AppleMusic *string `json:"appleMusic"`
+}
+
+// Validate checks the fields that are set
+func (u *ReleaseUpdate) Validate() error {
+	if u.Title != nil && strings.TrimSpace(*u.Title) == "" {
+		return errors.New("title cannot be empty")
+	}
+
+	if u.Artist != nil && strings.TrimSpace(*u.Artist) == "" {
+		return errors.New("artist cannot be empty")
+	}
+
+	if u.FollowerCount != nil && *u.FollowerCount < 0 {
+		return errors.New("followerCount cannot be negative")
+	}
+
+	if u.Country != nil && *u.Country != "" && !ValidCountryCode(*u.Country) {
+		return errors.Errorf("%q is not an ISO 3166-1 alpha-2 country code", *u.Country)
+	}
+
+	return nil
+}
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation
+const uniqueViolation = "23505"
+
+// UpdateRelease applies update to the release and returns it; ErrInvalidID
+// when id is not a UUID, ErrNotFound when there is no such release and
+// ErrConflict when the update would duplicate another release
+func (r *Release) UpdateRelease(ctx context.Context, id string, update *ReleaseUpdate) (*ReleaseResponse, error) {
+	releaseID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if err := update.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid update")
+	}
+
+	current, err := r.opts.Backend.GetRelease(ctx, releaseID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+
+		return nil, errors.Wrap(err, "failed to fetch release")
+	}
+
+	params, err := applyUpdate(current, update)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := r.opts.Backend.UpdateRelease(ctx, params)
+	if err != nil {
+		// Deleted since it was fetched
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+
+		if isUniqueViolation(err) {
+			return nil, ErrConflict
+		}
+
+		return nil, errors.Wrap(err, "failed to update release")
+	}
+
+	return convertDBReleaseToResponse(updated), nil
+}
+
+// DeleteRelease deletes a release; ErrInvalidID when id is not a UUID and
+// ErrNotFound when there is no such release
+func (r *Release) DeleteRelease(ctx context.Context, id string) error {
+	releaseID, err := uuid.Parse(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+
+	deleted, err := r.opts.Backend.DeleteRelease(ctx, releaseID)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete release")
+	}
+
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// applyUpdate returns update params for r with update's fields applied.
+// Preview links are kept in sync with their external_links entries, the
+// way the importer stores them.
+func applyUpdate(r gensql.Release, update *ReleaseUpdate) (gensql.UpdateReleaseParams, error) {
+	params := gensql.UpdateReleaseParams{
+		ID:            r.ID,
+		Title:         r.Title,
+		Artist:        r.Artist,
+		AlbumArtUrl:   r.AlbumArtUrl,
+		ReleaseDate:   r.ReleaseDate,
+		Label:         r.Label,
+		LabelUrl:      r.LabelUrl,
+		FollowerCount: r.FollowerCount,
+		Genres:        r.Genres,
+		Country:       r.Country,
+		ExternalLinks: r.ExternalLinks,
+		SpotifyUrl:    r.SpotifyUrl,
+		YoutubeUrl:    r.YoutubeUrl,
+		BandcampUrl:   r.BandcampUrl,
+		AppleMusicUrl: r.AppleMusicUrl,
+	}
+
+	if update.Title != nil {
+		params.Title = strings.TrimSpace(*update.Title)
+	}
+
+	if update.Artist != nil {
+		params.Artist = strings.TrimSpace(*update.Artist)
+	}
+
+	if update.AlbumArt != nil {
+		params.AlbumArtUrl = strings.TrimSpace(*update.AlbumArt)
+	}
+
+	if update.ReleaseDate != nil {
+		params.ReleaseDate = update.ReleaseDate.Time
+	}
+
+	if update.Label != nil {
+		params.Label = strings.TrimSpace(*update.Label)
+	}
+
+	if update.LabelUrl != nil {
+		params.LabelUrl = nullString(*update.LabelUrl)
+	}
+
+	if update.FollowerCount != nil {
+		params.FollowerCount = *update.FollowerCount
+	}
+
+	if update.Genres != nil {
+		genres, err := json.Marshal(update.Genres)
+		if err != nil {
+			return params, errors.Wrap(err, "failed to marshal genres")
+		}
+
+		params.Genres = genres
+	}
+
+	if update.Country != nil {
+		params.Country = nullString(strings.ToUpper(*update.Country))
+	}
+
+	if links := update.PreviewLinks; links != nil {
+		for name, link := range map[string]struct {
+			value *string
+			col   *sql.NullString
+		}{
+			"spotify":     {links.Spotify, &params.SpotifyUrl},
+			"youtube":     {links.Youtube, &params.YoutubeUrl},
+			"bandcamp":    {links.Bandcamp, &params.BandcampUrl},
+			"apple_music": {links.AppleMusic, &params.AppleMusicUrl},
+		} {
+			if link.value == nil {
+				continue
+			}
+
+			*link.col = nullString(*link.value)
+			params.ExternalLinks = setExternalLink(params.ExternalLinks, name, link.col.String)
+		}
+	}
+
+	return params, nil
+}
+
+func nullString(s string) sql.NullString {
+	s = strings.TrimSpace(s)
+
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// setExternalLink sets (or, for an empty u, removes) name in the importer's
+// name -> url external_links; other forms are returned unchanged
+func setExternalLink(raw []byte, name, u string) []byte {
+	links := map[string]string{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &links); err != nil {
+			return raw
+		}
+	}
+
+	if u == "" {
+		delete(links, name)
+	} else {
+		links[name] = u
+	}
+
+	b, err := json.Marshal(links)
+	if err != nil {
+		return raw
+	}
+
+	return b
+}
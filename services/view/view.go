@@ -0,0 +1,310 @@
+package view
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/db"
+	"github.com/dselans/blastbeat-api/backends/gensql"
+	"github.com/dselans/blastbeat-api/backends/state"
+)
+
+const (
+	DefaultFlushInterval = time.Minute
+	DefaultHalfLife      = 24 * time.Hour
+	DefaultWindow        = 72 * time.Hour
+
+	// bucketSize is the granularity of the trending sorted sets
+	bucketSize = time.Hour
+
+	// flushBatchSize is how many dirty releases are flushed per SPOP
+	flushBatchSize = 500
+
+	pendingKeyPrefix = "views:pending:"
+	dirtyKey         = "views:dirty"
+	trendingPrefix   = "views:trending:"
+)
+
+var ErrReleaseNotFound = errors.New("release not found")
+
+type IView interface {
+	Record(ctx context.Context, releaseID uuid.UUID) error
+	Trending(ctx context.Context, n int) ([]uuid.UUID, error)
+}
+
+type View struct {
+	opts    *Options
+	backend backend
+	state   counters
+	log     clog.ICustomLog
+}
+
+// backend is the part of *db.DB that View uses
+type backend interface {
+	GetRelease(ctx context.Context, id uuid.UUID) (gensql.Release, error)
+	AddReleaseViews(ctx context.Context, arg gensql.AddReleaseViewsParams) error
+}
+
+// counters is the part of *state.State that View uses
+type counters interface {
+	Incr(ctx context.Context, key string) (int64, error)
+	IncrBy(ctx context.Context, key string, n int64) (int64, error)
+	GetDel(ctx context.Context, key string) (int64, error)
+	SAdd(ctx context.Context, key string, members ...string) error
+	SPopN(ctx context.Context, key string, n int64) ([]string, error)
+	ZIncrBy(ctx context.Context, key, member string, incr float64, ttl time.Duration) error
+	ZUnionTop(ctx context.Context, keys []string, weights []float64, n int) ([]state.Z, error)
+}
+
+type Options struct {
+	Backend *db.DB
+	State   *state.State
+	Log     clog.ICustomLog
+
+	// FlushInterval is how often pending Redis counts are written to the
+	// DB; defaults to DefaultFlushInterval
+	FlushInterval time.Duration
+
+	// HalfLife is how long it takes a view to lose half its trending
+	// weight; defaults to DefaultHalfLife
+	HalfLife time.Duration
+
+	// Window is how far back trending looks; defaults to DefaultWindow
+	Window time.Duration
+}
+
+func New(opts *Options) (*View, error) {
+	if err := validateOptions(opts); err != nil {
+		return nil, errors.Wrap(err, "failed to validate options")
+	}
+
+	return &View{
+		opts:    opts,
+		backend: opts.Backend,
+		state:   opts.State,
+		log:     opts.Log.With(zap.String("pkg", "view")),
+	}, nil
+}
+
+func validateOptions(opts *Options) error {
+	if opts == nil {
+		return errors.New("options cannot be nil")
+	}
+
+	if opts.Backend == nil {
+		return errors.New("backend cannot be nil")
+	}
+
+	if opts.State == nil {
+		return errors.New("state cannot be nil")
+	}
+
+	if opts.Log == nil {
+		return errors.New("log cannot be nil")
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+
+	if opts.HalfLife <= 0 {
+		opts.HalfLife = DefaultHalfLife
+	}
+
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+
+	return nil
+}
+
+// Record counts a view of releaseID
+func (v *View) Record(ctx context.Context, releaseID uuid.UUID) error {
+	if _, err := v.backend.GetRelease(ctx, releaseID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrReleaseNotFound
+		}
+
+		return errors.Wrap(err, "failed to look up release")
+	}
+
+	id := releaseID.String()
+
+	if _, err := v.state.Incr(ctx, pendingKeyPrefix+id); err != nil {
+		return errors.Wrap(err, "failed to increment view count")
+	}
+
+	if err := v.state.SAdd(ctx, dirtyKey, id); err != nil {
+		return errors.Wrap(err, "failed to mark views dirty")
+	}
+
+	// Buckets outlive the window by one bucket so the oldest one is still
+	// readable while it ages out
+	if err := v.state.ZIncrBy(ctx, bucketKey(time.Now()), id, 1,
+		v.opts.Window+bucketSize); err != nil {
+		return errors.Wrap(err, "failed to update trending")
+	}
+
+	return nil
+}
+
+// Trending returns up to n release ids ordered by time-decayed view count
+func (v *View) Trending(ctx context.Context, n int) ([]uuid.UUID, error) {
+	keys, weights := trendingBuckets(time.Now(), v.opts.Window, v.opts.HalfLife)
+
+	top, err := v.state.ZUnionTop(ctx, keys, weights, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read trending")
+	}
+
+	ids := make([]uuid.UUID, 0, len(top))
+
+	for _, z := range top {
+		id, err := uuid.Parse(z.Member)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// RunFlusher periodically moves pending Redis view counts into the DB until
+// ctx is cancelled, flushing one last time on the way out
+func (v *View) RunFlusher(ctx context.Context) {
+	logger := v.log.With(zap.String("method", "RunFlusher"))
+
+	ticker := time.NewTicker(v.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := v.Flush(flushCtx); err != nil {
+				logger.Error("Final view flush failed", zap.Error(err))
+			}
+			cancel()
+
+			return
+		case <-ticker.C:
+			if err := v.Flush(ctx); err != nil {
+				logger.Error("View flush failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush writes all pending view counts to the DB
+func (v *View) Flush(ctx context.Context) error {
+	logger := v.log.With(zap.String("method", "Flush"))
+
+	var flushed int
+
+	for {
+		ids, err := v.state.SPopN(ctx, dirtyKey, flushBatchSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to pop dirty releases")
+		}
+
+		if len(ids) == 0 {
+			break
+		}
+
+		// SPOP already took the whole batch out of the dirty set, so on
+		// failure the ids not yet flushed are marked dirty again
+		for i, id := range ids {
+			releaseID, err := uuid.Parse(id)
+			if err != nil {
+				continue
+			}
+
+			views, err := v.state.GetDel(ctx, pendingKeyPrefix+id)
+			if err != nil {
+				v.markDirty(ctx, ids[i:]...)
+				return errors.Wrapf(err, "failed to read pending views for %s", id)
+			}
+
+			if views == 0 {
+				continue
+			}
+
+			if err := v.backend.AddReleaseViews(ctx, gensql.AddReleaseViewsParams{
+				Views:     views,
+				ReleaseID: releaseID,
+			}); err != nil {
+				v.requeue(ctx, id, views)
+				v.markDirty(ctx, ids[i+1:]...)
+
+				return errors.Wrapf(err, "failed to persist views for %s", id)
+			}
+
+			flushed++
+		}
+	}
+
+	if flushed > 0 {
+		logger.Debug("Flushed release views", zap.Int("releases", flushed))
+	}
+
+	return nil
+}
+
+// requeue puts views that failed to persist back so the next flush retries
+// them
+func (v *View) requeue(ctx context.Context, id string, views int64) {
+	if _, err := v.state.IncrBy(ctx, pendingKeyPrefix+id, views); err != nil {
+		v.log.Error("Failed to requeue views, dropping them",
+			zap.String("releaseID", id), zap.Int64("views", views), zap.Error(err))
+		return
+	}
+
+	v.markDirty(ctx, id)
+}
+
+// markDirty adds ids back to the dirty set so the next flush picks them up
+func (v *View) markDirty(ctx context.Context, ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	if err := v.state.SAdd(ctx, dirtyKey, ids...); err != nil {
+		v.log.Error("Failed to mark views dirty",
+			zap.Strings("releaseIDs", ids), zap.Error(err))
+	}
+}
+
+func bucketKey(t time.Time) string {
+	return trendingPrefix + strconv.FormatInt(t.Truncate(bucketSize).Unix(), 10)
+}
+
+// trendingBuckets returns the bucket keys covering window and a weight for
+// each that halves every halfLife
+func trendingBuckets(now time.Time, window, halfLife time.Duration) ([]string, []float64) {
+	n := int(window / bucketSize)
+	if n < 1 {
+		n = 1
+	}
+
+	keys := make([]string, 0, n)
+	weights := make([]float64, 0, n)
+
+	for i := 0; i < n; i++ {
+		age := time.Duration(i) * bucketSize
+
+		keys = append(keys, bucketKey(now.Add(-age)))
+		weights = append(weights, math.Pow(0.5, float64(age)/float64(halfLife)))
+	}
+
+	return keys, weights
+}
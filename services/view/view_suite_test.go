@@ -0,0 +1,16 @@
+package view
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+)
+
+func TestViewSuite(t *testing.T) {
+	// Reduce test noise
+	zap.IncreaseLevel(zap.FatalLevel)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "View Suite")
+}
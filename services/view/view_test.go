@@ -0,0 +1,239 @@
+package view
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/superpowerdotcom/go-common-lib/clog"
+	"go.uber.org/zap"
+
+	"github.com/dselans/blastbeat-api/backends/gensql"
+	"github.com/dselans/blastbeat-api/backends/state"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeCounters is an in-memory counters; SPopN pops in sorted order so
+// tests know which ids a batch holds
+type fakeCounters struct {
+	counts map[string]int64
+	sets   map[string]map[string]bool
+
+	getDelErr map[string]error
+}
+
+func newFakeCounters() *fakeCounters {
+	return &fakeCounters{
+		counts:    map[string]int64{},
+		sets:      map[string]map[string]bool{},
+		getDelErr: map[string]error{},
+	}
+}
+
+func (f *fakeCounters) Incr(ctx context.Context, key string) (int64, error) {
+	return f.IncrBy(ctx, key, 1)
+}
+
+func (f *fakeCounters) IncrBy(_ context.Context, key string, n int64) (int64, error) {
+	f.counts[key] += n
+	return f.counts[key], nil
+}
+
+func (f *fakeCounters) GetDel(_ context.Context, key string) (int64, error) {
+	if err := f.getDelErr[key]; err != nil {
+		return 0, err
+	}
+
+	v := f.counts[key]
+	delete(f.counts, key)
+
+	return v, nil
+}
+
+func (f *fakeCounters) SAdd(_ context.Context, key string, members ...string) error {
+	if f.sets[key] == nil {
+		f.sets[key] = map[string]bool{}
+	}
+
+	for _, m := range members {
+		f.sets[key][m] = true
+	}
+
+	return nil
+}
+
+func (f *fakeCounters) SPopN(_ context.Context, key string, n int64) ([]string, error) {
+	members := f.members(key)
+	if int64(len(members)) > n {
+		members = members[:n]
+	}
+
+	for _, m := range members {
+		delete(f.sets[key], m)
+	}
+
+	return members, nil
+}
+
+func (f *fakeCounters) ZIncrBy(context.Context, string, string, float64, time.Duration) error {
+	return nil
+}
+
+func (f *fakeCounters) ZUnionTop(context.Context, []string, []float64, int) ([]state.Z, error) {
+	return nil, nil
+}
+
+func (f *fakeCounters) members(key string) []string {
+	out := []string{}
+	for m := range f.sets[key] {
+		out = append(out, m)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+// fakeBackend records persisted views, failing for ids in fail
+type fakeBackend struct {
+	views map[uuid.UUID]int64
+	fail  map[uuid.UUID]bool
+}
+
+func (f *fakeBackend) GetRelease(context.Context, uuid.UUID) (gensql.Release, error) {
+	return gensql.Release{}, nil
+}
+
+func (f *fakeBackend) AddReleaseViews(_ context.Context, arg gensql.AddReleaseViewsParams) error {
+	if f.fail[arg.ReleaseID] {
+		return errors.New("boom")
+	}
+
+	f.views[arg.ReleaseID] += arg.Views
+
+	return nil
+}
+
+var _ = Describe("View", func() {
+	var (
+		ctx      context.Context
+		counters *fakeCounters
+		backend  *fakeBackend
+		v        *View
+		ids      []uuid.UUID
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		counters = newFakeCounters()
+		backend = &fakeBackend{views: map[uuid.UUID]int64{}, fail: map[uuid.UUID]bool{}}
+		v = &View{
+			opts:    &Options{},
+			backend: backend,
+			state:   counters,
+			log:     clog.New(zap.NewNop()),
+		}
+
+		ids = nil
+		for i := 0; i < 4; i++ {
+			ids = append(ids, uuid.New())
+		}
+
+		sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+		for i, id := range ids {
+			counters.counts[pendingKeyPrefix+id.String()] = int64(i + 1)
+			Expect(counters.SAdd(ctx, dirtyKey, id.String())).To(Succeed())
+		}
+	})
+
+	Describe("Flush", func() {
+		It("should persist and clear every pending count", func() {
+			Expect(v.Flush(ctx)).To(Succeed())
+
+			for i, id := range ids {
+				Expect(backend.views[id]).To(Equal(int64(i + 1)))
+			}
+
+			Expect(counters.counts).To(BeEmpty())
+			Expect(counters.members(dirtyKey)).To(BeEmpty())
+		})
+
+		It("should keep the rest of the batch dirty when persisting fails", func() {
+			backend.fail[ids[1]] = true
+
+			Expect(v.Flush(ctx)).ToNot(Succeed())
+
+			Expect(backend.views).To(Equal(map[uuid.UUID]int64{ids[0]: 1}))
+			Expect(counters.members(dirtyKey)).To(Equal([]string{
+				ids[1].String(), ids[2].String(), ids[3].String(),
+			}))
+
+			for i, id := range ids[1:] {
+				Expect(counters.counts[pendingKeyPrefix+id.String()]).To(Equal(int64(i + 2)))
+			}
+
+			delete(backend.fail, ids[1])
+
+			Expect(v.Flush(ctx)).To(Succeed())
+
+			for i, id := range ids {
+				Expect(backend.views[id]).To(Equal(int64(i + 1)))
+			}
+		})
+
+		It("should keep the failed id and the rest of the batch dirty when reading fails", func() {
+			counters.getDelErr[pendingKeyPrefix+ids[2].String()] = errors.New("boom")
+
+			Expect(v.Flush(ctx)).ToNot(Succeed())
+
+			Expect(counters.members(dirtyKey)).To(Equal([]string{ids[2].String(), ids[3].String()}))
+			Expect(counters.counts).To(HaveKeyWithValue(pendingKeyPrefix+ids[2].String(), int64(3)))
+		})
+	})
+
+	Describe("requeue", func() {
+		It("should add the views back and mark the release dirty", func() {
+			id := uuid.New().String()
+			counters.counts[pendingKeyPrefix+id] = 2
+
+			v.requeue(ctx, id, 5)
+
+			Expect(counters.counts[pendingKeyPrefix+id]).To(Equal(int64(7)))
+			Expect(counters.members(dirtyKey)).To(ContainElement(id))
+		})
+	})
+
+	Describe("trendingBuckets", func() {
+		It("should weight each hourly bucket by its age in half-lives", func() {
+			now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+
+			keys, weights := trendingBuckets(now, 4*time.Hour, 2*time.Hour)
+
+			Expect(keys).To(Equal([]string{
+				bucketKey(now),
+				bucketKey(now.Add(-time.Hour)),
+				bucketKey(now.Add(-2 * time.Hour)),
+				bucketKey(now.Add(-3 * time.Hour)),
+			}))
+			Expect(keys[0]).To(Equal(trendingPrefix + "1714557600"))
+
+			Expect(weights).To(HaveLen(4))
+			Expect(weights[0]).To(Equal(1.0))
+			Expect(weights[1]).To(BeNumerically("~", 0.7071, 0.0001))
+			Expect(weights[2]).To(Equal(0.5))
+			Expect(weights[3]).To(BeNumerically("~", 0.3536, 0.0001))
+		})
+
+		It("should cover at least one bucket", func() {
+			keys, weights := trendingBuckets(time.Now(), time.Minute, time.Hour)
+
+			Expect(keys).To(HaveLen(1))
+			Expect(weights).To(Equal([]float64{1}))
+		})
+	})
+})
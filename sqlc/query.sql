@@ -7,32 +7,37 @@ LIMIT 1;
 -- name: ListReleases :many
 SELECT *
 FROM releases
-ORDER BY release_date DESC, created_at DESC;
+ORDER BY release_date DESC, created_at DESC
+LIMIT $1;
 
 -- name: ListReleasesByDateRange :many
 SELECT *
 FROM releases
 WHERE release_date BETWEEN $1 AND $2
-ORDER BY release_date DESC, created_at DESC;
+ORDER BY release_date DESC, created_at DESC
+LIMIT $3;
 
 -- name: ListReleasesByExactDate :many
 SELECT *
 FROM releases
 WHERE release_date = $1
-ORDER BY created_at DESC;
+ORDER BY created_at DESC
+LIMIT $2;
 
 -- name: ListReleasesByArtist :many
 SELECT *
 FROM releases
-WHERE artist LIKE '%' || $1 || '%'
-ORDER BY release_date DESC, created_at DESC;
+WHERE f_unaccent(LOWER(artist)) LIKE '%' || f_unaccent(LOWER($1::text)) || '%'
+ORDER BY release_date DESC, created_at DESC
+LIMIT $2;
 
 -- name: SearchReleases :many
 SELECT *
 FROM releases
-WHERE artist LIKE '%' || $1 || '%'
-   OR title LIKE '%' || $1 || '%'
-ORDER BY release_date DESC, created_at DESC;
+WHERE f_unaccent(LOWER(artist)) LIKE '%' || f_unaccent(LOWER($1::text)) || '%'
+   OR f_unaccent(LOWER(title)) LIKE '%' || f_unaccent(LOWER($1::text)) || '%'
+ORDER BY release_date DESC, created_at DESC
+LIMIT $2;
 
 -- name: ListReleasesByGenre :many
 SELECT r.*
@@ -42,7 +47,15 @@ WHERE EXISTS (
   FROM jsonb_array_elements_text(r.genres) AS genre
   WHERE LOWER(genre) = LOWER($1)
 )
-ORDER BY r.release_date DESC, r.created_at DESC;
+ORDER BY r.release_date DESC, r.created_at DESC
+LIMIT $2;
+
+-- name: ListReleasesByGenreCount :many
+SELECT *
+FROM releases
+WHERE jsonb_array_length(genres) BETWEEN $1::int AND $2::int
+ORDER BY release_date DESC, created_at DESC
+LIMIT $3;
 
 -- name: ListReleasesByGenresAny :many
 SELECT r.*
@@ -52,13 +65,129 @@ WHERE EXISTS (
   FROM jsonb_array_elements_text(r.genres) g(genre)
   WHERE g.genre = ANY($1::text[])
 )
-ORDER BY r.release_date DESC, r.created_at DESC;
+ORDER BY r.release_date DESC, r.created_at DESC
+LIMIT $2;
+
+-- name: ListReleasesByIDs :many
+SELECT *
+FROM releases
+WHERE id = ANY(@ids::uuid[]);
+
+-- name: ListReleasesNeedingArt :many
+SELECT *
+FROM releases
+WHERE album_art_url = '' OR album_art_url LIKE 'https://via.placeholder.com/%'
+ORDER BY follower_count DESC, release_date DESC
+LIMIT $1;
+
+-- name: ListReleasesWithMissingFields :many
+SELECT *
+FROM releases
+WHERE country IS NULL
+   OR genres = '[]'::jsonb
+   OR album_art_url = '' OR album_art_url LIKE 'https://via.placeholder.com/%'
+   OR spotify_url IS NULL
+   OR youtube_url IS NULL
+   OR apple_music_url IS NULL
+   OR label_url IS NULL
+ORDER BY follower_count DESC, release_date DESC
+LIMIT $1;
+
+-- name: ListReleasesWithInvalidCountry :many
+SELECT *
+FROM releases
+WHERE country IS NOT NULL
+  AND NOT (country = ANY(@codes::text[]))
+ORDER BY country, created_at DESC
+LIMIT @row_limit::int;
 
 -- name: ListReleasesByFollowerRange :many
 SELECT *
 FROM releases
 WHERE follower_count BETWEEN $1 AND $2
-ORDER BY follower_count DESC, release_date DESC;
+ORDER BY follower_count DESC, release_date DESC
+LIMIT $3;
+
+-- name: ListReleasesFiltered :many
+-- Genres are compared lowercased (callers pass lowercased genres) so
+-- matching stays case-insensitive like the in-memory filters
+SELECT *
+FROM releases
+WHERE (sqlc.narg('date_from')::date IS NULL OR release_date >= sqlc.narg('date_from')::date)
+  AND (sqlc.narg('date_to')::date IS NULL OR release_date <= sqlc.narg('date_to')::date)
+  AND jsonb_array_length(genres) BETWEEN @min_genres::int AND @max_genres::int
+  AND follower_count BETWEEN @follower_min::bigint AND @follower_max::bigint
+  AND (
+    cardinality(@included_genres::text[]) = 0
+    OR (@genres_match_any::bool AND LOWER(genres::text)::jsonb ?| @included_genres::text[])
+    OR (NOT @genres_match_any::bool AND LOWER(genres::text)::jsonb @> to_jsonb(@included_genres::text[]))
+  )
+  AND NOT (LOWER(genres::text)::jsonb ?| @excluded_genres::text[])
+  AND (
+    @has_preview::text = ''
+    OR (@has_preview::text IN ('spotify', 'any') AND COALESCE(spotify_url, '') <> '')
+    OR (@has_preview::text IN ('youtube', 'any') AND COALESCE(youtube_url, '') <> '')
+    OR (@has_preview::text IN ('bandcamp', 'any') AND COALESCE(bandcamp_url, '') <> '')
+    OR (@has_preview::text IN ('apple_music', 'any') AND COALESCE(apple_music_url, '') <> '')
+  )
+ORDER BY release_date DESC, created_at DESC
+LIMIT @row_limit;
+
+-- name: CountReleases :one
+SELECT COUNT(*)
+FROM releases;
+
+-- name: GetCatalogStats :one
+SELECT
+  COUNT(*) AS total_releases,
+  COUNT(*) FILTER (WHERE release_date BETWEEN @recent_from::date AND @recent_to::date) AS recent_releases,
+  COUNT(DISTINCT LOWER(artist)) AS distinct_artists,
+  COUNT(DISTINCT LOWER(label)) FILTER (WHERE label <> '') AS distinct_labels
+FROM releases;
+
+-- name: GetEnrichmentCoverage :one
+SELECT
+  COUNT(*) AS total_releases,
+  COUNT(*) FILTER (WHERE COALESCE(country, '') <> '') AS with_country,
+  COUNT(*) FILTER (WHERE album_art_url <> '' AND album_art_url NOT LIKE 'https://via.placeholder.com/%') AS with_art,
+  COUNT(*) FILTER (WHERE COALESCE(spotify_url, '') <> '') AS with_spotify,
+  COUNT(*) FILTER (WHERE COALESCE(youtube_url, '') <> '') AS with_youtube,
+  COUNT(*) FILTER (WHERE COALESCE(bandcamp_url, '') <> '') AS with_bandcamp,
+  COUNT(*) FILTER (WHERE COALESCE(apple_music_url, '') <> '') AS with_apple_music,
+  COUNT(*) FILTER (WHERE jsonb_array_length(genres) >= @min_genres::int) AS with_min_genres
+FROM releases;
+
+-- name: ListGenreCounts :many
+SELECT LOWER(g.genre)::text AS name, COUNT(DISTINCT r.id) AS release_count
+FROM releases AS r, jsonb_array_elements_text(r.genres) AS g(genre)
+GROUP BY LOWER(g.genre)
+ORDER BY release_count DESC, name
+LIMIT $1;
+
+-- name: ListReleaseKeysByDate :many
+SELECT artist, title
+FROM releases
+WHERE release_date = $1;
+
+-- name: ListReleaseSitemapEntries :many
+SELECT id, updated_at
+FROM releases
+ORDER BY release_date DESC, id
+LIMIT $1 OFFSET $2;
+
+-- name: ListCountries :many
+SELECT country::text AS code, COUNT(*) AS release_count
+FROM releases
+WHERE country IS NOT NULL
+GROUP BY country
+ORDER BY release_count DESC, country;
+
+-- name: ListLabels :many
+SELECT label AS name, COUNT(*) AS release_count
+FROM releases
+WHERE label <> ''
+GROUP BY label
+ORDER BY release_count DESC, label;
 
 -- name: CreateRelease :one
 INSERT INTO releases (
@@ -75,7 +204,8 @@ INSERT INTO releases (
   external_links,
   spotify_url,
   youtube_url,
-  bandcamp_url
+  bandcamp_url,
+  apple_music_url
 ) VALUES (
   $1,  -- id
   $2,  -- title
@@ -90,10 +220,73 @@ INSERT INTO releases (
   $11, -- external_links (jsonb)
   $12, -- spotify_url
   $13, -- youtube_url
-  $14  -- bandcamp_url
+  $14, -- bandcamp_url
+  $15  -- apple_music_url
 )
 RETURNING *;
 
+-- name: UpsertRelease :one
+INSERT INTO releases (
+  id,
+  title,
+  artist,
+  album_art_url,
+  release_date,
+  label,
+  label_url,
+  follower_count,
+  genres,
+  country,
+  external_links,
+  spotify_url,
+  youtube_url,
+  bandcamp_url,
+  apple_music_url
+) VALUES (
+  $1,  -- id
+  $2,  -- title
+  $3,  -- artist
+  $4,  -- album_art_url
+  $5,  -- release_date
+  $6,  -- label
+  $7,  -- label_url
+  $8,  -- follower_count
+  $9,  -- genres (jsonb)
+  $10, -- country
+  $11, -- external_links (jsonb)
+  $12, -- spotify_url
+  $13, -- youtube_url
+  $14, -- bandcamp_url
+  $15  -- apple_music_url
+)
+ON CONFLICT (lower(artist), lower(title), release_date) DO UPDATE
+SET
+  -- Stored values win; only empty fields are filled in
+  album_art_url = CASE
+    WHEN releases.album_art_url = '' OR releases.album_art_url LIKE 'https://via.placeholder.com/%'
+      THEN EXCLUDED.album_art_url
+    ELSE releases.album_art_url
+  END,
+  label = COALESCE(NULLIF(releases.label, ''), EXCLUDED.label),
+  label_url = COALESCE(NULLIF(releases.label_url, ''), EXCLUDED.label_url),
+  follower_count = COALESCE(NULLIF(releases.follower_count, 0), EXCLUDED.follower_count),
+  genres = CASE
+    WHEN releases.genres = '[]'::jsonb THEN EXCLUDED.genres
+    ELSE releases.genres
+  END,
+  country = COALESCE(NULLIF(releases.country, ''), EXCLUDED.country),
+  external_links = CASE
+    WHEN jsonb_typeof(releases.external_links) = 'object' AND jsonb_typeof(EXCLUDED.external_links) = 'object'
+      THEN EXCLUDED.external_links || releases.external_links
+    ELSE releases.external_links
+  END,
+  spotify_url = COALESCE(NULLIF(releases.spotify_url, ''), EXCLUDED.spotify_url),
+  youtube_url = COALESCE(NULLIF(releases.youtube_url, ''), EXCLUDED.youtube_url),
+  bandcamp_url = COALESCE(NULLIF(releases.bandcamp_url, ''), EXCLUDED.bandcamp_url),
+  apple_music_url = COALESCE(NULLIF(releases.apple_music_url, ''), EXCLUDED.apple_music_url),
+  updated_at = now()
+RETURNING *, (xmax = 0) AS inserted;
+
 -- name: UpdateRelease :one
 UPDATE releases
 SET
@@ -110,11 +303,49 @@ SET
   spotify_url = $12,
   youtube_url = $13,
   bandcamp_url = $14,
+  apple_music_url = $15,
   updated_at = now()
 WHERE id = $1
 RETURNING *;
 
--- name: DeleteRelease :exec
+-- name: AddReleaseViews :exec
+INSERT INTO release_views (release_id, view_count)
+SELECT id, @views::bigint
+FROM releases
+WHERE id = @release_id
+ON CONFLICT (release_id) DO UPDATE
+SET view_count = release_views.view_count + EXCLUDED.view_count,
+    updated_at = now();
+
+-- name: UpdateReleaseArt :execrows
+UPDATE releases
+SET
+  album_art_url = $2,
+  updated_at = now()
+WHERE id = $1
+  AND (album_art_url = '' OR album_art_url LIKE 'https://via.placeholder.com/%');
+
+-- name: UpdateReleaseCountry :execrows
+UPDATE releases
+SET
+  country = @new_country,
+  updated_at = now()
+WHERE country = @old_country::text;
+
+-- name: UpdateReleaseYoutubeURL :execrows
+UPDATE releases
+SET
+  youtube_url = @new_url::text,
+  external_links = CASE
+    WHEN jsonb_typeof(external_links) = 'object'
+      THEN jsonb_set(external_links, '{youtube}', to_jsonb(@new_url::text))
+    ELSE external_links
+  END,
+  updated_at = now()
+WHERE id = @id
+  AND youtube_url = @old_url::text;
+
+-- name: DeleteRelease :execrows
 DELETE FROM releases
 WHERE id = $1;
 
@@ -160,3 +391,48 @@ RETURNING *;
 -- name: DeleteGenre :exec
 DELETE FROM genres
 WHERE id = $1;
+
+-- name: AddFavorite :execrows
+INSERT INTO favorites (token, release_id)
+SELECT @token::text, @release_id::uuid
+WHERE (SELECT COUNT(*) FROM favorites WHERE token = @token::text) < @max_favorites::int
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveFavorite :exec
+DELETE FROM favorites
+WHERE token = $1 AND release_id = $2;
+
+-- name: ListFavoriteReleaseIDs :many
+SELECT release_id
+FROM favorites
+WHERE token = $1
+ORDER BY created_at DESC;
+
+-- name: FavoriteExists :one
+SELECT EXISTS (
+  SELECT 1
+  FROM favorites
+  WHERE token = $1 AND release_id = $2
+);
+
+-- name: CreateEnrichmentAudit :exec
+INSERT INTO enrichment_audit (
+  release_id,
+  artist,
+  album,
+  provider,
+  lookup,
+  url,
+  status_code,
+  error,
+  response_excerpt,
+  duration_ms
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+);
+
+-- name: ListEnrichmentAuditByRelease :many
+SELECT *
+FROM enrichment_audit
+WHERE release_id = $1
+ORDER BY created_at, id;
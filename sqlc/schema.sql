@@ -6,13 +6,14 @@ CREATE TABLE releases (
   release_date DATE NOT NULL,
   label TEXT NOT NULL,
   label_url TEXT,
-  follower_count INTEGER NOT NULL DEFAULT 0,
+  follower_count BIGINT NOT NULL DEFAULT 0,
   genres JSONB NOT NULL, -- keep as JSON array
   country CHAR(2),
   external_links JSONB NOT NULL,
   spotify_url TEXT,
   youtube_url TEXT,
   bandcamp_url TEXT,
+  apple_music_url TEXT,
   created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
@@ -20,9 +21,46 @@ CREATE TABLE releases (
 CREATE INDEX idx_releases_release_date ON releases (release_date);
 CREATE INDEX idx_releases_follower_count ON releases (follower_count);
 CREATE INDEX idx_releases_artist ON releases (artist);
+CREATE UNIQUE INDEX idx_releases_unique_key ON releases (lower(artist), lower(title), release_date);
+
+CREATE FUNCTION f_unaccent(text) RETURNS text
+  AS $$ SELECT public.unaccent('public.unaccent'::regdictionary, $1) $$
+  LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT;
 
 CREATE TABLE genres (
   id UUID PRIMARY KEY,
   name TEXT UNIQUE NOT NULL,
   slug TEXT UNIQUE NOT NULL
 );
+
+CREATE TABLE favorites (
+  token TEXT NOT NULL,
+  release_id UUID NOT NULL REFERENCES releases (id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (token, release_id)
+);
+
+CREATE INDEX idx_favorites_token_created_at ON favorites (token, created_at DESC);
+
+CREATE TABLE release_views (
+  release_id UUID PRIMARY KEY REFERENCES releases (id) ON DELETE CASCADE,
+  view_count BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE enrichment_audit (
+  id BIGSERIAL PRIMARY KEY,
+  release_id UUID REFERENCES releases (id) ON DELETE CASCADE,
+  artist TEXT NOT NULL,
+  album TEXT NOT NULL,
+  provider TEXT NOT NULL,
+  lookup TEXT NOT NULL,
+  url TEXT NOT NULL,
+  status_code INTEGER NOT NULL,
+  error TEXT,
+  response_excerpt TEXT,
+  duration_ms INTEGER NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_enrichment_audit_release_id ON enrichment_audit (release_id, created_at);